@@ -0,0 +1,223 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	database "secure-video-api/internal/database"
+	"secure-video-api/internal/jwtkeys"
+	"secure-video-api/internal/keyrotation"
+	"secure-video-api/internal/kms"
+	"secure-video-api/internal/storage"
+	"secure-video-api/internal/utils"
+)
+
+// runCommand runs a maintenance subcommand instead of the server.
+func runCommand(args []string) {
+	switch args[0] {
+	case "rotate-keys":
+		rotateKeys()
+	case "keystore":
+		if len(args) < 2 {
+			log.Fatal("Usage: keystore init|add-key")
+		}
+		keystore(args[1])
+	case "jwt-keys":
+		jwtKeys(args[1:])
+	case "migrate":
+		migrate(args[1:])
+	default:
+		log.Fatalf("Unknown command %q (available: rotate-keys, keystore, jwt-keys, migrate)", args[0])
+	}
+}
+
+// rotateKeys moves every video onto the active master key. It can be
+// interrupted and re-run at any time; finished videos are skipped.
+func rotateKeys() {
+	if err := database.InitDB(); err != nil {
+		log.Fatal("Failed to initialize database:", err)
+	}
+	if err := kms.Init(); err != nil {
+		log.Fatal("Failed to initialize key provider:", err)
+	}
+
+	encryptedPath, err := storage.Dir()
+	if err != nil {
+		log.Fatal("Failed to resolve encrypted directory:", err)
+	}
+
+	progress, err := keyrotation.Run(kms.Provider, encryptedPath, func(p keyrotation.Progress) {
+		fmt.Printf("\r[%d/%d] rewrapped=%d reencrypted=%d skipped=%d failed=%d",
+			p.Processed, p.Total, p.Rewrapped, p.Reencrypted, p.Skipped, p.Failed)
+	})
+	fmt.Println()
+	if err != nil {
+		log.Fatal("Key rotation failed:", err)
+	}
+
+	for _, e := range progress.Errors {
+		fmt.Println("  error:", e)
+	}
+	if progress.Failed > 0 {
+		os.Exit(1)
+	}
+	fmt.Printf("All videos are on key version %d\n", progress.ActiveVersion)
+}
+
+// keystore manages the local keystore at KEYSTORE_PATH. "init" seeds it with
+// the keys from ENCRYPTION_KEYS / ENCRYPTION_KEY so existing videos stay
+// readable; "add-key" generates a new active key version.
+func keystore(action string) {
+	path := os.Getenv("KEYSTORE_PATH")
+	passphrase := os.Getenv("KEYSTORE_PASSPHRASE")
+
+	switch action {
+	case "init":
+		keys := make(map[int][]byte)
+		active := 0
+		if keyring, err := utils.LoadKeyring(); err == nil {
+			for _, version := range keyring.Versions() {
+				keys[version], _ = keyring.Key(version)
+			}
+			active = keyring.ActiveVersion()
+		} else {
+			log.Printf("No usable environment keys (%v); generating a new key", err)
+		}
+
+		ks, err := kms.CreateKeystore(path, passphrase, keys, active)
+		if err != nil {
+			log.Fatal("Failed to create keystore:", err)
+		}
+		active, _ = ks.ActiveVersion()
+		fmt.Printf("Created keystore %s with key versions %v (active %d)\n", path, ks.Versions(), active)
+
+	case "add-key":
+		ks, err := kms.OpenKeystore(path, passphrase)
+		if err != nil {
+			log.Fatal("Failed to open keystore:", err)
+		}
+		version, err := ks.AddKey()
+		if err != nil {
+			log.Fatal("Failed to add key:", err)
+		}
+		fmt.Printf("Added key version %d; run rotate-keys to move existing videos onto it\n", version)
+
+	default:
+		log.Fatalf("Unknown keystore action %q (available: init, add-key)", action)
+	}
+}
+
+// jwtKeys manages the token signing keys in JWT_KEYS_DIR. "generate" adds a
+// new key (ed25519 unless "rsa" is given), which signs new tokens from the
+// next restart; "retire <kid>" keeps only the public half of an old key so
+// it verifies the tokens it signed until they expire.
+func jwtKeys(args []string) {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		log.Fatal("JWT_KEYS_DIR is not set")
+	}
+	if len(args) == 0 {
+		log.Fatal("Usage: jwt-keys generate [ed25519|rsa] | retire <kid>")
+	}
+
+	switch args[0] {
+	case "generate":
+		keyType := "ed25519"
+		if len(args) > 1 {
+			keyType = args[1]
+		}
+		kid, err := jwtkeys.GenerateKey(dir, keyType)
+		if err != nil {
+			log.Fatal("Failed to generate JWT key:", err)
+		}
+		fmt.Printf("Generated %s key %s\n", keyType, kid)
+	case "retire":
+		if len(args) < 2 {
+			log.Fatal("Usage: jwt-keys retire <kid>")
+		}
+		if err := jwtkeys.RetireKey(dir, args[1]); err != nil {
+			log.Fatal("Failed to retire JWT key:", err)
+		}
+		fmt.Printf("Key %s now only verifies existing tokens\n", args[1])
+	default:
+		log.Fatalf("Unknown jwt-keys action %q", args[0])
+	}
+}
+
+// migrate inspects and changes the database schema. "status" lists the
+// migrations and when they were applied; "up [version]" applies pending
+// migrations (up to version); "down [steps]" reverts the last migration, or
+// the last steps. --dry-run lists the statements without running them.
+func migrate(args []string) {
+	if len(args) == 0 {
+		log.Fatal("Usage: migrate status | up [--dry-run] [version] | down [--dry-run] [steps]")
+	}
+	if err := database.Open(); err != nil {
+		log.Fatal("Failed to open database:", err)
+	}
+
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "list the statements without running them")
+	flags.Parse(args[1:])
+	number := 0
+	if flags.NArg() > 0 {
+		var err error
+		if number, err = strconv.Atoi(flags.Arg(0)); err != nil || number < 1 {
+			log.Fatalf("Invalid number %q", flags.Arg(0))
+		}
+	}
+
+	switch args[0] {
+	case "status":
+		statuses, err := database.MigrationStatuses()
+		if err != nil {
+			log.Fatal("Failed to read migrations:", err)
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d  %-32s %s\n", status.Version, status.Name, applied)
+		}
+	case "up", "down":
+		var migrations []database.Migration
+		var err error
+		done, planned := "Applied", "Would apply"
+		if args[0] == "up" {
+			migrations, err = database.MigrateUp(number, *dryRun)
+		} else {
+			if number == 0 {
+				number = 1
+			}
+			migrations, err = database.MigrateDown(number, *dryRun)
+			done, planned = "Reverted", "Would revert"
+		}
+		for _, migration := range migrations {
+			if !*dryRun {
+				fmt.Printf("%s %04d_%s\n", done, migration.Version, migration.Name)
+				continue
+			}
+			fmt.Printf("%s %04d_%s\n", planned, migration.Version, migration.Name)
+			statements := migration.Up
+			if args[0] == "down" {
+				statements = migration.Down
+			}
+			for _, statement := range statements {
+				fmt.Printf("  %s;\n", statement)
+			}
+		}
+		if err != nil {
+			log.Fatal("Migration failed: ", err)
+		}
+		if len(migrations) == 0 {
+			fmt.Println("Nothing to do")
+		}
+	default:
+		log.Fatalf("Unknown migrate action %q", args[0])
+	}
+}
@@ -7,12 +7,20 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"syscall"
 	"time"
 
 	database "secure-video-api/internal/database"
 	handlers "secure-video-api/internal/handlers"
+	jobs "secure-video-api/internal/jobs"
+	jwtkeys "secure-video-api/internal/jwtkeys"
+	kms "secure-video-api/internal/kms"
 	middleware "secure-video-api/internal/middleware"
+	models "secure-video-api/internal/models"
+	repository "secure-video-api/internal/repository"
+	storage "secure-video-api/internal/storage"
+	utils "secure-video-api/internal/utils"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -25,6 +33,12 @@ func init() {
 }
 
 func main() {
+	// Maintenance subcommands, e.g. "rotate-keys"
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1:])
+		return
+	}
+
 	// Set up signal handling for graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
@@ -33,6 +47,23 @@ func main() {
 	if err := database.InitDB(); err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
+	repos := repository.NewSQL(database.DB)
+	h := handlers.New(repos)
+
+	// Initialize the key provider protecting video data keys
+	if err := kms.Init(); err != nil {
+		log.Fatal("Failed to initialize key provider:", err)
+	}
+
+	// Load the keys signing and verifying access tokens
+	if err := jwtkeys.Init(); err != nil {
+		log.Fatal("Failed to load JWT keys:", err)
+	}
+
+	// Make sure playback URLs cannot be signed with a guessable key
+	if err := utils.CheckPlaybackSecret(); err != nil {
+		log.Fatal("Invalid playback URL secret:", err)
+	}
 
 	// Create default admin user
 	if err := database.CreateDefaultAdmin(); err != nil {
@@ -60,7 +91,10 @@ func main() {
 
 	// Create storage directories if they don't exist
 	storagePath := filepath.Join(workDir, "storage", "videos")
-	encryptedPath := filepath.Join(workDir, "storage", "encrypted")
+	encryptedPath, err := storage.Dir()
+	if err != nil {
+		log.Fatal("Failed to resolve encrypted directory:", err)
+	}
 
 	// Override environment variables with absolute paths
 	os.Setenv("STORAGE_PATH", storagePath)
@@ -84,47 +118,129 @@ func main() {
 		log.Fatal("Failed to set encrypted directory permissions:", err)
 	}
 
+	// Public keys verifying access tokens, for other services
+	router.GET("/.well-known/jwks.json", handlers.JWKS)
+
 	// API routes
 	api := router.Group("/api")
 	{
 		// Public routes
 		auth := api.Group("/auth")
 		{
-			auth.POST("/register", handlers.Register)
-			auth.POST("/login", handlers.Login)
+			auth.POST("/register", h.Register)
+			auth.POST("/login", h.Login)
+			auth.POST("/refresh", h.Refresh)
+			auth.POST("/logout", middleware.AuthMiddleware(repos.Users, repos.Tokens), h.Logout)
 		}
 
+		// Signed playback URLs, authenticated by their query string
+		api.GET("/videos/:id/play",
+			middleware.PlaybackURLMiddleware(repos.Users),
+			middleware.RequirePermission(models.PermVideosView),
+			middleware.RequireVideoAccess(repos.Videos),
+			h.StreamVideo)
+
 		// Protected routes
 		protected := api.Group("")
-		protected.Use(middleware.AuthMiddleware())
+		protected.Use(middleware.AuthMiddleware(repos.Users, repos.Tokens))
 		{
-			// Video routes accessible to all authenticated users
+			// Video routes for users allowed to watch videos
 			videos := protected.Group("/videos")
+			videos.Use(middleware.RequirePermission(models.PermVideosView))
 			{
-				videos.GET("", handlers.ListVideos)
-				videos.GET("/:id/stream", handlers.StreamVideo)
+				videos.GET("", h.ListVideos)
+
+				// Routes for a single video, which must be visible to the user
+				video := videos.Group("/:id")
+				video.Use(middleware.RequireVideoAccess(repos.Videos))
+				{
+					video.GET("/stream", h.StreamVideo)
+					video.POST("/playback-token", h.CreatePlaybackToken)
+					video.GET("/hls/key", h.HLSKey)
+					video.GET("/hls/:file", h.StreamHLS)
+					video.GET("/dash", h.DASHManifest)
+					video.GET("/dash/:file", h.StreamDASH)
+				}
 			}
 
-			// Admin-only routes
+			// Administration routes, each guarded by its permission
 			admin := protected.Group("/admin")
-			admin.Use(middleware.AdminMiddleware())
 			{
-				// Video management
-				admin.POST("/videos", handlers.UploadVideo)
-				admin.PUT("/videos/:id", handlers.UpdateVideo)
-				admin.DELETE("/videos/:id", handlers.DeleteVideo)
+				// Video uploads and management
+				upload := middleware.RequirePermission(models.PermVideosUpload)
+				manage := middleware.RequirePermission(models.PermVideosManage)
+				admin.POST("/videos", upload, h.UploadVideo)
+				admin.PUT("/videos/:id", manage, h.UpdateVideo)
+				admin.PUT("/videos/:id/file", manage, h.ReplaceVideoFile)
+				admin.DELETE("/videos/:id", manage, h.DeleteVideo)
+
+				// Video visibility and access grants
+				admin.PUT("/videos/:id/visibility", manage, h.SetVideoVisibility)
+				admin.GET("/videos/:id/acl", manage, h.GetVideoACL)
+				admin.POST("/videos/:id/acl", manage, h.GrantVideoAccess)
+				admin.DELETE("/videos/:id/acl/:type/:subject", manage, h.RevokeVideoAccess)
+
+				// Resumable (tus) uploads
+				admin.OPTIONS("/uploads", upload, handlers.TusOptions)
+				admin.POST("/uploads", upload, h.CreateUpload)
+				admin.HEAD("/uploads/:id", upload, h.UploadStatus)
+				admin.PATCH("/uploads/:id", upload, h.PatchUpload)
+				admin.DELETE("/uploads/:id", upload, h.TerminateUpload)
+
+				// Background processing jobs
+				admin.GET("/jobs/:id", upload, h.GetJob)
 
 				// User management
-				admin.GET("/users", handlers.ListUsers)
-				admin.POST("/users/:id/deactivate", handlers.DeactivateUser)
-				admin.POST("/users/:id/reactivate", handlers.ReactivateUser)
-				admin.DELETE("/users/:id", handlers.DeleteUser)
-				admin.POST("/admin/register", handlers.RegisterAdmin)
-				admin.DELETE("/admin/:id", handlers.DeleteAdmin)
+				users := middleware.RequirePermission(models.PermUsersManage)
+				admin.GET("/users", users, h.ListUsers)
+				admin.POST("/users/:id/deactivate", users, h.DeactivateUser)
+				admin.POST("/users/:id/reactivate", users, h.ReactivateUser)
+				admin.DELETE("/users/:id", users, h.DeleteUser)
+
+				// Roles
+				assign := middleware.RequirePermission(models.PermRolesAssign)
+				admin.GET("/roles", assign, h.ListRoles)
+				admin.GET("/users/:id/roles", assign, h.GetUserRoles)
+				admin.PUT("/users/:id/roles", assign, h.SetUserRoles)
+
+				// Groups, whose members share roles and video grants
+				groups := middleware.RequirePermission(models.PermGroupsManage)
+				admin.GET("/groups", groups, h.ListGroups)
+				admin.POST("/groups", groups, h.CreateGroup)
+				admin.GET("/groups/:id", groups, h.GetGroup)
+				admin.PUT("/groups/:id", groups, h.UpdateGroup)
+				admin.DELETE("/groups/:id", groups, h.DeleteGroup)
+				admin.POST("/groups/:id/members", groups, h.AddGroupMember)
+				admin.DELETE("/groups/:id/members/:user_id", groups, h.RemoveGroupMember)
+				admin.PUT("/groups/:id/roles", assign, h.SetGroupRoles)
+
+				// Admin accounts
+				admins := middleware.RequirePermission(models.PermAdminsManage)
+				admin.POST("/admin/register", admins, h.RegisterAdmin)
+				admin.DELETE("/admin/:id", admins, h.DeleteAdmin)
+
+				// Master key rotation
+				keys := middleware.RequirePermission(models.PermKeysManage)
+				admin.GET("/keys/rotation", keys, handlers.KeyRotationStatus)
+				admin.POST("/keys/rotation", keys, handlers.StartKeyRotation)
 			}
 		}
 	}
 
+	// Start the background workers processing uploaded videos
+	jobs.Register(jobs.TypeProcessVideo, h.ProcessVideo)
+	workerCount, err := strconv.Atoi(os.Getenv("JOB_WORKERS"))
+	if err != nil || workerCount < 1 {
+		workerCount = 2
+	}
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	if err := jobs.Start(workerCtx, database.DB, workerCount); err != nil {
+		log.Fatal("Failed to start job workers:", err)
+	}
+	// Remove files left unreferenced by uploads, deletes and rotations that
+	// were interrupted
+	storage.StartRecovery(workerCtx, database.DB)
+
 	port := os.Getenv("SERVER_PORT")
 	if port == "" {
 		port = "8080"
@@ -157,5 +273,9 @@ func main() {
 		log.Fatal("Server forced to shutdown:", err)
 	}
 
+	// Interrupted jobs are requeued and resume on the next start
+	stopWorkers()
+	jobs.Wait()
+
 	log.Println("Server stopped gracefully")
 }
@@ -0,0 +1,216 @@
+// Package dash packages MP4 videos for MPEG-DASH: an MPD manifest with one
+// adaptation set per track and fragmented MP4 segments.
+//
+// Segments are stored encrypted at rest under the video's data key, in the
+// same format as the video itself, and decrypted as they are served. The
+// manifest holds no media and is stored in the clear.
+package dash
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"secure-video-api/internal/media"
+	"secure-video-api/internal/utils"
+)
+
+// Manifest is the file name of the MPD inside a video's DASH directory.
+const Manifest = "manifest.mpd"
+
+// SegmentBase is the path of the segments relative to the manifest URL,
+// which is served without a trailing slash.
+const SegmentBase = "dash/"
+
+// TargetSegmentDuration is the minimum segment length in seconds; segments
+// end at the first video sync sample after it.
+const TargetSegmentDuration = 4.0
+
+var segmentName = regexp.MustCompile(`^(init_[0-9]+\.mp4|seg_[0-9]+_[0-9]{5}\.m4s)$`)
+
+// IsSegmentName reports whether name is an init or media segment file name,
+// so it can be safely joined to the DASH directory.
+func IsSegmentName(name string) bool {
+	return segmentName.MatchString(name)
+}
+
+// SegmentAAD binds an encrypted segment to its video and file name; see
+// utils.NewEncryptWriter.
+func SegmentAAD(videoID, name string) string {
+	return videoID + "/dash/" + name
+}
+
+type mpd struct {
+	XMLName                   xml.Name `xml:"urn:mpeg:dash:schema:mpd:2011 MPD"`
+	Profiles                  string   `xml:"profiles,attr"`
+	Type                      string   `xml:"type,attr"`
+	MediaPresentationDuration string   `xml:"mediaPresentationDuration,attr"`
+	MinBufferTime             string   `xml:"minBufferTime,attr"`
+	Period                    period   `xml:"Period"`
+}
+
+type period struct {
+	ID             string          `xml:"id,attr"`
+	Start          string          `xml:"start,attr"`
+	AdaptationSets []adaptationSet `xml:"AdaptationSet"`
+}
+
+type adaptationSet struct {
+	ID               int             `xml:"id,attr"`
+	ContentType      string          `xml:"contentType,attr"`
+	MimeType         string          `xml:"mimeType,attr"`
+	SegmentAlignment bool            `xml:"segmentAlignment,attr"`
+	StartWithSAP     int             `xml:"startWithSAP,attr"`
+	SegmentTemplate  segmentTemplate `xml:"SegmentTemplate"`
+	Representation   representation  `xml:"Representation"`
+}
+
+type segmentTemplate struct {
+	Timescale      uint32          `xml:"timescale,attr"`
+	Initialization string          `xml:"initialization,attr"`
+	Media          string          `xml:"media,attr"`
+	StartNumber    int             `xml:"startNumber,attr"`
+	Timeline       []timelineEntry `xml:"SegmentTimeline>S"`
+}
+
+// timelineEntry is an S element: r more segments of duration d follow the
+// one starting at t.
+type timelineEntry struct {
+	T uint64 `xml:"t,attr"`
+	D uint64 `xml:"d,attr"`
+	R int    `xml:"r,attr,omitempty"`
+}
+
+type representation struct {
+	ID        string `xml:"id,attr"`
+	Codecs    string `xml:"codecs,attr,omitempty"`
+	Bandwidth int64  `xml:"bandwidth,attr"`
+	Width     int    `xml:"width,attr,omitempty"`
+	Height    int    `xml:"height,attr,omitempty"`
+}
+
+// Package writes the DASH rendition of movie, read from r, into dir,
+// encrypting every segment with dataKey.
+func Package(movie *media.Movie, r io.ReadSeeker, dir string, dataKey []byte, videoID string) error {
+	segments := movie.Segments(TargetSegmentDuration)
+	if len(segments) == 0 {
+		return fmt.Errorf("%w: no samples to package", media.ErrUnsupportedMedia)
+	}
+
+	var total float64
+	for _, seg := range segments {
+		total += seg.Duration
+	}
+	manifest := mpd{
+		Profiles:                  "urn:mpeg:dash:profile:isoff-live:2011",
+		Type:                      "static",
+		MediaPresentationDuration: fmt.Sprintf("PT%.3fS", total),
+		MinBufferTime:             fmt.Sprintf("PT%.0fS", TargetSegmentDuration),
+		Period:                    period{ID: "0", Start: "PT0S"},
+	}
+
+	for t, track := range movie.Tracks {
+		single, parts := movie.Split(t, segments)
+		if len(parts) == 0 {
+			continue
+		}
+
+		initName := fmt.Sprintf("init_%d.mp4", track.ID)
+		if err := writeSegment(dir, initName, single.InitSegment(), dataKey, videoID); err != nil {
+			return err
+		}
+
+		var timeline []timelineEntry
+		var totalBytes int64
+		var trackDuration float64
+		for n, part := range parts {
+			fragment, err := single.Fragment(r, part, uint32(n+1))
+			if err != nil {
+				return err
+			}
+			name := fmt.Sprintf("seg_%d_%05d.m4s", track.ID, n)
+			if err := writeSegment(dir, name, fragment, dataKey, videoID); err != nil {
+				return err
+			}
+
+			start, duration := single.Span(part)
+			timeline = appendTimeline(timeline, start, duration)
+			totalBytes += int64(len(fragment))
+			trackDuration += part.Duration
+		}
+
+		set := adaptationSet{
+			ID:               len(manifest.Period.AdaptationSets) + 1,
+			ContentType:      "audio",
+			MimeType:         "audio/mp4",
+			SegmentAlignment: true,
+			StartWithSAP:     1,
+			SegmentTemplate: segmentTemplate{
+				Timescale:      track.Timescale,
+				Initialization: SegmentBase + initName,
+				Media:          fmt.Sprintf("%sseg_%d_$Number%%05d$.m4s", SegmentBase, track.ID),
+				StartNumber:    0,
+				Timeline:       timeline,
+			},
+			Representation: representation{
+				ID:     fmt.Sprintf("%d", track.ID),
+				Codecs: track.CodecString(),
+			},
+		}
+		if trackDuration > 0 {
+			set.Representation.Bandwidth = int64(float64(totalBytes*8) / trackDuration)
+		}
+		if track.IsVideo() {
+			set.ContentType = "video"
+			set.MimeType = "video/mp4"
+			set.Representation.Width, set.Representation.Height = track.Dimensions()
+		}
+		manifest.Period.AdaptationSets = append(manifest.Period.AdaptationSets, set)
+	}
+
+	out, err := xml.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %v", err)
+	}
+	out = append([]byte(xml.Header), append(out, '\n')...)
+	if err := os.WriteFile(filepath.Join(dir, Manifest), out, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+	return nil
+}
+
+// appendTimeline adds a segment to the timeline, folding it into the last
+// entry when it follows on with the same duration.
+func appendTimeline(timeline []timelineEntry, start, duration uint64) []timelineEntry {
+	if n := len(timeline); n > 0 {
+		last := &timeline[n-1]
+		if last.D == duration && last.T+uint64(last.R+1)*last.D == start {
+			last.R++
+			return timeline
+		}
+	}
+	return append(timeline, timelineEntry{T: start, D: duration})
+}
+
+func writeSegment(dir, name string, data, dataKey []byte, videoID string) error {
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create segment: %v", err)
+	}
+	defer file.Close()
+
+	ew, err := utils.NewEncryptWriter(file, dataKey, SegmentAAD(videoID, name))
+	if err != nil {
+		return err
+	}
+	if _, err := ew.Write(data); err != nil {
+		return fmt.Errorf("failed to write segment: %v", err)
+	}
+	if err := ew.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"database/sql"
+
+	"secure-video-api/internal/models"
+)
+
+// VisibleVideosFilter returns an SQL condition, and its arguments, matching
+// the rows of videos (aliased v) that a user can see: every video for users
+// with videos:manage, otherwise their own uploads, videos visible to all
+// users and restricted videos whose ACL grants access to them or to one of
+// their groups.
+func VisibleVideosFilter(userID string, access *models.UserAccess) (string, []any) {
+	if access != nil && access.Can(models.PermVideosManage) {
+		return "1 = 1", nil
+	}
+	return `(v.uploaded_by = ?
+		OR v.visibility = ?
+		OR (v.visibility = ? AND EXISTS (
+			SELECT 1 FROM video_acl a
+			WHERE a.video_id = v.id AND (
+				(a.subject_type = ? AND a.subject_id = ?)
+				OR (a.subject_type = ? AND a.subject_id IN (SELECT group_id FROM group_members WHERE user_id = ?))
+			)
+		)))`,
+		[]any{
+			userID, models.VisibilityAllUsers, models.VisibilityRestricted,
+			models.ACLSubjectUser, userID, models.ACLSubjectGroup, userID,
+		}
+}
+
+// CanViewVideo reports whether a video exists and the user can see it.
+func CanViewVideo(q Querier, videoID, userID string, access *models.UserAccess) (bool, error) {
+	filter, args := VisibleVideosFilter(userID, access)
+	var visible bool
+	err := q.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM videos v WHERE v.id = ? AND "+filter+")",
+		append([]any{videoID}, args...)...,
+	).Scan(&visible)
+	return visible, err
+}
+
+// ListVideoGrants returns the ACL of a video, oldest grant first.
+func ListVideoGrants(q Querier, videoID string) ([]models.VideoGrant, error) {
+	rows, err := q.Query(`
+		SELECT subject_type, subject_id, granted_by, created_at
+		FROM video_acl
+		WHERE video_id = ?
+		ORDER BY created_at, subject_type, subject_id
+	`, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := []models.VideoGrant{}
+	for rows.Next() {
+		var grant models.VideoGrant
+		var grantedBy sql.NullString
+		if err := rows.Scan(&grant.SubjectType, &grant.SubjectID, &grantedBy, (*Time)(&grant.CreatedAt)); err != nil {
+			return nil, err
+		}
+		grant.GrantedBy = grantedBy.String
+		grants = append(grants, grant)
+	}
+	return grants, rows.Err()
+}
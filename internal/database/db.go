@@ -2,58 +2,91 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 	"os"
 	"time"
 
+	"secure-video-api/internal/models"
+
 	_ "github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
 )
 
 var DB *sql.DB
 
-func InitDB() error {
-	dbPath := os.Getenv("SQLITE_DB_PATH")
+// Database drivers selected by DB_DRIVER
+const (
+	DriverSQLite   = "sqlite3"
+	DriverPostgres = "postgres"
+)
+
+// Driver is the driver DB was opened with.
+var Driver string
+
+// Open opens the database without touching its schema: the SQLite file at
+// SQLITE_DB_PATH, or with DB_DRIVER=postgres the PostgreSQL database at
+// DATABASE_URL.
+func Open() error {
+	Driver = os.Getenv("DB_DRIVER")
 	var err error
-	DB, err = sql.Open("sqlite3", dbPath)
-	if err != nil {
-		return err
+	switch Driver {
+	case "", DriverSQLite:
+		Driver = DriverSQLite
+		DB, err = sql.Open("sqlite3", os.Getenv("SQLITE_DB_PATH"))
+	case DriverPostgres:
+		dsn := os.Getenv("DATABASE_URL")
+		if dsn == "" {
+			return fmt.Errorf("DATABASE_URL is not set")
+		}
+		DB, err = openPostgres(dsn)
+	default:
+		return fmt.Errorf("invalid DB_DRIVER %q (expected sqlite3 or postgres)", Driver)
 	}
+	return err
+}
 
-	// Create users table
-	_, err = DB.Exec(`
-		CREATE TABLE IF NOT EXISTS users (
-			id TEXT PRIMARY KEY,
-			email TEXT UNIQUE NOT NULL,
-			password TEXT NOT NULL,
-			is_admin BOOLEAN DEFAULT FALSE,
-			status TEXT DEFAULT 'active',
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)
-	`)
-	if err != nil {
+// InitDB opens the database, brings its schema up to date and seeds the
+// built-in roles. DB_AUTO_MIGRATE selects what happens to pending
+// migrations: "true" (the default) applies them, "dry-run" logs the
+// statements they would run and "false" leaves them to the migrate command;
+// in the last two cases InitDB fails while migrations are pending.
+func InitDB() error {
+	if err := Open(); err != nil {
 		return err
 	}
 
-	// Create videos table
-	_, err = DB.Exec(`
-		CREATE TABLE IF NOT EXISTS videos (
-			id TEXT PRIMARY KEY,
-			title TEXT NOT NULL,
-			description TEXT,
-			file_name TEXT NOT NULL,
-			uploaded_by TEXT NOT NULL,
-			created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (uploaded_by) REFERENCES users(id)
-		)
-	`)
-	if err != nil {
-		return err
+	mode := os.Getenv("DB_AUTO_MIGRATE")
+	switch mode {
+	case "", "true":
+		applied, err := MigrateUp(0, false)
+		for _, migration := range applied {
+			log.Printf("[Migrate] Applied %04d_%s", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+	case "dry-run", "false":
+		pending, err := MigrateUp(0, true)
+		if err != nil {
+			return err
+		}
+		for _, migration := range pending {
+			log.Printf("[Migrate] Pending %04d_%s", migration.Version, migration.Name)
+			if mode == "dry-run" {
+				for _, statement := range migration.Up {
+					log.Printf("[Migrate]   %s;", statement)
+				}
+			}
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("%d migrations pending; run \"migrate up\" or set DB_AUTO_MIGRATE=true", len(pending))
+		}
+	default:
+		return fmt.Errorf("invalid DB_AUTO_MIGRATE %q (expected true, false or dry-run)", mode)
 	}
 
-	return nil
+	return seedRoles()
 }
 
 func CreateDefaultAdmin() error {
@@ -79,13 +112,16 @@ func CreateDefaultAdmin() error {
 	}
 
 	// Create admin user
-	currentTime := time.Now().Format("2006-01-02 15:04:05")
+	currentTime := FormatTime(time.Now())
 	_, err = DB.Exec(`
 		INSERT INTO users (id, email, password, is_admin, status, created_at, updated_at)
 		VALUES (?, ?, ?, ?, ?, ?, ?)
 	`, generateUUID(), email, string(hashedPassword), true, "active", currentTime, currentTime)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return SetUserRoles(DB, generateUUID(), []string{models.RoleSuperAdmin})
 }
 
 func generateUUID() string {
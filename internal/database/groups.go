@@ -0,0 +1,154 @@
+package database
+
+import (
+	"database/sql"
+
+	"secure-video-api/internal/models"
+)
+
+// ListGroups returns every group with its roles and member count, ordered
+// by name.
+func ListGroups(q Querier) ([]models.Group, error) {
+	rows, err := q.Query(`
+		SELECT g.id, g.name, g.description, g.created_at, g.updated_at,
+			(SELECT COUNT(*) FROM group_members gm WHERE gm.group_id = g.id)
+		FROM groups g
+		ORDER BY g.name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []models.Group{}
+	for rows.Next() {
+		group, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, *group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range groups {
+		if groups[i].Roles, err = GroupRoles(q, groups[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// LoadGroup returns a group with its roles and member count, or
+// sql.ErrNoRows if it does not exist.
+func LoadGroup(q Querier, groupID string) (*models.Group, error) {
+	group, err := scanGroup(q.QueryRow(`
+		SELECT g.id, g.name, g.description, g.created_at, g.updated_at,
+			(SELECT COUNT(*) FROM group_members gm WHERE gm.group_id = g.id)
+		FROM groups g
+		WHERE g.id = ?
+	`, groupID))
+	if err != nil {
+		return nil, err
+	}
+	group.Roles, err = GroupRoles(q, groupID)
+	return group, err
+}
+
+func scanGroup(row interface{ Scan(...any) error }) (*models.Group, error) {
+	var group models.Group
+	err := row.Scan(&group.ID, &group.Name, &group.Description,
+		(*Time)(&group.CreatedAt), (*Time)(&group.UpdatedAt), &group.MemberCount)
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// GroupRoles returns the roles held by members of a group, sorted.
+func GroupRoles(q Querier, groupID string) ([]string, error) {
+	rows, err := q.Query("SELECT role_id FROM group_roles WHERE group_id = ? ORDER BY role_id", groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// SetGroupRoles replaces the roles of a group.
+func SetGroupRoles(q Querier, groupID string, roles []string) error {
+	if err := checkRoles(q, roles); err != nil {
+		return err
+	}
+	if _, err := q.Exec("DELETE FROM group_roles WHERE group_id = ?", groupID); err != nil {
+		return err
+	}
+	for _, role := range uniqueStrings(roles) {
+		if _, err := q.Exec("INSERT INTO group_roles (group_id, role_id) VALUES (?, ?)", groupID, role); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GroupMembers returns the members of a group ordered by email.
+func GroupMembers(q Querier, groupID string) ([]models.GroupMember, error) {
+	rows, err := q.Query(`
+		SELECT u.id, u.email, gm.created_at
+		FROM group_members gm
+		JOIN users u ON u.id = gm.user_id
+		WHERE gm.group_id = ?
+		ORDER BY u.email
+	`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []models.GroupMember{}
+	for rows.Next() {
+		var member models.GroupMember
+		if err := rows.Scan(&member.UserID, &member.Email, (*Time)(&member.AddedAt)); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// DeleteGroup removes a group with its memberships, roles and video
+// grants, returning sql.ErrNoRows if it does not exist.
+func DeleteGroup(q Querier, groupID string) error {
+	for _, query := range []string{
+		"DELETE FROM group_members WHERE group_id = ?",
+		"DELETE FROM group_roles WHERE group_id = ?",
+	} {
+		if _, err := q.Exec(query, groupID); err != nil {
+			return err
+		}
+	}
+	_, err := q.Exec("DELETE FROM video_acl WHERE subject_type = ? AND subject_id = ?", models.ACLSubjectGroup, groupID)
+	if err != nil {
+		return err
+	}
+
+	result, err := q.Exec("DELETE FROM groups WHERE id = ?", groupID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package database_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	database "secure-video-api/internal/database"
+)
+
+// forEachDatabase runs fn against a new SQLite database and, when
+// DATABASE_URL is set, against that PostgreSQL database. Tests may revert
+// every migration, dropping all data: only point DATABASE_URL at a scratch
+// database, e.g. one started for the test run.
+func forEachDatabase(t *testing.T, fn func(t *testing.T)) {
+	t.Run(database.DriverSQLite, func(t *testing.T) {
+		t.Setenv("DB_DRIVER", database.DriverSQLite)
+		t.Setenv("SQLITE_DB_PATH", filepath.Join(t.TempDir(), "test.db"))
+		openDatabase(t)
+		fn(t)
+	})
+	t.Run(database.DriverPostgres, func(t *testing.T) {
+		if os.Getenv("DATABASE_URL") == "" {
+			t.Skip("DATABASE_URL is not set")
+		}
+		t.Setenv("DB_DRIVER", database.DriverPostgres)
+		openDatabase(t)
+		fn(t)
+	})
+}
+
+// openDatabase migrates the database selected by DB_DRIVER and seeds its
+// roles, as the server does on startup, with files stored in a temporary
+// ENCRYPTED_PATH.
+func openDatabase(t *testing.T) {
+	t.Helper()
+	t.Setenv("DB_AUTO_MIGRATE", "true")
+	t.Setenv("ENCRYPTED_PATH", t.TempDir())
+	if err := database.InitDB(); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() {
+		database.DB.Close()
+		database.DB = nil
+	})
+}
+
+func TestMigrations(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T) {
+		all, err := database.Migrations()
+		if err != nil {
+			t.Fatal(err)
+		}
+		reverted, err := database.MigrateDown(len(all), false)
+		if err != nil {
+			t.Fatalf("reverting every migration: %v", err)
+		}
+		if len(reverted) != len(all) {
+			t.Fatalf("reverted %d of %d migrations", len(reverted), len(all))
+		}
+		applied, err := database.MigrateUp(0, false)
+		if err != nil {
+			t.Fatalf("reapplying every migration: %v", err)
+		}
+		if len(applied) != len(all) {
+			t.Fatalf("applied %d of %d migrations", len(applied), len(all))
+		}
+		pending, err := database.MigrateUp(0, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) != 0 {
+			t.Errorf("%d migrations still pending", len(pending))
+		}
+	})
+}
+
+func TestRolesBackfillRerunnable(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T) {
+		all, err := database.Migrations()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := database.MigrateDown(len(all)-7, false); err != nil {
+			t.Fatal(err)
+		}
+		now := database.FormatTime(time.Now())
+		for _, user := range []struct {
+			id      string
+			isAdmin bool
+		}{{"admin", true}, {"user", false}} {
+			if _, err := database.DB.Exec(`
+				INSERT INTO users (id, email, password, is_admin, created_at, updated_at)
+				VALUES (?, ?, 'x', ?, ?, ?)
+			`, user.id, user.id+"@example.com", user.isAdmin, now, now); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// A backfill run by hand, without the migration being recorded, is
+		// run again by the migration
+		if _, err := database.MigrateUp(8, false); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := database.DB.Exec("DELETE FROM schema_migrations WHERE version = 8"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := database.MigrateUp(0, false); err != nil {
+			t.Fatalf("rerunning the roles migration: %v", err)
+		}
+
+		for id, want := range map[string]string{"admin": "super-admin", "user": "viewer"} {
+			var roles []string
+			rows, err := database.DB.Query("SELECT role_id FROM user_roles WHERE user_id = ?", id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for rows.Next() {
+				var role string
+				if err := rows.Scan(&role); err != nil {
+					t.Fatal(err)
+				}
+				roles = append(roles, role)
+			}
+			rows.Close()
+			if len(roles) != 1 || roles[0] != want {
+				t.Errorf("roles of %s = %v, want [%s]", id, roles, want)
+			}
+		}
+	})
+}
+
+// openLegacyDatabase opens a SQLite database migrated up to just before
+// migration 0011 normalised its timestamps.
+func openLegacyDatabase(t *testing.T) {
+	t.Helper()
+	t.Setenv("DB_DRIVER", database.DriverSQLite)
+	t.Setenv("SQLITE_DB_PATH", filepath.Join(t.TempDir(), "test.db"))
+	openDatabase(t)
+	all, err := database.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.MigrateDown(len(all)-10, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// insertLegacyUser stores a user with timestamps as earlier versions wrote
+// them.
+func insertLegacyUser(t *testing.T, id, createdAt, updatedAt string) {
+	t.Helper()
+	_, err := database.DB.Exec(`
+		INSERT INTO users (id, email, password, is_admin, status, created_at, updated_at)
+		VALUES (?, ?, 'x', 0, 'active', ?, ?)
+	`, id, id+"@example.com", createdAt, updatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNormalizeTimestamps(t *testing.T) {
+	t.Setenv("LEGACY_TIMEZONE", "Asia/Dhaka")
+	openLegacyDatabase(t)
+	insertLegacyUser(t, "legacy", "2025-05-26 13:44:09", "2025-05-26T15:09:03+06:00")
+	if _, err := database.MigrateUp(0, false); err != nil {
+		t.Fatal(err)
+	}
+
+	var createdAt, updatedAt string
+	err := database.DB.QueryRow("SELECT CAST(created_at AS TEXT), CAST(updated_at AS TEXT) FROM users WHERE id = 'legacy'").
+		Scan(&createdAt, &updatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if createdAt != "2025-05-26T07:44:09Z" {
+		t.Errorf("zone-less created_at = %s, want it read in LEGACY_TIMEZONE", createdAt)
+	}
+	if updatedAt != "2025-05-26T09:09:03Z" {
+		t.Errorf("updated_at with an offset = %s, want the same instant in UTC", updatedAt)
+	}
+}
+
+func TestNormalizeTimestampsRejectsInvalid(t *testing.T) {
+	openLegacyDatabase(t)
+	insertLegacyUser(t, "valid", "2025-05-26 13:44:09", "2025-05-26 13:44:09")
+	insertLegacyUser(t, "invalid", "26/05/2025", "2025-05-26 13:44:09")
+
+	// A value every read would reject stops the migration, which names it
+	// and leaves the database as it was
+	_, err := database.MigrateUp(0, false)
+	if err == nil {
+		t.Fatal("migration accepted a timestamp that does not parse")
+	}
+	if !strings.Contains(err.Error(), `users.created_at "26/05/2025" (rowid 2)`) {
+		t.Errorf("error does not name the invalid timestamp: %v", err)
+	}
+	var createdAt string
+	if err := database.DB.QueryRow("SELECT CAST(created_at AS TEXT) FROM users WHERE id = 'valid'").Scan(&createdAt); err != nil {
+		t.Fatal(err)
+	}
+	if createdAt != "2025-05-26 13:44:09" {
+		t.Errorf("failed migration rewrote created_at to %s", createdAt)
+	}
+
+	// Once it is fixed by hand the migration goes through
+	if _, err := database.DB.Exec("UPDATE users SET created_at = '2025-05-26 00:00:00' WHERE id = 'invalid'"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.MigrateUp(0, false); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,283 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema changes are SQL files embedded from migrations/sqlite/ and
+// migrations/postgres/, named <version>_<name>.up.sql and
+// <version>_<name>.down.sql. Both drivers have the same migrations, each
+// written in the driver's dialect. Each migration runs in its own
+// transaction and is recorded in schema_migrations.
+//
+// SQLite databases created before migrations existed were upgraded in place
+// by InitDB, so migrations are written to be re-runnable against them:
+// tables and indexes are created IF NOT EXISTS, and on SQLite ADD COLUMN
+// statements for columns that are already there are skipped.
+
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+// migrationSteps finish the migrations whose change SQL cannot express, by
+// driver and version. A step runs after the migration's up statements, in
+// the same transaction.
+var migrationSteps = map[string]map[int]func(tx *sql.Tx) error{
+	DriverSQLite: {11: normalizeTimestamps},
+}
+
+var (
+	migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+	addColumn     = regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+(\w+)\s+ADD\s+COLUMN\s+(\w+)`)
+)
+
+// Migration is a versioned schema change.
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string // statements applying the change
+	Down    []string // statements reverting it
+}
+
+// MigrationStatus is a migration and when it was applied, if it was.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrations returns the embedded migrations for Driver ordered by version.
+func Migrations() ([]Migration, error) {
+	dir := "migrations/sqlite"
+	if Driver == DriverPostgres {
+		dir = "migrations/postgres"
+	}
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		data, err := migrationFiles.ReadFile(dir + "/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names (%s and %s)", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = splitStatements(string(data))
+		} else {
+			migration.Down = splitStatements(string(data))
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == nil {
+			return nil, fmt.Errorf("migration %d (%s) has no up file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// splitStatements splits a migration file into statements, dropping
+// comment lines. Statements end with a semicolon at the end of a line.
+func splitStatements(script string) []string {
+	var lines []string
+	for _, line := range strings.Split(script, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+
+	statements := []string{}
+	for _, statement := range strings.Split(strings.Join(lines, "\n"), ";\n") {
+		statement = strings.TrimSuffix(strings.TrimSpace(statement), ";")
+		if statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+// MigrationStatuses returns every migration with the time it was applied.
+func MigrationStatuses() ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, migration := range migrations {
+		statuses[i].Migration = migration
+		if appliedAt, ok := applied[migration.Version]; ok {
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+	return statuses, nil
+}
+
+// appliedMigrations returns the versions recorded in schema_migrations,
+// creating the table if needed.
+func appliedMigrations() (map[int]time.Time, error) {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TEXT NOT NULL
+		)
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, (*Time)(&appliedAt)); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// MigrateUp applies the pending migrations up to and including version
+// target (every pending migration if target is 0), oldest first, and
+// returns them. With dryRun set, it only returns what it would apply.
+func MigrateUp(target int, dryRun bool) ([]Migration, error) {
+	statuses, err := MigrationStatuses()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, status := range statuses {
+		if status.AppliedAt == nil && (target == 0 || status.Version <= target) {
+			pending = append(pending, status.Migration)
+		}
+	}
+	if dryRun {
+		return pending, nil
+	}
+
+	for i, migration := range pending {
+		if err := runMigration(migration, true); err != nil {
+			return pending[:i], fmt.Errorf("migration %d (%s) failed: %v", migration.Version, migration.Name, err)
+		}
+	}
+	return pending, nil
+}
+
+// MigrateDown reverts the last steps applied migrations, newest first, and
+// returns them. With dryRun set, it only returns what it would revert.
+func MigrateDown(steps int, dryRun bool) ([]Migration, error) {
+	statuses, err := MigrationStatuses()
+	if err != nil {
+		return nil, err
+	}
+
+	var reverting []Migration
+	for i := len(statuses) - 1; i >= 0 && len(reverting) < steps; i-- {
+		if statuses[i].AppliedAt != nil {
+			reverting = append(reverting, statuses[i].Migration)
+		}
+	}
+	if dryRun {
+		return reverting, nil
+	}
+
+	for i, migration := range reverting {
+		if migration.Down == nil {
+			return reverting[:i], fmt.Errorf("migration %d (%s) cannot be reverted", migration.Version, migration.Name)
+		}
+		if err := runMigration(migration, false); err != nil {
+			return reverting[:i], fmt.Errorf("reverting migration %d (%s) failed: %v", migration.Version, migration.Name, err)
+		}
+	}
+	return reverting, nil
+}
+
+// runMigration applies or reverts a migration and records it, in one
+// transaction.
+func runMigration(migration Migration, up bool) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := migration.Down
+	if up {
+		statements = migration.Up
+	}
+	for _, statement := range statements {
+		if match := addColumn.FindStringSubmatch(statement); match != nil && Driver == DriverSQLite {
+			exists, err := columnExists(tx, match[1], match[2])
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+		}
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("%v in %q", err, statement)
+		}
+	}
+	if step := migrationSteps[Driver][migration.Version]; up && step != nil {
+		if err := step(tx); err != nil {
+			return err
+		}
+	}
+
+	if up {
+		_, err = tx.Exec(
+			"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+			migration.Version, migration.Name, FormatTime(time.Now()),
+		)
+	} else {
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func columnExists(tx *sql.Tx, table, column string) (bool, error) {
+	var exists bool
+	err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM pragma_table_info(?) WHERE name = ?)", table, column).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	email TEXT UNIQUE NOT NULL,
+	password TEXT NOT NULL,
+	is_admin BOOLEAN DEFAULT FALSE,
+	status TEXT DEFAULT 'active',
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Videos outlive the account that uploaded them, as they do on SQLite, which
+-- does not enforce foreign keys; uploaded_by is therefore not one
+CREATE TABLE IF NOT EXISTS videos (
+	id TEXT PRIMARY KEY,
+	title TEXT NOT NULL,
+	description TEXT,
+	file_name TEXT NOT NULL,
+	uploaded_by TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
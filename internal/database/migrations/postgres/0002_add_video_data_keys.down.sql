@@ -0,0 +1,2 @@
+ALTER TABLE videos DROP COLUMN key_version;
+ALTER TABLE videos DROP COLUMN wrapped_key;
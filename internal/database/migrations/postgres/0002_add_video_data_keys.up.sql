@@ -0,0 +1,5 @@
+-- Per-video data key, wrapped by the master key
+ALTER TABLE videos ADD COLUMN wrapped_key TEXT;
+
+-- Version of the master key that wrapped the data key
+ALTER TABLE videos ADD COLUMN key_version INTEGER NOT NULL DEFAULT 1;
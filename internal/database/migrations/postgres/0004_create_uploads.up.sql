@@ -0,0 +1,16 @@
+-- Resumable (tus) uploads in progress; like videos they are kept when their
+-- creator's account is deleted
+CREATE TABLE IF NOT EXISTS uploads (
+	id TEXT PRIMARY KEY,
+	upload_length BIGINT NOT NULL,
+	upload_offset BIGINT NOT NULL DEFAULT 0,
+	staged_size BIGINT NOT NULL DEFAULT 0,
+	staged_records INTEGER NOT NULL DEFAULT 0,
+	metadata TEXT,
+	wrapped_key TEXT NOT NULL,
+	key_version INTEGER NOT NULL,
+	video_id TEXT,
+	created_by TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
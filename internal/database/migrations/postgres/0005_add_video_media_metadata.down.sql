@@ -0,0 +1,8 @@
+ALTER TABLE videos DROP COLUMN file_size;
+ALTER TABLE videos DROP COLUMN bitrate;
+ALTER TABLE videos DROP COLUMN audio_codec;
+ALTER TABLE videos DROP COLUMN video_codec;
+ALTER TABLE videos DROP COLUMN height;
+ALTER TABLE videos DROP COLUMN width;
+ALTER TABLE videos DROP COLUMN duration;
+ALTER TABLE videos DROP COLUMN mime_type;
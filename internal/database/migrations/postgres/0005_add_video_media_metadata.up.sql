@@ -0,0 +1,12 @@
+-- Content type detected at upload
+ALTER TABLE videos ADD COLUMN mime_type TEXT;
+
+-- Technical metadata read from the container at upload (duration in
+-- seconds, bitrate in bits per second, file size in bytes)
+ALTER TABLE videos ADD COLUMN duration DOUBLE PRECISION;
+ALTER TABLE videos ADD COLUMN width INTEGER;
+ALTER TABLE videos ADD COLUMN height INTEGER;
+ALTER TABLE videos ADD COLUMN video_codec TEXT;
+ALTER TABLE videos ADD COLUMN audio_codec TEXT;
+ALTER TABLE videos ADD COLUMN bitrate BIGINT;
+ALTER TABLE videos ADD COLUMN file_size BIGINT;
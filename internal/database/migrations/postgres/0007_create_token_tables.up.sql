@@ -0,0 +1,21 @@
+-- Refresh tokens are stored as SHA-256 hashes and rotated on use, each
+-- rotation staying in the family of the login that started it
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	family_id TEXT NOT NULL,
+	token_hash TEXT UNIQUE NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	revoked_at TIMESTAMPTZ,
+	replaced_by TEXT,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user ON refresh_tokens (user_id);
+
+-- Access tokens revoked before they expire, by JWT ID
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+	jti TEXT PRIMARY KEY,
+	expires_at TIMESTAMPTZ NOT NULL
+);
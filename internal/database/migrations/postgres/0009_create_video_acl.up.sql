@@ -0,0 +1,17 @@
+-- Who can see a video besides its uploader and video managers; videos
+-- stored before visibility existed stay visible to all users
+ALTER TABLE videos ADD COLUMN visibility TEXT NOT NULL DEFAULT 'all-users';
+
+-- Grants of view rights on restricted videos to a user or a group
+-- (subject_type "user" or "group")
+CREATE TABLE IF NOT EXISTS video_acl (
+	video_id TEXT NOT NULL,
+	subject_type TEXT NOT NULL,
+	subject_id TEXT NOT NULL,
+	granted_by TEXT,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (video_id, subject_type, subject_id),
+	FOREIGN KEY (video_id) REFERENCES videos(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_video_acl_subject ON video_acl (subject_type, subject_id);
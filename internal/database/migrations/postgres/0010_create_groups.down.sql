@@ -0,0 +1,5 @@
+DELETE FROM video_acl WHERE subject_type = 'group';
+DROP TABLE IF EXISTS group_roles;
+DROP INDEX IF EXISTS idx_group_members_user;
+DROP TABLE IF EXISTS group_members;
+DROP TABLE IF EXISTS groups;
@@ -0,0 +1,4 @@
+-- TIMESTAMPTZ columns store instants rather than text, so there is nothing
+-- to normalise. Zone-less values were read in the session zone, UTC, when
+-- they were written and can no longer be told apart. Kept so that both
+-- drivers have the same migrations.
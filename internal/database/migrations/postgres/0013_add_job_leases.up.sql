@@ -0,0 +1,5 @@
+-- The worker running a processing job and when its claim lapses unless
+-- renewed. Jobs whose lease has lapsed are requeued; jobs left processing
+-- before leases existed have none and are requeued too.
+ALTER TABLE jobs ADD COLUMN claimed_by TEXT;
+ALTER TABLE jobs ADD COLUMN lease_until TIMESTAMPTZ;
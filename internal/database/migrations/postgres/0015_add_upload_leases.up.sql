@@ -0,0 +1,5 @@
+-- The request writing to a resumable upload and when its claim lapses
+-- unless renewed. Requests on any replica take the lease before touching the
+-- staging file, so their writes never interleave.
+ALTER TABLE uploads ADD COLUMN lease_holder TEXT;
+ALTER TABLE uploads ADD COLUMN lease_until TIMESTAMPTZ;
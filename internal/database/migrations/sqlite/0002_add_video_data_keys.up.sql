@@ -0,0 +1,7 @@
+-- Per-video data key, wrapped by the master key. NULL for videos encrypted
+-- directly with the master key before envelope encryption.
+ALTER TABLE videos ADD COLUMN wrapped_key TEXT;
+
+-- Version of the master key that wrapped the data key (or, for legacy
+-- videos, that encrypted the file)
+ALTER TABLE videos ADD COLUMN key_version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,20 @@
+-- Background video processing
+CREATE TABLE IF NOT EXISTS jobs (
+	id TEXT PRIMARY KEY,
+	type TEXT NOT NULL,
+	video_id TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'queued',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL DEFAULT 3,
+	last_error TEXT,
+	run_at TEXT NOT NULL,
+	created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	finished_at TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_status_run_at ON jobs (status, run_at);
+
+-- Processing state mirrored from the video's job; videos stored before the
+-- job queue existed are ready
+ALTER TABLE videos ADD COLUMN status TEXT NOT NULL DEFAULT 'ready';
@@ -0,0 +1,16 @@
+-- Resumable (tus) uploads in progress
+CREATE TABLE IF NOT EXISTS uploads (
+	id TEXT PRIMARY KEY,
+	upload_length INTEGER NOT NULL,
+	upload_offset INTEGER NOT NULL DEFAULT 0,
+	staged_size INTEGER NOT NULL DEFAULT 0,
+	staged_records INTEGER NOT NULL DEFAULT 0,
+	metadata TEXT,
+	wrapped_key TEXT NOT NULL,
+	key_version INTEGER NOT NULL,
+	video_id TEXT,
+	created_by TEXT NOT NULL,
+	created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (created_by) REFERENCES users(id)
+);
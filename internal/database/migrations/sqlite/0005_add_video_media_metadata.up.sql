@@ -0,0 +1,14 @@
+-- Content type detected at upload; NULL for videos stored before content
+-- sniffing, which fall back to their file extension
+ALTER TABLE videos ADD COLUMN mime_type TEXT;
+
+-- Technical metadata read from the container at upload (duration in
+-- seconds, bitrate in bits per second, file size in bytes); NULL for older
+-- videos
+ALTER TABLE videos ADD COLUMN duration REAL;
+ALTER TABLE videos ADD COLUMN width INTEGER;
+ALTER TABLE videos ADD COLUMN height INTEGER;
+ALTER TABLE videos ADD COLUMN video_codec TEXT;
+ALTER TABLE videos ADD COLUMN audio_codec TEXT;
+ALTER TABLE videos ADD COLUMN bitrate INTEGER;
+ALTER TABLE videos ADD COLUMN file_size INTEGER;
@@ -0,0 +1,3 @@
+-- AES-128 key of the HLS segments, sealed with the video's data key; NULL
+-- for videos without an HLS rendition
+ALTER TABLE videos ADD COLUMN hls_key TEXT;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS revoked_tokens;
+DROP INDEX IF EXISTS idx_refresh_tokens_user;
+DROP TABLE IF EXISTS refresh_tokens;
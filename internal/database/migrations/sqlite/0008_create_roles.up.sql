@@ -0,0 +1,35 @@
+-- Role-based access control: roles grant permissions and users hold roles.
+-- The built-in roles and their permissions are seeded at startup.
+CREATE TABLE IF NOT EXISTS roles (
+	id TEXT PRIMARY KEY,
+	description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+	id TEXT PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+	role_id TEXT NOT NULL,
+	permission_id TEXT NOT NULL,
+	PRIMARY KEY (role_id, permission_id),
+	FOREIGN KEY (role_id) REFERENCES roles(id),
+	FOREIGN KEY (permission_id) REFERENCES permissions(id)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+	user_id TEXT NOT NULL,
+	role_id TEXT NOT NULL,
+	PRIMARY KEY (user_id, role_id),
+	FOREIGN KEY (user_id) REFERENCES users(id),
+	FOREIGN KEY (role_id) REFERENCES roles(id)
+);
+
+-- Existing users get the role matching their is_admin flag: admins had full
+-- access before roles existed. This runs once, so roles an admin removes
+-- later are not handed back. Roles a user already holds, e.g. after a partial
+-- manual run, are skipped.
+INSERT INTO roles (id) VALUES ('super-admin') ON CONFLICT DO NOTHING;
+INSERT INTO roles (id) VALUES ('viewer') ON CONFLICT DO NOTHING;
+INSERT OR IGNORE INTO user_roles (user_id, role_id)
+SELECT id, CASE WHEN is_admin THEN 'super-admin' ELSE 'viewer' END FROM users;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_video_acl_subject;
+DROP TABLE IF EXISTS video_acl;
+ALTER TABLE videos DROP COLUMN visibility;
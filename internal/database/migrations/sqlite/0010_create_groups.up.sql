@@ -0,0 +1,27 @@
+-- Members of a group hold the group's roles and the video grants made to it
+CREATE TABLE IF NOT EXISTS groups (
+	id TEXT PRIMARY KEY,
+	name TEXT UNIQUE NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS group_members (
+	group_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (group_id, user_id),
+	FOREIGN KEY (group_id) REFERENCES groups(id),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_members_user ON group_members (user_id);
+
+CREATE TABLE IF NOT EXISTS group_roles (
+	group_id TEXT NOT NULL,
+	role_id TEXT NOT NULL,
+	PRIMARY KEY (group_id, role_id),
+	FOREIGN KEY (group_id) REFERENCES groups(id),
+	FOREIGN KEY (role_id) REFERENCES roles(id)
+);
@@ -0,0 +1 @@
+-- The original formats are not restored; every version reads UTC RFC 3339
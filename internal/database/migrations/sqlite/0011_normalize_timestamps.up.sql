@@ -0,0 +1,12 @@
+-- Rewrite every timestamp as UTC RFC 3339 text. This is done by the
+-- migration's Go step (normalizeTimestamps), since it needs time zones.
+--
+-- Earlier writes used RFC 3339 with a local offset, which keeps its instant,
+-- and "YYYY-MM-DD HH:MM:SS" without a zone. The server wrote nearly all
+-- zone-less values from time.Now() in its own zone, so they are read in
+-- LEGACY_TIMEZONE (an IANA name), or in the server's local zone if that is
+-- unset: set it before migrating if the database was written on a machine
+-- in another zone. The exception is accounts that self-registered before
+-- migrations existed, whose created_at came from SQLite's CURRENT_TIMESTAMP
+-- in UTC and shifts by the zone's offset. Values that do not parse are left
+-- as they are.
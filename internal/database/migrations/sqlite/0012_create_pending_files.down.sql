@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_pending_files_video;
+DROP TABLE IF EXISTS pending_files;
@@ -0,0 +1,13 @@
+-- Files under ENCRYPTED_PATH being added or removed alongside a change to
+-- their video's row (action "create" or "remove", path relative to
+-- ENCRYPTED_PATH). The row's transaction drops "create" entries and adds
+-- "remove" ones, so an entry left behind names an unreferenced file.
+CREATE TABLE IF NOT EXISTS pending_files (
+	id TEXT PRIMARY KEY,
+	video_id TEXT NOT NULL,
+	action TEXT NOT NULL,
+	path TEXT NOT NULL,
+	created_at TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_pending_files_video ON pending_files (video_id, action);
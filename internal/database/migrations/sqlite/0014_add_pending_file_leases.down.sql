@@ -0,0 +1,2 @@
+ALTER TABLE pending_files DROP COLUMN lease_until;
+ALTER TABLE pending_files DROP COLUMN owner;
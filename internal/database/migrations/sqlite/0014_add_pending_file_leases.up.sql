@@ -0,0 +1,5 @@
+-- The process that staged a "create" entry and when its claim lapses
+-- unless renewed. Recover only sweeps entries whose lease has lapsed;
+-- entries journaled before leases existed have none and are swept by age.
+ALTER TABLE pending_files ADD COLUMN owner TEXT;
+ALTER TABLE pending_files ADD COLUMN lease_until TEXT;
@@ -0,0 +1,2 @@
+ALTER TABLE uploads DROP COLUMN lease_until;
+ALTER TABLE uploads DROP COLUMN lease_holder;
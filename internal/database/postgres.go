@@ -0,0 +1,111 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// openPostgres connects to the PostgreSQL database at dsn. Queries are
+// written with SQLite's ? placeholders, which are rewritten to $1, $2, ...
+// so that the same SQL runs on both databases. Sessions use UTC, matching
+// how SQLite reads timestamps stored without a zone.
+func openPostgres(dsn string) (*sql.DB, error) {
+	cfg, err := pq.NewConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Runtime == nil {
+		cfg.Runtime = make(map[string]string)
+	}
+	if _, ok := cfg.Runtime["timezone"]; !ok {
+		cfg.Runtime["timezone"] = "UTC"
+	}
+	connector, err := pq.NewConnectorConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(postgresConnector{connector}), nil
+}
+
+type postgresConnector struct {
+	*pq.Connector
+}
+
+func (c postgresConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return postgresConn{conn}, nil
+}
+
+// postgresConn rewrites the placeholders of every query run on a pq
+// connection.
+type postgresConn struct {
+	driver.Conn
+}
+
+func (c postgresConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(rebind(query))
+}
+
+func (c postgresConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.Conn.(driver.ConnPrepareContext).PrepareContext(ctx, rebind(query))
+}
+
+func (c postgresConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.Conn.(driver.QueryerContext).QueryContext(ctx, rebind(query), args)
+}
+
+func (c postgresConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, rebind(query), args)
+}
+
+func (c postgresConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c postgresConn) CheckNamedValue(value *driver.NamedValue) error {
+	return c.Conn.(driver.NamedValueChecker).CheckNamedValue(value)
+}
+
+func (c postgresConn) ResetSession(ctx context.Context) error {
+	return c.Conn.(driver.SessionResetter).ResetSession(ctx)
+}
+
+func (c postgresConn) IsValid() bool {
+	return c.Conn.(driver.Validator).IsValid()
+}
+
+// rebind replaces the ? placeholders of query with PostgreSQL's numbered
+// ones, leaving quoted strings and identifiers alone.
+func rebind(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	var quote rune
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
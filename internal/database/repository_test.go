@@ -0,0 +1,696 @@
+package database_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	database "secure-video-api/internal/database"
+	"secure-video-api/internal/jobs"
+	"secure-video-api/internal/models"
+	"secure-video-api/internal/repository"
+	"secure-video-api/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// allow authorizes every change.
+func allow[T any](T) error { return nil }
+
+// createUser stores a user with a unique email, holding roles.
+func createUser(t *testing.T, users repository.UserRepository, roles ...string) *models.User {
+	t.Helper()
+	user := &models.User{
+		ID:       uuid.New().String(),
+		Email:    "test-" + uuid.New().String() + "@example.invalid",
+		Password: "not-a-hash",
+	}
+	if err := users.Create(user, roles); err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	return user
+}
+
+// createVideo stores a video uploaded by uploadedBy, marked ready.
+func createVideo(t *testing.T, videos repository.VideoRepository, uploadedBy, visibility string) string {
+	t.Helper()
+	id := uuid.New().String()
+	video := &models.Video{ID: id, Title: "test", FileName: id + ".mp4", UploadedBy: uploadedBy, Visibility: visibility}
+	if _, err := videos.Create(video); err != nil {
+		t.Fatalf("creating video: %v", err)
+	}
+	if _, err := database.DB.Exec("UPDATE videos SET status = ? WHERE id = ?", jobs.StatusReady, id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// pendingFiles counts the journaled files of a video.
+func pendingFiles(t *testing.T, videoID string) int {
+	t.Helper()
+	var n int
+	if err := database.DB.QueryRow("SELECT COUNT(*) FROM pending_files WHERE video_id = ?", videoID).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestUserRepository(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T) {
+		users := repository.NewSQL(database.DB).Users
+		user := createUser(t, users, models.RoleUploader)
+
+		err := users.Create(&models.User{ID: uuid.New().String(), Email: "x" + user.Email}, []string{"no-such-role"})
+		if !errors.Is(err, database.ErrUnknownRole) {
+			t.Errorf("creating user with unknown role: got %v, want %v", err, database.ErrUnknownRole)
+		}
+		if err := users.Create(&models.User{ID: uuid.New().String(), Email: user.Email}, []string{models.RoleViewer}); err == nil {
+			t.Error("duplicate email accepted")
+		}
+
+		found, err := users.FindByEmail(user.Email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.ID != user.ID || found.Password != user.Password || !found.IsAdmin {
+			t.Errorf("FindByEmail = %+v", found)
+		}
+		listed, err := users.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !containsUser(listed, user.ID) {
+			t.Errorf("user %s not listed", user.ID)
+		}
+
+		if err := users.SetStatus(user.ID, models.UserStatusInactive); err != nil {
+			t.Fatal(err)
+		}
+		if found, err = users.Get(user.ID); err != nil || found.Status != models.UserStatusInactive {
+			t.Errorf("deactivated user = %+v, %v", found, err)
+		}
+		if err := users.SetStatus(uuid.New().String(), models.UserStatusActive); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("SetStatus of missing user: got %v", err)
+		}
+
+		if err := users.Delete(user.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := users.Get(user.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Get of deleted user: got %v", err)
+		}
+	})
+}
+
+func TestUserRoles(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T) {
+		users := repository.NewSQL(database.DB).Users
+		user := createUser(t, users, models.RoleViewer)
+
+		access, err := users.Access(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if access.IsAdmin || !access.Can(models.PermVideosView) || access.Can(models.PermVideosUpload) {
+			t.Errorf("viewer access = %+v", access)
+		}
+
+		refused := errors.New("refused")
+		err = users.SetRoles(user.ID, []string{models.RoleUploader}, func(*models.UserAccess) error { return refused })
+		if err != refused {
+			t.Errorf("refused SetRoles: got %v", err)
+		}
+		err = users.SetRoles(user.ID, []string{"no-such-role"}, allow[*models.UserAccess])
+		if !errors.Is(err, database.ErrUnknownRole) {
+			t.Errorf("SetRoles with unknown role: got %v", err)
+		}
+		err = users.SetRoles(uuid.New().String(), []string{models.RoleViewer}, allow[*models.UserAccess])
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("SetRoles of missing user: got %v", err)
+		}
+		if access, _ := users.Access(user.ID); access.Can(models.PermVideosUpload) {
+			t.Error("failed role changes were applied")
+		}
+
+		if err := users.SetRoles(user.ID, []string{models.RoleUploader}, allow[*models.UserAccess]); err != nil {
+			t.Fatal(err)
+		}
+		if access, err = users.Access(user.ID); err != nil || !access.IsAdmin || !access.Can(models.PermVideosUpload) {
+			t.Errorf("uploader access = %+v, %v", access, err)
+		}
+	})
+}
+
+func TestVideoRepository(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T) {
+		repos := repository.NewSQL(database.DB)
+		user := createUser(t, repos.Users, models.RoleUploader)
+
+		video := &models.Video{
+			ID:         uuid.New().String(),
+			Title:      "test",
+			FileName:   "test.mp4",
+			UploadedBy: user.ID,
+			KeyVersion: 1,
+			MIMEType:   "video/mp4",
+			Duration:   12.5,
+			Width:      1280,
+			Height:     720,
+			FileSize:   1 << 20,
+			Visibility: models.VisibilityPrivate,
+		}
+		if err := repos.Videos.StageFile(video.ID, video.FileName+".enc"); err != nil {
+			t.Fatal(err)
+		}
+		jobID, err := repos.Videos.Create(video)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := pendingFiles(t, video.ID); n != 0 {
+			t.Errorf("%d files still pending after create", n)
+		}
+		job, err := repos.Jobs.Get(jobID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.VideoID != video.ID || job.Type != jobs.TypeProcessVideo || job.Status != jobs.StatusQueued {
+			t.Errorf("job = %+v, want the queued job of the video", job)
+		}
+		if _, err := repos.Jobs.Get(uuid.New().String()); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Get of missing job: got %v", err)
+		}
+
+		stored, err := repos.Videos.Get(video.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Status != jobs.StatusQueued || stored.Duration != video.Duration ||
+			stored.Width != video.Width || stored.FileSize != video.FileSize || stored.CreatedAt.IsZero() {
+			t.Errorf("Get = %+v", stored)
+		}
+		visible, err := repos.Videos.ListVisible(user.ID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if containsVideo(visible, video.ID) {
+			t.Error("queued video listed")
+		}
+
+		if err := repos.Videos.UpdateDetails(video.ID, "updated", "description"); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Videos.SetVisibility(video.ID, models.VisibilityAllUsers); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Videos.SetHLSKey(video.ID, "wrapped"); err != nil {
+			t.Fatal(err)
+		}
+		if stored, err = repos.Videos.Get(video.ID); err != nil {
+			t.Fatal(err)
+		}
+		if stored.Title != "updated" || stored.Description != "description" ||
+			stored.Visibility != models.VisibilityAllUsers || stored.HLSKey != "wrapped" {
+			t.Errorf("updated video = %+v", stored)
+		}
+		if err := repos.Videos.UpdateDetails(uuid.New().String(), "", ""); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("UpdateDetails of missing video: got %v", err)
+		}
+		if err := repos.Videos.SetHLSKey(uuid.New().String(), ""); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("SetHLSKey of missing video: got %v", err)
+		}
+
+		// A rendition published by processing is kept while the video exists
+		if err := repos.Videos.StageFile(video.ID, filepath.Join("dash", video.ID)); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Videos.KeepFiles(video.ID); err != nil {
+			t.Fatal(err)
+		}
+		if n := pendingFiles(t, video.ID); n != 0 {
+			t.Errorf("%d files still pending after KeepFiles", n)
+		}
+		if err := repos.Videos.KeepFiles(uuid.New().String()); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("KeepFiles of missing video: got %v", err)
+		}
+
+		dir, err := storage.Dir()
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(dir, video.FileName+".enc")
+		if err := os.WriteFile(file, []byte("encrypted"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Videos.Delete(video.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repos.Videos.Get(video.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Get of deleted video: got %v", err)
+		}
+		if _, err := repos.Jobs.Get(jobID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Get of deleted video's job: got %v", err)
+		}
+		if n := pendingFiles(t, video.ID); n != 0 {
+			t.Errorf("%d files still pending after delete", n)
+		}
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("video file not removed: %v", err)
+		}
+		if err := repos.Videos.Delete(video.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Delete of missing video: got %v", err)
+		}
+	})
+}
+
+func TestReplaceVideoFile(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T) {
+		repos := repository.NewSQL(database.DB)
+		uploader := createUser(t, repos.Users, models.RoleUploader)
+		id := createVideo(t, repos.Videos, uploader.ID, models.VisibilityAllUsers)
+		if err := repos.Videos.SetHLSKey(id, "wrapped"); err != nil {
+			t.Fatal(err)
+		}
+		dir, err := storage.Dir()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// writeStaged stages a new file for the video and writes it
+		writeStaged := func(fileName string) string {
+			t.Helper()
+			if err := repos.Videos.StageFile(id, fileName+".enc"); err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, fileName+".enc")
+			if err := os.WriteFile(path, []byte("encrypted"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			return path
+		}
+		old := filepath.Join(dir, id+".mp4.enc")
+		if err := os.WriteFile(old, []byte("encrypted"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		replacement := writeStaged(id + ".new.mp4")
+		jobID, err := repos.Videos.ReplaceFile(&models.Video{
+			ID: id, FileName: id + ".new.mp4", WrappedKey: "key", KeyVersion: 2, MIMEType: "video/mp4", Duration: 3,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		video, err := repos.Videos.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if video.FileName != id+".new.mp4" || video.WrappedKey != "key" || video.KeyVersion != 2 ||
+			video.Duration != 3 || video.HLSKey != "" || video.Status != jobs.StatusQueued || video.Title != "test" {
+			t.Errorf("replaced video = %+v", video)
+		}
+		if job, err := repos.Jobs.Get(jobID); err != nil || job.VideoID != id || job.Status != jobs.StatusQueued {
+			t.Errorf("job = %+v, %v; want the queued job of the video", job, err)
+		}
+		if n := pendingFiles(t, id); n != 0 {
+			t.Errorf("%d files still pending after replace", n)
+		}
+		if _, err := os.Stat(old); !os.IsNotExist(err) {
+			t.Errorf("old file not removed: %v", err)
+		}
+		if _, err := os.Stat(replacement); err != nil {
+			t.Errorf("new file: %v", err)
+		}
+
+		// A video being processed keeps its file; the caller discards the
+		// new one
+		if _, err := database.DB.Exec("UPDATE videos SET status = ? WHERE id = ?", jobs.StatusProcessing, id); err != nil {
+			t.Fatal(err)
+		}
+		rejected := writeStaged(id + ".other.mp4")
+		if _, err := repos.Videos.ReplaceFile(&models.Video{ID: id, FileName: id + ".other.mp4"}); !errors.Is(err, repository.ErrBusy) {
+			t.Errorf("ReplaceFile while processing: got %v, want ErrBusy", err)
+		}
+		if err := repos.Videos.DiscardFiles(id); err != nil {
+			t.Fatal(err)
+		}
+		if n := pendingFiles(t, id); n != 0 {
+			t.Errorf("%d files still pending after discard", n)
+		}
+		if _, err := os.Stat(rejected); !os.IsNotExist(err) {
+			t.Errorf("rejected file not removed: %v", err)
+		}
+		if video, err := repos.Videos.Get(id); err != nil || video.FileName != id+".new.mp4" {
+			t.Errorf("video after a rejected replace = %+v, %v", video, err)
+		}
+
+		if _, err := repos.Videos.ReplaceFile(&models.Video{ID: uuid.New().String()}); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ReplaceFile of missing video: got %v", err)
+		}
+	})
+}
+
+func TestVideoGrants(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T) {
+		repos := repository.NewSQL(database.DB)
+		uploader := createUser(t, repos.Users, models.RoleUploader)
+		viewer := createUser(t, repos.Users, models.RoleViewer)
+		member := createUser(t, repos.Users, models.RoleViewer)
+		video := createVideo(t, repos.Videos, uploader.ID, models.VisibilityRestricted)
+		group := &models.Group{ID: uuid.New().String(), Name: "test-" + uuid.New().String()}
+		if err := repos.Groups.Create(group); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Groups.AddMember(group.ID, member.ID, allow[*models.Group]); err != nil {
+			t.Fatal(err)
+		}
+
+		canView := func(user *models.User) bool {
+			t.Helper()
+			access, err := repos.Users.Access(user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ok, err := repos.Videos.CanView(video, user.ID, access)
+			if err != nil {
+				t.Fatal(err)
+			}
+			listed, err := repos.Videos.ListVisible(user.ID, access)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if containsVideo(listed, video) != ok {
+				t.Errorf("CanView and ListVisible disagree for %s", user.Email)
+			}
+			return ok
+		}
+		if canView(viewer) || canView(member) {
+			t.Fatal("restricted video visible before any grant")
+		}
+		if !canView(uploader) {
+			t.Error("uploader cannot see their video")
+		}
+
+		grant := func(subjectType, subjectID string) error {
+			return repos.Videos.Grant(video, models.VideoGrant{SubjectType: subjectType, SubjectID: subjectID, GrantedBy: uploader.ID})
+		}
+		if err := grant(models.ACLSubjectUser, uuid.New().String()); !errors.Is(err, repository.ErrUnknownSubject) {
+			t.Errorf("granting a missing user: got %v", err)
+		}
+		if err := grant(models.ACLSubjectGroup, uuid.New().String()); !errors.Is(err, repository.ErrUnknownSubject) {
+			t.Errorf("granting a missing group: got %v", err)
+		}
+		err := repos.Videos.Grant(uuid.New().String(), models.VideoGrant{SubjectType: models.ACLSubjectUser, SubjectID: viewer.ID})
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("granting access to a missing video: got %v", err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := grant(models.ACLSubjectUser, viewer.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := grant(models.ACLSubjectGroup, group.ID); err != nil {
+			t.Fatal(err)
+		}
+		if grants, err := repos.Videos.Grants(video); err != nil || len(grants) != 2 {
+			t.Errorf("Grants = %+v, %v; want 2", grants, err)
+		}
+		if !canView(viewer) || !canView(member) {
+			t.Error("granted users cannot see the video")
+		}
+
+		if err := repos.Videos.Revoke(video, models.ACLSubjectUser, viewer.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Videos.Revoke(video, models.ACLSubjectUser, viewer.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("revoking twice: got %v", err)
+		}
+		if err := repos.Groups.RemoveMember(group.ID, member.ID, allow[*models.Group]); err != nil {
+			t.Fatal(err)
+		}
+		if canView(viewer) || canView(member) {
+			t.Error("video visible after its grants were revoked")
+		}
+	})
+}
+
+func TestGroupRepository(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T) {
+		repos := repository.NewSQL(database.DB)
+		member := createUser(t, repos.Users, models.RoleViewer)
+		name := "test-" + uuid.New().String()
+		group := &models.Group{ID: uuid.New().String(), Name: name}
+		if err := repos.Groups.Create(group); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Groups.Create(&models.Group{ID: uuid.New().String(), Name: name}); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("creating a group with a taken name: got %v", err)
+		}
+		other := &models.Group{ID: uuid.New().String(), Name: "test-" + uuid.New().String()}
+		if err := repos.Groups.Create(other); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Groups.Update(other.ID, name, ""); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("renaming a group to a taken name: got %v", err)
+		}
+		if err := repos.Groups.Update(uuid.New().String(), "test-"+uuid.New().String(), ""); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("renaming a missing group: got %v", err)
+		}
+
+		if err := repos.Groups.AddMember(group.ID, uuid.New().String(), allow[*models.Group]); !errors.Is(err, repository.ErrUnknownSubject) {
+			t.Errorf("adding a missing user: got %v", err)
+		}
+		if err := repos.Groups.AddMember(uuid.New().String(), member.ID, allow[*models.Group]); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("adding to a missing group: got %v", err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := repos.Groups.AddMember(group.ID, member.ID, allow[*models.Group]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := repos.Groups.SetRoles(group.ID, []string{"no-such-role"}, allow[*models.Group]); !errors.Is(err, database.ErrUnknownRole) {
+			t.Errorf("SetRoles with unknown role: got %v", err)
+		}
+		if err := repos.Groups.SetRoles(group.ID, []string{models.RoleUploader}, allow[*models.Group]); err != nil {
+			t.Fatal(err)
+		}
+
+		stored, err := repos.Groups.Get(group.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.MemberCount != 1 || len(stored.Roles) != 1 || stored.Roles[0] != models.RoleUploader {
+			t.Errorf("Get = %+v", stored)
+		}
+		members, err := repos.Groups.Members(group.ID)
+		if err != nil || len(members) != 1 || members[0].UserID != member.ID {
+			t.Errorf("Members = %+v, %v", members, err)
+		}
+		if access, err := repos.Users.Access(member.ID); err != nil || !access.Can(models.PermVideosUpload) {
+			t.Errorf("member access = %+v, %v", access, err)
+		}
+
+		// authorize sees the group as it is inside the change
+		refused := errors.New("refused")
+		err = repos.Groups.RemoveMember(group.ID, member.ID, func(g *models.Group) error {
+			if len(g.Roles) != 1 || g.Roles[0] != models.RoleUploader {
+				t.Errorf("authorize got %+v", g)
+			}
+			return refused
+		})
+		if err != refused {
+			t.Errorf("refused RemoveMember: got %v", err)
+		}
+		if err := repos.Groups.RemoveMember(group.ID, member.ID, allow[*models.Group]); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Groups.RemoveMember(group.ID, member.ID, allow[*models.Group]); !errors.Is(err, repository.ErrNotMember) {
+			t.Errorf("removing a non-member: got %v", err)
+		}
+		if access, err := repos.Users.Access(member.ID); err != nil || access.Can(models.PermVideosUpload) {
+			t.Errorf("access after leaving = %+v, %v", access, err)
+		}
+
+		if err := repos.Groups.Delete(group.ID, allow[*models.Group]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repos.Groups.Get(group.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Get of deleted group: got %v", err)
+		}
+	})
+}
+
+func TestTokenRepository(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T) {
+		repos := repository.NewSQL(database.DB)
+		user := createUser(t, repos.Users, models.RoleViewer)
+		newToken := func() *models.RefreshToken {
+			return &models.RefreshToken{
+				ID:        uuid.New().String(),
+				TokenHash: uuid.New().String(),
+				ExpiresAt: time.Now().Add(time.Hour),
+			}
+		}
+
+		first := newToken()
+		first.UserID, first.FamilyID = user.ID, uuid.New().String()
+		if err := repos.Tokens.CreateRefreshToken(first); err != nil {
+			t.Fatal(err)
+		}
+		second := newToken()
+		if err := repos.Tokens.RotateRefreshToken(first.TokenHash, second); err != nil {
+			t.Fatal(err)
+		}
+		if second.UserID != user.ID || second.FamilyID != first.FamilyID {
+			t.Errorf("rotated token = %+v", second)
+		}
+		if err := repos.Tokens.RotateRefreshToken(uuid.New().String(), newToken()); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("rotating an unknown token: got %v", err)
+		}
+
+		// Reusing the first token ends the family, so the second one is
+		// now refused too
+		if err := repos.Tokens.RotateRefreshToken(first.TokenHash, newToken()); !errors.Is(err, repository.ErrTokenReused) {
+			t.Errorf("reusing a rotated token: got %v", err)
+		}
+		if err := repos.Tokens.RotateRefreshToken(second.TokenHash, newToken()); !errors.Is(err, repository.ErrTokenReused) {
+			t.Errorf("rotating a token of a revoked family: got %v", err)
+		}
+
+		expired := newToken()
+		expired.UserID, expired.FamilyID = user.ID, uuid.New().String()
+		expired.ExpiresAt = time.Now().Add(-time.Minute)
+		if err := repos.Tokens.CreateRefreshToken(expired); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Tokens.RotateRefreshToken(expired.TokenHash, newToken()); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("rotating an expired token: got %v", err)
+		}
+
+		session := newToken()
+		session.UserID, session.FamilyID = user.ID, uuid.New().String()
+		if err := repos.Tokens.CreateRefreshToken(session); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Tokens.RevokeUserRefreshTokens(user.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Tokens.RotateRefreshToken(session.TokenHash, newToken()); !errors.Is(err, repository.ErrTokenReused) {
+			t.Errorf("rotating a revoked token: got %v", err)
+		}
+
+		jti := uuid.New().String()
+		if revoked, err := repos.Tokens.AccessTokenRevoked(jti); err != nil || revoked {
+			t.Errorf("AccessTokenRevoked before revoking = %v, %v", revoked, err)
+		}
+		if err := repos.Tokens.RevokeAccessToken(jti, time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if revoked, err := repos.Tokens.AccessTokenRevoked(jti); err != nil || !revoked {
+			t.Errorf("AccessTokenRevoked after revoking = %v, %v", revoked, err)
+		}
+	})
+}
+
+func TestUploadRepository(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T) {
+		repos := repository.NewSQL(database.DB)
+		user := createUser(t, repos.Users, models.RoleUploader)
+		upload := &models.Upload{
+			ID:         uuid.New().String(),
+			Length:     1 << 20,
+			Metadata:   "filename dGVzdC5tcDQ=",
+			WrappedKey: "wrapped",
+			KeyVersion: 1,
+			CreatedBy:  user.ID,
+		}
+		if err := repos.Uploads.Create(upload); err != nil {
+			t.Fatal(err)
+		}
+
+		// Only one request at a time holds the upload, until it releases it
+		// or its lease lapses
+		until := time.Now().Add(time.Minute)
+		if err := repos.Uploads.Lease(upload.ID, "first", until); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Uploads.Lease(upload.ID, "second", until); !errors.Is(err, repository.ErrLeased) {
+			t.Errorf("leasing a leased upload: got %v, want ErrLeased", err)
+		}
+		upload.Offset, upload.StagedSize, upload.StagedRecords = 4096, 4124, 1
+		if err := repos.Uploads.SetProgress(upload, "second"); !errors.Is(err, repository.ErrLeased) {
+			t.Errorf("SetProgress without the lease: got %v, want ErrLeased", err)
+		}
+		if err := repos.Uploads.SetProgress(upload, "first"); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Uploads.RenewLease(upload.ID, "first", time.Now().Add(-time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Uploads.Lease(upload.ID, "second", until); err != nil {
+			t.Fatalf("leasing an upload whose lease lapsed: %v", err)
+		}
+		if err := repos.Uploads.RenewLease(upload.ID, "first", until); !errors.Is(err, repository.ErrLeased) {
+			t.Errorf("renewing a lease taken over: got %v, want ErrLeased", err)
+		}
+		if err := repos.Uploads.ReleaseLease(upload.ID, "first"); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Uploads.Lease(upload.ID, "third", until); !errors.Is(err, repository.ErrLeased) {
+			t.Errorf("a lost lease released another holder's: got %v", err)
+		}
+		if err := repos.Uploads.ReleaseLease(upload.ID, "second"); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Uploads.RenewLease(upload.ID, "second", until); !errors.Is(err, repository.ErrLeased) {
+			t.Errorf("renewing a released lease: got %v, want ErrLeased", err)
+		}
+		if err := repos.Uploads.Lease(upload.ID, "third", until); err != nil {
+			t.Fatalf("leasing a released upload: %v", err)
+		}
+		if err := repos.Uploads.Lease(uuid.New().String(), "third", until); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("leasing a missing upload: got %v", err)
+		}
+		videoID := uuid.New().String()
+		if err := repos.Uploads.SetVideoID(upload.ID, videoID); err != nil {
+			t.Fatal(err)
+		}
+		stored, err := repos.Uploads.Get(upload.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Length != upload.Length || stored.Offset != 4096 || stored.StagedSize != 4124 ||
+			stored.StagedRecords != 1 || stored.Metadata != upload.Metadata || stored.WrappedKey != "wrapped" ||
+			stored.VideoID != videoID || stored.CreatedBy != user.ID {
+			t.Errorf("Get = %+v", stored)
+		}
+		if err := repos.Uploads.SetVideoID(uuid.New().String(), videoID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("SetVideoID of missing upload: got %v", err)
+		}
+
+		if err := repos.Uploads.Delete(upload.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repos.Uploads.Get(upload.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Get of deleted upload: got %v", err)
+		}
+	})
+}
+
+func containsUser(users []models.User, id string) bool {
+	for _, user := range users {
+		if user.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containsVideo(videos []models.Video, id string) bool {
+	for _, video := range videos {
+		if video.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"secure-video-api/internal/models"
+)
+
+// ErrUnknownRole is returned by SetUserRoles for roles that do not exist.
+var ErrUnknownRole = errors.New("unknown role")
+
+// Querier is implemented by both *sql.DB and *sql.Tx.
+type Querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// seedRoles creates the built-in roles and their permissions. Users are
+// given roles when they are created; existing users got theirs from the
+// migration creating user_roles.
+func seedRoles() error {
+	for _, role := range models.BuiltinRoles {
+		if _, err := DB.Exec("INSERT INTO roles (id, description) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET description = excluded.description", role.ID, role.Description); err != nil {
+			return err
+		}
+		for _, permission := range role.Permissions {
+			if _, err := DB.Exec("INSERT INTO permissions (id) VALUES (?) ON CONFLICT DO NOTHING", permission); err != nil {
+				return err
+			}
+			if _, err := DB.Exec(
+				"INSERT INTO role_permissions (role_id, permission_id) VALUES (?, ?) ON CONFLICT DO NOTHING",
+				role.ID, permission,
+			); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// LoadUserAccess returns the status, roles and permissions of a user, or
+// sql.ErrNoRows if the user does not exist. Roles include those held through
+// the user's groups, and a user with any role beyond viewer counts as an
+// admin.
+func LoadUserAccess(q Querier, userID string) (*models.UserAccess, error) {
+	access := &models.UserAccess{Roles: []string{}, Permissions: []string{}}
+	err := q.QueryRow(
+		"SELECT is_admin, COALESCE(status, ?) FROM users WHERE id = ?",
+		models.UserStatusActive, userID,
+	).Scan(&access.IsAdmin, &access.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(`
+		SELECT r.role_id, rp.permission_id
+		FROM (
+			SELECT role_id FROM user_roles WHERE user_id = ?
+			UNION
+			SELECT gr.role_id FROM group_roles gr
+			JOIN group_members gm ON gm.group_id = gr.group_id
+			WHERE gm.user_id = ?
+		) r
+		LEFT JOIN role_permissions rp ON rp.role_id = r.role_id
+	`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make(map[string]bool)
+	permissions := make(map[string]bool)
+	for rows.Next() {
+		var role string
+		var permission sql.NullString
+		if err := rows.Scan(&role, &permission); err != nil {
+			return nil, err
+		}
+		roles[role] = true
+		if role != models.RoleViewer {
+			access.IsAdmin = true
+		}
+		if permission.Valid {
+			permissions[permission.String] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	access.Roles = sortedKeys(roles)
+	access.Permissions = sortedKeys(permissions)
+	return access, nil
+}
+
+// ListRoles returns every role with its permissions.
+func ListRoles(q Querier) ([]models.Role, error) {
+	rows, err := q.Query(`
+		SELECT r.id, r.description, rp.permission_id
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role_id = r.id
+		ORDER BY r.id, rp.permission_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+	for rows.Next() {
+		var id, description string
+		var permission sql.NullString
+		if err := rows.Scan(&id, &description, &permission); err != nil {
+			return nil, err
+		}
+		if len(roles) == 0 || roles[len(roles)-1].ID != id {
+			roles = append(roles, models.Role{ID: id, Description: description, Permissions: []string{}})
+		}
+		if permission.Valid {
+			last := &roles[len(roles)-1]
+			last.Permissions = append(last.Permissions, permission.String)
+		}
+	}
+	return roles, rows.Err()
+}
+
+// SetUserRoles replaces the roles of a user. is_admin is kept in step for
+// code and clients that still read it: a user with any role beyond viewer
+// counts as an admin.
+func SetUserRoles(q Querier, userID string, roles []string) error {
+	if err := checkRoles(q, roles); err != nil {
+		return err
+	}
+
+	if _, err := q.Exec("DELETE FROM user_roles WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	isAdmin := false
+	for _, role := range uniqueStrings(roles) {
+		if _, err := q.Exec("INSERT INTO user_roles (user_id, role_id) VALUES (?, ?)", userID, role); err != nil {
+			return err
+		}
+		if role != models.RoleViewer {
+			isAdmin = true
+		}
+	}
+	_, err := q.Exec("UPDATE users SET is_admin = ? WHERE id = ?", isAdmin, userID)
+	return err
+}
+
+// checkRoles returns ErrUnknownRole if any of roles does not exist.
+func checkRoles(q Querier, roles []string) error {
+	if len(roles) == 0 {
+		return nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(roles)), ",")
+	args := make([]any, len(roles))
+	for i, role := range roles {
+		args[i] = role
+	}
+	var known int
+	err := q.QueryRow("SELECT COUNT(*) FROM roles WHERE id IN ("+placeholders+")", args...).Scan(&known)
+	if err != nil {
+		return err
+	}
+	if known != len(uniqueStrings(roles)) {
+		return fmt.Errorf("%w in %v", ErrUnknownRole, roles)
+	}
+	return nil
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool)
+	for _, v := range values {
+		seen[v] = true
+	}
+	return sortedKeys(seen)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
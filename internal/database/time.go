@@ -0,0 +1,183 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Timestamps are stored as UTC RFC 3339 text such as "2024-05-01T12:00:00Z",
+// which sorts and compares correctly as a string on SQLite and is accepted
+// by PostgreSQL's TIMESTAMPTZ columns. Every write formats its times with
+// FormatTime and every read scans them into a Time or NullTime.
+
+// FormatTime returns t as it is stored in the database.
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// legacyTimeFormats are the zone-less formats timestamps were written in
+// before migration 0011 normalised them, mostly from time.Now() formatted
+// without its zone. They are read in LegacyLocation.
+var legacyTimeFormats = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+}
+
+// LegacyLocation returns the zone the server wrote zone-less timestamps in:
+// LEGACY_TIMEZONE, an IANA name such as "Europe/Berlin", or the server's
+// local zone if it is unset.
+func LegacyLocation() (*time.Location, error) {
+	name := os.Getenv("LEGACY_TIMEZONE")
+	if name == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LEGACY_TIMEZONE %q: %v", name, err)
+	}
+	return loc, nil
+}
+
+// ParseTime parses a timestamp read from the database.
+func ParseTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t.UTC(), nil
+	}
+	loc, err := LegacyLocation()
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, layout := range legacyTimeFormats {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", value)
+}
+
+// timestampColumns are the SQLite timestamp columns migration 0011
+// normalises: every one in the schema at that version.
+var timestampColumns = []struct{ table, column string }{
+	{"users", "created_at"}, {"users", "updated_at"},
+	{"videos", "created_at"}, {"videos", "updated_at"},
+	{"jobs", "run_at"}, {"jobs", "created_at"}, {"jobs", "updated_at"}, {"jobs", "finished_at"},
+	{"uploads", "created_at"}, {"uploads", "updated_at"},
+	{"refresh_tokens", "expires_at"}, {"refresh_tokens", "created_at"}, {"refresh_tokens", "revoked_at"},
+	{"revoked_tokens", "expires_at"},
+	{"video_acl", "created_at"},
+	{"groups", "created_at"}, {"groups", "updated_at"},
+	{"group_members", "created_at"},
+}
+
+// normalizeTimestamps is the step of SQLite migration 0011, which rewrites
+// every timestamp as FormatTime writes it. Values with an offset keep their
+// instant and zone-less ones are read in LegacyLocation, as ParseTime does.
+// Values that do not parse would fail every read of their rows, so they
+// fail the migration instead, naming the rows to fix by hand.
+func normalizeTimestamps(tx *sql.Tx) error {
+	if _, err := LegacyLocation(); err != nil {
+		return err
+	}
+	var invalid []string
+	for _, c := range timestampColumns {
+		// Cast so that the driver does not parse DATETIME columns itself,
+		// as UTC
+		rows, err := tx.Query(fmt.Sprintf(
+			"SELECT rowid, CAST(%s AS TEXT) FROM %s WHERE %s IS NOT NULL ORDER BY rowid", c.column, c.table, c.column,
+		))
+		if err != nil {
+			return err
+		}
+		var values []string
+		rowids := make(map[string][]string)
+		for rows.Next() {
+			var rowid, value string
+			if err := rows.Scan(&rowid, &value); err != nil {
+				rows.Close()
+				return err
+			}
+			if rowids[value] == nil {
+				values = append(values, value)
+			}
+			rowids[value] = append(rowids[value], rowid)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, value := range values {
+			t, err := ParseTime(value)
+			if err != nil {
+				invalid = append(invalid, fmt.Sprintf("%s.%s %q (rowid %s)",
+					c.table, c.column, value, strings.Join(rowids[value], ", ")))
+				continue
+			}
+			if FormatTime(t) == value {
+				continue
+			}
+			if _, err := tx.Exec(
+				fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ?", c.table, c.column, c.column),
+				FormatTime(t), value,
+			); err != nil {
+				return fmt.Errorf("%v in %s.%s", err, c.table, c.column)
+			}
+		}
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("invalid timestamps, fix or clear them and migrate again: %s", strings.Join(invalid, "; "))
+	}
+	return nil
+}
+
+// Time scans a timestamp column into a time.Time, e.g.
+// row.Scan((*database.Time)(&video.CreatedAt)). Drivers hand timestamps over
+// either parsed (PostgreSQL, and SQLite for DATETIME columns) or as text.
+type Time time.Time
+
+func (t *Time) Scan(src any) error {
+	var parsed time.Time
+	var err error
+	switch v := src.(type) {
+	case time.Time:
+		parsed = v.UTC()
+	case string:
+		parsed, err = ParseTime(v)
+	case []byte:
+		parsed, err = ParseTime(string(v))
+	default:
+		err = fmt.Errorf("cannot scan %T into a timestamp", src)
+	}
+	if err != nil {
+		return err
+	}
+	*t = Time(parsed)
+	return nil
+}
+
+// NullTime scans a timestamp column that may be NULL.
+type NullTime struct {
+	Time  time.Time
+	Valid bool // Valid is true if Time is not NULL
+}
+
+func (t *NullTime) Scan(src any) error {
+	if src == nil {
+		*t = NullTime{}
+		return nil
+	}
+	t.Valid = true
+	return (*Time)(&t.Time).Scan(src)
+}
+
+// Ptr returns the time, or nil if it is NULL.
+func (t NullTime) Ptr() *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
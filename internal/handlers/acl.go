@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"secure-video-api/internal/models"
+	"secure-video-api/internal/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+type VisibilityRequest struct {
+	Visibility string `json:"visibility" binding:"required"`
+}
+
+type VideoGrantRequest struct {
+	SubjectType string `json:"subject_type" binding:"required"`
+	SubjectID   string `json:"subject_id" binding:"required"`
+}
+
+// SetVideoVisibility changes who can see a video
+func (h *Handler) SetVideoVisibility(c *gin.Context) {
+	videoID := c.Param("id")
+	var req VisibilityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !models.ValidVisibility(req.Visibility) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Visibility must be private, restricted or all-users"})
+		return
+	}
+
+	err := h.Videos.SetVisibility(videoID, req.Visibility)
+	if err == repository.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("[ACL] Error updating visibility of video %s: %v", videoID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update video"})
+		return
+	}
+
+	log.Printf("[ACL] User %s set visibility of video %s to %s", c.GetString("user_id"), videoID, req.Visibility)
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Visibility updated successfully",
+		"video_id":   videoID,
+		"visibility": req.Visibility,
+	})
+}
+
+// GetVideoACL shows a video's visibility and access grants
+func (h *Handler) GetVideoACL(c *gin.Context) {
+	videoID := c.Param("id")
+	video, err := h.Videos.Get(videoID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return
+	}
+
+	grants, err := h.Videos.Grants(videoID)
+	if err != nil {
+		log.Printf("[ACL] Error fetching ACL of video %s: %v", videoID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch access grants"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"video_id":   videoID,
+		"visibility": video.Visibility,
+		"grants":     grants,
+	})
+}
+
+// GrantVideoAccess lets a user, or the members of a group, view a
+// restricted video. Granting access does not change the video's visibility.
+func (h *Handler) GrantVideoAccess(c *gin.Context) {
+	videoID := c.Param("id")
+	var req VideoGrantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.SubjectType != models.ACLSubjectUser && req.SubjectType != models.ACLSubjectGroup {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Subject type must be user or group"})
+		return
+	}
+
+	err := h.Videos.Grant(videoID, models.VideoGrant{
+		SubjectType: req.SubjectType,
+		SubjectID:   req.SubjectID,
+		GrantedBy:   c.GetString("user_id"),
+	})
+	if err == repository.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return
+	}
+	if err == repository.ErrUnknownSubject {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subject not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("[ACL] Error granting access to video %s: %v", videoID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to grant access"})
+		return
+	}
+
+	log.Printf("[ACL] User %s granted %s %s access to video %s", c.GetString("user_id"), req.SubjectType, req.SubjectID, videoID)
+	c.JSON(http.StatusCreated, gin.H{
+		"message":      "Access granted successfully",
+		"video_id":     videoID,
+		"subject_type": req.SubjectType,
+		"subject_id":   req.SubjectID,
+	})
+}
+
+// RevokeVideoAccess removes an access grant from a video
+func (h *Handler) RevokeVideoAccess(c *gin.Context) {
+	videoID := c.Param("id")
+	err := h.Videos.Revoke(videoID, c.Param("type"), c.Param("subject"))
+	if err == repository.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Access grant not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("[ACL] Error revoking access to video %s: %v", videoID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke access"})
+		return
+	}
+
+	log.Printf("[ACL] User %s revoked %s %s access to video %s", c.GetString("user_id"), c.Param("type"), c.Param("subject"), videoID)
+	c.JSON(http.StatusOK, gin.H{"message": "Access revoked successfully"})
+}
@@ -1,16 +1,14 @@
 package handlers
 
 import (
-	"database/sql"
+	"errors"
+	"log"
 	"net/http"
-	"os"
-	"time"
 
-	"secure-video-api/internal/database"
 	"secure-video-api/internal/models"
+	"secure-video-api/internal/repository"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -25,30 +23,23 @@ type RegisterRequest struct {
 	Password string `json:"password" binding:"required,min=8"`
 }
 
-func generateToken(userID string, isAdmin bool) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id":  userID,
-		"is_admin": isAdmin,
-		"exp":      time.Now().Add(time.Hour * 24).Unix(),
-	})
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
 
-	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
-func Login(c *gin.Context) {
+func (h *Handler) Login(c *gin.Context) {
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	var user models.User
-	err := database.DB.QueryRow(
-		"SELECT id, password, is_admin, status FROM users WHERE email = ?",
-		req.Email,
-	).Scan(&user.ID, &user.Password, &user.IsAdmin, &user.Status)
-
-	if err == sql.ErrNoRows {
+	user, err := h.Users.FindByEmail(req.Email)
+	if err == repository.ErrNotFound {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
@@ -69,23 +60,23 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	token, err := generateToken(user.ID, user.IsAdmin)
+	tokens, err := h.issueTokens(user.ID)
 	if err != nil {
+		log.Printf("[Auth] Error issuing tokens: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"token": token,
-		"user": gin.H{
-			"id":       user.ID,
-			"email":    req.Email,
-			"is_admin": user.IsAdmin,
-		},
-	})
+	response := tokens.json()
+	response["user"] = gin.H{
+		"id":       user.ID,
+		"email":    req.Email,
+		"is_admin": user.IsAdmin,
+	}
+	c.JSON(http.StatusOK, response)
 }
 
-func Register(c *gin.Context) {
+func (h *Handler) Register(c *gin.Context) {
 	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -93,14 +84,13 @@ func Register(c *gin.Context) {
 	}
 
 	// Check if user exists
-	var exists bool
-	err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE email = ?)", req.Email).Scan(&exists)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+	_, err := h.Users.FindByEmail(req.Email)
+	if err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
 		return
 	}
-	if exists {
-		c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+	if err != repository.ErrNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
 
@@ -113,27 +103,79 @@ func Register(c *gin.Context) {
 
 	// Create user
 	userID := uuid.New().String()
-	_, err = database.DB.Exec(
-		"INSERT INTO users (id, email, password, is_admin) VALUES (?, ?, ?, ?)",
-		userID, req.Email, string(hashedPassword), false,
-	)
+	err = h.Users.Create(&models.User{
+		ID:       userID,
+		Email:    req.Email,
+		Password: string(hashedPassword),
+	}, []string{models.RoleViewer})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
 
-	token, err := generateToken(userID, false)
+	tokens, err := h.issueTokens(userID)
 	if err != nil {
+		log.Printf("[Auth] Error issuing tokens: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"token": token,
-		"user": gin.H{
-			"id":       userID,
-			"email":    req.Email,
-			"is_admin": false,
-		},
-	})
+	response := tokens.json()
+	response["user"] = gin.H{
+		"id":       userID,
+		"email":    req.Email,
+		"is_admin": false,
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// Refresh exchanges a refresh token for a new access token and a new
+// refresh token; the old refresh token can no longer be used.
+func (h *Handler) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := h.rotateRefreshToken(req.RefreshToken)
+	if errors.Is(err, errInvalidRefreshToken) {
+		log.Printf("[Auth] Rejected refresh: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+	if err != nil {
+		log.Printf("[Auth] Error rotating refresh token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens.json())
+}
+
+// Logout revokes the access token it is called with and, if given, the
+// session of a refresh token.
+func (h *Handler) Logout(c *gin.Context) {
+	var req LogoutRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := h.Tokens.RevokeAccessToken(c.GetString("token_id"), c.GetTime("token_expires")); err != nil {
+		log.Printf("[Auth] Error revoking access token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+	if req.RefreshToken != "" {
+		if err := h.revokeRefreshToken(req.RefreshToken, c.GetString("user_id")); err != nil {
+			log.Printf("[Auth] Error revoking refresh token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
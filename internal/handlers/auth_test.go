@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"secure-video-api/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRegisterAndRefresh(t *testing.T) {
+	s := newTestServer(t)
+
+	w := s.do(http.MethodPost, "/auth/register", "", gin.H{"email": "viewer@example.com", "password": testPassword})
+	s.expect(w, http.StatusCreated)
+	var registered struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	decode(t, w, &registered)
+	s.expect(s.do(http.MethodGet, "/videos", registered.Token, nil), http.StatusOK)
+	s.expect(s.do(http.MethodPost, "/auth/register", "", gin.H{"email": "viewer@example.com", "password": testPassword}), http.StatusConflict)
+
+	w = s.do(http.MethodPost, "/auth/refresh", "", gin.H{"refresh_token": registered.RefreshToken})
+	s.expect(w, http.StatusOK)
+	var refreshed struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	decode(t, w, &refreshed)
+	if refreshed.RefreshToken == registered.RefreshToken {
+		t.Fatal("refresh returned the same refresh token")
+	}
+	s.expect(s.do(http.MethodGet, "/videos", refreshed.Token, nil), http.StatusOK)
+
+	// Replaying the rotated token revokes the whole family, including the
+	// token that replaced it
+	s.expect(s.do(http.MethodPost, "/auth/refresh", "", gin.H{"refresh_token": registered.RefreshToken}), http.StatusUnauthorized)
+	s.expect(s.do(http.MethodPost, "/auth/refresh", "", gin.H{"refresh_token": refreshed.RefreshToken}), http.StatusUnauthorized)
+}
+
+func TestLoginFailures(t *testing.T) {
+	s := newTestServer(t)
+	s.createUser("viewer@example.com", models.RoleViewer)
+	inactive := s.createUser("inactive@example.com", models.RoleViewer)
+	if err := s.repos.Users.SetStatus(inactive, models.UserStatusInactive); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		body   gin.H
+		status int
+	}{
+		{"wrong password", gin.H{"email": "viewer@example.com", "password": "wrong-password"}, http.StatusUnauthorized},
+		{"unknown email", gin.H{"email": "nobody@example.com", "password": testPassword}, http.StatusUnauthorized},
+		{"deactivated", gin.H{"email": "inactive@example.com", "password": testPassword}, http.StatusUnauthorized},
+		{"missing password", gin.H{"email": "viewer@example.com"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := s.do(http.MethodPost, "/auth/login", "", tt.body); w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestLogoutRevokesTokens(t *testing.T) {
+	s := newTestServer(t)
+	s.createUser("viewer@example.com", models.RoleViewer)
+	token, refresh := s.login("viewer@example.com")
+
+	s.expect(s.do(http.MethodPost, "/auth/logout", token, gin.H{"refresh_token": refresh}), http.StatusOK)
+	s.expect(s.do(http.MethodGet, "/videos", token, nil), http.StatusUnauthorized)
+	s.expect(s.do(http.MethodPost, "/auth/refresh", "", gin.H{"refresh_token": refresh}), http.StatusUnauthorized)
+}
+
+func TestDeactivateUserEndsSessions(t *testing.T) {
+	s := newTestServer(t)
+	s.createUser("admin@example.com", models.RoleUserAdmin)
+	viewer := s.createUser("viewer@example.com", models.RoleViewer)
+	adminToken, _ := s.login("admin@example.com")
+	token, refresh := s.login("viewer@example.com")
+
+	s.expect(s.do(http.MethodPost, "/admin/users/"+viewer+"/deactivate", token, nil), http.StatusForbidden)
+	s.expect(s.do(http.MethodPost, "/admin/users/"+viewer+"/deactivate", adminToken, nil), http.StatusOK)
+	s.expect(s.do(http.MethodGet, "/videos", token, nil), http.StatusUnauthorized)
+	s.expect(s.do(http.MethodPost, "/auth/refresh", "", gin.H{"refresh_token": refresh}), http.StatusUnauthorized)
+
+	if err := s.repos.Users.SetStatus(viewer, models.UserStatusActive); err != nil {
+		t.Fatal(err)
+	}
+	// Reactivation does not bring the old refresh token back
+	s.expect(s.do(http.MethodPost, "/auth/refresh", "", gin.H{"refresh_token": refresh}), http.StatusUnauthorized)
+	s.expect(s.do(http.MethodPost, "/admin/users/missing/deactivate", adminToken, nil), http.StatusNotFound)
+}
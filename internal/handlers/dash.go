@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"path/filepath"
+
+	"secure-video-api/internal/dash"
+	"secure-video-api/internal/jobs"
+	"secure-video-api/internal/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dashVideo is a ready video with a DASH rendition.
+type dashVideo struct {
+	id      string
+	dir     string
+	dataKey []byte
+}
+
+// loadDASHVideo looks up the video named in the URL, responding with 404 if
+// it is not ready or has no DASH rendition.
+func (h *Handler) loadDASHVideo(c *gin.Context) (*dashVideo, bool) {
+	video := &dashVideo{id: c.Param("id")}
+	stored, err := h.Videos.Get(video.id)
+	if err != nil {
+		if err != repository.ErrNotFound {
+			log.Printf("[DASH] Error fetching video %s: %v", video.id, err)
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return nil, false
+	}
+
+	dashRoot, err := dashStoragePath()
+	if err != nil {
+		log.Printf("[DASH] Error resolving storage: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read video"})
+		return nil, false
+	}
+	video.dir = filepath.Join(dashRoot, video.id)
+	if stored.Status != jobs.StatusReady || !fileExists(filepath.Join(video.dir, dash.Manifest)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "DASH is not available for this video"})
+		return nil, false
+	}
+
+	video.dataKey, err = videoDataKey(stored.WrappedKey, stored.KeyVersion)
+	if err != nil {
+		log.Printf("[DASH] Error resolving key for video %s: %v", video.id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid encryption key"})
+		return nil, false
+	}
+	return video, true
+}
+
+// DASHManifest serves the MPD of a video's DASH rendition. Segment URLs in
+// the manifest are relative to it and resolve to StreamDASH.
+func (h *Handler) DASHManifest(c *gin.Context) {
+	video, ok := h.loadDASHVideo(c)
+	if !ok {
+		return
+	}
+	c.Header("Cache-Control", "private, no-cache")
+	serveRenditionFile(c, filepath.Join(video.dir, dash.Manifest), "application/dash+xml")
+}
+
+// StreamDASH decrypts and serves an init or media segment of a video's
+// DASH rendition.
+func (h *Handler) StreamDASH(c *gin.Context) {
+	name := c.Param("file")
+	if !dash.IsSegmentName(name) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	video, ok := h.loadDASHVideo(c)
+	if !ok {
+		return
+	}
+
+	contentType := "video/iso.segment"
+	if filepath.Ext(name) == ".mp4" {
+		contentType = "video/mp4"
+	}
+	c.Header("Cache-Control", "private, max-age=86400")
+	serveSealedFile(c, filepath.Join(video.dir, name), video.dataKey, dash.SegmentAAD(video.id, name), contentType)
+}
+
+// dashStoragePath returns the directory holding DASH renditions.
+func dashStoragePath() (string, error) {
+	return renditionStoragePath("dash")
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"secure-video-api/internal/database"
+	"secure-video-api/internal/models"
+	"secure-video-api/internal/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type GroupRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+}
+
+type GroupMemberRequest struct {
+	UserID string `json:"user_id" binding:"required"`
+}
+
+type GroupRolesRequest struct {
+	Roles []string `json:"roles" binding:"required"`
+}
+
+// ListGroups lists every group with its roles and member count
+func (h *Handler) ListGroups(c *gin.Context) {
+	groups, err := h.Groups.List()
+	if err != nil {
+		log.Printf("[Groups] Error listing groups: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch groups"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"groups": groups, "count": len(groups)})
+}
+
+// CreateGroup creates an empty group without roles
+func (h *Handler) CreateGroup(c *gin.Context) {
+	var req GroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+
+	groupID := uuid.New().String()
+	err := h.Groups.Create(&models.Group{ID: groupID, Name: req.Name, Description: req.Description})
+	if err == repository.ErrConflict {
+		c.JSON(http.StatusConflict, gin.H{"error": "Group name already in use"})
+		return
+	}
+	if err != nil {
+		log.Printf("[Groups] Error creating group: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create group"})
+		return
+	}
+
+	log.Printf("[Groups] User %s created group %s (%s)", c.GetString("user_id"), groupID, req.Name)
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Group created successfully",
+		"id":      groupID,
+		"name":    req.Name,
+	})
+}
+
+// GetGroup shows a group with its roles and members
+func (h *Handler) GetGroup(c *gin.Context) {
+	group, err := h.Groups.Get(c.Param("id"))
+	if err == repository.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		return
+	}
+	var members []models.GroupMember
+	if err == nil {
+		members, err = h.Groups.Members(group.ID)
+	}
+	if err != nil {
+		log.Printf("[Groups] Error fetching group %s: %v", c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch group"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"group": group, "members": members})
+}
+
+// UpdateGroup renames a group or changes its description
+func (h *Handler) UpdateGroup(c *gin.Context) {
+	groupID := c.Param("id")
+	var req GroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+
+	err := h.Groups.Update(groupID, req.Name, req.Description)
+	if err == repository.ErrConflict {
+		c.JSON(http.StatusConflict, gin.H{"error": "Group name already in use"})
+		return
+	}
+	if err == repository.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("[Groups] Error updating group %s: %v", groupID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update group"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Group updated successfully"})
+}
+
+// DeleteGroup removes a group. Its members lose the group's roles and the
+// videos shared with it, so the caller must hold the group's permissions.
+func (h *Handler) DeleteGroup(c *gin.Context) {
+	groupID := c.Param("id")
+	authorize, err := h.groupChangeCheck(c)
+	if err == nil {
+		err = h.Groups.Delete(groupID, authorize)
+	}
+	if groupChangeFailed(c, groupID, err, "Failed to delete group") {
+		return
+	}
+
+	log.Printf("[Groups] User %s deleted group %s", c.GetString("user_id"), groupID)
+	c.JSON(http.StatusOK, gin.H{"message": "Group deleted successfully"})
+}
+
+// AddGroupMember adds a user to a group, giving them its roles
+func (h *Handler) AddGroupMember(c *gin.Context) {
+	groupID := c.Param("id")
+	var req GroupMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	authorize, err := h.groupChangeCheck(c)
+	if err == nil {
+		err = h.Groups.AddMember(groupID, req.UserID, authorize)
+	}
+	if err == repository.ErrUnknownSubject {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if groupChangeFailed(c, groupID, err, "Failed to add member") {
+		return
+	}
+
+	log.Printf("[Groups] User %s added %s to group %s", c.GetString("user_id"), req.UserID, groupID)
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Member added successfully",
+		"group_id": groupID,
+		"user_id":  req.UserID,
+	})
+}
+
+// RemoveGroupMember removes a user from a group
+func (h *Handler) RemoveGroupMember(c *gin.Context) {
+	groupID := c.Param("id")
+	userID := c.Param("user_id")
+	authorize, err := h.groupChangeCheck(c)
+	if err == nil {
+		err = h.Groups.RemoveMember(groupID, userID, authorize)
+	}
+	if err == repository.ErrNotMember {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User is not a member of this group"})
+		return
+	}
+	if groupChangeFailed(c, groupID, err, "Failed to remove member") {
+		return
+	}
+
+	log.Printf("[Groups] User %s removed %s from group %s", c.GetString("user_id"), userID, groupID)
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
+
+// SetGroupRoles replaces the roles held by members of a group. As with
+// users, callers can only hand out or take away roles whose permissions
+// they hold themselves.
+func (h *Handler) SetGroupRoles(c *gin.Context) {
+	groupID := c.Param("id")
+	var req GroupRolesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	authorize, err := h.groupChangeCheck(c, req.Roles...)
+	if err == nil {
+		err = h.Groups.SetRoles(groupID, req.Roles, authorize)
+	}
+	if errors.Is(err, database.ErrUnknownRole) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+		return
+	}
+	if groupChangeFailed(c, groupID, err, "Failed to update roles") {
+		return
+	}
+
+	log.Printf("[Groups] User %s set roles of group %s to %v", c.GetString("user_id"), groupID, req.Roles)
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Roles updated successfully",
+		"group_id": groupID,
+		"roles":    req.Roles,
+	})
+}
+
+// groupChangeCheck returns the authorize function for a change to a group
+// whose members gain or lose its roles: the caller must hold the
+// permissions of the group's roles and of any roles being added.
+func (h *Handler) groupChangeCheck(c *gin.Context, addedRoles ...string) (func(*models.Group) error, error) {
+	canAssign, err := h.roleCheck(c)
+	if err != nil {
+		return nil, err
+	}
+	return func(group *models.Group) error {
+		return canAssign(append(addedRoles, group.Roles...))
+	}, nil
+}
+
+// groupChangeFailed responds to the errors any group change can fail with,
+// reporting whether there was one.
+func groupChangeFailed(c *gin.Context, groupID string, err error, failure string) bool {
+	if err == nil {
+		return false
+	}
+	if err == repository.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		return true
+	}
+	if err == errRolesBeyondPermissions {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot grant or revoke roles beyond your own permissions"})
+		return true
+	}
+	log.Printf("[Groups] Error changing group %s: %v", groupID, err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": failure})
+	return true
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"secure-video-api/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGroupLifecycle(t *testing.T) {
+	s := newTestServer(t)
+	s.createUser("super@example.com", models.RoleSuperAdmin)
+	s.createUser("admin@example.com", models.RoleUserAdmin)
+	member := s.createUser("member@example.com", models.RoleViewer)
+	superToken, _ := s.login("super@example.com")
+	adminToken, _ := s.login("admin@example.com")
+
+	w := s.do(http.MethodPost, "/admin/groups", adminToken, gin.H{"name": "Editors"})
+	s.expect(w, http.StatusCreated)
+	var created struct {
+		ID string `json:"id"`
+	}
+	decode(t, w, &created)
+	group := "/admin/groups/" + created.ID
+	s.expect(s.do(http.MethodPost, "/admin/groups", adminToken, gin.H{"name": "Editors"}), http.StatusConflict)
+	s.expect(s.do(http.MethodPost, "/admin/groups", adminToken, gin.H{"name": "  "}), http.StatusBadRequest)
+
+	s.expect(s.do(http.MethodPost, group+"/members", adminToken, gin.H{"user_id": "missing"}), http.StatusNotFound)
+	s.expect(s.do(http.MethodPost, "/admin/groups/missing/members", adminToken, gin.H{"user_id": member}), http.StatusNotFound)
+	s.expect(s.do(http.MethodPost, group+"/members", adminToken, gin.H{"user_id": member}), http.StatusCreated)
+
+	w = s.do(http.MethodGet, group, adminToken, nil)
+	s.expect(w, http.StatusOK)
+	var fetched struct {
+		Group   models.Group         `json:"group"`
+		Members []models.GroupMember `json:"members"`
+	}
+	decode(t, w, &fetched)
+	if fetched.Group.MemberCount != 1 || len(fetched.Members) != 1 || fetched.Members[0].UserID != member {
+		t.Fatalf("group = %+v, want %s as its only member", fetched, member)
+	}
+
+	// The user admin cannot hand out uploads through a group; the super
+	// admin can, and members pick the role up
+	s.expect(s.do(http.MethodPut, group+"/roles", adminToken, gin.H{"roles": []string{models.RoleUploader}}), http.StatusForbidden)
+	s.expect(s.do(http.MethodPut, group+"/roles", superToken, gin.H{"roles": []string{"no-such-role"}}), http.StatusBadRequest)
+	s.expect(s.do(http.MethodPut, group+"/roles", superToken, gin.H{"roles": []string{models.RoleUploader}}), http.StatusOK)
+	access, err := s.repos.Users.Access(member)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !access.Can(models.PermVideosUpload) {
+		t.Errorf("member access = %+v, want %s", access, models.PermVideosUpload)
+	}
+
+	// Once the group carries roles beyond the user admin's permissions,
+	// its membership is out of their hands too
+	s.expect(s.do(http.MethodDelete, group+"/members/"+member, adminToken, nil), http.StatusForbidden)
+	s.expect(s.do(http.MethodDelete, group, adminToken, nil), http.StatusForbidden)
+	s.expect(s.do(http.MethodDelete, group+"/members/"+member, superToken, nil), http.StatusOK)
+	s.expect(s.do(http.MethodDelete, group+"/members/"+member, superToken, nil), http.StatusNotFound)
+	if access, err := s.repos.Users.Access(member); err != nil || access.Can(models.PermVideosUpload) {
+		t.Errorf("member access after removal = %+v, %v; want no %s", access, err, models.PermVideosUpload)
+	}
+
+	s.expect(s.do(http.MethodDelete, group, superToken, nil), http.StatusOK)
+	s.expect(s.do(http.MethodGet, group, superToken, nil), http.StatusNotFound)
+}
+
+func TestSetUserRoles(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.createUser("admin@example.com", models.RoleUserAdmin)
+	viewer := s.createUser("viewer@example.com", models.RoleViewer)
+	token, _ := s.login("admin@example.com")
+
+	tests := []struct {
+		name   string
+		userID string
+		roles  []string
+		status int
+	}{
+		{"own roles", admin, []string{models.RoleSuperAdmin}, http.StatusForbidden},
+		{"beyond permissions", viewer, []string{models.RoleModerator}, http.StatusForbidden},
+		{"unknown role", viewer, []string{"no-such-role"}, http.StatusBadRequest},
+		{"missing user", "missing", []string{models.RoleViewer}, http.StatusNotFound},
+		{"within permissions", viewer, []string{models.RoleViewer, models.RoleUserAdmin}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do(http.MethodPut, "/admin/users/"+tt.userID+"/roles", token, gin.H{"roles": tt.roles})
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	w := s.do(http.MethodGet, "/admin/users/"+viewer+"/roles", token, nil)
+	s.expect(w, http.StatusOK)
+	var resp struct {
+		Roles []string `json:"roles"`
+	}
+	decode(t, w, &resp)
+	if !equalIDs(sortedIDs(resp.Roles...), []string{models.RoleUserAdmin, models.RoleViewer}) {
+		t.Errorf("roles = %v, want user-admin and viewer", resp.Roles)
+	}
+}
@@ -0,0 +1,14 @@
+package handlers
+
+import "secure-video-api/internal/repository"
+
+// Handler serves the API from the repositories it is created with: the SQL
+// ones in the server, the in-memory ones in tests.
+type Handler struct {
+	repository.Repositories
+}
+
+// New returns a Handler working on repos.
+func New(repos repository.Repositories) *Handler {
+	return &Handler{Repositories: repos}
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"secure-video-api/internal/jwtkeys"
+	"secure-video-api/internal/middleware"
+	"secure-video-api/internal/models"
+	"secure-video-api/internal/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testPassword = "password123"
+
+// testServer serves the routes under test, wired as in main, from
+// in-memory repositories.
+type testServer struct {
+	t      *testing.T
+	repos  repository.Repositories
+	router *gin.Engine
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_KEYS_DIR", t.TempDir())
+	t.Setenv("JWT_ACTIVE_KID", "")
+	t.Setenv("JWT_SECRET", "test-jwt-secret")
+	t.Setenv("PLAYBACK_URL_SECRET", "test-playback-secret")
+	keys, err := jwtkeys.NewFromEnv()
+	if err != nil {
+		t.Fatalf("jwtkeys.NewFromEnv: %v", err)
+	}
+	previous := jwtkeys.Keys
+	jwtkeys.Keys = keys
+	t.Cleanup(func() { jwtkeys.Keys = previous })
+
+	repos := repository.NewMemory()
+	h := New(repos)
+	router := gin.New()
+	auth := middleware.AuthMiddleware(repos.Users, repos.Tokens)
+	perm := middleware.RequirePermission
+	visible := middleware.RequireVideoAccess(repos.Videos)
+
+	router.POST("/auth/register", h.Register)
+	router.POST("/auth/login", h.Login)
+	router.POST("/auth/refresh", h.Refresh)
+	router.POST("/auth/logout", auth, h.Logout)
+
+	router.GET("/videos", auth, perm(models.PermVideosView), h.ListVideos)
+	router.POST("/videos/:id/playback-token", auth, perm(models.PermVideosView), visible, h.CreatePlaybackToken)
+
+	router.GET("/admin/jobs/:id", auth, perm(models.PermVideosUpload), h.GetJob)
+
+	manage := perm(models.PermVideosManage)
+	router.PUT("/admin/videos/:id", auth, manage, h.UpdateVideo)
+	router.DELETE("/admin/videos/:id", auth, manage, h.DeleteVideo)
+	router.PUT("/admin/videos/:id/visibility", auth, manage, h.SetVideoVisibility)
+	router.GET("/admin/videos/:id/acl", auth, manage, h.GetVideoACL)
+	router.POST("/admin/videos/:id/acl", auth, manage, h.GrantVideoAccess)
+	router.DELETE("/admin/videos/:id/acl/:type/:subject", auth, manage, h.RevokeVideoAccess)
+
+	users := perm(models.PermUsersManage)
+	router.GET("/admin/users", auth, users, h.ListUsers)
+	router.POST("/admin/users/:id/deactivate", auth, users, h.DeactivateUser)
+	router.DELETE("/admin/users/:id", auth, users, h.DeleteUser)
+
+	assign := perm(models.PermRolesAssign)
+	router.GET("/admin/users/:id/roles", auth, assign, h.GetUserRoles)
+	router.PUT("/admin/users/:id/roles", auth, assign, h.SetUserRoles)
+
+	groups := perm(models.PermGroupsManage)
+	router.POST("/admin/groups", auth, groups, h.CreateGroup)
+	router.GET("/admin/groups/:id", auth, groups, h.GetGroup)
+	router.PUT("/admin/groups/:id", auth, groups, h.UpdateGroup)
+	router.DELETE("/admin/groups/:id", auth, groups, h.DeleteGroup)
+	router.POST("/admin/groups/:id/members", auth, groups, h.AddGroupMember)
+	router.DELETE("/admin/groups/:id/members/:user_id", auth, groups, h.RemoveGroupMember)
+	router.PUT("/admin/groups/:id/roles", auth, assign, h.SetGroupRoles)
+
+	return &testServer{t: t, repos: repos, router: router}
+}
+
+// createUser stores a user holding roles, with testPassword.
+func (s *testServer) createUser(email string, roles ...string) string {
+	s.t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	id := uuid.New().String()
+	if err := s.repos.Users.Create(&models.User{ID: id, Email: email, Password: string(hash)}, roles); err != nil {
+		s.t.Fatalf("creating %s: %v", email, err)
+	}
+	return id
+}
+
+// login logs a user in, returning the access and refresh tokens.
+func (s *testServer) login(email string) (string, string) {
+	s.t.Helper()
+	w := s.do(http.MethodPost, "/auth/login", "", gin.H{"email": email, "password": testPassword})
+	if w.Code != http.StatusOK {
+		s.t.Fatalf("login %s: status %d: %s", email, w.Code, w.Body)
+	}
+	var resp struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	decode(s.t, w, &resp)
+	return resp.Token, resp.RefreshToken
+}
+
+// createVideo stores a video uploaded by uploadedBy, marked ready.
+func (s *testServer) createVideo(uploadedBy, visibility string) string {
+	s.t.Helper()
+	id := uuid.New().String()
+	video := &models.Video{ID: id, Title: "Video " + id[:8], FileName: id + ".mp4", UploadedBy: uploadedBy, Visibility: visibility}
+	if _, err := s.repos.Videos.Create(video); err != nil {
+		s.t.Fatal(err)
+	}
+	if err := s.repos.Videos.(*repository.MemoryVideoRepository).SetStatus(id, "ready"); err != nil {
+		s.t.Fatal(err)
+	}
+	return id
+}
+
+// do sends a request with an optional bearer token and JSON body.
+func (s *testServer) do(method, path, token string, body any) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// expect checks the status of a response.
+func (s *testServer) expect(w *httptest.ResponseRecorder, status int) {
+	s.t.Helper()
+	if w.Code != status {
+		s.t.Fatalf("status = %d, want %d: %s", w.Code, status, w.Body)
+	}
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+}
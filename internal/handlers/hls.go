@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"secure-video-api/internal/hls"
+	"secure-video-api/internal/jobs"
+	"secure-video-api/internal/repository"
+	"secure-video-api/internal/storage"
+	"secure-video-api/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const playlistContentType = "application/vnd.apple.mpegurl"
+
+// hlsVideo is a ready video with an HLS rendition.
+type hlsVideo struct {
+	id         string
+	dir        string
+	wrappedKey string
+	keyVersion int
+	hlsKey     string
+}
+
+// loadHLSVideo looks up the video named in the URL, responding with 404 if
+// it is not ready or has no HLS rendition.
+func (h *Handler) loadHLSVideo(c *gin.Context) (*hlsVideo, bool) {
+	video := &hlsVideo{id: c.Param("id")}
+	stored, err := h.Videos.Get(video.id)
+	if err != nil {
+		if err != repository.ErrNotFound {
+			log.Printf("[HLS] Error fetching video %s: %v", video.id, err)
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return nil, false
+	}
+	if stored.Status != jobs.StatusReady || stored.HLSKey == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "HLS is not available for this video"})
+		return nil, false
+	}
+	video.wrappedKey = stored.WrappedKey
+	video.keyVersion = stored.KeyVersion
+	video.hlsKey = stored.HLSKey
+
+	hlsRoot, err := hlsStoragePath()
+	if err != nil {
+		log.Printf("[HLS] Error resolving storage: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read video"})
+		return nil, false
+	}
+	video.dir = filepath.Join(hlsRoot, video.id)
+	return video, true
+}
+
+// StreamHLS serves the playlists and segments of a video's HLS rendition,
+// starting from master.m3u8. Media segments are served as stored, already
+// encrypted with the key from HLSKey.
+func (h *Handler) StreamHLS(c *gin.Context) {
+	video, ok := h.loadHLSVideo(c)
+	if !ok {
+		return
+	}
+
+	name := c.Param("file")
+	switch {
+	case name == hls.MasterPlaylist || name == hls.MediaPlaylist:
+		c.Header("Cache-Control", "private, no-cache")
+		serveRenditionFile(c, filepath.Join(video.dir, name), playlistContentType)
+
+	case name == hls.InitSegment:
+		serveHLSInit(c, video)
+
+	case hls.IsSegmentName(name):
+		c.Header("Cache-Control", "private, max-age=86400")
+		serveRenditionFile(c, filepath.Join(video.dir, name), "video/iso.segment")
+
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+	}
+}
+
+// HLSKey releases the AES-128 key of a video's HLS segments. Like the
+// segments themselves it is only served to authenticated users allowed to
+// stream the video, and it must never be cached.
+func (h *Handler) HLSKey(c *gin.Context) {
+	video, ok := h.loadHLSVideo(c)
+	if !ok {
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+
+	key, err := unwrapHLSKey(video)
+	if err != nil {
+		log.Printf("[HLS] Error unwrapping key for video %s: %v", video.id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid encryption key"})
+		return
+	}
+
+	log.Printf("[HLS] Released key for video %s to user %s", video.id, c.GetString("user_id"))
+	c.Data(http.StatusOK, "application/octet-stream", key)
+}
+
+func unwrapHLSKey(video *hlsVideo) ([]byte, error) {
+	dataKey, err := videoDataKey(video.wrappedKey, video.keyVersion)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(video.hlsKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode HLS key: %v", err)
+	}
+	key, err := utils.UnwrapSecret(dataKey, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap HLS key: %v", err)
+	}
+	return key, nil
+}
+
+// serveHLSInit decrypts the stored init segment.
+func serveHLSInit(c *gin.Context, video *hlsVideo) {
+	dataKey, err := videoDataKey(video.wrappedKey, video.keyVersion)
+	if err != nil {
+		log.Printf("[HLS] Error resolving key for video %s: %v", video.id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid encryption key"})
+		return
+	}
+
+	c.Header("Cache-Control", "private, max-age=86400")
+	serveSealedFile(c, filepath.Join(video.dir, hls.InitSegment), dataKey, hls.InitAAD(video.id), "video/mp4")
+}
+
+// serveSealedFile decrypts and serves a rendition file stored encrypted
+// under a video's data key.
+func serveSealedFile(c *gin.Context, path string, dataKey []byte, aad, contentType string) {
+	reader, err := utils.NewDecryptingReader(path, dataKey, aad)
+	if err == nil {
+		defer reader.Close()
+		err = reader.Verify()
+	}
+	if errors.Is(err, utils.ErrIntegrity) {
+		respondContentError(c, err)
+		return
+	}
+	if err != nil {
+		log.Printf("[Stream] Error opening %s: %v", path, err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	serveRanges(c, reader, reader.Size(), contentType, "", time.Time{})
+}
+
+func serveRenditionFile(c *gin.Context, path, contentType string) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("[Stream] Error opening %s: %v", path, err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read video"})
+		return
+	}
+	serveRanges(c, file, info.Size(), contentType, "", info.ModTime())
+}
+
+// hlsStoragePath returns the directory holding HLS renditions.
+func hlsStoragePath() (string, error) {
+	return renditionStoragePath("hls")
+}
+
+// renditionStoragePath returns the directory under ENCRYPTED_PATH holding
+// renditions of one kind, creating it if needed.
+func renditionStoragePath(kind string) (string, error) {
+	encryptedDir, err := storage.Dir()
+	if err != nil {
+		return "", err
+	}
+	root := filepath.Join(encryptedDir, kind)
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return "", fmt.Errorf("failed to create %s directory: %v", kind, err)
+	}
+	return root, nil
+}
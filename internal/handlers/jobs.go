@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"secure-video-api/internal/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetJob reports the state of a background processing job (admin only)
+func (h *Handler) GetJob(c *gin.Context) {
+	job, err := h.Jobs.Get(c.Param("id"))
+	if err == repository.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("[Jobs] Error fetching job: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"secure-video-api/internal/jobs"
+	"secure-video-api/internal/models"
+
+	"github.com/google/uuid"
+)
+
+func TestGetJob(t *testing.T) {
+	s := newTestServer(t)
+	uploader := s.createUser("uploader@example.com", models.RoleUploader)
+	s.createUser("viewer@example.com", models.RoleViewer)
+	token, _ := s.login("uploader@example.com")
+	viewerToken, _ := s.login("viewer@example.com")
+
+	videoID := uuid.New().String()
+	jobID, err := s.repos.Videos.Create(&models.Video{ID: videoID, Title: "Video", FileName: videoID + ".mp4", UploadedBy: uploader})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := s.do(http.MethodGet, "/admin/jobs/"+jobID, token, nil)
+	s.expect(w, http.StatusOK)
+	var job jobs.Job
+	decode(t, w, &job)
+	if job.ID != jobID || job.VideoID != videoID || job.Type != jobs.TypeProcessVideo || job.Status != jobs.StatusQueued {
+		t.Errorf("job = %+v, want the queued job of video %s", job, videoID)
+	}
+
+	s.expect(s.do(http.MethodGet, "/admin/jobs/"+jobID, viewerToken, nil), http.StatusForbidden)
+	s.expect(s.do(http.MethodGet, "/admin/jobs/missing", token, nil), http.StatusNotFound)
+
+	// Deleting a video deletes its jobs
+	if err := s.repos.Videos.Delete(videoID); err != nil {
+		t.Fatal(err)
+	}
+	s.expect(s.do(http.MethodGet, "/admin/jobs/"+jobID, token, nil), http.StatusNotFound)
+}
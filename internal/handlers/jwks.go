@@ -0,0 +1,16 @@
+package handlers
+
+import (
+	"net/http"
+
+	"secure-video-api/internal/jwtkeys"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS publishes the public keys verifying access tokens, so other services
+// can validate them without sharing a secret.
+func JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": jwtkeys.Keys.JWKS()})
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"secure-video-api/internal/keyrotation"
+	"secure-video-api/internal/kms"
+	"secure-video-api/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StartKeyRotation re-wraps or re-encrypts every video onto the active
+// master key in the background (admin only)
+func StartKeyRotation(c *gin.Context) {
+	encryptedDir, err := storage.Dir()
+	if err != nil {
+		log.Printf("[KeyRotation] Error resolving encrypted directory: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start key rotation"})
+		return
+	}
+	if err := keyrotation.Start(kms.Provider, encryptedDir); err != nil {
+		if errors.Is(err, keyrotation.ErrAlreadyRunning) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "progress": keyrotation.Status()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start key rotation"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":  "Key rotation started",
+		"progress": keyrotation.Status(),
+	})
+}
+
+// KeyRotationStatus reports the key provider and the progress of the latest
+// rotation (admin only)
+func KeyRotationStatus(c *gin.Context) {
+	active, err := kms.Provider.ActiveVersion()
+	if err != nil {
+		log.Printf("[KeyRotation] Error reading active key version: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Encryption key error", "details": err.Error()})
+		return
+	}
+
+	pending, err := keyrotation.Pending(kms.Provider)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"provider":       kms.Provider.Name(),
+		"active_version": active,
+		"pending":        pending,
+		"progress":       keyrotation.Status(),
+	})
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"secure-video-api/internal/jobs"
+	"secure-video-api/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultPlaybackURLTTL is how long playback URLs stay valid unless
+// PLAYBACK_URL_TTL says otherwise. Players keep issuing range requests
+// against the URL, so it must outlast the time it takes to watch the video.
+const defaultPlaybackURLTTL = 15 * time.Minute
+
+type PlaybackTokenRequest struct {
+	BindIP bool `json:"bind_ip"`
+}
+
+// CreatePlaybackToken mints a signed, expiring URL streaming the video for
+// the current user without an Authorization header, e.g. as the src of an
+// HTML5 video element.
+func (h *Handler) CreatePlaybackToken(c *gin.Context) {
+	videoID := c.Param("id")
+	var req PlaybackTokenRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	video, err := h.Videos.Get(videoID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return
+	}
+	if video.Status != jobs.StatusReady {
+		c.JSON(http.StatusConflict, gin.H{"error": "Video is not ready", "status": video.Status})
+		return
+	}
+
+	ttl, err := tokenTTL("PLAYBACK_URL_TTL", defaultPlaybackURLTTL)
+	if err != nil {
+		log.Printf("[Playback] %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create playback URL"})
+		return
+	}
+
+	userID := c.GetString("user_id")
+	expiresAt := time.Now().Add(ttl).UTC()
+	clientIP := ""
+	query := url.Values{}
+	query.Set("uid", userID)
+	query.Set("exp", strconv.FormatInt(expiresAt.Unix(), 10))
+	if req.BindIP {
+		clientIP = c.ClientIP()
+		query.Set("ipb", "1")
+	}
+	query.Set("sig", utils.SignPlayback(videoID, userID, expiresAt.Unix(), clientIP))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"url":        "/api/videos/" + url.PathEscape(videoID) + "/play?" + query.Encode(),
+		"expires_at": expiresAt.Format(time.RFC3339),
+	})
+}
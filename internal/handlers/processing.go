@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"secure-video-api/internal/dash"
+	"secure-video-api/internal/hls"
+	"secure-video-api/internal/jobs"
+	"secure-video-api/internal/media"
+	"secure-video-api/internal/repository"
+	"secure-video-api/internal/storage"
+	"secure-video-api/internal/utils"
+)
+
+// ProcessVideo is the job handler run for every stored video before it is
+// listed. It decrypts the whole file once, so a video that was damaged or cut
+// short while being stored is never published, then packages MP4 and
+// QuickTime videos for HLS and DASH.
+func (h *Handler) ProcessVideo(ctx context.Context, job *jobs.Job) error {
+	video, err := h.Videos.Get(job.VideoID)
+	if err == repository.ErrNotFound {
+		return jobs.Permanent(fmt.Errorf("video %s no longer exists", job.VideoID))
+	}
+	if err != nil {
+		return err
+	}
+
+	key, err := videoDataKey(video.WrappedKey, video.KeyVersion)
+	if err != nil {
+		return fmt.Errorf("failed to resolve video key: %v", err)
+	}
+
+	encryptedDir, err := storage.Dir()
+	if err != nil {
+		return err
+	}
+	encryptedPath := filepath.Join(encryptedDir, video.FileName+".enc")
+	file, err := utils.OpenEncryptedFile(encryptedPath, key, job.VideoID)
+	if err != nil {
+		return permanentIfIntegrity(err)
+	}
+	defer file.Close()
+
+	buf := make([]byte, 0, file.ChunkSize())
+	for i := int64(0); i < file.ChunkCount(); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := file.ReadChunk(i, buf[:0]); err != nil {
+			return permanentIfIntegrity(err)
+		}
+	}
+
+	log.Printf("[Processing] Verified video %s (%d bytes, %d chunks)", job.VideoID, file.Size(), file.ChunkCount())
+
+	switch video.MIMEType {
+	case "video/mp4", "video/quicktime":
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return h.packageStreams(job.VideoID, encryptedPath, key)
+	}
+	// Renditions of a file the video had before its file was replaced
+	// must not outlive it
+	return removeRenditions(job.VideoID, "hls", "dash")
+}
+
+// removeRenditions removes the renditions of the given kinds stored for a
+// video, if any.
+func removeRenditions(videoID string, kinds ...string) error {
+	encryptedDir, err := storage.Dir()
+	if err != nil {
+		return err
+	}
+	for _, kind := range kinds {
+		if err := os.RemoveAll(filepath.Join(encryptedDir, kind, videoID)); err != nil {
+			return fmt.Errorf("failed to remove %s rendition: %v", kind, err)
+		}
+	}
+	return nil
+}
+
+// packageStreams remuxes a video for HLS and DASH, replacing any earlier
+// renditions. Videos the remuxer cannot handle stay available as a
+// progressive stream only.
+func (h *Handler) packageStreams(videoID, encryptedPath string, dataKey []byte) error {
+	reader, err := utils.NewDecryptingReader(encryptedPath, dataKey, videoID)
+	if err != nil {
+		return permanentIfIntegrity(err)
+	}
+	defer reader.Close()
+
+	movie, err := media.ReadMovie(reader, reader.Size())
+	if errors.Is(err, media.ErrUnsupportedMedia) || errors.Is(err, media.ErrInvalidMedia) {
+		log.Printf("[Processing] Skipping HLS and DASH for video %s: %v", videoID, err)
+		return removeRenditions(videoID, "hls", "dash")
+	}
+	if err != nil {
+		return permanentIfIntegrity(err)
+	}
+
+	if err := h.packageHLS(videoID, movie, reader, dataKey); err != nil {
+		return err
+	}
+	return h.packageDASH(videoID, movie, reader, dataKey)
+}
+
+// packageHLS writes the HLS rendition under a fresh AES-128 segment key.
+func (h *Handler) packageHLS(videoID string, movie *media.Movie, r io.ReadSeeker, dataKey []byte) error {
+	hlsRoot, err := hlsStoragePath()
+	if err != nil {
+		return err
+	}
+
+	segmentKey, err := hls.GenerateKey()
+	if err != nil {
+		return err
+	}
+	// The segment key is sealed with the video's data key, so master key
+	// rotation never has to touch it
+	wrappedSegmentKey, err := utils.WrapSecret(dataKey, segmentKey)
+	if err != nil {
+		return err
+	}
+	hlsKey := base64.StdEncoding.EncodeToString(wrappedSegmentKey)
+
+	published, err := h.publishRendition(hlsRoot, videoID, func(dir string) error {
+		return hls.Package(movie, r, dir, segmentKey, dataKey, videoID)
+	}, func() error {
+		return h.Videos.SetHLSKey(videoID, hlsKey)
+	})
+	if err != nil || !published {
+		return err
+	}
+
+	log.Printf("[Processing] Packaged video %s for HLS", videoID)
+	return nil
+}
+
+// packageDASH writes the DASH rendition, its segments sealed with the
+// video's data key.
+func (h *Handler) packageDASH(videoID string, movie *media.Movie, r io.ReadSeeker, dataKey []byte) error {
+	dashRoot, err := dashStoragePath()
+	if err != nil {
+		return err
+	}
+
+	published, err := h.publishRendition(dashRoot, videoID, func(dir string) error {
+		return dash.Package(movie, r, dir, dataKey, videoID)
+	}, func() error {
+		return h.Videos.KeepFiles(videoID)
+	})
+	if err != nil || !published {
+		return err
+	}
+
+	log.Printf("[Processing] Packaged video %s for DASH", videoID)
+	return nil
+}
+
+// publishRendition builds a rendition in a temporary directory under root
+// and moves it into place as root/videoID once complete, so a rendition is
+// never served half written. The final directory is staged with
+// Videos.StageFile and kept by keep, which stores the video's row or fails with
+// repository.ErrNotFound if the video was deleted meanwhile; the rendition is
+// then discarded. It reports false if the movie could not be packaged.
+func (h *Handler) publishRendition(root, videoID string, build func(dir string) error, keep func() error) (bool, error) {
+	tmp, err := os.MkdirTemp(root, "."+videoID+"-*")
+	if err != nil {
+		return false, fmt.Errorf("failed to create rendition directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	err = build(tmp)
+	if errors.Is(err, media.ErrUnsupportedMedia) {
+		log.Printf("[Processing] Skipping %s for video %s: %v", filepath.Base(root), videoID, err)
+		return false, removeRenditions(videoID, filepath.Base(root))
+	}
+	if err != nil {
+		return false, permanentIfIntegrity(err)
+	}
+
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return false, fmt.Errorf("failed to set rendition directory permissions: %v", err)
+	}
+	kind := filepath.Base(root)
+	if err := h.Videos.StageFile(videoID, filepath.Join(kind, videoID)); err != nil {
+		return false, err
+	}
+	finalDir := filepath.Join(root, videoID)
+	if err := os.RemoveAll(finalDir); err != nil {
+		h.discardRendition(videoID)
+		return false, fmt.Errorf("failed to replace rendition directory: %v", err)
+	}
+	if err := os.Rename(tmp, finalDir); err != nil {
+		h.discardRendition(videoID)
+		return false, fmt.Errorf("failed to move rendition directory into place: %v", err)
+	}
+	if err := keep(); err != nil {
+		h.discardRendition(videoID)
+		if err == repository.ErrNotFound {
+			return false, jobs.Permanent(fmt.Errorf("video %s no longer exists", videoID))
+		}
+		return false, fmt.Errorf("failed to store %s rendition: %v", kind, err)
+	}
+	return true, nil
+}
+
+// discardRendition removes a rendition staged for a video whose row was not
+// updated. Files left behind are removed later by storage.Recover.
+func (h *Handler) discardRendition(videoID string) {
+	if err := h.Videos.DiscardFiles(videoID); err != nil {
+		log.Printf("[Processing] Error discarding rendition of video %s: %v", videoID, err)
+	}
+}
+
+// permanentIfIntegrity stops retries for files that will never decrypt.
+func permanentIfIntegrity(err error) error {
+	if errors.Is(err, utils.ErrIntegrity) || errors.Is(err, utils.ErrInvalidFormat) {
+		return jobs.Permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"secure-video-api/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxRanges caps the number of ranges served from a single request.
+// Requests asking for more are answered with the full representation.
+const maxRanges = 16
+
+var (
+	// errMalformedRange marks a Range header that does not follow the
+	// byte-ranges grammar. RFC 7233 lets servers ignore such headers.
+	errMalformedRange = errors.New("malformed range header")
+	// errUnsatisfiableRange marks a syntactically valid Range header none of
+	// whose ranges overlap the representation.
+	errUnsatisfiableRange = errors.New("range not satisfiable")
+)
+
+// httpRange is a resolved, inclusive byte range.
+type httpRange struct {
+	start, end int64
+}
+
+func (r httpRange) length() int64 {
+	return r.end - r.start + 1
+}
+
+func (r httpRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size)
+}
+
+// parseRange parses a Range header as defined by RFC 7233 section 2.1
+// against a representation of the given size. Suffix ("-500") and
+// open-ended ("500-") specs are supported, ends past the representation are
+// clamped, and specs that start past the end are dropped. If no spec
+// survives, errUnsatisfiableRange is returned.
+func parseRange(rangeHeader string, size int64) ([]httpRange, error) {
+	unit, set, ok := strings.Cut(rangeHeader, "=")
+	if !ok || strings.TrimSpace(unit) != "bytes" {
+		return nil, errMalformedRange
+	}
+
+	var ranges []httpRange
+	sawSpec := false
+	for _, spec := range strings.Split(set, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			// Empty list elements are allowed by the #rule syntax
+			continue
+		}
+		sawSpec = true
+
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, errMalformedRange
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var r httpRange
+		if first == "" {
+			// suffix-byte-range-spec: the final N bytes
+			n, err := parseRangeInt(last)
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r = httpRange{start: size - n, end: size - 1}
+		} else {
+			start, err := parseRangeInt(first)
+			if err != nil {
+				return nil, err
+			}
+			end := size - 1
+			if last != "" {
+				if end, err = parseRangeInt(last); err != nil {
+					return nil, err
+				}
+				if end < start {
+					return nil, errMalformedRange
+				}
+				if end > size-1 {
+					end = size - 1
+				}
+			}
+			if start >= size {
+				continue
+			}
+			r = httpRange{start: start, end: end}
+		}
+		ranges = append(ranges, r)
+	}
+
+	if !sawSpec {
+		return nil, errMalformedRange
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+func parseRangeInt(s string) (int64, error) {
+	if s == "" {
+		return 0, errMalformedRange
+	}
+	for _, ch := range s {
+		if ch < '0' || ch > '9' {
+			return 0, errMalformedRange
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errMalformedRange
+	}
+	return n, nil
+}
+
+// checkIfRange reports whether a Range header may be honoured given the
+// request's If-Range precondition (RFC 7233 section 3.2). An If-Range that
+// does not match the current representation means the full body is sent.
+func checkIfRange(ifRange, etag string, modTime time.Time) bool {
+	ifRange = strings.TrimSpace(ifRange)
+	if ifRange == "" {
+		return true
+	}
+
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		// Only strong validators can be used with If-Range
+		return etag != "" && !strings.HasPrefix(ifRange, "W/") && ifRange == etag
+	}
+
+	t, err := http.ParseTime(ifRange)
+	if err != nil || modTime.IsZero() {
+		return false
+	}
+	return modTime.UTC().Truncate(time.Second).Equal(t.UTC())
+}
+
+// sumRangesSize returns the total number of bytes covered by ranges.
+func sumRangesSize(ranges []httpRange) int64 {
+	var total int64
+	for _, r := range ranges {
+		total += r.length()
+	}
+	return total
+}
+
+// serveRanges writes content to the response, honouring Range and If-Range
+// headers. A single range is sent as a 206 with Content-Range, several
+// ranges as a multipart/byteranges body, and an unsatisfiable range as a 416
+// carrying "Content-Range: bytes */size".
+func serveRanges(c *gin.Context, content io.ReadSeeker, size int64, contentType, etag string, modTime time.Time) {
+	c.Header("Accept-Ranges", "bytes")
+	if etag != "" {
+		c.Header("ETag", etag)
+	}
+	if !modTime.IsZero() {
+		c.Header("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+
+	var ranges []httpRange
+	rangeHeader := c.GetHeader("Range")
+	if rangeHeader != "" && checkIfRange(c.GetHeader("If-Range"), etag, modTime) {
+		var err error
+		ranges, err = parseRange(rangeHeader, size)
+		switch {
+		case errors.Is(err, errUnsatisfiableRange):
+			c.Header("Content-Range", fmt.Sprintf("bytes */%d", size))
+			c.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{"error": "Requested range not satisfiable"})
+			return
+		case err != nil:
+			// Malformed Range headers are ignored and the full body is sent
+			log.Printf("Ignoring malformed range %q: %v", rangeHeader, err)
+			ranges = nil
+		case len(ranges) > maxRanges || sumRangesSize(ranges) > size:
+			// Overlapping or excessive ranges are not worth honouring
+			log.Printf("Ignoring excessive range request %q", rangeHeader)
+			ranges = nil
+		}
+	}
+
+	switch len(ranges) {
+	case 0:
+		body, err := openRangeBody(content, 0, size)
+		if err != nil {
+			respondContentError(c, err)
+			return
+		}
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Length", strconv.FormatInt(size, 10))
+		c.Status(http.StatusOK)
+		if c.Request.Method == http.MethodHead {
+			return
+		}
+		if _, err := io.Copy(c.Writer, body); err != nil {
+			logStreamError("content", err)
+		}
+
+	case 1:
+		r := ranges[0]
+		body, err := openRangeBody(content, r.start, r.length())
+		if err != nil {
+			respondContentError(c, err)
+			return
+		}
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Range", r.contentRange(size))
+		c.Header("Content-Length", strconv.FormatInt(r.length(), 10))
+		c.Status(http.StatusPartialContent)
+		if c.Request.Method == http.MethodHead {
+			return
+		}
+		if _, err := io.Copy(c.Writer, body); err != nil {
+			logStreamError("range", err)
+		}
+
+	default:
+		serveMultipartRanges(c, content, size, contentType, ranges)
+	}
+}
+
+// openRangeBody positions content at start and reads ahead into the range,
+// so that a failure to read it (such as an integrity error) can still be
+// reported with a proper status instead of a truncated 200 or 206.
+func openRangeBody(content io.ReadSeeker, start, length int64) (io.Reader, error) {
+	if _, err := content.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	body := bufio.NewReader(io.LimitReader(content, length))
+	if length > 0 {
+		if _, err := body.Peek(1); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// respondContentError reports a failure to read content before any of it
+// has been sent.
+func respondContentError(c *gin.Context, err error) {
+	if errors.Is(err, utils.ErrIntegrity) {
+		log.Printf("Integrity check failed for %s: %v", c.Request.URL.Path, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Video integrity check failed",
+			"details": "The stored video does not match its metadata or has been tampered with",
+		})
+		return
+	}
+	log.Printf("Error reading content for %s: %v", c.Request.URL.Path, err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read video"})
+}
+
+// logStreamError logs an error that happened after the response started.
+// The client sees a body shorter than its Content-Length.
+func logStreamError(what string, err error) {
+	if errors.Is(err, utils.ErrIntegrity) {
+		log.Printf("Integrity check failed while streaming %s: %v", what, err)
+		return
+	}
+	log.Printf("Error streaming %s: %v", what, err)
+}
+
+func serveMultipartRanges(c *gin.Context, content io.ReadSeeker, size int64, contentType string, ranges []httpRange) {
+	// Compute the body length up front by rendering the part headers
+	// against a counting writer
+	counter := &countingWriter{}
+	mw := multipart.NewWriter(counter)
+	for _, r := range ranges {
+		mw.CreatePart(rangePartHeader(r, size, contentType))
+		counter.n += r.length()
+	}
+	mw.Close()
+
+	boundary := mw.Boundary()
+	c.Header("Content-Type", "multipart/byteranges; boundary="+boundary)
+	c.Header("Content-Length", strconv.FormatInt(counter.n, 10))
+	c.Status(http.StatusPartialContent)
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+
+	mw = multipart.NewWriter(c.Writer)
+	if err := mw.SetBoundary(boundary); err != nil {
+		log.Printf("Error setting multipart boundary: %v", err)
+		return
+	}
+	for _, r := range ranges {
+		part, err := mw.CreatePart(rangePartHeader(r, size, contentType))
+		if err != nil {
+			log.Printf("Error writing range part: %v", err)
+			return
+		}
+		if _, err := content.Seek(r.start, io.SeekStart); err != nil {
+			log.Printf("Error seeking content: %v", err)
+			return
+		}
+		if _, err := io.CopyN(part, content, r.length()); err != nil {
+			logStreamError("range part", err)
+			return
+		}
+	}
+	if err := mw.Close(); err != nil {
+		log.Printf("Error finishing multipart response: %v", err)
+	}
+}
+
+func rangePartHeader(r httpRange, size int64, contentType string) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Range": {r.contentRange(size)},
+		"Content-Type":  {contentType},
+	}
+}
+
+// countingWriter discards its input, counting the bytes written.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		size   int64
+		want   []httpRange
+		err    error
+	}{
+		{"closed", "bytes=0-99", 1000, []httpRange{{0, 99}}, nil},
+		{"single byte", "bytes=5-5", 1000, []httpRange{{5, 5}}, nil},
+		{"open-ended", "bytes=500-", 1000, []httpRange{{500, 999}}, nil},
+		{"suffix", "bytes=-100", 1000, []httpRange{{900, 999}}, nil},
+		{"suffix longer than size", "bytes=-5000", 1000, []httpRange{{0, 999}}, nil},
+		{"end clamped", "bytes=900-5000", 1000, []httpRange{{900, 999}}, nil},
+		{"several", "bytes=0-0, 10-19,-1", 1000, []httpRange{{0, 0}, {10, 19}, {999, 999}}, nil},
+		{"overlapping kept as sent", "bytes=0-499,250-749", 1000, []httpRange{{0, 499}, {250, 749}}, nil},
+		{"empty list elements", "bytes=,0-1,,", 1000, []httpRange{{0, 1}}, nil},
+		{"whitespace", " bytes = 1 - 2 ", 1000, []httpRange{{1, 2}}, nil},
+		{"unsatisfiable spec dropped", "bytes=2000-3000,0-9", 1000, []httpRange{{0, 9}}, nil},
+
+		{"start past end", "bytes=1000-", 1000, nil, errUnsatisfiableRange},
+		{"zero suffix", "bytes=-0", 1000, nil, errUnsatisfiableRange},
+		{"empty representation", "bytes=-10", 0, nil, errUnsatisfiableRange},
+		{"all specs past end", "bytes=1000-1001,2000-", 1000, nil, errUnsatisfiableRange},
+
+		{"end before start", "bytes=10-5", 1000, nil, errMalformedRange},
+		{"other unit", "items=0-1", 1000, nil, errMalformedRange},
+		{"missing unit", "0-1", 1000, nil, errMalformedRange},
+		{"no specs", "bytes=", 1000, nil, errMalformedRange},
+		{"missing dash", "bytes=10", 1000, nil, errMalformedRange},
+		{"bare dash", "bytes=-", 1000, nil, errMalformedRange},
+		{"negative", "bytes=--5", 1000, nil, errMalformedRange},
+		{"signed start", "bytes=+1-2", 1000, nil, errMalformedRange},
+		{"not a number", "bytes=a-b", 1000, nil, errMalformedRange},
+		{"overflow", "bytes=0-99999999999999999999", 1000, nil, errMalformedRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRange(tt.header, tt.size)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseRange(%q, %d) error = %v, want %v", tt.header, tt.size, err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRange(%q, %d) = %v, want %v", tt.header, tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckIfRange(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	date := modTime.Format(http.TimeFormat)
+
+	tests := []struct {
+		name    string
+		ifRange string
+		etag    string
+		modTime time.Time
+		want    bool
+	}{
+		{"absent", "", `"v1"`, modTime, true},
+		{"matching etag", `"v1"`, `"v1"`, modTime, true},
+		{"other etag", `"v2"`, `"v1"`, modTime, false},
+		{"weak etag", `W/"v1"`, `"v1"`, modTime, false},
+		{"etag without current etag", `"v1"`, "", modTime, false},
+		{"matching date", date, `"v1"`, modTime, true},
+		{"obsolete date format", modTime.Format(time.RFC850), `"v1"`, modTime, true},
+		{"older date", modTime.Add(-time.Hour).Format(http.TimeFormat), `"v1"`, modTime, false},
+		{"newer date", modTime.Add(time.Hour).Format(http.TimeFormat), `"v1"`, modTime, false},
+		{"date without modification time", date, `"v1"`, time.Time{}, false},
+		{"invalid date", "yesterday", `"v1"`, modTime, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkIfRange(tt.ifRange, tt.etag, tt.modTime); got != tt.want {
+				t.Errorf("checkIfRange(%q, %q, %v) = %v, want %v", tt.ifRange, tt.etag, tt.modTime, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeRanges(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	content := make([]byte, 100)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	const etag = `"v1"`
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	manyRanges := make([]string, maxRanges+1)
+	for i := range manyRanges {
+		manyRanges[i] = fmt.Sprintf("%d-%d", i*2, i*2)
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		headers      map[string]string
+		status       int
+		contentRange string
+		body         []byte
+		parts        []string // Content-Range of each multipart part
+	}{
+		{
+			name:   "no range",
+			status: http.StatusOK,
+			body:   content,
+		},
+		{
+			name:         "single range",
+			headers:      map[string]string{"Range": "bytes=10-19"},
+			status:       http.StatusPartialContent,
+			contentRange: "bytes 10-19/100",
+			body:         content[10:20],
+		},
+		{
+			name:         "suffix range",
+			headers:      map[string]string{"Range": "bytes=-5"},
+			status:       http.StatusPartialContent,
+			contentRange: "bytes 95-99/100",
+			body:         content[95:],
+		},
+		{
+			name:         "open-ended range",
+			headers:      map[string]string{"Range": "bytes=90-"},
+			status:       http.StatusPartialContent,
+			contentRange: "bytes 90-99/100",
+			body:         content[90:],
+		},
+		{
+			name:         "head",
+			method:       http.MethodHead,
+			headers:      map[string]string{"Range": "bytes=0-9"},
+			status:       http.StatusPartialContent,
+			contentRange: "bytes 0-9/100",
+			body:         []byte{},
+		},
+		{
+			name:    "multiple ranges",
+			headers: map[string]string{"Range": "bytes=0-9,50-59"},
+			status:  http.StatusPartialContent,
+			parts:   []string{"bytes 0-9/100", "bytes 50-59/100"},
+		},
+		{
+			name:    "small overlap served",
+			headers: map[string]string{"Range": "bytes=0-9,5-14"},
+			status:  http.StatusPartialContent,
+			parts:   []string{"bytes 0-9/100", "bytes 5-14/100"},
+		},
+		{
+			name:    "overlap larger than content ignored",
+			headers: map[string]string{"Range": "bytes=0-99,0-99"},
+			status:  http.StatusOK,
+			body:    content,
+		},
+		{
+			name:    "too many ranges ignored",
+			headers: map[string]string{"Range": "bytes=" + strings.Join(manyRanges, ",")},
+			status:  http.StatusOK,
+			body:    content,
+		},
+		{
+			name:    "malformed range ignored",
+			headers: map[string]string{"Range": "bytes=9-1"},
+			status:  http.StatusOK,
+			body:    content,
+		},
+		{
+			name:         "unsatisfiable",
+			headers:      map[string]string{"Range": "bytes=100-"},
+			status:       http.StatusRequestedRangeNotSatisfiable,
+			contentRange: "bytes */100",
+		},
+		{
+			name:         "if-range etag matches",
+			headers:      map[string]string{"Range": "bytes=0-9", "If-Range": etag},
+			status:       http.StatusPartialContent,
+			contentRange: "bytes 0-9/100",
+			body:         content[:10],
+		},
+		{
+			name:    "if-range etag differs",
+			headers: map[string]string{"Range": "bytes=0-9", "If-Range": `"v0"`},
+			status:  http.StatusOK,
+			body:    content,
+		},
+		{
+			name:         "if-range date matches",
+			headers:      map[string]string{"Range": "bytes=0-9", "If-Range": modTime.Format(http.TimeFormat)},
+			status:       http.StatusPartialContent,
+			contentRange: "bytes 0-9/100",
+			body:         content[:10],
+		},
+		{
+			name:    "if-range date differs",
+			headers: map[string]string{"Range": "bytes=0-9", "If-Range": modTime.Add(-time.Minute).Format(http.TimeFormat)},
+			status:  http.StatusOK,
+			body:    content,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(method, "/video", nil)
+			for k, v := range tt.headers {
+				c.Request.Header.Set(k, v)
+			}
+
+			serveRanges(c, bytes.NewReader(content), int64(len(content)), "video/mp4", etag, modTime)
+			// gin writes the status of a bodiless response once the handler returns
+			c.Writer.WriteHeaderNow()
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Content-Range"); got != tt.contentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.contentRange)
+			}
+			if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+				t.Errorf("Accept-Ranges = %q, want bytes", got)
+			}
+			if got := w.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag = %q, want %q", got, etag)
+			}
+			if tt.body != nil && !bytes.Equal(w.Body.Bytes(), tt.body) {
+				t.Errorf("body = %v, want %v", w.Body.Bytes(), tt.body)
+			}
+			if tt.body != nil && tt.status != http.StatusRequestedRangeNotSatisfiable && method == http.MethodGet {
+				if got, want := w.Header().Get("Content-Length"), fmt.Sprint(len(tt.body)); got != want {
+					t.Errorf("Content-Length = %s, want %s", got, want)
+				}
+			}
+			if tt.parts != nil {
+				checkMultipartRanges(t, w, content, tt.parts)
+			}
+		})
+	}
+}
+
+// checkMultipartRanges checks that a multipart/byteranges response holds
+// the expected parts, each carrying the bytes named by its Content-Range.
+func checkMultipartRanges(t *testing.T, w *httptest.ResponseRecorder, content []byte, want []string) {
+	t.Helper()
+
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("Content-Type = %q, want multipart/byteranges", w.Header().Get("Content-Type"))
+	}
+	if got, want := w.Header().Get("Content-Length"), fmt.Sprint(w.Body.Len()); got != want {
+		t.Errorf("Content-Length = %s, body is %s bytes", got, want)
+	}
+
+	mr := multipart.NewReader(w.Body, params["boundary"])
+	for i := 0; ; i++ {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			if i != len(want) {
+				t.Errorf("got %d parts, want %d", i, len(want))
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("reading part %d: %v", i, err)
+		}
+		if i >= len(want) {
+			t.Fatalf("unexpected part %d", i)
+		}
+		if got := part.Header.Get("Content-Range"); got != want[i] {
+			t.Errorf("part %d Content-Range = %q, want %q", i, got, want[i])
+		}
+		if got := part.Header.Get("Content-Type"); got != "video/mp4" {
+			t.Errorf("part %d Content-Type = %q, want video/mp4", i, got)
+		}
+		var start, end, size int64
+		fmt.Sscanf(want[i], "bytes %d-%d/%d", &start, &end, &size)
+		body, _ := io.ReadAll(part)
+		if !bytes.Equal(body, content[start:end+1]) {
+			t.Errorf("part %d body = %v, want %v", i, body, content[start:end+1])
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"secure-video-api/internal/database"
+	"secure-video-api/internal/models"
+	"secure-video-api/internal/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UserRolesRequest struct {
+	Roles []string `json:"roles" binding:"required"`
+}
+
+// ListRoles lists the roles and the permissions they grant
+func (h *Handler) ListRoles(c *gin.Context) {
+	roles, err := h.Roles.List()
+	if err != nil {
+		log.Printf("[Roles] Error listing roles: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch roles"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// GetUserRoles shows a user's roles and the permissions they add up to
+func (h *Handler) GetUserRoles(c *gin.Context) {
+	access, err := h.Users.Access(c.Param("id"))
+	if err == repository.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("[Roles] Error fetching user roles: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch roles"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":     c.Param("id"),
+		"roles":       access.Roles,
+		"permissions": access.Permissions,
+	})
+}
+
+// SetUserRoles replaces a user's roles. Callers can only hand out, or take
+// away, roles whose permissions they hold themselves, and cannot change
+// their own roles.
+func (h *Handler) SetUserRoles(c *gin.Context) {
+	userID := c.Param("id")
+	var req UserRolesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if userID == c.GetString("user_id") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot change your own roles"})
+		return
+	}
+
+	canAssign, err := h.roleCheck(c)
+	if err == nil {
+		err = h.Users.SetRoles(userID, req.Roles, func(target *models.UserAccess) error {
+			return canAssign(append(req.Roles, target.Roles...))
+		})
+	}
+	if err == repository.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err == errRolesBeyondPermissions {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot grant or revoke roles beyond your own permissions"})
+		return
+	}
+	if errors.Is(err, database.ErrUnknownRole) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+		return
+	}
+	if err != nil {
+		log.Printf("[Roles] Error updating roles of user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update roles"})
+		return
+	}
+
+	log.Printf("[Roles] User %s set roles of %s to %v", c.GetString("user_id"), userID, req.Roles)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Roles updated successfully",
+		"user_id": userID,
+		"roles":   req.Roles,
+	})
+}
+
+// errRolesBeyondPermissions stops a role change the caller may not make.
+var errRolesBeyondPermissions = errors.New("roles beyond the caller's permissions")
+
+// roleCheck returns a function failing with errRolesBeyondPermissions
+// unless the caller holds every permission granted by the given roles.
+// Unknown roles are left for the repositories to reject.
+func (h *Handler) roleCheck(c *gin.Context) (func(roleIDs []string) error, error) {
+	roles, err := h.Roles.List()
+	if err != nil {
+		return nil, err
+	}
+	granted := make(map[string][]string, len(roles))
+	for _, role := range roles {
+		granted[role.ID] = role.Permissions
+	}
+
+	value, _ := c.Get("access")
+	caller, _ := value.(*models.UserAccess)
+	return func(roleIDs []string) error {
+		if caller == nil {
+			return errRolesBeyondPermissions
+		}
+		for _, id := range roleIDs {
+			for _, permission := range granted[id] {
+				if !caller.Can(permission) {
+					return errRolesBeyondPermissions
+				}
+			}
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"secure-video-api/internal/jwtkeys"
+	"secure-video-api/internal/models"
+	"secure-video-api/internal/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Default token lifetimes, overridden by ACCESS_TOKEN_TTL and
+// REFRESH_TOKEN_TTL (Go durations such as "10m" or "720h")
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// errInvalidRefreshToken covers unknown, expired, revoked and reused refresh
+// tokens alike, so callers learn nothing about which it was.
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+
+// tokenPair is what a client receives on login and refresh.
+type tokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int64 // access token lifetime in seconds
+}
+
+func (p *tokenPair) json() gin.H {
+	return gin.H{
+		"token":         p.AccessToken,
+		"refresh_token": p.RefreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    p.ExpiresIn,
+	}
+}
+
+// issueTokens starts a new session for a user: an access token and the
+// first refresh token of a new family.
+func (h *Handler) issueTokens(userID string) (*tokenPair, error) {
+	refreshToken, stored, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	stored.UserID = userID
+	stored.FamilyID = uuid.New().String()
+	if err := h.Tokens.CreateRefreshToken(stored); err != nil {
+		return nil, err
+	}
+	return h.accessTokenPair(userID, refreshToken)
+}
+
+// rotateRefreshToken exchanges a refresh token for a new pair. Each refresh
+// token is single use: presenting one that was already rotated means it
+// leaked, so the whole family is revoked and the session ends.
+func (h *Handler) rotateRefreshToken(refreshToken string) (*tokenPair, error) {
+	newToken, stored, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	err = h.Tokens.RotateRefreshToken(hashRefreshToken(refreshToken), stored)
+	if err == repository.ErrNotFound || errors.Is(err, repository.ErrTokenReused) {
+		return nil, fmt.Errorf("%w: %v", errInvalidRefreshToken, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return h.accessTokenPair(stored.UserID, newToken)
+}
+
+// revokeRefreshToken ends the session a refresh token belongs to, if the
+// token is one of userID's.
+func (h *Handler) revokeRefreshToken(refreshToken, userID string) error {
+	return h.Tokens.RevokeRefreshFamily(hashRefreshToken(refreshToken), userID)
+}
+
+// newRefreshToken generates a refresh token, returning it with the form it
+// is stored in; the caller sets the user and family.
+func newRefreshToken() (string, *models.RefreshToken, error) {
+	ttl, err := tokenTTL("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL)
+	if err != nil {
+		return "", nil, err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return "", nil, fmt.Errorf("failed to generate refresh token: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	now := time.Now()
+	return token, &models.RefreshToken{
+		ID:        uuid.New().String(),
+		TokenHash: hashRefreshToken(token),
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}, nil
+}
+
+func (h *Handler) accessTokenPair(userID, refreshToken string) (*tokenPair, error) {
+	ttl, err := tokenTTL("ACCESS_TOKEN_TTL", defaultAccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	access, err := h.Users.Access(userID)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := generateToken(userID, access, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(ttl / time.Second),
+	}, nil
+}
+
+// generateToken signs an access token. Its jti lets it be revoked before
+// it expires; roles and permissions let other services authorize the user
+// without calling back.
+func generateToken(userID string, access *models.UserAccess, ttl time.Duration) (string, error) {
+	now := time.Now()
+	return jwtkeys.Keys.Sign(jwt.MapClaims{
+		"user_id":     userID,
+		"is_admin":    access.IsAdmin,
+		"roles":       access.Roles,
+		"permissions": access.Permissions,
+		"jti":         uuid.New().String(),
+		"iat":         now.Unix(),
+		"exp":         now.Add(ttl).Unix(),
+	})
+}
+
+// hashRefreshToken is the form refresh tokens are stored in. The tokens are
+// random, so an unsalted fast hash is enough.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func tokenTTL(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return ttl, nil
+}
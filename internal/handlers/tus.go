@@ -0,0 +1,537 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"secure-video-api/internal/kms"
+	"secure-video-api/internal/media"
+	"secure-video-api/internal/models"
+	"secure-video-api/internal/repository"
+	"secure-video-api/internal/storage"
+	"secure-video-api/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Resumable uploads implement the tus 1.0 protocol (core, creation and
+// termination extensions). Received bytes are staged encrypted under a
+// per-upload key and only turned into a video, through the same pipeline
+// as UploadVideo, once the whole file has arrived.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination"
+	tusMaxSize    = 50 << 30 // 50 GB
+)
+
+// Requests writing to an upload lease its row first, so requests on this
+// or any other replica sharing the database never interleave writes to its
+// staging file. The lease is renewed as the request reads, and a request
+// that cannot renew it stops before writing anything more.
+const (
+	uploadLeaseDuration      = time.Minute
+	uploadLeaseRenewInterval = 20 * time.Second
+)
+
+// uploadLease is a request's lease on an upload.
+type uploadLease struct {
+	uploads repository.UploadRepository
+	id      string
+	holder  string
+	renewed time.Time
+}
+
+// leaseUpload leases an upload to the current request.
+func (h *Handler) leaseUpload(id string) (*uploadLease, error) {
+	now := time.Now()
+	lease := &uploadLease{uploads: h.Uploads, id: id, holder: uuid.New().String(), renewed: now}
+	if err := h.Uploads.Lease(id, lease.holder, now.Add(uploadLeaseDuration)); err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
+
+// release ends the lease, letting the next request in at once.
+func (l *uploadLease) release() {
+	if err := l.uploads.ReleaseLease(l.id, l.holder); err != nil {
+		log.Printf("[Tus] Error releasing lease on upload %s: %v", l.id, err)
+	}
+}
+
+// reader returns a reader over r that renews the lease as it is read, and
+// fails instead of returning data once the lease cannot be renewed.
+func (l *uploadLease) reader(r io.Reader) io.Reader {
+	return &leasedReader{lease: l, r: r}
+}
+
+type leasedReader struct {
+	lease *uploadLease
+	r     io.Reader
+}
+
+func (lr *leasedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	// Checked after reading, since a read may block for longer than the
+	// lease lasts
+	l := lr.lease
+	if time.Since(l.renewed) >= uploadLeaseRenewInterval {
+		now := time.Now()
+		if renewErr := l.uploads.RenewLease(l.id, l.holder, now.Add(uploadLeaseDuration)); renewErr != nil {
+			return 0, fmt.Errorf("failed to renew lease on upload %s: %w", l.id, renewErr)
+		}
+		l.renewed = now
+	}
+	return n, err
+}
+
+// respondUploadLeaseError answers a request that could not lease an upload.
+func respondUploadLeaseError(c *gin.Context, err error) {
+	switch err {
+	case repository.ErrLeased:
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload is being written by another request"})
+	case repository.ErrNotFound:
+		respondUploadLookupError(c, errUploadNotFound)
+	default:
+		respondUploadLookupError(c, err)
+	}
+}
+
+// checkTusResumable rejects requests speaking another protocol version.
+func checkTusResumable(c *gin.Context) bool {
+	c.Header("Tus-Resumable", tusVersion)
+	if c.Request.Method == http.MethodOptions {
+		return true
+	}
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Unsupported tus version"})
+		return false
+	}
+	return true
+}
+
+// TusOptions advertises the server's tus capabilities (admin only)
+func TusOptions(c *gin.Context) {
+	checkTusResumable(c)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(tusMaxSize, 10))
+	c.Status(http.StatusNoContent)
+}
+
+// CreateUpload starts a resumable upload (admin only)
+func (h *Handler) CreateUpload(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A valid Upload-Length header is required"})
+		return
+	}
+	if length > tusMaxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Upload exceeds Tus-Max-Size"})
+		return
+	}
+
+	rawMetadata := c.GetHeader("Upload-Metadata")
+	metadata, err := parseTusMetadata(rawMetadata)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Metadata", "details": err.Error()})
+		return
+	}
+	ext := strings.ToLower(filepath.Ext(metadata["filename"]))
+	if !allowedVideoExts[ext] {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":              "Invalid file type",
+			"details":            "Upload-Metadata must include a filename ending in .mp4, .mov, .avi or .mkv",
+			"received_extension": ext,
+		})
+		return
+	}
+	if visibility, ok := metadata["visibility"]; ok && !models.ValidVisibility(visibility) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Visibility must be private, restricted or all-users"})
+		return
+	}
+
+	// Staged bytes are encrypted under their own key from the first byte
+	stagingKey, err := utils.GenerateDataKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Encryption key error"})
+		return
+	}
+	wrappedKey, keyVersion, err := kms.Provider.WrapKey(stagingKey)
+	if err != nil {
+		log.Printf("[Tus] Error wrapping staging key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Encryption key error"})
+		return
+	}
+
+	uploadID := uuid.New().String()
+	stagingPath, err := uploadStagingPath(uploadID)
+	if err != nil {
+		log.Printf("[Tus] Error preparing staging area: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload"})
+		return
+	}
+	if err := os.WriteFile(stagingPath, nil, 0600); err != nil {
+		log.Printf("[Tus] Error creating staging file: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload"})
+		return
+	}
+
+	err = h.Uploads.Create(&models.Upload{
+		ID:         uploadID,
+		Length:     length,
+		Metadata:   rawMetadata,
+		WrappedKey: base64.StdEncoding.EncodeToString(wrappedKey),
+		KeyVersion: keyVersion,
+		CreatedBy:  c.GetString("user_id"),
+	})
+	if err != nil {
+		os.Remove(stagingPath)
+		log.Printf("[Tus] Error saving upload: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload"})
+		return
+	}
+
+	log.Printf("[Tus] Created upload %s (%d bytes, file %s)", uploadID, length, metadata["filename"])
+
+	c.Header("Location", "/api/admin/uploads/"+uploadID)
+	c.Header("Upload-Offset", "0")
+	c.Status(http.StatusCreated)
+}
+
+// UploadStatus reports the offset of a resumable upload (admin only)
+func (h *Handler) UploadStatus(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+
+	upload, err := h.getUpload(c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		respondUploadLookupError(c, err)
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		c.Header("Upload-Metadata", upload.Metadata)
+	}
+	// The video ID is recorded before the video is stored, so only point at
+	// it once it exists
+	if upload.VideoID != "" {
+		if _, err := h.Videos.Get(upload.VideoID); err == nil {
+			c.Header("Location", "/api/videos/"+upload.VideoID+"/stream")
+		}
+	}
+	c.Status(http.StatusOK)
+}
+
+// PatchUpload appends a chunk to a resumable upload (admin only)
+func (h *Handler) PatchUpload(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/offset+octet-stream"})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A valid Upload-Offset header is required"})
+		return
+	}
+
+	uploadID := c.Param("id")
+	lease, err := h.leaseUpload(uploadID)
+	if err != nil {
+		respondUploadLeaseError(c, err)
+		return
+	}
+	defer lease.release()
+
+	upload, err := h.getUpload(uploadID, c.GetString("user_id"))
+	if err != nil {
+		respondUploadLookupError(c, err)
+		return
+	}
+	if offset != upload.Offset {
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload-Offset does not match the current offset"})
+		return
+	}
+
+	if upload.Offset < upload.Length {
+		if err := h.appendToUpload(upload, lease, c.Request.Body); err != nil {
+			log.Printf("[Tus] Error appending to upload %s: %v", upload.ID, err)
+		}
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if upload.Offset < upload.Length {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	// The whole file is staged: turn it into a video. Re-sending an empty
+	// PATCH at the final offset retries this step if it failed.
+	videoID, err := h.finishUpload(upload, lease)
+	if errors.Is(err, media.ErrInvalidMedia) {
+		// Retrying cannot fix the content, so the upload is discarded
+		log.Printf("[Tus] Rejected upload %s: %v", upload.ID, err)
+		if err := h.removeUpload(upload.ID); err != nil {
+			log.Printf("[Tus] Error removing upload %s: %v", upload.ID, err)
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video file", "details": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("[Tus] Error finishing upload %s: %v", upload.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process completed upload", "details": err.Error()})
+		return
+	}
+	c.Header("Location", "/api/videos/"+videoID+"/stream")
+	c.Status(http.StatusNoContent)
+}
+
+// TerminateUpload discards a resumable upload (admin only)
+func (h *Handler) TerminateUpload(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+
+	uploadID := c.Param("id")
+	lease, err := h.leaseUpload(uploadID)
+	if err != nil {
+		respondUploadLeaseError(c, err)
+		return
+	}
+	defer lease.release()
+
+	upload, err := h.getUpload(uploadID, c.GetString("user_id"))
+	if err != nil {
+		respondUploadLookupError(c, err)
+		return
+	}
+
+	if err := h.removeUpload(upload.ID); err != nil {
+		log.Printf("[Tus] Error terminating upload %s: %v", upload.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to terminate upload"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// appendToUpload stages the request body and advances the upload offset by
+// the bytes that were completely staged, even if the body was cut short.
+func (h *Handler) appendToUpload(upload *models.Upload, lease *uploadLease, body io.Reader) error {
+	stagingKey, err := videoDataKey(upload.WrappedKey, upload.KeyVersion)
+	if err != nil {
+		return err
+	}
+	stagingPath, err := uploadStagingPath(upload.ID)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(stagingPath, os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open staging file: %v", err)
+	}
+	defer file.Close()
+
+	// Drop anything written after the last recorded offset, e.g. by a
+	// request that was interrupted by a crash
+	if err := file.Truncate(upload.StagedSize); err != nil {
+		return fmt.Errorf("failed to truncate staging file: %v", err)
+	}
+	if _, err := file.Seek(upload.StagedSize, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek staging file: %v", err)
+	}
+
+	remaining := upload.Length - upload.Offset
+	progress, appendErr := utils.AppendStagingRecords(file, lease.reader(io.LimitReader(body, remaining)), stagingKey, upload.ID, upload.StagedRecords)
+	if err := file.Sync(); err != nil && appendErr == nil {
+		appendErr = fmt.Errorf("failed to sync staging file: %v", err)
+	}
+
+	upload.Offset += progress.PlaintextBytes
+	upload.StagedSize += progress.StagedBytes
+	upload.StagedRecords += progress.Records
+	err = h.Uploads.SetProgress(upload, lease.holder)
+	if err != nil {
+		return fmt.Errorf("failed to record upload offset: %v", err)
+	}
+	return appendErr
+}
+
+// finishUpload decrypts the staged file into the regular video pipeline and
+// removes the upload once the video is registered. The video ID is recorded
+// on the upload before anything is stored, so a retry after a failure
+// resumes the same video rather than creating a second one.
+func (h *Handler) finishUpload(upload *models.Upload, lease *uploadLease) (string, error) {
+	var videoID string
+	if upload.VideoID != "" {
+		videoID = upload.VideoID
+		_, err := h.Videos.Get(videoID)
+		if err == nil {
+			// An earlier attempt stored the video but did not clean up
+			if err := h.removeUpload(upload.ID); err != nil {
+				log.Printf("[Tus] Error cleaning up upload %s: %v", upload.ID, err)
+			}
+			return videoID, nil
+		}
+		if err != repository.ErrNotFound {
+			return "", err
+		}
+		// An earlier attempt failed before storing the video
+		if err := h.Videos.DiscardFiles(videoID); err != nil {
+			return "", err
+		}
+	} else {
+		videoID = uuid.New().String()
+		if err := h.Uploads.SetVideoID(upload.ID, videoID); err != nil {
+			return "", err
+		}
+		upload.VideoID = videoID
+	}
+
+	metadata, _ := parseTusMetadata(upload.Metadata)
+	filename := metadata["filename"]
+	ext := strings.ToLower(filepath.Ext(filename))
+	title := metadata["title"]
+	if strings.TrimSpace(title) == "" {
+		title = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	}
+	visibility := metadata["visibility"]
+	if visibility == "" {
+		visibility = models.VisibilityAllUsers
+	}
+
+	stagingKey, err := videoDataKey(upload.WrappedKey, upload.KeyVersion)
+	if err != nil {
+		return "", err
+	}
+	stagingPath, err := uploadStagingPath(upload.ID)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(stagingPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open staging file: %v", err)
+	}
+	defer file.Close()
+
+	src, err := utils.NewStagingReader(lease.reader(io.LimitReader(file, upload.StagedSize)), stagingKey, upload.ID)
+	if err != nil {
+		return "", err
+	}
+
+	stored, err := h.storeEncryptedVideo(src, videoID, videoID+ext)
+	if err != nil {
+		return "", err
+	}
+	if stored.Size != upload.Length {
+		h.discardVideoFiles(videoID)
+		return "", fmt.Errorf("staged %d bytes, expected %d", stored.Size, upload.Length)
+	}
+
+	jobID, err := h.insertVideo(videoID, title, metadata["description"], visibility, upload.CreatedBy, stored)
+	if err != nil {
+		h.discardVideoFiles(videoID)
+		return "", fmt.Errorf("failed to save video metadata: %v", err)
+	}
+
+	if err := h.removeUpload(upload.ID); err != nil {
+		log.Printf("[Tus] Error cleaning up upload %s: %v", upload.ID, err)
+	}
+
+	log.Printf("[Tus] Upload %s completed as video %s (job %s)", upload.ID, videoID, jobID)
+	return videoID, nil
+}
+
+func (h *Handler) removeUpload(uploadID string) error {
+	stagingPath, err := uploadStagingPath(uploadID)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(stagingPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return h.Uploads.Delete(uploadID)
+}
+
+// errUploadNotFound is returned by getUpload for unknown uploads and for
+// uploads created by another user.
+var errUploadNotFound = fmt.Errorf("upload not found")
+
+func (h *Handler) getUpload(uploadID, userID string) (*models.Upload, error) {
+	upload, err := h.Uploads.Get(uploadID)
+	if err == repository.ErrNotFound {
+		return nil, errUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if upload.CreatedBy != userID {
+		return nil, errUploadNotFound
+	}
+	return upload, nil
+}
+
+func respondUploadLookupError(c *gin.Context, err error) {
+	if err == errUploadNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return
+	}
+	log.Printf("[Tus] Error loading upload: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+}
+
+// uploadStagingPath returns where an upload's encrypted bytes are staged.
+func uploadStagingPath(uploadID string) (string, error) {
+	encryptedDir, err := storage.Dir()
+	if err != nil {
+		return "", err
+	}
+	stagingDir := filepath.Join(encryptedDir, "uploads")
+	if err := os.MkdirAll(stagingDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %v", err)
+	}
+	return filepath.Join(stagingDir, uploadID+".part"), nil
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma separated
+// "key base64value" pairs, where the value may be omitted.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, fmt.Errorf("empty metadata key")
+		}
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("metadata %q is not valid base64", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
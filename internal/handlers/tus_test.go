@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"secure-video-api/internal/models"
+	"secure-video-api/internal/repository"
+)
+
+func TestUploadLease(t *testing.T) {
+	h := New(repository.NewMemory())
+	if err := h.Uploads.Create(&models.Upload{ID: "upload", Length: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	lease, err := h.leaseUpload("upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.leaseUpload("upload"); err != repository.ErrLeased {
+		t.Fatalf("second lease: got %v, want ErrLeased", err)
+	}
+
+	// A request reading past the renewal interval renews its lease
+	lease.renewed = time.Now().Add(-uploadLeaseRenewInterval)
+	if _, err := io.ReadAll(lease.reader(strings.NewReader("data"))); err != nil {
+		t.Fatalf("reading under a held lease: %v", err)
+	}
+	if time.Since(lease.renewed) >= uploadLeaseRenewInterval {
+		t.Error("lease not renewed")
+	}
+
+	// Once another request has taken the upload over, the first one gets
+	// no more data to write
+	if err := h.Uploads.RenewLease("upload", lease.holder, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	next, err := h.leaseUpload("upload")
+	if err != nil {
+		t.Fatalf("leasing an upload whose lease lapsed: %v", err)
+	}
+	lease.renewed = time.Now().Add(-uploadLeaseRenewInterval)
+	n, err := lease.reader(strings.NewReader("data")).Read(make([]byte, 4))
+	if n != 0 || !errors.Is(err, repository.ErrLeased) {
+		t.Errorf("reading after losing the lease = %d, %v; want no data and ErrLeased", n, err)
+	}
+
+	next.release()
+	if _, err := h.leaseUpload("upload"); err != nil {
+		t.Errorf("leasing a released upload: %v", err)
+	}
+}
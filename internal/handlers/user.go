@@ -1,11 +1,12 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
-	"time"
 
 	"secure-video-api/internal/models"
 	"secure-video-api/internal/database"
+	"secure-video-api/internal/repository"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
@@ -13,10 +14,11 @@ import (
 )
 
 // RegisterAdmin registers a new admin user (super admin only)
-func RegisterAdmin(c *gin.Context) {
+func (h *Handler) RegisterAdmin(c *gin.Context) {
 	var req struct {
-		Email    string `json:"email" binding:"required,email"`
-		Password string `json:"password" binding:"required,min=8"`
+		Email    string   `json:"email" binding:"required,email"`
+		Password string   `json:"password" binding:"required,min=8"`
+		Roles    []string `json:"roles"` // defaults to super-admin
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -25,15 +27,13 @@ func RegisterAdmin(c *gin.Context) {
 	}
 
 	// Check if user already exists
-	var count int
-	err := database.DB.QueryRow("SELECT COUNT(*) FROM users WHERE email = ?", req.Email).Scan(&count)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+	_, err := h.Users.FindByEmail(req.Email)
+	if err == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User already exists"})
 		return
 	}
-
-	if count > 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User already exists"})
+	if err != repository.ErrNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
 
@@ -44,15 +44,30 @@ func RegisterAdmin(c *gin.Context) {
 		return
 	}
 
-	// Get current time
-	currentTime := time.Now().Format("2006-01-02 15:04:05")
-
-	// Insert new admin user
-	_, err = database.DB.Exec(`
-		INSERT INTO users (id, email, password, is_admin, status, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`, uuid.New().String(), req.Email, string(hashedPassword), true, models.UserStatusActive, currentTime, currentTime)
+	// Admins can only be given roles within the caller's own permissions
+	if len(req.Roles) == 0 {
+		req.Roles = []string{models.RoleSuperAdmin}
+	}
+	canAssign, err := h.roleCheck(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if canAssign(req.Roles) != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot grant roles beyond your own permissions"})
+		return
+	}
 
+	// Insert new admin user with their roles
+	err = h.Users.Create(&models.User{
+		ID:       uuid.New().String(),
+		Email:    req.Email,
+		Password: string(hashedPassword),
+	}, req.Roles)
+	if errors.Is(err, database.ErrUnknownRole) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create admin user"})
 		return
@@ -65,7 +80,7 @@ func RegisterAdmin(c *gin.Context) {
 }
 
 // DeleteUser deletes a regular user (admin only)
-func DeleteUser(c *gin.Context) {
+func (h *Handler) DeleteUser(c *gin.Context) {
 	userID := c.Param("id")
 	if userID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID is required"})
@@ -73,11 +88,7 @@ func DeleteUser(c *gin.Context) {
 	}
 
 	// Verify if user exists and is not an admin
-	var user models.User
-	err := database.DB.QueryRow(`
-		SELECT id, email, is_admin FROM users WHERE id = ?
-	`, userID).Scan(&user.ID, &user.Email, &user.IsAdmin)
-
+	user, err := h.Users.Get(userID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
@@ -88,9 +99,8 @@ func DeleteUser(c *gin.Context) {
 		return
 	}
 
-	// Delete the user
-	_, err = database.DB.Exec("DELETE FROM users WHERE id = ?", userID)
-	if err != nil {
+	// Delete the user and end their sessions
+	if err := h.Users.Delete(userID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
 		return
 	}
@@ -102,7 +112,7 @@ func DeleteUser(c *gin.Context) {
 }
 
 // DeleteAdmin deletes an admin user (super admin only)
-func DeleteAdmin(c *gin.Context) {
+func (h *Handler) DeleteAdmin(c *gin.Context) {
 	userID := c.Param("id")
 	if userID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID is required"})
@@ -110,11 +120,7 @@ func DeleteAdmin(c *gin.Context) {
 	}
 
 	// Verify if user exists and is admin
-	var user models.User
-	err := database.DB.QueryRow(`
-		SELECT id, email, is_admin FROM users WHERE id = ?
-	`, userID).Scan(&user.ID, &user.Email, &user.IsAdmin)
-
+	user, err := h.Users.Get(userID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
@@ -125,9 +131,8 @@ func DeleteAdmin(c *gin.Context) {
 		return
 	}
 
-	// Delete the admin user
-	_, err = database.DB.Exec("DELETE FROM users WHERE id = ?", userID)
-	if err != nil {
+	// Delete the admin user and end their sessions
+	if err := h.Users.Delete(userID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete admin user"})
 		return
 	}
@@ -139,40 +144,12 @@ func DeleteAdmin(c *gin.Context) {
 }
 
 // ListUsers lists all users (admin only)
-func ListUsers(c *gin.Context) {
-	rows, err := database.DB.Query(`
-		SELECT 
-			u.id, 
-			u.email, 
-			u.is_admin,
-			u.status,
-			u.created_at, 
-			u.updated_at
-		FROM users u 
-		ORDER BY u.created_at DESC
-	`)
+func (h *Handler) ListUsers(c *gin.Context) {
+	users, err := h.Users.List()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
 		return
 	}
-	defer rows.Close()
-
-	var users []models.User
-	for rows.Next() {
-		var user models.User
-		err := rows.Scan(
-			&user.ID,
-			&user.Email,
-			&user.IsAdmin,
-			&user.Status,
-			&user.CreatedAt,
-			&user.UpdatedAt,
-		)
-		if err != nil {
-			continue
-		}
-		users = append(users, user)
-	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"users": users,
@@ -181,7 +158,7 @@ func ListUsers(c *gin.Context) {
 }
 
 // DeactivateUser deactivates a user's token (admin only)
-func DeactivateUser(c *gin.Context) {
+func (h *Handler) DeactivateUser(c *gin.Context) {
 	userID := c.Param("id")
 	if userID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID is required"})
@@ -189,26 +166,7 @@ func DeactivateUser(c *gin.Context) {
 	}
 
 	// Get current user status
-	var user models.User
-	var createdAt, updatedAt string
-	err := database.DB.QueryRow(`
-		SELECT 
-			id, 
-			email, 
-			is_admin,
-			status,
-			created_at, 
-			updated_at 
-		FROM users 
-		WHERE id = ?
-	`, userID).Scan(
-		&user.ID,
-		&user.Email,
-		&user.IsAdmin,
-		&user.Status,
-		&createdAt,
-		&updatedAt,
-	)
+	user, err := h.Users.Get(userID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
@@ -221,13 +179,14 @@ func DeactivateUser(c *gin.Context) {
 	}
 
 	// Update status to inactive
-	currentTime := time.Now().Format(time.RFC3339)
-	_, err = database.DB.Exec(`
-		UPDATE users 
-		SET status = ?, updated_at = ?
-		WHERE id = ?
-	`, models.UserStatusInactive, currentTime, userID)
-	if err != nil {
+	if err := h.Users.SetStatus(userID, models.UserStatusInactive); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deactivate user"})
+		return
+	}
+
+	// Access tokens stop working with the status change; refresh tokens
+	// are revoked so that reactivation does not bring old sessions back
+	if err := h.Tokens.RevokeUserRefreshTokens(userID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deactivate user"})
 		return
 	}
@@ -239,7 +198,7 @@ func DeactivateUser(c *gin.Context) {
 }
 
 // ReactivateUser reactivates a user's token (admin only)
-func ReactivateUser(c *gin.Context) {
+func (h *Handler) ReactivateUser(c *gin.Context) {
 	userID := c.Param("id")
 	if userID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID is required"})
@@ -247,26 +206,7 @@ func ReactivateUser(c *gin.Context) {
 	}
 
 	// Get current user status
-	var user models.User
-	var createdAt, updatedAt string
-	err := database.DB.QueryRow(`
-		SELECT 
-			id, 
-			email, 
-			is_admin,
-			status,
-			created_at, 
-			updated_at 
-		FROM users 
-		WHERE id = ?
-	`, userID).Scan(
-		&user.ID,
-		&user.Email,
-		&user.IsAdmin,
-		&user.Status,
-		&createdAt,
-		&updatedAt,
-	)
+	user, err := h.Users.Get(userID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
@@ -279,13 +219,7 @@ func ReactivateUser(c *gin.Context) {
 	}
 
 	// Update status to active
-	currentTime := time.Now().Format(time.RFC3339)
-	_, err = database.DB.Exec(`
-		UPDATE users 
-		SET status = ?, updated_at = ?
-		WHERE id = ?
-	`, models.UserStatusActive, currentTime, userID)
-	if err != nil {
+	if err := h.Users.SetStatus(userID, models.UserStatusActive); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reactivate user"})
 		return
 	}
@@ -1,17 +1,24 @@
 package handlers
 
 import (
+	"bufio"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 
-	"secure-video-api/internal/database"
+	"secure-video-api/internal/jobs"
+	"secure-video-api/internal/kms"
+	"secure-video-api/internal/media"
 	"secure-video-api/internal/models"
+	"secure-video-api/internal/repository"
+	"secure-video-api/internal/storage"
 	"secure-video-api/internal/utils"
 
 	"github.com/gin-gonic/gin"
@@ -23,237 +30,327 @@ type VideoRequest struct {
 	Description string `form:"description"`
 }
 
-func UploadVideo(c *gin.Context) {
+// maxFormFieldSize bounds the text fields accepted alongside an upload
+const maxFormFieldSize = 64 << 10
+
+var allowedVideoExts = map[string]bool{".mp4": true, ".mov": true, ".avi": true, ".mkv": true}
+
+// UploadVideo reads the multipart upload as a stream and encrypts the video
+// part as it arrives, so the plaintext never touches disk (not even as a
+// multipart temp file) and the ciphertext is written exactly once. The rest
+// of the processing happens in a background job; the video is listed once
+// that job has finished.
+func (h *Handler) UploadVideo(c *gin.Context) {
 	log.Println("Starting video upload process...")
 
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		log.Printf("Error reading multipart request: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Multipart form data is required"})
+		return
+	}
+
+	videoID := uuid.New().String()
 	var req VideoRequest
-	if err := c.ShouldBind(&req); err != nil {
-		log.Printf("Error binding request: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	visibility := models.VisibilityAllUsers
+	var stored *encryptedVideo
+	committed := false
+	defer func() {
+		if stored != nil && !committed {
+			h.discardVideoFiles(videoID)
+		}
+	}()
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("[Upload] Error reading upload: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Malformed multipart body", "details": err.Error()})
+			return
+		}
+
+		switch part.FormName() {
+		case "title", "description", "visibility":
+			value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize+1))
+			if err != nil || len(value) > maxFormFieldSize {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + part.FormName()})
+				return
+			}
+			switch part.FormName() {
+			case "title":
+				req.Title = string(value)
+			case "description":
+				req.Description = string(value)
+			default:
+				visibility = string(value)
+			}
+
+		case "video":
+			if stored != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Only one video file is allowed"})
+				return
+			}
+			if stored = h.receiveVideo(c, part, videoID, videoID); stored == nil {
+				return
+			}
+
+		default:
+			io.Copy(io.Discard, part)
+		}
+		part.Close()
+	}
+
+	if stored == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Video file is required"})
+		return
+	}
+	if strings.TrimSpace(req.Title) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Title is required"})
+		return
+	}
+	if !models.ValidVisibility(visibility) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Visibility must be private, restricted or all-users"})
 		return
 	}
 
-	file, err := c.FormFile("video")
+	// Save video metadata to database
+	userID := c.GetString("user_id")
+	jobID, err := h.insertVideo(videoID, req.Title, req.Description, visibility, userID, stored)
 	if err != nil {
-		log.Printf("Error getting video file: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Video file is required"})
+		log.Printf("Error saving video metadata: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":     "Failed to save video metadata",
+			"details":   err.Error(),
+			"video_id":  videoID,
+			"file_name": stored.FileName,
+		})
 		return
 	}
+	committed = true
+
+	// Log success
+	log.Printf("Successfully uploaded video: ID=%s, Title=%s, FileName=%s, Job=%s", videoID, req.Title, stored.FileName, jobID)
 
-	log.Printf("[Upload] Received video upload request - Title: %s, File: %s, Size: %d bytes",
-		req.Title, file.Filename, file.Size)
+	c.JSON(http.StatusAccepted, gin.H{
+		"id":          videoID,
+		"message":     "Video uploaded and queued for processing",
+		"file_name":   stored.FileName,
+		"uploaded_by": userID,
+		"visibility":  visibility,
+		"status":      jobs.StatusQueued,
+		"job_id":      jobID,
+	})
+}
 
+// receiveVideo stores the video file of an upload as storeEncryptedVideo
+// does, under baseName and the file's extension. If the file is rejected or
+// cannot be stored it responds with the error and returns nil.
+func (h *Handler) receiveVideo(c *gin.Context, part *multipart.Part, videoID, baseName string) *encryptedVideo {
 	// Validate file extension
-	ext := strings.ToLower(filepath.Ext(file.Filename))
-	allowedExts := map[string]bool{".mp4": true, ".mov": true, ".avi": true, ".mkv": true}
-	if !allowedExts[ext] {
+	ext := strings.ToLower(filepath.Ext(part.FileName()))
+	if !allowedVideoExts[ext] {
 		log.Printf("[Upload] Invalid file extension: %s", ext)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error":              "Invalid file type",
 			"details":            "Only video files (.mp4, .mov, .avi, .mkv) are allowed",
 			"received_extension": ext,
 		})
-		return
+		return nil
 	}
 
-	// Generate unique filename and ensure directories exist
-	videoID := uuid.New().String()
-	filename := videoID + ext
-
-	// Get absolute paths from environment
-	workDir, err := os.Getwd()
-	if err != nil {
-		log.Printf("[Upload] Error getting working directory: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get working directory"})
-		return
-	}
-
-	storagePath := os.Getenv("STORAGE_PATH")
-	encryptedPath := os.Getenv("ENCRYPTED_PATH")
-
-	// Convert to absolute paths if they're relative
-	if !filepath.IsAbs(storagePath) {
-		storagePath = filepath.Join(workDir, storagePath)
-	}
-	if !filepath.IsAbs(encryptedPath) {
-		encryptedPath = filepath.Join(workDir, encryptedPath)
-	}
-
-	log.Printf("[Upload] Using storage path: %s", storagePath)
-	log.Printf("[Upload] Using encrypted path: %s", encryptedPath)
-
-	// Ensure storage directories exist with proper permissions
-	if err := os.MkdirAll(storagePath, 0755); err != nil {
-		log.Printf("[Upload] Failed to create storage directory: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Failed to create storage directory",
+	log.Printf("[Upload] Receiving video - File: %s", part.FileName())
+	stored, err := h.storeEncryptedVideo(part, videoID, baseName+ext)
+	if errors.Is(err, media.ErrInvalidMedia) {
+		log.Printf("[Upload] Rejected %s: %v", part.FileName(), err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid video file",
 			"details": err.Error(),
-			"path":    storagePath,
 		})
-		return
+		return nil
 	}
-	if err := os.MkdirAll(encryptedPath, 0755); err != nil {
-		log.Printf("[Upload] Failed to create encrypted directory: %v", err)
+	if err != nil {
+		log.Printf("[Encryption] Failed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Failed to create encrypted directory",
+			"error":   "Encryption failed",
 			"details": err.Error(),
-			"path":    encryptedPath,
 		})
-		return
+		return nil
 	}
+	log.Printf("[Encryption] Encrypted %d bytes of %s to %s", stored.Size, stored.MIMEType, stored.Path)
+	return stored
+}
 
-	uploadPath := filepath.Join(storagePath, filename)
-	encryptedPath = filepath.Join(encryptedPath, filename+".enc")
+// encryptedVideo describes a video whose ciphertext has been written to
+// ENCRYPTED_PATH but which may not be registered in the database yet. Its
+// file is staged with Videos.StageFile: it is kept once insertVideo
+// succeeds and removed by discardVideoFiles otherwise.
+type encryptedVideo struct {
+	FileName   string
+	Path       string
+	WrappedKey string
+	KeyVersion int
+	Size       int64
+	MIMEType   string
+	Metadata   *media.Metadata
+}
 
-	// Open uploaded file
-	src, err := file.Open()
+// storeEncryptedVideo encrypts src under a fresh data key as it is read and
+// writes the ciphertext to ENCRYPTED_PATH as fileName.enc. The content must
+// be a well-formed container matching the extension of fileName; otherwise
+// an error wrapping media.ErrInvalidMedia is returned and nothing is stored.
+func (h *Handler) storeEncryptedVideo(src io.Reader, videoID, fileName string) (*encryptedVideo, error) {
+	ext := filepath.Ext(fileName)
+	encryptedDir, err := storage.Dir()
 	if err != nil {
-		log.Printf("Error opening uploaded file: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to open uploaded file: %v", err)})
-		return
+		return nil, err
 	}
-	defer src.Close()
 
-	// Create destination file
-	if err := os.MkdirAll(filepath.Dir(uploadPath), 0755); err != nil {
-		log.Printf("Error creating directory: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create directory: %v", err)})
-		return
+	// Reject anything that is not a video before encrypting a single byte
+	body := bufio.NewReaderSize(src, media.SniffLen)
+	header, err := body.Peek(media.SniffLen)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to receive video: %v", err)
 	}
-
-	dst, err := os.Create(uploadPath)
+	format, err := media.Sniff(header)
 	if err != nil {
-		log.Printf("Error creating destination file: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create destination file: %v", err)})
-		return
+		return nil, err
+	}
+	if !format.MatchesExt(ext) {
+		return nil, fmt.Errorf("%w: %s content does not match the %s extension", media.ErrInvalidMedia, format.MIMEType, ext)
 	}
-	defer dst.Close()
 
-	// Copy file in chunks
-	if _, err = io.Copy(dst, src); err != nil {
-		os.Remove(uploadPath)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save video: %v", err)})
-		return
+	// Every video gets its own data key; only the wrapped form is stored
+	dataKey, err := utils.GenerateDataKey()
+	if err != nil {
+		return nil, err
+	}
+	wrappedKey, keyVersion, err := kms.Provider.WrapKey(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %v", err)
 	}
-	dst.Close() // Close before encryption
 
-	// Encrypt the video
-	key := []byte(os.Getenv("ENCRYPTION_KEY"))
-	log.Printf("[Encryption] Starting encryption process")
-	log.Printf("[Encryption] Key length: %d bytes", len(key))
-	log.Printf("[Encryption] Upload path: %s", uploadPath)
-	log.Printf("[Encryption] Encrypted path: %s", encryptedPath)
-	log.Printf("[Encryption] Upload file exists: %v", fileExists(uploadPath))
+	finalPath := filepath.Join(encryptedDir, fileName+".enc")
 
-	if fileInfo, err := os.Stat(uploadPath); err != nil {
-		log.Printf("[Encryption] Error checking upload file: %v", err)
-	} else {
-		log.Printf("[Encryption] Upload file size: %d bytes", fileInfo.Size())
-		log.Printf("[Encryption] Upload file permissions: %v", fileInfo.Mode())
+	tmp, err := os.CreateTemp(encryptedDir, ".upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create encrypted file: %v", err)
 	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
 
-	if len(key) != 32 {
-		os.Remove(uploadPath)
-		errMsg := fmt.Sprintf("Invalid key length: %d bytes (expected 32)", len(key))
-		log.Printf("[Encryption] Error: %s", errMsg)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":      "Encryption key error",
-			"details":    errMsg,
-			"key_length": len(key),
-		})
-		return
+	ew, err := utils.NewEncryptWriter(tmp, dataKey, videoID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(ew, body); err != nil {
+		return nil, fmt.Errorf("failed to receive video: %v", err)
+	}
+	if err := ew.Close(); err != nil {
+		return nil, err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		return nil, fmt.Errorf("failed to set encrypted file permissions: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish encrypted file: %v", err)
 	}
 
-	if err := utils.EncryptFile(uploadPath, encryptedPath, key); err != nil {
-		log.Printf("[Encryption] Failed: %v", err)
-		// Check encryption directory
-		if encDir := filepath.Dir(encryptedPath); true {
-			if info, err := os.Stat(encDir); err != nil {
-				log.Printf("[Encryption] Error accessing encrypted dir: %v", err)
-			} else {
-				log.Printf("[Encryption] Encrypted dir permissions: %v", info.Mode())
-			}
-		}
-		os.Remove(uploadPath)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":          "Encryption failed",
-			"details":        err.Error(),
-			"upload_path":    uploadPath,
-			"encrypted_path": encryptedPath,
-			"file_exists":    fileExists(uploadPath),
-			"enc_dir_exists": fileExists(filepath.Dir(encryptedPath)),
-		})
-		return
+	// Walk the container headers of what was stored and read the media
+	// metadata from them; only the chunks holding them are decrypted
+	stored, err := utils.NewDecryptingReader(tmp.Name(), dataKey, videoID)
+	if err != nil {
+		return nil, err
+	}
+	var metadata *media.Metadata
+	err = media.Validate(stored, stored.Size(), format)
+	if err == nil {
+		metadata, err = media.Probe(stored, stored.Size(), format)
+	}
+	stored.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := h.Videos.StageFile(videoID, fileName+".enc"); err != nil {
+		return nil, fmt.Errorf("failed to record encrypted file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), finalPath); err != nil {
+		h.discardVideoFiles(videoID)
+		return nil, fmt.Errorf("failed to move encrypted file into place: %v", err)
 	}
 
-	log.Printf("[Encryption] Successfully encrypted video to %s", encryptedPath)
+	return &encryptedVideo{
+		FileName:   fileName,
+		Path:       finalPath,
+		WrappedKey: base64.StdEncoding.EncodeToString(wrappedKey),
+		KeyVersion: keyVersion,
+		Size:       ew.Written(),
+		MIMEType:   format.MIMEType,
+		Metadata:   metadata,
+	}, nil
+}
 
-	// Remove the original file
-	os.Remove(uploadPath)
+// insertVideo registers a stored video together with the job that
+// processes it, returning the job ID.
+func (h *Handler) insertVideo(videoID, title, description, visibility, userID string, stored *encryptedVideo) (string, error) {
+	video := stored.video(videoID)
+	video.Title = title
+	video.Description = description
+	video.UploadedBy = userID
+	video.Visibility = visibility
+	return h.Videos.Create(video)
+}
 
-	// Save video metadata to database
-	userID, _ := c.Get("user_id")
-	currentTime := time.Now().Format(time.RFC3339)
-
-	_, err = database.DB.Exec(`
-		INSERT INTO videos (
-			id, 
-			title, 
-			description, 
-			file_name, 
-			uploaded_by, 
-			created_at, 
-			updated_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		videoID,
-		req.Title,
-		req.Description,
-		filename,
-		userID,
-		currentTime,
-		currentTime,
-	)
-	if err != nil {
-		log.Printf("Error saving video metadata: %v", err)
-		os.Remove(encryptedPath)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Failed to save video metadata",
-			"details": err.Error(),
-			"video_id": videoID,
-			"file_name": filename,
-		})
-		return
+// video returns a video holding the file fields of a stored video.
+func (v *encryptedVideo) video(videoID string) *models.Video {
+	return &models.Video{
+		ID:         videoID,
+		FileName:   v.FileName,
+		WrappedKey: v.WrappedKey,
+		KeyVersion: v.KeyVersion,
+		MIMEType:   v.MIMEType,
+		Duration:   v.Metadata.Duration,
+		Width:      v.Metadata.Width,
+		Height:     v.Metadata.Height,
+		VideoCodec: v.Metadata.VideoCodec,
+		AudioCodec: v.Metadata.AudioCodec,
+		Bitrate:    v.Metadata.Bitrate,
+		FileSize:   v.Metadata.FileSize,
 	}
+}
 
-	// Log success
-	log.Printf("Successfully uploaded video: ID=%s, Title=%s, FileName=%s", videoID, req.Title, filename)
-
-	c.JSON(http.StatusCreated, gin.H{
-		"id":          videoID,
-		"message":     "Video uploaded successfully",
-		"file_name":   filename,
-		"uploaded_by": userID,
-	})
+// discardVideoFiles removes the files staged for a video that was not
+// stored. Files left behind are removed later by storage.Recover.
+func (h *Handler) discardVideoFiles(videoID string) {
+	if err := h.Videos.DiscardFiles(videoID); err != nil {
+		log.Printf("[Upload] Error discarding files of video %s: %v", videoID, err)
+	}
 }
 
-func StreamVideo(c *gin.Context) {
+func (h *Handler) StreamVideo(c *gin.Context) {
 	videoID := c.Param("id")
 
 	// Get video metadata
-	var video models.Video
-	err := database.DB.QueryRow(
-		"SELECT file_name FROM videos WHERE id = ?",
-		videoID,
-	).Scan(&video.FileName)
+	video, err := h.Videos.Get(videoID)
 	if err != nil {
 		log.Printf("Error fetching video metadata: %v", err)
 		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
 		return
 	}
+	if video.Status != jobs.StatusReady {
+		c.JSON(http.StatusConflict, gin.H{"error": "Video is not ready", "status": video.Status})
+		return
+	}
 
-	encryptedPath := filepath.Join(os.Getenv("ENCRYPTED_PATH"), video.FileName+".enc")
-	tempPath := filepath.Join(os.TempDir(), uuid.New().String()+filepath.Ext(video.FileName))
-	defer os.Remove(tempPath)
+	encryptedDir, err := storage.Dir()
+	if err != nil {
+		log.Printf("Error resolving encrypted directory: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read video"})
+		return
+	}
+	encryptedPath := filepath.Join(encryptedDir, video.FileName+".enc")
 
 	// Check if encrypted file exists
 	if !fileExists(encryptedPath) {
@@ -262,178 +359,69 @@ func StreamVideo(c *gin.Context) {
 		return
 	}
 
-	// Create temporary directory with proper permissions
-	tempDir := filepath.Join(os.TempDir(), "secure-video")
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		log.Printf("Error creating temp directory: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to create temp directory",
-			"details": err.Error(),
-			"temp_dir": tempDir,
-		})
-		return
-	}
-
-	// Create temporary file path in our custom temp directory
-	tempPath = filepath.Join(tempDir, uuid.New().String()+filepath.Ext(video.FileName))
-	defer os.Remove(tempPath)
-
-	// Decrypt video to temp file
-	key := []byte(os.Getenv("ENCRYPTION_KEY"))
-	if len(key) != 32 {
-		log.Printf("Invalid encryption key length: %d", len(key))
+	key, err := videoDataKey(video.WrappedKey, video.KeyVersion)
+	if err != nil {
+		log.Printf("Error resolving video key: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid encryption key"})
 		return
 	}
 
-	// Create temp file with proper permissions
-	tempFile, err := os.OpenFile(tempPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
-	if err != nil {
-		log.Printf("Error creating temp file: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to create temp file",
-			"details": err.Error(),
-			"temp_path": tempPath,
-		})
+	// Open the video for on-demand decryption; only the chunks covering the
+	// requested bytes are decrypted, and only in memory
+	videoReader, err := utils.NewDecryptingReader(encryptedPath, key, videoID)
+	if err == nil {
+		defer videoReader.Close()
+		err = videoReader.Verify()
+	}
+	if errors.Is(err, utils.ErrIntegrity) {
+		respondContentError(c, err)
 		return
 	}
-	tempFile.Close()
-
-	if err := utils.DecryptFile(encryptedPath, tempPath, key); err != nil {
-		log.Printf("Error decrypting video: %v", err)
+	if err != nil {
+		log.Printf("Error opening encrypted video: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to decrypt video",
+			"error":   "Failed to open video",
 			"details": err.Error(),
-			"encrypted_path": encryptedPath,
-			"temp_path": tempPath,
 		})
 		return
 	}
 
-	// Stream the video
-	videoFile, err := os.Open(tempPath)
-	if err != nil {
-		log.Printf("Error opening decrypted video: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open video"})
-		return
-	}
-	defer videoFile.Close()
-
-	fileInfo, err := videoFile.Stat()
+	encInfo, err := os.Stat(encryptedPath)
 	if err != nil {
 		log.Printf("Error getting video info: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get video info"})
 		return
 	}
 
-	// Handle range requests for video streaming
-	rangeHeader := c.GetHeader("Range")
-	if rangeHeader != "" {
-		ranges, err := parseRange(rangeHeader, fileInfo.Size())
-		if err != nil {
-			log.Printf("Invalid range request: %v", err)
-			c.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{"error": "Invalid range"})
-			return
-		}
+	// The encrypted file is rewritten whenever the video content changes, so
+	// its modification time makes a suitable strong validator
+	size := videoReader.Size()
+	etag := fmt.Sprintf(`"%s-%x-%x"`, videoID, encInfo.ModTime().UnixNano(), size)
 
-		length := ranges[1] - ranges[0] + 1
-		c.Status(http.StatusPartialContent)
-		c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", ranges[0], ranges[1], fileInfo.Size()))
-		c.Header("Content-Length", fmt.Sprintf("%d", length))
-		c.Header("Accept-Ranges", "bytes")
-		c.Header("Content-Type", "video/mp4")
-
-		videoFile.Seek(ranges[0], 0)
-		io.CopyN(c.Writer, videoFile, length)
-		return
+	// Videos stored before content sniffing have no recorded type
+	contentType := video.MIMEType
+	if contentType == "" {
+		contentType = media.MIMETypeForExt(strings.ToLower(filepath.Ext(video.FileName)))
 	}
 
-	// Stream entire video if no range is specified
-	c.Header("Content-Length", fmt.Sprintf("%d", fileInfo.Size()))
-	c.Header("Content-Type", "video/mp4")
-	io.Copy(c.Writer, videoFile)
-	io.Copy(c.Writer, videoFile)
+	serveRanges(c, videoReader, size, contentType, etag, encInfo.ModTime())
 }
 
-func parseRange(rangeHeader string, size int64) ([]int64, error) {
-	var start, end int64
-	fmt.Sscanf(rangeHeader, "bytes=%d-%d", &start, &end)
-	if end == 0 {
-		end = size - 1
-	}
-	if start > end || start < 0 || end >= size {
-		return nil, fmt.Errorf("invalid range")
-	}
-	return []int64{start, end}, nil
-}
-
-func ListVideos(c *gin.Context) {
+func (h *Handler) ListVideos(c *gin.Context) {
 	log.Println("Starting to fetch videos...")
 
-	// Get all videos from database
-	rows, err := database.DB.Query(`
-		SELECT 
-			v.id, 
-			v.title, 
-			v.description, 
-			v.file_name, 
-			v.uploaded_by, 
-			v.created_at, 
-			v.updated_at 
-		FROM videos v 
-		ORDER BY v.created_at DESC
-	`)
+	// Get the videos the user can see from database
+	value, _ := c.Get("access")
+	access, _ := value.(*models.UserAccess)
+	videos, err := h.Videos.ListVisible(c.GetString("user_id"), access)
 	if err != nil {
 		log.Printf("Error fetching videos: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch videos"})
 		return
 	}
-	defer rows.Close()
-
-	// Create slice to hold video data
-	var videos []models.Video
-	for rows.Next() {
-		var video models.Video
-		var createdAt, updatedAt string
-		err := rows.Scan(
-			&video.ID,
-			&video.Title,
-			&video.Description,
-			&video.FileName,
-			&video.UploadedBy,
-			&createdAt,
-			&updatedAt,
-		)
-		if err != nil {
-			log.Printf("Error scanning video row: %v", err)
-			continue
-		}
-
-		// Convert string timestamps to time.Time
-		video.CreatedAt, err = time.Parse(time.RFC3339, createdAt)
-		if err != nil {
-			log.Printf("Error parsing created_at: %v", err)
-			continue
-		}
-		video.UpdatedAt, err = time.Parse(time.RFC3339, updatedAt)
-		if err != nil {
-			log.Printf("Error parsing updated_at: %v", err)
-			continue
-		}
-
-		// Log video details
+	for _, video := range videos {
 		log.Printf("Scanned video: ID=%s, Title=%s, FileName=%s, UploadedBy=%s, CreatedAt=%v, UpdatedAt=%v",
 			video.ID, video.Title, video.FileName, video.UploadedBy, video.CreatedAt, video.UpdatedAt)
-
-		// Add to videos slice
-		videos = append(videos, video)
-	}
-
-	// Check for any errors after scanning
-	if err = rows.Err(); err != nil {
-		log.Printf("Error after scanning rows: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error reading videos"})
-		return
 	}
 
 	// Log total number of videos
@@ -446,8 +434,7 @@ func ListVideos(c *gin.Context) {
 	})
 }
 
-
-func UpdateVideo(c *gin.Context) {
+func (h *Handler) UpdateVideo(c *gin.Context) {
 	videoID := c.Param("id")
 	var req VideoRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -455,55 +442,138 @@ func UpdateVideo(c *gin.Context) {
 		return
 	}
 
-	result, err := database.DB.Exec(
-		"UPDATE videos SET title = ?, description = ? WHERE id = ?",
-		req.Title, req.Description, videoID,
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update video"})
+	err := h.Videos.UpdateDetails(videoID, req.Title, req.Description)
+	if err == repository.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
 		return
 	}
-
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update video"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Video updated successfully"})
 }
 
-func DeleteVideo(c *gin.Context) {
+// ReplaceVideoFile replaces the file of a video with the video part of a
+// multipart upload, read as UploadVideo reads it. The video keeps its ID,
+// details and access grants; it is processed again, and listed once that is
+// done. The old file is removed once the new one is stored.
+func (h *Handler) ReplaceVideoFile(c *gin.Context) {
 	videoID := c.Param("id")
+	if _, err := h.Videos.Get(videoID); err == repository.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch video"})
+		return
+	}
 
-	// Get video filename
-	var filename string
-	err := database.DB.QueryRow("SELECT file_name FROM videos WHERE id = ?", videoID).Scan(&filename)
+	reader, err := c.Request.MultipartReader()
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Multipart form data is required"})
 		return
 	}
 
-	// Delete encrypted file
-	encryptedPath := filepath.Join(os.Getenv("ENCRYPTED_PATH"), filename+".enc")
-	os.Remove(encryptedPath)
+	var stored *encryptedVideo
+	committed := false
+	defer func() {
+		if stored != nil && !committed {
+			h.discardVideoFiles(videoID)
+		}
+	}()
 
-	// Delete from database
-	result, err := database.DB.Exec("DELETE FROM videos WHERE id = ?", videoID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete video"})
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("[Upload] Error reading upload: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Malformed multipart body", "details": err.Error()})
+			return
+		}
+
+		if part.FormName() != "video" {
+			io.Copy(io.Discard, part)
+			part.Close()
+			continue
+		}
+		if stored != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only one video file is allowed"})
+			return
+		}
+		// The new file gets a name of its own, so the old one stays in
+		// place until the row no longer refers to it
+		if stored = h.receiveVideo(c, part, videoID, videoID+"."+uuid.New().String()[:8]); stored == nil {
+			return
+		}
+		part.Close()
+	}
+
+	if stored == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Video file is required"})
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
+	jobID, err := h.Videos.ReplaceFile(stored.video(videoID))
+	switch {
+	case err == repository.ErrNotFound:
 		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
 		return
+	case err == repository.ErrBusy:
+		c.JSON(http.StatusConflict, gin.H{"error": "Video is being processed, try again once it is done"})
+		return
+	case err != nil:
+		log.Printf("Error replacing file of video %s: %v", videoID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to replace video file"})
+		return
+	}
+	committed = true
+
+	log.Printf("Replaced file of video %s with %s, Job=%s", videoID, stored.FileName, jobID)
+	c.JSON(http.StatusAccepted, gin.H{
+		"id":        videoID,
+		"message":   "Video file replaced and queued for processing",
+		"file_name": stored.FileName,
+		"status":    jobs.StatusQueued,
+		"job_id":    jobID,
+	})
+}
+
+func (h *Handler) DeleteVideo(c *gin.Context) {
+	videoID := c.Param("id")
+
+	// The row goes first, with its access grants and jobs, so no request
+	// finds it pointing at missing files; the files follow
+	err := h.Videos.Delete(videoID)
+	if err == repository.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete video"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Video deleted successfully"})
 }
 
+// videoDataKey returns the key a video was encrypted with: its unwrapped
+// data key, or the master key itself for videos stored before envelope
+// encryption was introduced.
+func videoDataKey(wrappedKey string, keyVersion int) ([]byte, error) {
+	if wrappedKey == "" {
+		return kms.Provider.GetKey(keyVersion)
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode wrapped key: %v", err)
+	}
+	return kms.Provider.UnwrapKey(wrapped, keyVersion)
+}
+
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return !os.IsNotExist(err)
@@ -0,0 +1,411 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"secure-video-api/internal/database"
+	"secure-video-api/internal/kms"
+	"secure-video-api/internal/models"
+	"secure-video-api/internal/repository"
+	"secure-video-api/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// listVideoIDs returns the sorted IDs of the videos a user can list.
+func (s *testServer) listVideoIDs(token string) []string {
+	s.t.Helper()
+	w := s.do(http.MethodGet, "/videos", token, nil)
+	s.expect(w, http.StatusOK)
+	var resp struct {
+		Videos []models.Video `json:"videos"`
+	}
+	decode(s.t, w, &resp)
+	ids := []string{}
+	for _, video := range resp.Videos {
+		ids = append(ids, video.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func sortedIDs(ids ...string) []string {
+	sort.Strings(ids)
+	return ids
+}
+
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestListVideosVisibility(t *testing.T) {
+	s := newTestServer(t)
+	uploader := s.createUser("uploader@example.com", models.RoleUploader)
+	viewer := s.createUser("viewer@example.com", models.RoleViewer)
+	s.createUser("moderator@example.com", models.RoleModerator)
+
+	public := s.createVideo(uploader, models.VisibilityAllUsers)
+	private := s.createVideo(uploader, models.VisibilityPrivate)
+	byUser := s.createVideo(uploader, models.VisibilityRestricted)
+	byGroup := s.createVideo(uploader, models.VisibilityRestricted)
+	ungranted := s.createVideo(uploader, models.VisibilityRestricted)
+
+	group := &models.Group{ID: uuid.New().String(), Name: "Reviewers"}
+	if err := s.repos.Groups.Create(group); err != nil {
+		t.Fatal(err)
+	}
+	allow := func(*models.Group) error { return nil }
+	if err := s.repos.Groups.AddMember(group.ID, viewer, allow); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.repos.Videos.Grant(byUser, models.VideoGrant{SubjectType: models.ACLSubjectUser, SubjectID: viewer}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.repos.Videos.Grant(byGroup, models.VideoGrant{SubjectType: models.ACLSubjectGroup, SubjectID: group.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	viewerToken, _ := s.login("viewer@example.com")
+	if got, want := s.listVideoIDs(viewerToken), sortedIDs(public, byUser, byGroup); !equalIDs(got, want) {
+		t.Errorf("viewer sees %v, want %v", got, want)
+	}
+	uploaderToken, _ := s.login("uploader@example.com")
+	if got, want := s.listVideoIDs(uploaderToken), sortedIDs(public, private, byUser, byGroup, ungranted); !equalIDs(got, want) {
+		t.Errorf("uploader sees %v, want %v", got, want)
+	}
+	moderatorToken, _ := s.login("moderator@example.com")
+	if got, want := s.listVideoIDs(moderatorToken), sortedIDs(public, private, byUser, byGroup, ungranted); !equalIDs(got, want) {
+		t.Errorf("moderator sees %v, want %v", got, want)
+	}
+
+	// Leaving the group takes its grants away
+	if err := s.repos.Groups.RemoveMember(group.ID, viewer, allow); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.listVideoIDs(viewerToken), sortedIDs(public, byUser); !equalIDs(got, want) {
+		t.Errorf("viewer sees %v after leaving the group, want %v", got, want)
+	}
+}
+
+func TestRequireVideoAccess(t *testing.T) {
+	s := newTestServer(t)
+	uploader := s.createUser("uploader@example.com", models.RoleUploader)
+	s.createUser("viewer@example.com", models.RoleViewer)
+	token, _ := s.login("viewer@example.com")
+
+	private := s.createVideo(uploader, models.VisibilityPrivate)
+	public := s.createVideo(uploader, models.VisibilityAllUsers)
+
+	// Hidden videos look missing rather than forbidden
+	s.expect(s.do(http.MethodPost, "/videos/"+private+"/playback-token", token, nil), http.StatusNotFound)
+	s.expect(s.do(http.MethodPost, "/videos/missing/playback-token", token, nil), http.StatusNotFound)
+	s.expect(s.do(http.MethodPost, "/videos/"+public+"/playback-token", token, nil), http.StatusCreated)
+
+	s.createUser("moderator@example.com", models.RoleModerator)
+	moderatorToken, _ := s.login("moderator@example.com")
+	s.expect(s.do(http.MethodPut, "/admin/videos/"+private+"/visibility", moderatorToken, gin.H{"visibility": models.VisibilityAllUsers}), http.StatusOK)
+	s.expect(s.do(http.MethodPost, "/videos/"+private+"/playback-token", token, nil), http.StatusCreated)
+}
+
+func TestVideoACL(t *testing.T) {
+	s := newTestServer(t)
+	uploader := s.createUser("uploader@example.com", models.RoleUploader)
+	viewer := s.createUser("viewer@example.com", models.RoleViewer)
+	s.createUser("moderator@example.com", models.RoleModerator)
+	token, _ := s.login("moderator@example.com")
+	video := s.createVideo(uploader, models.VisibilityRestricted)
+	acl := "/admin/videos/" + video + "/acl"
+
+	tests := []struct {
+		name   string
+		path   string
+		body   gin.H
+		status int
+	}{
+		{"bad subject type", acl, gin.H{"subject_type": "team", "subject_id": viewer}, http.StatusBadRequest},
+		{"unknown user", acl, gin.H{"subject_type": models.ACLSubjectUser, "subject_id": "missing"}, http.StatusNotFound},
+		{"unknown group", acl, gin.H{"subject_type": models.ACLSubjectGroup, "subject_id": "missing"}, http.StatusNotFound},
+		{"missing video", "/admin/videos/missing/acl", gin.H{"subject_type": models.ACLSubjectUser, "subject_id": viewer}, http.StatusNotFound},
+		{"user", acl, gin.H{"subject_type": models.ACLSubjectUser, "subject_id": viewer}, http.StatusCreated},
+		{"same user again", acl, gin.H{"subject_type": models.ACLSubjectUser, "subject_id": viewer}, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := s.do(http.MethodPost, tt.path, token, tt.body); w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	w := s.do(http.MethodGet, acl, token, nil)
+	s.expect(w, http.StatusOK)
+	var resp struct {
+		Visibility string              `json:"visibility"`
+		Grants     []models.VideoGrant `json:"grants"`
+	}
+	decode(t, w, &resp)
+	if resp.Visibility != models.VisibilityRestricted || len(resp.Grants) != 1 || resp.Grants[0].SubjectID != viewer {
+		t.Fatalf("ACL = %+v, want one grant to %s", resp, viewer)
+	}
+
+	viewerToken, _ := s.login("viewer@example.com")
+	s.expect(s.do(http.MethodPost, "/videos/"+video+"/playback-token", viewerToken, nil), http.StatusCreated)
+	s.expect(s.do(http.MethodDelete, acl+"/user/"+viewer, token, nil), http.StatusOK)
+	s.expect(s.do(http.MethodDelete, acl+"/user/"+viewer, token, nil), http.StatusNotFound)
+	s.expect(s.do(http.MethodPost, "/videos/"+video+"/playback-token", viewerToken, nil), http.StatusNotFound)
+}
+
+func TestUpdateAndDeleteVideo(t *testing.T) {
+	s := newTestServer(t)
+	uploader := s.createUser("uploader@example.com", models.RoleUploader)
+	s.createUser("moderator@example.com", models.RoleModerator)
+	token, _ := s.login("moderator@example.com")
+	id := s.createVideo(uploader, models.VisibilityAllUsers)
+
+	s.expect(s.do(http.MethodPut, "/admin/videos/missing", token, gin.H{"title": "Renamed"}), http.StatusNotFound)
+	s.expect(s.do(http.MethodPut, "/admin/videos/"+id, token, gin.H{"description": "No title"}), http.StatusBadRequest)
+	s.expect(s.do(http.MethodPut, "/admin/videos/"+id, token, gin.H{"title": "Renamed", "description": "New"}), http.StatusOK)
+	video, err := s.repos.Videos.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Title != "Renamed" || video.Description != "New" {
+		t.Errorf("video = %q/%q, want Renamed/New", video.Title, video.Description)
+	}
+
+	uploaderToken, _ := s.login("uploader@example.com")
+	s.expect(s.do(http.MethodDelete, "/admin/videos/"+id, uploaderToken, nil), http.StatusForbidden)
+	s.expect(s.do(http.MethodDelete, "/admin/videos/"+id, token, nil), http.StatusOK)
+	s.expect(s.do(http.MethodDelete, "/admin/videos/"+id, token, nil), http.StatusNotFound)
+	if ids := s.listVideoIDs(token); len(ids) != 0 {
+		t.Errorf("videos after delete = %v, want none", ids)
+	}
+}
+
+// openUploadStorage points the storage package at a temporary directory
+// backed by a SQLite database and encrypts under a test master key,
+// returning the encrypted directory.
+func openUploadStorage(t *testing.T) string {
+	t.Helper()
+	t.Setenv("DB_DRIVER", database.DriverSQLite)
+	t.Setenv("SQLITE_DB_PATH", filepath.Join(t.TempDir(), "test.db"))
+	t.Setenv("DB_AUTO_MIGRATE", "true")
+	t.Setenv("ENCRYPTED_PATH", t.TempDir())
+	t.Setenv("ENCRYPTION_KEYS", "")
+	t.Setenv("ENCRYPTION_KEY", strings.Repeat("k", 32))
+	if err := database.InitDB(); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() {
+		database.DB.Close()
+		database.DB = nil
+	})
+
+	provider, err := kms.NewEnvProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := kms.Provider
+	kms.Provider = provider
+	t.Cleanup(func() { kms.Provider = previous })
+
+	dir, err := storage.Dir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func mp4Box(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(box, typ...), body...)
+}
+
+// uploadBody returns a multipart upload of a video file with the given
+// fields, cut off before its closing boundary unless complete.
+func uploadBody(t *testing.T, fileName string, content []byte, fields map[string]string, complete bool) (*bytes.Buffer, string) {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := w.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	part, err := w.CreateFormFile("video", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	if complete {
+		w.Close()
+	}
+	return &body, w.FormDataContentType()
+}
+
+// testMP4 returns the smallest MP4 file uploads accept: a file type box and
+// a movie box without tracks.
+func testMP4() (ftyp, video []byte) {
+	ftyp = mp4Box("ftyp", []byte("isom"), make([]byte, 4), []byte("isommp41"))
+	mvhd := mp4Box("mvhd", make([]byte, 12), binary.BigEndian.AppendUint32(nil, 1000), binary.BigEndian.AppendUint32(nil, 5000), make([]byte, 80))
+	return ftyp, append(append([]byte{}, ftyp...), mp4Box("moov", mvhd)...)
+}
+
+// postUpload sends a multipart upload to the router.
+func postUpload(router *gin.Engine, method, path string, body *bytes.Buffer, contentType string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, body)
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// storedFiles returns the names of the files in dir, leaving out
+// directories.
+func storedFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+// countPendingFiles counts the entries of the pending_files journal.
+func countPendingFiles(t *testing.T) int {
+	t.Helper()
+	var pending int
+	if err := database.DB.QueryRow("SELECT COUNT(*) FROM pending_files").Scan(&pending); err != nil {
+		t.Fatal(err)
+	}
+	return pending
+}
+
+func TestUploadVideoRejectedLeavesNothing(t *testing.T) {
+	dir := openUploadStorage(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/videos", New(repository.NewSQL(database.DB)).UploadVideo)
+
+	ftyp, video := testMP4()
+	titled := map[string]string{"title": "Video"}
+
+	tests := []struct {
+		name     string
+		fileName string
+		content  []byte
+		fields   map[string]string
+		complete bool
+		status   int
+	}{
+		{"not a video", "video.mp4", []byte(strings.Repeat("not a video ", 100)), titled, true, http.StatusBadRequest},
+		{"content not matching the extension", "video.mkv", video, titled, true, http.StatusBadRequest},
+		{"truncated container", "video.mp4", video[:len(video)-10], titled, true, http.StatusBadRequest},
+		{"no movie box", "video.mp4", ftyp, titled, true, http.StatusBadRequest},
+		{"body cut off", "video.mp4", video, titled, false, http.StatusInternalServerError},
+		// The video is stored before the missing title is noticed
+		{"missing title", "video.mp4", video, nil, true, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, contentType := uploadBody(t, tt.fileName, tt.content, tt.fields, tt.complete)
+			w := postUpload(router, http.MethodPost, "/videos", body, contentType)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if files := storedFiles(t, dir); len(files) != 0 {
+				t.Errorf("%v left in ENCRYPTED_PATH", files)
+			}
+			if pending := countPendingFiles(t); pending != 0 {
+				t.Errorf("%d pending_files entries left", pending)
+			}
+		})
+	}
+}
+
+func TestReplaceVideoFile(t *testing.T) {
+	dir := openUploadStorage(t)
+	gin.SetMode(gin.TestMode)
+	repos := repository.NewSQL(database.DB)
+	router := gin.New()
+	router.PUT("/videos/:id/file", New(repos).ReplaceVideoFile)
+
+	uploader := &models.User{ID: uuid.New().String(), Email: "uploader@example.com", Password: "not-a-hash"}
+	if err := repos.Users.Create(uploader, []string{models.RoleUploader}); err != nil {
+		t.Fatal(err)
+	}
+	id := uuid.New().String()
+	if _, err := repos.Videos.Create(&models.Video{ID: id, Title: "Video", FileName: id + ".mp4", UploadedBy: uploader.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, id+".mp4.enc"), []byte("encrypted"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, content := testMP4()
+
+	// A rejected file leaves the video and its file as they were
+	body, contentType := uploadBody(t, "video.mp4", []byte("not a video"), nil, true)
+	if w := postUpload(router, http.MethodPut, "/videos/"+id+"/file", body, contentType); w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+	if files := storedFiles(t, dir); len(files) != 1 || files[0] != id+".mp4.enc" {
+		t.Errorf("files after a rejected replacement = %v, want only the old file", files)
+	}
+
+	body, contentType = uploadBody(t, "video.mov", content, nil, true)
+	w := postUpload(router, http.MethodPut, "/videos/"+id+"/file", body, contentType)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusAccepted, w.Body)
+	}
+	var resp struct {
+		FileName string `json:"file_name"`
+		JobID    string `json:"job_id"`
+	}
+	decode(t, w, &resp)
+	video, err := repos.Videos.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.FileName != resp.FileName || filepath.Ext(video.FileName) != ".mov" || video.Title != "Video" || video.Duration != 5 {
+		t.Errorf("replaced video = %+v", video)
+	}
+	if job, err := repos.Jobs.Get(resp.JobID); err != nil || job.VideoID != id {
+		t.Errorf("job = %+v, %v; want the job of the video", job, err)
+	}
+	if files := storedFiles(t, dir); len(files) != 1 || files[0] != video.FileName+".enc" {
+		t.Errorf("files after replacing = %v, want only %s.enc", files, video.FileName)
+	}
+	if pending := countPendingFiles(t); pending != 0 {
+		t.Errorf("%d pending_files entries left", pending)
+	}
+
+	body, contentType = uploadBody(t, "video.mp4", content, nil, true)
+	if w := postUpload(router, http.MethodPut, "/videos/missing/file", body, contentType); w.Code != http.StatusNotFound {
+		t.Errorf("replacing the file of a missing video: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
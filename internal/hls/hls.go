@@ -0,0 +1,203 @@
+// Package hls packages MP4 videos for HTTP Live Streaming: fragmented MP4
+// segments encrypted with AES-128, a media playlist and a master playlist.
+//
+// Segments are stored exactly as they are served, already encrypted under
+// the video's HLS key, so the key never needs to be applied on request. The
+// init segment carries no media and is served in the clear, but is stored
+// encrypted under the video's data key like the video itself.
+package hls
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"secure-video-api/internal/media"
+	"secure-video-api/internal/utils"
+)
+
+// File names inside a video's HLS directory
+const (
+	MasterPlaylist = "master.m3u8"
+	MediaPlaylist  = "index.m3u8"
+	InitSegment    = "init.mp4"
+	KeyURI         = "key"
+)
+
+// KeySize is the size of the AES-128 segment keys.
+const KeySize = 16
+
+// TargetSegmentDuration is the minimum segment length in seconds; segments
+// end at the first video sync sample after it.
+const TargetSegmentDuration = 6.0
+
+var segmentName = regexp.MustCompile(`^seg_[0-9]{5}\.m4s$`)
+
+// IsSegmentName reports whether name is a media segment file name, so it can
+// be safely joined to the HLS directory.
+func IsSegmentName(name string) bool {
+	return segmentName.MatchString(name)
+}
+
+// GenerateKey returns a new random segment key.
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("failed to generate HLS key: %v", err)
+	}
+	return key, nil
+}
+
+// InitAAD binds an encrypted init segment to its video; see
+// utils.NewEncryptWriter.
+func InitAAD(videoID string) string {
+	return videoID + "/hls/" + InitSegment
+}
+
+// Package writes the HLS rendition of movie, read from r, into dir. Segments
+// are encrypted with key; the init segment is sealed with dataKey.
+func Package(movie *media.Movie, r io.ReadSeeker, dir string, key, dataKey []byte, videoID string) error {
+	segments := movie.Segments(TargetSegmentDuration)
+	if len(segments) == 0 {
+		return fmt.Errorf("%w: no samples to package", media.ErrUnsupportedMedia)
+	}
+
+	if err := writeInitSegment(filepath.Join(dir, InitSegment), movie.InitSegment(), dataKey, videoID); err != nil {
+		return err
+	}
+
+	var playlist strings.Builder
+	var totalBytes int64
+	var totalDuration, maxDuration, peakBandwidth float64
+	for i, seg := range segments {
+		fragment, err := movie.Fragment(r, seg, uint32(i+1))
+		if err != nil {
+			return err
+		}
+		encrypted, err := EncryptSegment(key, uint64(i), fragment)
+		if err != nil {
+			return err
+		}
+
+		name := fmt.Sprintf("seg_%05d.m4s", i)
+		if err := os.WriteFile(filepath.Join(dir, name), encrypted, 0644); err != nil {
+			return fmt.Errorf("failed to write segment: %v", err)
+		}
+
+		fmt.Fprintf(&playlist, "#EXTINF:%.6f,\n%s\n", seg.Duration, name)
+		totalBytes += int64(len(fragment))
+		totalDuration += seg.Duration
+		maxDuration = math.Max(maxDuration, seg.Duration)
+		if seg.Duration > 0 {
+			peakBandwidth = math.Max(peakBandwidth, float64(len(fragment)*8)/seg.Duration)
+		}
+	}
+
+	// The key applies to the media segments only: the init segment, which
+	// is declared before it, is sent in the clear
+	index := "#EXTM3U\n" +
+		"#EXT-X-VERSION:7\n" +
+		fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(maxDuration))) +
+		"#EXT-X-MEDIA-SEQUENCE:0\n" +
+		"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+		"#EXT-X-INDEPENDENT-SEGMENTS\n" +
+		fmt.Sprintf("#EXT-X-MAP:URI=%q\n", InitSegment) +
+		fmt.Sprintf("#EXT-X-KEY:METHOD=AES-128,URI=%q\n", KeyURI) +
+		playlist.String() +
+		"#EXT-X-ENDLIST\n"
+	if err := os.WriteFile(filepath.Join(dir, MediaPlaylist), []byte(index), 0644); err != nil {
+		return fmt.Errorf("failed to write playlist: %v", err)
+	}
+
+	averageBandwidth := 0.0
+	if totalDuration > 0 {
+		averageBandwidth = float64(totalBytes*8) / totalDuration
+	}
+	master := "#EXTM3U\n" +
+		"#EXT-X-VERSION:7\n" +
+		"#EXT-X-INDEPENDENT-SEGMENTS\n" +
+		"#EXT-X-STREAM-INF:" + streamInfo(movie, int64(peakBandwidth), int64(averageBandwidth)) + "\n" +
+		MediaPlaylist + "\n"
+	if err := os.WriteFile(filepath.Join(dir, MasterPlaylist), []byte(master), 0644); err != nil {
+		return fmt.Errorf("failed to write master playlist: %v", err)
+	}
+	return nil
+}
+
+// streamInfo renders the EXT-X-STREAM-INF attributes of the single variant.
+func streamInfo(movie *media.Movie, peak, average int64) string {
+	attrs := []string{
+		fmt.Sprintf("BANDWIDTH=%d", peak),
+		fmt.Sprintf("AVERAGE-BANDWIDTH=%d", average),
+	}
+
+	var codecs []string
+	complete := true
+	for _, track := range movie.Tracks {
+		codec := track.CodecString()
+		if codec == "" {
+			complete = false
+		}
+		codecs = append(codecs, codec)
+		if track.IsVideo() {
+			if width, height := track.Dimensions(); width > 0 && height > 0 {
+				attrs = append(attrs, fmt.Sprintf("RESOLUTION=%dx%d", width, height))
+			}
+		}
+	}
+	// A partial CODECS list would make players reject the variant
+	if complete {
+		attrs = append(attrs, fmt.Sprintf("CODECS=%q", strings.Join(codecs, ",")))
+	}
+	return strings.Join(attrs, ",")
+}
+
+// EncryptSegment encrypts a media segment as HLS METHOD=AES-128 requires:
+// AES-128-CBC with PKCS#7 padding, using the media sequence number as IV
+// since the playlist declares none.
+func EncryptSegment(key []byte, sequence uint64, segment []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], sequence)
+
+	padding := aes.BlockSize - len(segment)%aes.BlockSize
+	out := make([]byte, len(segment), len(segment)+padding)
+	copy(out, segment)
+	out = append(out, bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, out)
+	return out, nil
+}
+
+func writeInitSegment(path string, data, dataKey []byte, videoID string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create init segment: %v", err)
+	}
+	defer file.Close()
+
+	ew, err := utils.NewEncryptWriter(file, dataKey, InitAAD(videoID))
+	if err != nil {
+		return err
+	}
+	if _, err := ew.Write(data); err != nil {
+		return fmt.Errorf("failed to write init segment: %v", err)
+	}
+	if err := ew.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
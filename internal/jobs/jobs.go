@@ -0,0 +1,484 @@
+// Package jobs runs background work for videos from a queue persisted in the
+// jobs table, so queued work survives restarts.
+//
+// A job moves from queued to processing when a worker claims it, and ends as
+// ready or failed. Failed attempts are retried with a growing delay until the
+// job runs out of attempts. The job's status is mirrored onto its video, which
+// is only listed once it is ready.
+//
+// A claim is a lease held by the claiming process and renewed while the job
+// runs. Only jobs whose lease has lapsed, because the process running them
+// died or lost touch with the database, are queued again, so replicas
+// sharing the queue never take over each other's running jobs.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"secure-video-api/internal/database"
+
+	"github.com/google/uuid"
+)
+
+// Job states
+const (
+	StatusQueued     = "queued"
+	StatusProcessing = "processing"
+	StatusReady      = "ready"
+	StatusFailed     = "failed"
+)
+
+// TypeProcessVideo is the job run for every newly stored video.
+const TypeProcessVideo = "process_video"
+
+// DefaultMaxAttempts is how many times a job is attempted before it fails.
+const DefaultMaxAttempts = 3
+
+const (
+	retryBaseDelay = 10 * time.Second
+	pollInterval   = 5 * time.Second
+
+	// leaseDuration is how long a claim lasts without being renewed;
+	// running jobs renew it every leaseRenewInterval
+	leaseDuration      = time.Minute
+	leaseRenewInterval = 20 * time.Second
+)
+
+// ErrNotFound is returned by Get for unknown jobs.
+var ErrNotFound = errors.New("job not found")
+
+// errLeaseLost is returned when saving a job whose claim has lapsed and
+// passed to another worker.
+var errLeaseLost = errors.New("lease lost")
+
+// Job is a row of the jobs table.
+type Job struct {
+	ID          string     `json:"id"`
+	Type        string     `json:"type"`
+	VideoID     string     `json:"video_id"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	MaxAttempts int        `json:"max_attempts"`
+	LastError   string     `json:"last_error,omitempty"`
+	RunAt       time.Time  `json:"run_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// Handler runs a job. It is called again on retry, so it must be safe to
+// repeat. ctx is cancelled when the server shuts down, or when the job's
+// lease is lost and another worker may run it.
+type Handler func(ctx context.Context, job *Job) error
+
+// permanentError marks a failure that retrying cannot fix.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that the job fails immediately instead of being
+// retried, e.g. when the uploaded file is not a video.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+var (
+	handlersMu sync.RWMutex
+	handlers   = make(map[string]Handler)
+
+	// claimMu serialises claiming by this process's workers
+	claimMu sync.Mutex
+	wake    = make(chan struct{}, 1)
+	workers sync.WaitGroup
+
+	// owner identifies this process in the claims it holds
+	owner = newOwner()
+)
+
+func newOwner() string {
+	host, _ := os.Hostname()
+	return host + "/" + uuid.New().String()
+}
+
+// Register sets the handler for a job type. It must be called before Start.
+func Register(jobType string, handler Handler) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	handlers[jobType] = handler
+}
+
+// Enqueue adds a job for a video within tx, so the job exists if and only if
+// the transaction commits. Call Notify after committing.
+func Enqueue(tx *sql.Tx, jobType, videoID string) (string, error) {
+	jobID := uuid.New().String()
+	now := database.FormatTime(time.Now())
+	_, err := tx.Exec(`
+		INSERT INTO jobs (id, type, video_id, status, max_attempts, run_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, jobID, jobType, videoID, StatusQueued, DefaultMaxAttempts, now, now, now)
+	if err != nil {
+		return "", fmt.Errorf("failed to enqueue job: %v", err)
+	}
+	return jobID, nil
+}
+
+// Notify wakes an idle worker to look for new jobs.
+func Notify() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// Get returns a job by ID.
+func Get(q database.Querier, jobID string) (*Job, error) {
+	job, err := scanJob(q.QueryRow(selectJob+" WHERE id = ?", jobID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return job, err
+}
+
+// DeleteForVideo removes the jobs of a video, within the transaction
+// deleting it.
+func DeleteForVideo(tx *sql.Tx, videoID string) error {
+	_, err := tx.Exec("DELETE FROM jobs WHERE video_id = ?", videoID)
+	return err
+}
+
+// Start requeues interrupted jobs and starts count workers running the jobs
+// queued in db, which stop once ctx is cancelled. Use Wait to wait for them.
+func Start(ctx context.Context, db *sql.DB, count int) error {
+	if err := requeueExpired(db); err != nil {
+		return fmt.Errorf("failed to recover interrupted jobs: %v", err)
+	}
+
+	log.Printf("[Jobs] Starting %d workers", count)
+	for i := 0; i < count; i++ {
+		workers.Add(1)
+		go worker(ctx, db)
+	}
+	return nil
+}
+
+// Wait blocks until all workers have stopped.
+func Wait() {
+	workers.Wait()
+}
+
+func worker(ctx context.Context, db *sql.DB) {
+	defer workers.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-wake:
+		}
+
+		if err := requeueExpired(db); err != nil {
+			log.Printf("[Jobs] Error recovering interrupted jobs: %v", err)
+		}
+
+		// Drain the queue before going back to sleep
+		for ctx.Err() == nil {
+			job, err := claim(db)
+			if err != nil {
+				log.Printf("[Jobs] Error claiming job: %v", err)
+				break
+			}
+			if job == nil {
+				break
+			}
+			run(ctx, db, job)
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(pollInterval)
+	}
+}
+
+// requeueExpired queues again the processing jobs whose lease has lapsed.
+// Jobs other workers are still running keep their lease and are left alone.
+func requeueExpired(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := database.FormatTime(time.Now())
+	if _, err := tx.Exec(`
+		UPDATE videos SET status = ?
+		WHERE id IN (
+			SELECT video_id FROM jobs
+			WHERE status = ? AND (lease_until IS NULL OR lease_until < ?)
+		)
+	`, StatusQueued, StatusProcessing, now); err != nil {
+		return err
+	}
+	result, err := tx.Exec(`
+		UPDATE jobs SET status = ?, claimed_by = NULL, lease_until = NULL, updated_at = ?
+		WHERE status = ? AND (lease_until IS NULL OR lease_until < ?)
+	`, StatusQueued, now, StatusProcessing, now)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("[Jobs] Requeued %d interrupted jobs", n)
+	}
+	return nil
+}
+
+// claim marks the next due job as processing, leased to this process, and
+// returns it, or returns nil if no job is due.
+func claim(db *sql.DB) (*Job, error) {
+	claimMu.Lock()
+	defer claimMu.Unlock()
+
+	for {
+		now := time.Now()
+		job, err := scanJob(db.QueryRow(
+			selectJob+" WHERE status = ? AND run_at <= ? ORDER BY run_at LIMIT 1",
+			StatusQueued, database.FormatTime(now),
+		))
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		claimed, err := claimJob(db, job, now)
+		if err != nil {
+			return nil, err
+		}
+		if claimed {
+			return job, nil
+		}
+	}
+}
+
+// claimJob takes a queued job for this process, mirroring its new status
+// onto its video. Workers of other replicas sharing the database may pick
+// the same job; only the one whose update still finds it queued gets it.
+func claimJob(db *sql.DB, job *Job, now time.Time) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE jobs
+		SET status = ?, attempts = attempts + 1, claimed_by = ?, lease_until = ?, updated_at = ?
+		WHERE id = ? AND status = ?
+	`, StatusProcessing, owner, database.FormatTime(now.Add(leaseDuration)), database.FormatTime(now),
+		job.ID, StatusQueued)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if _, err := tx.Exec("UPDATE videos SET status = ? WHERE id = ?", StatusProcessing, job.VideoID); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	job.Status = StatusProcessing
+	job.Attempts++
+	job.UpdatedAt = now
+	return true, nil
+}
+
+// renewLease extends this process's claim on a running job, returning
+// errLeaseLost if the claim has lapsed.
+func renewLease(db *sql.DB, job *Job) error {
+	now := time.Now()
+	result, err := db.Exec(
+		"UPDATE jobs SET lease_until = ? WHERE id = ? AND status = ? AND claimed_by = ?",
+		database.FormatTime(now.Add(leaseDuration)), job.ID, StatusProcessing, owner,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errLeaseLost
+	}
+	return nil
+}
+
+// keepLease renews the lease of a running job until stop is closed. If the
+// lease is lost it cancels the job and reports it on the returned channel,
+// which is closed once keepLease returns.
+func keepLease(db *sql.DB, job *Job, cancel context.CancelFunc, stop <-chan struct{}) <-chan bool {
+	lost := make(chan bool, 1)
+	go func() {
+		defer close(lost)
+		ticker := time.NewTicker(leaseRenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			err := renewLease(db, job)
+			if errors.Is(err, errLeaseLost) {
+				cancel()
+				lost <- true
+				return
+			}
+			if err != nil {
+				log.Printf("[Jobs] Error renewing lease of job %s: %v", job.ID, err)
+			}
+		}
+	}()
+	return lost
+}
+
+// run executes a claimed job and records the outcome.
+func run(ctx context.Context, db *sql.DB, job *Job) {
+	handlersMu.RLock()
+	handler := handlers[job.Type]
+	handlersMu.RUnlock()
+
+	var err error
+	if handler == nil {
+		err = Permanent(fmt.Errorf("no handler for job type %q", job.Type))
+	} else {
+		log.Printf("[Jobs] Running %s job %s for video %s (attempt %d/%d)",
+			job.Type, job.ID, job.VideoID, job.Attempts, job.MaxAttempts)
+		jobCtx, cancel := context.WithCancel(ctx)
+		stop := make(chan struct{})
+		lost := keepLease(db, job, cancel, stop)
+		err = runHandler(jobCtx, handler, job)
+		close(stop)
+		cancel()
+		if <-lost {
+			log.Printf("[Jobs] Job %s lost its lease and was left to the worker now holding it", job.ID)
+			return
+		}
+	}
+
+	now := time.Now()
+	job.UpdatedAt = now
+	var permanent permanentError
+	switch {
+	case err == nil:
+		job.Status = StatusReady
+		job.LastError = ""
+		job.FinishedAt = &now
+		log.Printf("[Jobs] Job %s finished", job.ID)
+
+	case ctx.Err() != nil:
+		// Interrupted by shutdown; this attempt does not count
+		job.Status = StatusQueued
+		job.Attempts--
+		log.Printf("[Jobs] Job %s interrupted, requeued", job.ID)
+
+	case errors.As(err, &permanent) || job.Attempts >= job.MaxAttempts:
+		job.Status = StatusFailed
+		job.LastError = err.Error()
+		job.FinishedAt = &now
+		log.Printf("[Jobs] Job %s failed: %v", job.ID, err)
+
+	default:
+		job.Status = StatusQueued
+		job.LastError = err.Error()
+		job.RunAt = now.Add(retryBaseDelay << (job.Attempts - 1))
+		log.Printf("[Jobs] Job %s failed, retrying at %s: %v", job.ID, job.RunAt.Format(time.RFC3339), err)
+	}
+
+	if err := saveJob(db, job); errors.Is(err, errLeaseLost) {
+		log.Printf("[Jobs] Job %s lost its lease before its outcome was saved", job.ID)
+	} else if err != nil {
+		log.Printf("[Jobs] Error saving job %s: %v", job.ID, err)
+	}
+}
+
+// runHandler calls handler, turning a panic into an error.
+func runHandler(ctx context.Context, handler Handler, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// saveJob stores the outcome of a job run by this process, releasing its
+// claim, and mirrors its status onto its video. It returns errLeaseLost,
+// changing nothing, if the claim has passed to another worker.
+func saveJob(db *sql.DB, job *Job) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var finishedAt sql.NullString
+	if job.FinishedAt != nil {
+		finishedAt = sql.NullString{String: database.FormatTime(*job.FinishedAt), Valid: true}
+	}
+	result, err := tx.Exec(`
+		UPDATE jobs
+		SET status = ?, attempts = ?, last_error = ?, run_at = ?, updated_at = ?, finished_at = ?,
+			claimed_by = NULL, lease_until = NULL
+		WHERE id = ? AND status = ? AND claimed_by = ?
+	`, job.Status, job.Attempts, job.LastError, database.FormatTime(job.RunAt), database.FormatTime(job.UpdatedAt), finishedAt,
+		job.ID, StatusProcessing, owner)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errLeaseLost
+	}
+	if _, err := tx.Exec("UPDATE videos SET status = ? WHERE id = ?", job.Status, job.VideoID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const selectJob = `
+	SELECT id, type, video_id, status, attempts, max_attempts, last_error,
+		run_at, created_at, updated_at, finished_at
+	FROM jobs`
+
+func scanJob(row *sql.Row) (*Job, error) {
+	var job Job
+	var lastError sql.NullString
+	var finishedAt database.NullTime
+	err := row.Scan(&job.ID, &job.Type, &job.VideoID, &job.Status, &job.Attempts, &job.MaxAttempts,
+		&lastError, (*database.Time)(&job.RunAt), (*database.Time)(&job.CreatedAt),
+		(*database.Time)(&job.UpdatedAt), &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	job.LastError = lastError.String
+	job.FinishedAt = finishedAt.Ptr()
+	return &job, nil
+}
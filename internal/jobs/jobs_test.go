@@ -0,0 +1,102 @@
+package jobs
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"secure-video-api/internal/database"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	t.Setenv("DB_DRIVER", database.DriverSQLite)
+	t.Setenv("SQLITE_DB_PATH", filepath.Join(t.TempDir(), "test.db"))
+	t.Setenv("DB_AUTO_MIGRATE", "true")
+	if err := database.InitDB(); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() {
+		database.DB.Close()
+		database.DB = nil
+	})
+	return database.DB
+}
+
+func enqueueTestJob(t *testing.T, db *sql.DB) string {
+	t.Helper()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	id, err := Enqueue(tx, TypeProcessVideo, "video")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestLeases(t *testing.T) {
+	db := openTestDB(t)
+	id := enqueueTestJob(t, db)
+
+	job, err := claim(db)
+	if err != nil || job == nil || job.ID != id {
+		t.Fatalf("claim = %+v, %v; want job %s", job, err, id)
+	}
+	if next, err := claim(db); err != nil || next != nil {
+		t.Fatalf("second claim = %+v, %v; want none", next, err)
+	}
+
+	// A job whose lease is current is still running somewhere and stays
+	if err := requeueExpired(db); err != nil {
+		t.Fatal(err)
+	}
+	if job, _ := Get(db, id); job.Status != StatusProcessing {
+		t.Fatalf("status with a current lease = %s, want %s", job.Status, StatusProcessing)
+	}
+	if err := renewLease(db, job); err != nil {
+		t.Fatalf("renewing a held lease: %v", err)
+	}
+
+	// Once it lapses the job is queued again, and the worker that held it
+	// can neither renew it nor save an outcome over the next run
+	lapsed := database.FormatTime(time.Now().Add(-time.Second))
+	if _, err := db.Exec("UPDATE jobs SET lease_until = ? WHERE id = ?", lapsed, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := requeueExpired(db); err != nil {
+		t.Fatal(err)
+	}
+	if job, _ := Get(db, id); job.Status != StatusQueued {
+		t.Fatalf("status with a lapsed lease = %s, want %s", job.Status, StatusQueued)
+	}
+	if err := renewLease(db, job); err != errLeaseLost {
+		t.Errorf("renewing a lapsed lease: got %v, want %v", err, errLeaseLost)
+	}
+	job.Status = StatusReady
+	if err := saveJob(db, job); err != errLeaseLost {
+		t.Errorf("saving a job after losing its lease: got %v, want %v", err, errLeaseLost)
+	}
+
+	job, err = claim(db)
+	if err != nil || job == nil || job.Attempts != 2 {
+		t.Fatalf("reclaim = %+v, %v; want the job on its second attempt", job, err)
+	}
+	job.Status = StatusReady
+	if err := saveJob(db, job); err != nil {
+		t.Fatal(err)
+	}
+	var claimedBy, leaseUntil sql.NullString
+	if err := db.QueryRow("SELECT claimed_by, lease_until FROM jobs WHERE id = ?", id).Scan(&claimedBy, &leaseUntil); err != nil {
+		t.Fatal(err)
+	}
+	if claimedBy.Valid || leaseUntil.Valid {
+		t.Errorf("finished job still claimed by %q until %q", claimedBy.String, leaseUntil.String)
+	}
+}
@@ -0,0 +1,326 @@
+// Package jwtkeys signs and verifies the API's JWTs. Tokens are signed with
+// an asymmetric key (RS256 or EdDSA) named by the kid header, and the public
+// halves of every key are published as a JWKS, so other services can verify
+// tokens without sharing a secret.
+//
+// Keys are PEM files named <kid>.pem in JWT_KEYS_DIR. Private keys sign and
+// verify; public keys only verify, which is how retired keys are kept until
+// the tokens they signed have expired. New tokens are signed with the
+// private key named by JWT_ACTIVE_KID, or with the highest kid. On first
+// start, when JWT_KEYS_DIR holds no keys yet, an Ed25519 key is generated in
+// it. Without JWT_KEYS_DIR, tokens are signed with HS256 and JWT_SECRET as
+// before, with a warning on every start.
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var kidPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Key is a verification key, with its private half if it can sign.
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Public  crypto.PublicKey
+	private crypto.PrivateKey
+}
+
+// KeySet holds the signing and verification keys.
+type KeySet struct {
+	keys   map[string]*Key
+	active *Key
+	secret []byte // HS256 secret when no asymmetric keys are configured
+}
+
+// Keys is the key set used by the application, set up by Init.
+var Keys *KeySet
+
+// Init loads the key set from the environment.
+func Init() error {
+	keys, err := NewFromEnv()
+	if err != nil {
+		return err
+	}
+	Keys = keys
+	return nil
+}
+
+// NewFromEnv loads the keys in JWT_KEYS_DIR, generating an Ed25519 key
+// there if it has none yet, or falls back to HS256 with JWT_SECRET if it is
+// not set. JWT_SECRET may then be left empty, so no other key may be derived
+// from it.
+func NewFromEnv() (*KeySet, error) {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return nil, errors.New("neither JWT_KEYS_DIR nor JWT_SECRET is set")
+		}
+		log.Printf("[JWT] WARNING: JWT_KEYS_DIR is not set, so tokens are signed with HS256 and JWT_SECRET " +
+			"and no public keys are published. Set JWT_KEYS_DIR to sign them with an asymmetric key.")
+		return &KeySet{secret: []byte(secret)}, nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		kid, err := GenerateKey(dir, "ed25519")
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[JWT] Generated signing key %s in %s", kid, dir)
+	}
+	return LoadDir(dir, os.Getenv("JWT_ACTIVE_KID"))
+}
+
+// LoadDir loads every <kid>.pem file in dir. activeKID selects the signing
+// key; if empty, the private key with the highest kid is used.
+func LoadDir(dir, activeKID string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	set := &KeySet{keys: make(map[string]*Key)}
+	var signers []string
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		if !kidPattern.MatchString(kid) {
+			return nil, fmt.Errorf("invalid key ID %q (from %s)", kid, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT key: %v", err)
+		}
+		key, err := parseKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		set.keys[kid] = key
+		if key.private != nil {
+			signers = append(signers, kid)
+		}
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no private JWT keys in %s", dir)
+	}
+
+	if activeKID == "" {
+		sort.Strings(signers)
+		activeKID = signers[len(signers)-1]
+	}
+	active, ok := set.keys[activeKID]
+	if !ok || active.private == nil {
+		return nil, fmt.Errorf("active JWT key %q has no private key in %s", activeKID, dir)
+	}
+	set.active = active
+	return set, nil
+}
+
+// parseKey reads a PKCS#8, PKCS#1 or PKIX PEM key.
+func parseKey(kid string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+
+	key := &Key{ID: kid}
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key.private = private
+		key.Public = private.(crypto.Signer).Public()
+	case "RSA PRIVATE KEY":
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key.private = private
+		key.Public = private.Public()
+	case "PUBLIC KEY":
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key.Public = public
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+
+	switch public := key.Public.(type) {
+	case *rsa.PublicKey:
+		if public.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key is too small (%d bits)", public.N.BitLen())
+		}
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T (expected RSA or Ed25519)", public)
+	}
+	return key, nil
+}
+
+// Sign signs claims with the active key, naming it in the kid header.
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if s.active == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	}
+	token := jwt.NewWithClaims(s.active.Method, claims)
+	token.Header["kid"] = s.active.ID
+	return token.SignedString(s.active.private)
+}
+
+// Parse verifies a token with the key named by its kid, accepting only the
+// algorithm of that key.
+func (s *KeySet) Parse(tokenString string) (*jwt.Token, error) {
+	if s.active == nil {
+		return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+			return s.secret, nil
+		}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	}
+
+	var methods []string
+	for _, key := range s.keys {
+		methods = append(methods, key.Method.Alg())
+	}
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := s.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key ID %q", kid)
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("algorithm %s does not match key %q", token.Method.Alg(), kid)
+		}
+		return key.Public, nil
+	}, jwt.WithValidMethods(methods))
+}
+
+// JWK is a public key in JSON Web Key form (RFC 7517).
+type JWK struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	Alg     string `json:"alg"`
+	N       string `json:"n,omitempty"`
+	E       string `json:"e,omitempty"`
+	Curve   string `json:"crv,omitempty"`
+	X       string `json:"x,omitempty"`
+}
+
+// JWKS returns the public keys of every verification key, ordered by kid.
+// It is empty when tokens are signed with HS256.
+func (s *KeySet) JWKS() []JWK {
+	jwks := []JWK{}
+	for _, key := range s.keys {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Alg: key.Method.Alg()}
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		jwks = append(jwks, jwk)
+	}
+	sort.Slice(jwks, func(i, j int) bool { return jwks[i].KeyID < jwks[j].KeyID })
+	return jwks
+}
+
+// GenerateKey writes a new private key of the given type ("ed25519" or
+// "rsa") to dir and returns its kid. Kids sort by creation time, so the new
+// key becomes the active one unless JWT_ACTIVE_KID pins another.
+func GenerateKey(dir, keyType string) (string, error) {
+	var private crypto.PrivateKey
+	var err error
+	switch keyType {
+	case "ed25519":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case "rsa":
+		private, err = rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		return "", fmt.Errorf("unknown key type %q (expected ed25519 or rsa)", keyType)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to generate key: %v", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", err
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	kid := fmt.Sprintf("%s-%x", time.Now().UTC().Format("20060102T150405Z"), suffix)
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create key directory: %v", err)
+	}
+	path := filepath.Join(dir, kid+".pem")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create key file: %v", err)
+	}
+	defer file.Close()
+	if err := pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		return "", fmt.Errorf("failed to write key file: %v", err)
+	}
+	return kid, file.Close()
+}
+
+// RetireKey replaces a private key file with its public half, so the key
+// keeps verifying tokens it already signed but signs no new ones.
+func RetireKey(dir, kid string) error {
+	if !kidPattern.MatchString(kid) {
+		return fmt.Errorf("invalid key ID %q", kid)
+	}
+	path := filepath.Join(dir, kid+".pem")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read key: %v", err)
+	}
+	key, err := parseKey(kid, data)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("failed to write key: %v", err)
+	}
+	return os.Rename(tmp, path)
+}
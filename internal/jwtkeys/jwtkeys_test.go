@@ -0,0 +1,256 @@
+package jwtkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{"sub": "user", "exp": time.Now().Add(time.Minute).Unix()}
+}
+
+func generateKey(t *testing.T, dir, keyType string) string {
+	t.Helper()
+	kid, err := GenerateKey(dir, keyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kid
+}
+
+func loadDir(t *testing.T, dir, activeKID string) *KeySet {
+	t.Helper()
+	keys, err := LoadDir(dir, activeKID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestSignAndParse(t *testing.T) {
+	for _, tt := range []struct {
+		keyType string
+		alg     string
+	}{
+		{"ed25519", "EdDSA"},
+		{"rsa", "RS256"},
+	} {
+		t.Run(tt.keyType, func(t *testing.T) {
+			dir := t.TempDir()
+			kid := generateKey(t, dir, tt.keyType)
+			keys := loadDir(t, dir, "")
+
+			signed, err := keys.Sign(testClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := keys.Parse(signed)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if token.Header["kid"] != kid || token.Method.Alg() != tt.alg {
+				t.Errorf("token signed by %v with %s, want %s with %s", token.Header["kid"], token.Method.Alg(), kid, tt.alg)
+			}
+
+			// A token from a key the set does not hold is rejected
+			otherDir := t.TempDir()
+			generateKey(t, otherDir, tt.keyType)
+			foreign, err := loadDir(t, otherDir, "").Sign(testClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := keys.Parse(foreign); err == nil {
+				t.Error("token signed by an unknown key accepted")
+			}
+		})
+	}
+}
+
+func TestRetiredKeyStillVerifies(t *testing.T) {
+	dir := t.TempDir()
+	old := generateKey(t, dir, "ed25519")
+	signed, err := loadDir(t, dir, old).Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current := generateKey(t, dir, "rsa")
+	if err := RetireKey(dir, old); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, old+".pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block, _ := pem.Decode(data); block == nil || block.Type != "PUBLIC KEY" {
+		t.Fatal("retired key file still holds a private key")
+	}
+
+	// Only the remaining private key signs; the retired one still verifies
+	// by its kid
+	keys := loadDir(t, dir, "")
+	if keys.active.ID != current {
+		t.Errorf("active key = %s, want %s", keys.active.ID, current)
+	}
+	token, err := keys.Parse(signed)
+	if err != nil {
+		t.Fatalf("token signed by the retired key: %v", err)
+	}
+	if token.Header["kid"] != old {
+		t.Errorf("verified by %v, want %s", token.Header["kid"], old)
+	}
+	if _, err := LoadDir(dir, old); err == nil {
+		t.Error("retired key accepted as the active key")
+	}
+}
+
+func TestParseRejectsAlgorithmConfusion(t *testing.T) {
+	dir := t.TempDir()
+	kid := generateKey(t, dir, "rsa")
+	keys := loadDir(t, dir, "")
+	public := keys.keys[kid].Public.(*rsa.PublicKey)
+
+	// The public key is no secret: a token MACed with it under HS256 must
+	// not pass as one signed by the private key
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, secret := range map[string][]byte{
+		"PEM": pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}),
+		"DER": der,
+	} {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+		token.Header["kid"] = kid
+		forged, err := token.SignedString(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := keys.Parse(forged); err == nil {
+			t.Errorf("HS256 token keyed with the %s public key accepted", name)
+		}
+	}
+
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, testClaims())
+	unsigned.Header["kid"] = kid
+	none, err := unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Parse(none); err == nil {
+		t.Error("unsigned token accepted")
+	}
+
+	// Nor may a token name one key and carry another key's algorithm
+	edKID := generateKey(t, dir, "ed25519")
+	keys = loadDir(t, dir, edKID)
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, testClaims())
+	token.Header["kid"] = kid
+	mismatched, err := token.SignedString(keys.active.private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Parse(mismatched); err == nil {
+		t.Error("EdDSA token naming an RSA key accepted")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	dir := t.TempDir()
+	rsaKID := generateKey(t, dir, "rsa")
+	edKID := generateKey(t, dir, "ed25519")
+	if err := RetireKey(dir, rsaKID); err != nil {
+		t.Fatal(err)
+	}
+	keys := loadDir(t, dir, "")
+
+	jwks := keys.JWKS()
+	if len(jwks) != 2 {
+		t.Fatalf("JWKS has %d keys, want 2", len(jwks))
+	}
+	if jwks[0].KeyID > jwks[1].KeyID {
+		t.Error("JWKS not ordered by kid")
+	}
+	for _, jwk := range jwks {
+		if jwk.Use != "sig" {
+			t.Errorf("key %s has use %q", jwk.KeyID, jwk.Use)
+		}
+		switch jwk.KeyID {
+		case rsaKID:
+			public := keys.keys[rsaKID].Public.(*rsa.PublicKey)
+			n, _ := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, _ := base64.RawURLEncoding.DecodeString(jwk.E)
+			if jwk.KeyType != "RSA" || jwk.Alg != "RS256" ||
+				new(big.Int).SetBytes(n).Cmp(public.N) != 0 || new(big.Int).SetBytes(e).Int64() != int64(public.E) {
+				t.Errorf("RSA JWK = %+v", jwk)
+			}
+		case edKID:
+			public := keys.keys[edKID].Public.(ed25519.PublicKey)
+			x, _ := base64.RawURLEncoding.DecodeString(jwk.X)
+			if jwk.KeyType != "OKP" || jwk.Curve != "Ed25519" || jwk.Alg != "EdDSA" || !public.Equal(ed25519.PublicKey(x)) {
+				t.Errorf("Ed25519 JWK = %+v", jwk)
+			}
+		default:
+			t.Errorf("unexpected key %s", jwk.KeyID)
+		}
+	}
+}
+
+func TestNewFromEnv(t *testing.T) {
+	t.Run("generates a key on first start", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "keys")
+		t.Setenv("JWT_KEYS_DIR", dir)
+		t.Setenv("JWT_ACTIVE_KID", "")
+		keys, err := NewFromEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keys.active == nil || keys.active.Method != jwt.SigningMethodEdDSA || len(keys.JWKS()) != 1 {
+			t.Fatalf("key set = %+v, want one generated Ed25519 key", keys)
+		}
+
+		// Restarting keeps the key rather than adding another
+		again, err := NewFromEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.active.ID != keys.active.ID || len(again.JWKS()) != 1 {
+			t.Errorf("restart signs with %s, want %s", again.active.ID, keys.active.ID)
+		}
+	})
+
+	t.Run("falls back to HS256", func(t *testing.T) {
+		t.Setenv("JWT_KEYS_DIR", "")
+		t.Setenv("JWT_SECRET", "secret")
+		keys, err := NewFromEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed, err := keys.Sign(testClaims())
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := keys.Parse(signed)
+		if err != nil || token.Method != jwt.SigningMethodHS256 {
+			t.Errorf("Parse = %v, %v; want an HS256 token", token, err)
+		}
+		if len(keys.JWKS()) != 0 {
+			t.Error("JWKS published for HS256")
+		}
+
+		t.Setenv("JWT_SECRET", "")
+		if _, err := NewFromEnv(); err == nil {
+			t.Error("started without any signing key")
+		}
+	})
+}
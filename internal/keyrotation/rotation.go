@@ -0,0 +1,313 @@
+// Package keyrotation moves every video onto the active master key.
+//
+// Videos using envelope encryption only need their data key re-wrapped.
+// Legacy videos, encrypted directly with a master key, are re-encrypted under
+// a fresh data key. Each video is committed on its own, so an interrupted
+// run simply resumes with the videos that are still pending.
+package keyrotation
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"secure-video-api/internal/database"
+	"secure-video-api/internal/kms"
+	"secure-video-api/internal/storage"
+	"secure-video-api/internal/utils"
+)
+
+// ErrAlreadyRunning is returned by Start while a rotation is in progress.
+var ErrAlreadyRunning = errors.New("key rotation already running")
+
+// errVideoChanged marks a video that was deleted or rotated by someone else
+// while it was being rotated. Its row was left alone.
+var errVideoChanged = errors.New("video deleted or changed during rotation")
+
+// maxReportedErrors bounds the per-video errors kept in Progress.
+const maxReportedErrors = 50
+
+// Progress describes a rotation run.
+type Progress struct {
+	Running       bool       `json:"running"`
+	ActiveVersion int        `json:"active_version"`
+	Total         int        `json:"total"`
+	Processed     int        `json:"processed"`
+	Rewrapped     int        `json:"rewrapped"`
+	Reencrypted   int        `json:"reencrypted"`
+	Skipped       int        `json:"skipped"`
+	Failed        int        `json:"failed"`
+	Errors        []string   `json:"errors,omitempty"`
+	StartedAt     time.Time  `json:"started_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+}
+
+type pendingVideo struct {
+	id         string
+	fileName   string
+	wrappedKey sql.NullString
+	keyVersion int
+}
+
+// Pending counts the videos not yet on the active key.
+func Pending(provider kms.KeyProvider) (int, error) {
+	active, err := provider.ActiveVersion()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = database.DB.QueryRow(
+		"SELECT COUNT(*) FROM videos WHERE key_version != ? OR wrapped_key IS NULL",
+		active,
+	).Scan(&count)
+	return count, err
+}
+
+// Run rotates every pending video onto the active key. report, if not nil,
+// is called after each video with the progress so far.
+func Run(provider kms.KeyProvider, encryptedDir string, report func(Progress)) (Progress, error) {
+	progress := Progress{Running: true, StartedAt: time.Now()}
+
+	active, err := provider.ActiveVersion()
+	if err != nil {
+		return progress, err
+	}
+	progress.ActiveVersion = active
+
+	videos, err := pendingVideos(active)
+	if err != nil {
+		return progress, err
+	}
+	progress.Total = len(videos)
+	log.Printf("[KeyRotation] %d videos to move onto key version %d using the %s provider",
+		len(videos), active, provider.Name())
+
+	for _, video := range videos {
+		var err error
+		if video.wrappedKey.Valid && video.wrappedKey.String != "" {
+			if err = rewrap(provider, video); err == nil {
+				progress.Rewrapped++
+			}
+		} else {
+			if err = reencrypt(provider, encryptedDir, video); err == nil {
+				progress.Reencrypted++
+			}
+		}
+		switch {
+		case errors.Is(err, errVideoChanged):
+			log.Printf("[KeyRotation] Video %s skipped: %v", video.id, err)
+			progress.Skipped++
+		case err != nil:
+			log.Printf("[KeyRotation] Video %s failed: %v", video.id, err)
+			progress.Failed++
+		}
+		if err != nil && len(progress.Errors) < maxReportedErrors {
+			progress.Errors = append(progress.Errors, fmt.Sprintf("%s: %v", video.id, err))
+		}
+		progress.Processed++
+
+		if report != nil {
+			report(progress)
+		}
+	}
+
+	finished := time.Now()
+	progress.Running = false
+	progress.FinishedAt = &finished
+	log.Printf("[KeyRotation] Finished: %d rewrapped, %d re-encrypted, %d skipped, %d failed",
+		progress.Rewrapped, progress.Reencrypted, progress.Skipped, progress.Failed)
+	return progress, nil
+}
+
+func pendingVideos(activeVersion int) ([]pendingVideo, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, file_name, wrapped_key, key_version
+		FROM videos
+		WHERE key_version != ? OR wrapped_key IS NULL
+		ORDER BY created_at
+	`, activeVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list videos: %v", err)
+	}
+	defer rows.Close()
+
+	var videos []pendingVideo
+	for rows.Next() {
+		var v pendingVideo
+		if err := rows.Scan(&v.id, &v.fileName, &v.wrappedKey, &v.keyVersion); err != nil {
+			return nil, fmt.Errorf("failed to scan video: %v", err)
+		}
+		videos = append(videos, v)
+	}
+	return videos, rows.Err()
+}
+
+// rewrap unwraps the video's data key with its recorded master key and wraps
+// it again with the active one. The ciphertext is untouched.
+func rewrap(provider kms.KeyProvider, video pendingVideo) error {
+	wrapped, err := base64.StdEncoding.DecodeString(video.wrappedKey.String)
+	if err != nil {
+		return fmt.Errorf("failed to decode wrapped key: %v", err)
+	}
+	dataKey, err := provider.UnwrapKey(wrapped, video.keyVersion)
+	if err != nil {
+		return err
+	}
+	rewrapped, version, err := provider.WrapKey(dataKey)
+	if err != nil {
+		return err
+	}
+
+	// Only commit if nobody else rotated the video in the meantime
+	result, err := database.DB.Exec(`
+		UPDATE videos SET wrapped_key = ?, key_version = ?
+		WHERE id = ? AND key_version = ? AND wrapped_key = ?
+	`, base64.StdEncoding.EncodeToString(rewrapped), version, video.id, video.keyVersion, video.wrappedKey.String)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errVideoChanged
+	}
+	return nil
+}
+
+// reencrypt moves a legacy video onto envelope encryption. The new
+// ciphertext is written under a new file name so the row and the file on
+// disk agree at every step: the new file is staged before it is written, and
+// the transaction switching the row over keeps it and releases the old one,
+// which is removed once the switch has committed.
+func reencrypt(provider kms.KeyProvider, encryptedDir string, video pendingVideo) error {
+	oldKey, err := provider.GetKey(video.keyVersion)
+	if err != nil {
+		return err
+	}
+
+	oldPath := filepath.Join(encryptedDir, video.fileName+".enc")
+	src, err := utils.NewDecryptingReader(oldPath, oldKey, video.id)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dataKey, err := utils.GenerateDataKey()
+	if err != nil {
+		return err
+	}
+	wrapped, version, err := provider.WrapKey(dataKey)
+	if err != nil {
+		return err
+	}
+
+	newFileName := rotatedFileName(video.fileName, version)
+	newPath := filepath.Join(encryptedDir, newFileName+".enc")
+	if err := storage.Stage(database.DB, video.id, newFileName+".enc"); err != nil {
+		return err
+	}
+	if err := utils.EncryptReader(src, newPath, dataKey, video.id); err != nil {
+		discard(video.id)
+		return err
+	}
+	if err := switchFile(video, newFileName, wrapped, version); err != nil {
+		discard(video.id)
+		return err
+	}
+
+	src.Close()
+	if err := storage.Purge(database.DB, video.id); err != nil {
+		log.Printf("[KeyRotation] Failed to remove old file of video %s: %v", video.id, err)
+	}
+	return nil
+}
+
+// switchFile points a legacy video's row at its re-encrypted file.
+func switchFile(video pendingVideo, newFileName string, wrapped []byte, version int) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE videos SET file_name = ?, wrapped_key = ?, key_version = ?
+		WHERE id = ? AND file_name = ? AND wrapped_key IS NULL
+	`, newFileName, base64.StdEncoding.EncodeToString(wrapped), version, video.id, video.fileName)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errVideoChanged
+	}
+	if err := storage.Keep(tx, video.id); err != nil {
+		return err
+	}
+	if err := storage.Release(tx, video.id, video.fileName+".enc"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// discard removes the file staged for a video whose rotation failed.
+func discard(videoID string) {
+	if err := storage.Discard(database.DB, videoID); err != nil {
+		log.Printf("[KeyRotation] Failed to remove new file of video %s: %v", videoID, err)
+	}
+}
+
+// rotatedFileName derives the file name of a re-encrypted video, e.g.
+// "abc.mp4" becomes "abc.k2.mp4" under key version 2.
+func rotatedFileName(fileName string, version int) string {
+	ext := filepath.Ext(fileName)
+	return strings.TrimSuffix(fileName, ext) + ".k" + strconv.Itoa(version) + ext
+}
+
+var (
+	mu      sync.Mutex
+	current Progress
+)
+
+// Start runs a rotation in the background, unless one is already running.
+func Start(provider kms.KeyProvider, encryptedDir string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if current.Running {
+		return ErrAlreadyRunning
+	}
+	current = Progress{Running: true, StartedAt: time.Now()}
+
+	go func() {
+		final, err := Run(provider, encryptedDir, func(p Progress) {
+			mu.Lock()
+			current = p
+			mu.Unlock()
+		})
+		if err != nil {
+			log.Printf("[KeyRotation] Run failed: %v", err)
+			final.Errors = append(final.Errors, err.Error())
+			finished := time.Now()
+			final.Running = false
+			final.FinishedAt = &finished
+		}
+		mu.Lock()
+		current = final
+		mu.Unlock()
+	}()
+	return nil
+}
+
+// Status returns the progress of the current or most recent background run.
+func Status() Progress {
+	mu.Lock()
+	defer mu.Unlock()
+	status := current
+	status.Errors = append([]string(nil), current.Errors...)
+	return status
+}
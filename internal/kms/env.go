@@ -0,0 +1,35 @@
+package kms
+
+import "secure-video-api/internal/utils"
+
+// EnvProvider serves master keys from ENCRYPTION_KEYS / ENCRYPTION_KEY.
+type EnvProvider struct {
+	keyring *utils.Keyring
+}
+
+// NewEnvProvider loads the keyring described by the environment.
+func NewEnvProvider() (*EnvProvider, error) {
+	keyring, err := utils.LoadKeyring()
+	if err != nil {
+		return nil, err
+	}
+	return &EnvProvider{keyring: keyring}, nil
+}
+
+func (p *EnvProvider) Name() string { return "env" }
+
+func (p *EnvProvider) ActiveVersion() (int, error) {
+	return p.keyring.ActiveVersion(), nil
+}
+
+func (p *EnvProvider) GetKey(version int) ([]byte, error) {
+	return p.keyring.Key(version)
+}
+
+func (p *EnvProvider) WrapKey(dataKey []byte) ([]byte, int, error) {
+	return p.keyring.WrapKey(dataKey)
+}
+
+func (p *EnvProvider) UnwrapKey(wrapped []byte, version int) ([]byte, error) {
+	return p.keyring.UnwrapKey(wrapped, version)
+}
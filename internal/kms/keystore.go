@@ -0,0 +1,250 @@
+package kms
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"secure-video-api/internal/utils"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Keystore file parameters. The master keys are sealed with AES-256-GCM
+// under a key derived from the passphrase with scrypt.
+const (
+	keystoreFormatVersion = 1
+	keystoreKDF           = "scrypt"
+	scryptN               = 1 << 15
+	scryptR               = 8
+	scryptP               = 1
+	keystoreSaltSize      = 16
+)
+
+// keystoreFile is the on-disk representation of a keystore.
+type keystoreFile struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	N       int    `json:"n"`
+	R       int    `json:"r"`
+	P       int    `json:"p"`
+	Salt    []byte `json:"salt"`
+	Sealed  []byte `json:"sealed"`
+}
+
+// keystoreContents is the sealed part of a keystore.
+type keystoreContents struct {
+	Active int            `json:"active"`
+	Keys   map[int][]byte `json:"keys"`
+}
+
+// KeystoreProvider serves master keys from a local file sealed with a
+// passphrase. It stands in for a KMS on hosts without one.
+type KeystoreProvider struct {
+	path       string
+	passphrase string
+	contents   keystoreContents
+	keyring    *utils.Keyring
+}
+
+// CreateKeystore writes a new keystore holding the given keys. With no keys,
+// a random version 1 key is generated.
+func CreateKeystore(path, passphrase string, keys map[int][]byte, active int) (*KeystoreProvider, error) {
+	if path == "" {
+		return nil, fmt.Errorf("keystore path is required")
+	}
+	if passphrase == "" {
+		return nil, fmt.Errorf("keystore passphrase is required")
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("keystore %s already exists", path)
+	}
+
+	if len(keys) == 0 {
+		key, err := utils.GenerateDataKey()
+		if err != nil {
+			return nil, err
+		}
+		keys, active = map[int][]byte{1: key}, 1
+	}
+
+	ks := &KeystoreProvider{
+		path:       path,
+		passphrase: passphrase,
+		contents:   keystoreContents{Active: active, Keys: keys},
+	}
+	if err := ks.load(); err != nil {
+		return nil, err
+	}
+	if err := ks.save(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// OpenKeystore unseals the keystore at path.
+func OpenKeystore(path, passphrase string) (*KeystoreProvider, error) {
+	if path == "" {
+		return nil, fmt.Errorf("KEYSTORE_PATH is required for the keystore provider")
+	}
+	if passphrase == "" {
+		return nil, fmt.Errorf("KEYSTORE_PASSPHRASE is required for the keystore provider")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keystore: %v", err)
+	}
+
+	var file keystoreFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse keystore: %v", err)
+	}
+	if file.Version != keystoreFormatVersion || file.KDF != keystoreKDF {
+		return nil, fmt.Errorf("unsupported keystore version %d (kdf %q)", file.Version, file.KDF)
+	}
+
+	sealingKey, err := scrypt.Key([]byte(passphrase), file.Salt, file.N, file.R, file.P, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive keystore key: %v", err)
+	}
+	plaintext, err := utils.UnwrapSecret(sealingKey, file.Sealed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unseal keystore (wrong passphrase?): %v", err)
+	}
+
+	ks := &KeystoreProvider{path: path, passphrase: passphrase}
+	if err := json.Unmarshal(plaintext, &ks.contents); err != nil {
+		return nil, fmt.Errorf("failed to parse keystore contents: %v", err)
+	}
+	if err := ks.load(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// AddKey generates a new master key version and makes it active.
+func (ks *KeystoreProvider) AddKey() (int, error) {
+	key, err := utils.GenerateDataKey()
+	if err != nil {
+		return 0, err
+	}
+
+	version := 1
+	for v := range ks.contents.Keys {
+		if v >= version {
+			version = v + 1
+		}
+	}
+
+	ks.contents.Keys[version] = key
+	previous := ks.contents.Active
+	ks.contents.Active = version
+	if err := ks.load(); err != nil {
+		delete(ks.contents.Keys, version)
+		ks.contents.Active = previous
+		return 0, err
+	}
+	if err := ks.save(); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// Versions lists the key versions held by the keystore.
+func (ks *KeystoreProvider) Versions() []int {
+	versions := make([]int, 0, len(ks.contents.Keys))
+	for v := range ks.contents.Keys {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+func (ks *KeystoreProvider) Name() string { return "keystore" }
+
+func (ks *KeystoreProvider) ActiveVersion() (int, error) {
+	return ks.keyring.ActiveVersion(), nil
+}
+
+func (ks *KeystoreProvider) GetKey(version int) ([]byte, error) {
+	return ks.keyring.Key(version)
+}
+
+func (ks *KeystoreProvider) WrapKey(dataKey []byte) ([]byte, int, error) {
+	return ks.keyring.WrapKey(dataKey)
+}
+
+func (ks *KeystoreProvider) UnwrapKey(wrapped []byte, version int) ([]byte, error) {
+	return ks.keyring.UnwrapKey(wrapped, version)
+}
+
+// load rebuilds the keyring from the unsealed contents.
+func (ks *KeystoreProvider) load() error {
+	keyring, err := utils.NewKeyring(ks.contents.Keys, ks.contents.Active)
+	if err != nil {
+		return fmt.Errorf("invalid keystore: %v", err)
+	}
+	ks.keyring = keyring
+	return nil
+}
+
+// save seals the contents under a freshly salted passphrase key and
+// atomically replaces the keystore file.
+func (ks *KeystoreProvider) save() error {
+	salt := make([]byte, keystoreSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return fmt.Errorf("failed to create salt: %v", err)
+	}
+	sealingKey, err := scrypt.Key([]byte(ks.passphrase), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return fmt.Errorf("failed to derive keystore key: %v", err)
+	}
+
+	plaintext, err := json.Marshal(ks.contents)
+	if err != nil {
+		return err
+	}
+	sealed, err := utils.WrapSecret(sealingKey, plaintext)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(keystoreFile{
+		Version: keystoreFormatVersion,
+		KDF:     keystoreKDF,
+		N:       scryptN,
+		R:       scryptR,
+		P:       scryptP,
+		Salt:    salt,
+		Sealed:  sealed,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ks.path), 0700); err != nil {
+		return fmt.Errorf("failed to create keystore directory: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(ks.path), ".keystore-*")
+	if err != nil {
+		return fmt.Errorf("failed to write keystore: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := tmp.Write(data); err != nil {
+		return fmt.Errorf("failed to write keystore: %v", err)
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		return fmt.Errorf("failed to set keystore permissions: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write keystore: %v", err)
+	}
+	return os.Rename(tmp.Name(), ks.path)
+}
@@ -0,0 +1,179 @@
+package kms
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testPassphrase = "correct horse battery staple"
+
+func TestKeystoreCreateAndOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "keystore.json")
+	keys := map[int][]byte{
+		1: bytes.Repeat([]byte{1}, 32),
+		2: bytes.Repeat([]byte{2}, 32),
+	}
+
+	ks, err := CreateKeystore(path, testPassphrase, keys, 2)
+	if err != nil {
+		t.Fatalf("CreateKeystore: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("keystore not written: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("keystore permissions = %o, want 600", perm)
+	}
+
+	data, _ := os.ReadFile(path)
+	for _, key := range keys {
+		if bytes.Contains(data, key) {
+			t.Fatal("keystore file contains a raw key")
+		}
+	}
+
+	wrapped, version, err := ks.WrapKey(bytes.Repeat([]byte{9}, 32))
+	if err != nil || version != 2 {
+		t.Fatalf("WrapKey = version %d, %v; want 2", version, err)
+	}
+
+	reopened, err := OpenKeystore(path, testPassphrase)
+	if err != nil {
+		t.Fatalf("OpenKeystore: %v", err)
+	}
+	if active, _ := reopened.ActiveVersion(); active != 2 {
+		t.Errorf("ActiveVersion = %d, want 2", active)
+	}
+	if got := reopened.Versions(); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("Versions = %v, want [1 2]", got)
+	}
+	for version, key := range keys {
+		got, err := reopened.GetKey(version)
+		if err != nil || !bytes.Equal(got, key) {
+			t.Errorf("GetKey(%d) = %x, %v; want %x", version, got, err, key)
+		}
+	}
+	dataKey, err := reopened.UnwrapKey(wrapped, 2)
+	if err != nil || !bytes.Equal(dataKey, bytes.Repeat([]byte{9}, 32)) {
+		t.Errorf("UnwrapKey = %x, %v", dataKey, err)
+	}
+}
+
+func TestKeystoreGeneratesKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	ks, err := CreateKeystore(path, testPassphrase, nil, 0)
+	if err != nil {
+		t.Fatalf("CreateKeystore: %v", err)
+	}
+	if got := ks.Versions(); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("Versions = %v, want [1]", got)
+	}
+	if key, err := ks.GetKey(1); err != nil || len(key) != 32 {
+		t.Errorf("GetKey(1) = %d bytes, %v", len(key), err)
+	}
+}
+
+func TestKeystoreAddKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	ks, err := CreateKeystore(path, testPassphrase, nil, 0)
+	if err != nil {
+		t.Fatalf("CreateKeystore: %v", err)
+	}
+	wrapped, _, err := ks.WrapKey(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatalf("WrapKey: %v", err)
+	}
+
+	version, err := ks.AddKey()
+	if err != nil || version != 2 {
+		t.Fatalf("AddKey = %d, %v; want 2", version, err)
+	}
+
+	reopened, err := OpenKeystore(path, testPassphrase)
+	if err != nil {
+		t.Fatalf("OpenKeystore: %v", err)
+	}
+	if active, _ := reopened.ActiveVersion(); active != 2 {
+		t.Errorf("ActiveVersion after AddKey = %d, want 2", active)
+	}
+	// Data keys wrapped under the previous version stay readable
+	if _, err := reopened.UnwrapKey(wrapped, 1); err != nil {
+		t.Errorf("UnwrapKey under version 1: %v", err)
+	}
+}
+
+func TestKeystoreErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keystore.json")
+	if _, err := CreateKeystore(path, testPassphrase, nil, 0); err != nil {
+		t.Fatalf("CreateKeystore: %v", err)
+	}
+
+	corrupt := filepath.Join(dir, "corrupt.json")
+	os.WriteFile(corrupt, []byte("{"), 0600)
+
+	future := filepath.Join(dir, "future.json")
+	data, _ := json.Marshal(keystoreFile{Version: keystoreFormatVersion + 1, KDF: keystoreKDF})
+	os.WriteFile(future, data, 0600)
+
+	tests := []struct {
+		name    string
+		open    func() error
+		wantErr string
+	}{
+		{"create without path", func() error {
+			_, err := CreateKeystore("", testPassphrase, nil, 0)
+			return err
+		}, "path is required"},
+		{"create without passphrase", func() error {
+			_, err := CreateKeystore(filepath.Join(dir, "new.json"), "", nil, 0)
+			return err
+		}, "passphrase is required"},
+		{"create over existing", func() error {
+			_, err := CreateKeystore(path, testPassphrase, nil, 0)
+			return err
+		}, "already exists"},
+		{"create with missing active key", func() error {
+			_, err := CreateKeystore(filepath.Join(dir, "bad.json"), testPassphrase, map[int][]byte{1: make([]byte, 32)}, 2)
+			return err
+		}, "invalid keystore"},
+		{"open without path", func() error {
+			_, err := OpenKeystore("", testPassphrase)
+			return err
+		}, "KEYSTORE_PATH"},
+		{"open without passphrase", func() error {
+			_, err := OpenKeystore(path, "")
+			return err
+		}, "KEYSTORE_PASSPHRASE"},
+		{"open missing file", func() error {
+			_, err := OpenKeystore(filepath.Join(dir, "missing.json"), testPassphrase)
+			return err
+		}, "failed to read keystore"},
+		{"open corrupt file", func() error {
+			_, err := OpenKeystore(corrupt, testPassphrase)
+			return err
+		}, "failed to parse keystore"},
+		{"open unsupported version", func() error {
+			_, err := OpenKeystore(future, testPassphrase)
+			return err
+		}, "unsupported keystore version"},
+		{"open with wrong passphrase", func() error {
+			_, err := OpenKeystore(path, "wrong passphrase")
+			return err
+		}, "wrong passphrase"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.open()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+// Package kms abstracts where the master keys protecting video data keys
+// live. Handlers only wrap and unwrap data keys through a KeyProvider, so
+// master keys can be kept in the environment, in a passphrase-sealed local
+// keystore, or in an external service such as Vault Transit.
+package kms
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrKeyNotExportable is returned by GetKey when the provider never releases
+// raw master key material, as is the case for Vault Transit.
+var ErrKeyNotExportable = errors.New("master key is not exportable from this provider")
+
+// KeyProvider wraps and unwraps per-video data keys with versioned master
+// keys.
+type KeyProvider interface {
+	// Name identifies the provider in logs and status output.
+	Name() string
+	// ActiveVersion reports the master key version used by WrapKey.
+	ActiveVersion() (int, error)
+	// GetKey returns the raw master key with the given version. It is only
+	// needed for legacy videos encrypted directly with a master key.
+	GetKey(version int) ([]byte, error)
+	// WrapKey wraps a data key with the active master key, returning the
+	// wrapped key and the version used.
+	WrapKey(dataKey []byte) ([]byte, int, error)
+	// UnwrapKey recovers a data key wrapped under the given version.
+	UnwrapKey(wrapped []byte, version int) ([]byte, error)
+}
+
+// Provider is the key provider used by the application, set up by Init.
+var Provider KeyProvider
+
+// Init selects the key provider from KEY_PROVIDER: "env" (the default),
+// "keystore" or "vault".
+func Init() error {
+	provider, err := NewFromEnv()
+	if err != nil {
+		return err
+	}
+	Provider = provider
+	return nil
+}
+
+// NewFromEnv builds the key provider configured in the environment.
+func NewFromEnv() (KeyProvider, error) {
+	switch kind := os.Getenv("KEY_PROVIDER"); kind {
+	case "", "env":
+		return NewEnvProvider()
+	case "keystore":
+		return OpenKeystore(os.Getenv("KEYSTORE_PATH"), os.Getenv("KEYSTORE_PASSPHRASE"))
+	case "vault":
+		return NewVaultProvider(VaultConfig{
+			Address: os.Getenv("VAULT_ADDR"),
+			Token:   os.Getenv("VAULT_TOKEN"),
+			Mount:   os.Getenv("VAULT_TRANSIT_MOUNT"),
+			KeyName: os.Getenv("VAULT_TRANSIT_KEY"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown KEY_PROVIDER %q (expected env, keystore or vault)", kind)
+	}
+}
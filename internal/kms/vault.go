@@ -0,0 +1,174 @@
+package kms
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VaultConfig configures the Vault Transit key provider.
+type VaultConfig struct {
+	// Address is the base URL of the Vault server, e.g. http://127.0.0.1:8200.
+	Address string
+	// Token authenticates requests through the X-Vault-Token header.
+	Token string
+	// Mount is the path the transit engine is mounted at, "transit" by default.
+	Mount string
+	// KeyName is the transit key that wraps data keys.
+	KeyName string
+	// Client overrides the HTTP client, mostly useful against stub servers.
+	Client *http.Client
+}
+
+// VaultProvider wraps data keys with a Vault Transit key. Master key
+// material never leaves Vault, so GetKey always fails and legacy videos must
+// be rotated onto envelope encryption before switching to this provider.
+type VaultProvider struct {
+	config VaultConfig
+	client *http.Client
+}
+
+// NewVaultProvider checks the configuration and returns a provider. No
+// request is made until a key is used.
+func NewVaultProvider(config VaultConfig) (*VaultProvider, error) {
+	if config.Address == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is required for the vault provider")
+	}
+	if config.KeyName == "" {
+		return nil, fmt.Errorf("VAULT_TRANSIT_KEY is required for the vault provider")
+	}
+	if config.Mount == "" {
+		config.Mount = "transit"
+	}
+	config.Address = strings.TrimRight(config.Address, "/")
+
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &VaultProvider{config: config, client: client}, nil
+}
+
+func (p *VaultProvider) Name() string { return "vault" }
+
+// ActiveVersion reports the latest version of the transit key.
+func (p *VaultProvider) ActiveVersion() (int, error) {
+	var resp struct {
+		Data struct {
+			LatestVersion int `json:"latest_version"`
+		} `json:"data"`
+	}
+	if err := p.do(http.MethodGet, "keys/"+p.config.KeyName, nil, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Data.LatestVersion, nil
+}
+
+func (p *VaultProvider) GetKey(version int) ([]byte, error) {
+	return nil, ErrKeyNotExportable
+}
+
+// WrapKey encrypts the data key with the transit key. The wrapped form is
+// Vault's "vault:v<version>:<ciphertext>" string.
+func (p *VaultProvider) WrapKey(dataKey []byte) ([]byte, int, error) {
+	var resp struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	req := map[string]string{"plaintext": base64.StdEncoding.EncodeToString(dataKey)}
+	if err := p.do(http.MethodPost, "encrypt/"+p.config.KeyName, req, &resp); err != nil {
+		return nil, 0, err
+	}
+
+	version, err := vaultCiphertextVersion(resp.Data.Ciphertext)
+	if err != nil {
+		return nil, 0, err
+	}
+	return []byte(resp.Data.Ciphertext), version, nil
+}
+
+func (p *VaultProvider) UnwrapKey(wrapped []byte, version int) ([]byte, error) {
+	if v, err := vaultCiphertextVersion(string(wrapped)); err != nil {
+		return nil, err
+	} else if v != version {
+		return nil, fmt.Errorf("wrapped key is for version %d, expected %d", v, version)
+	}
+
+	var resp struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	req := map[string]string{"ciphertext": string(wrapped)}
+	if err := p.do(http.MethodPost, "decrypt/"+p.config.KeyName, req, &resp); err != nil {
+		return nil, err
+	}
+
+	dataKey, err := base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid plaintext from vault: %v", err)
+	}
+	return dataKey, nil
+}
+
+// do sends a request to the transit engine and decodes the JSON response.
+func (p *VaultProvider) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	url := fmt.Sprintf("%s/v1/%s/%s", p.config.Address, p.config.Mount, path)
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", p.config.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&vaultErr)
+		return fmt.Errorf("vault %s %s: status %d: %s", method, path, resp.StatusCode, strings.Join(vaultErr.Errors, "; "))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response from vault: %v", err)
+	}
+	return nil
+}
+
+// vaultCiphertextVersion extracts the key version from a transit ciphertext
+// of the form "vault:v<version>:<data>".
+func vaultCiphertextVersion(ciphertext string) (int, error) {
+	parts := strings.SplitN(ciphertext, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" || !strings.HasPrefix(parts[1], "v") {
+		return 0, fmt.Errorf("unexpected vault ciphertext format")
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(parts[1], "v"))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected vault ciphertext version %q", parts[1])
+	}
+	return version, nil
+}
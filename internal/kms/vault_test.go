@@ -0,0 +1,281 @@
+package kms
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const stubToken = "stub-token"
+
+// transitStub is a stand-in for Vault's transit engine mounted at "transit".
+// Its "ciphertext" is the base64 plaintext prefixed with the key version,
+// which is enough to check what the provider sends and how it reads replies.
+type transitStub struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu       sync.Mutex
+	key      string
+	latest   int
+	requests []string
+	// fail, if set, answers every request with this status and Vault error
+	fail    int
+	failMsg string
+	// reply, if set, replaces the body of successful responses
+	reply string
+}
+
+func newTransitStub(t *testing.T) *transitStub {
+	s := &transitStub{t: t, key: "videos", latest: 1}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *transitStub) provider(t *testing.T) *VaultProvider {
+	t.Helper()
+	p, err := NewVaultProvider(VaultConfig{
+		Address: s.server.URL + "/",
+		Token:   stubToken,
+		KeyName: s.key,
+		Client:  s.server.Client(),
+	})
+	if err != nil {
+		t.Fatalf("NewVaultProvider: %v", err)
+	}
+	return p
+}
+
+func (s *transitStub) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	if s.fail != 0 {
+		writeVaultError(w, s.fail, s.failMsg)
+		return
+	}
+	if r.Header.Get("X-Vault-Token") != stubToken {
+		writeVaultError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+	if s.reply != "" {
+		w.Write([]byte(s.reply))
+		return
+	}
+
+	var body map[string]string
+	if r.Method == http.MethodPost {
+		if r.Header.Get("Content-Type") != "application/json" {
+			writeVaultError(w, http.StatusBadRequest, "expected JSON")
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeVaultError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/transit/keys/"+s.key:
+		writeVaultData(w, map[string]any{"latest_version": s.latest})
+
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/transit/encrypt/"+s.key:
+		if _, err := base64.StdEncoding.DecodeString(body["plaintext"]); err != nil {
+			writeVaultError(w, http.StatusBadRequest, "plaintext is not base64")
+			return
+		}
+		writeVaultData(w, map[string]any{"ciphertext": fmt.Sprintf("vault:v%d:%s", s.latest, body["plaintext"])})
+
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/transit/decrypt/"+s.key:
+		parts := strings.SplitN(body["ciphertext"], ":", 3)
+		if len(parts) != 3 {
+			writeVaultError(w, http.StatusBadRequest, "invalid ciphertext")
+			return
+		}
+		writeVaultData(w, map[string]any{"plaintext": parts[2]})
+
+	default:
+		writeVaultError(w, http.StatusNotFound, "no handler for route")
+	}
+}
+
+func writeVaultData(w http.ResponseWriter, data map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"data": data})
+}
+
+func writeVaultError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"errors": []string{msg}})
+}
+
+func TestNewVaultProviderConfig(t *testing.T) {
+	if _, err := NewVaultProvider(VaultConfig{KeyName: "videos"}); err == nil {
+		t.Error("missing address accepted")
+	}
+	if _, err := NewVaultProvider(VaultConfig{Address: "http://vault"}); err == nil {
+		t.Error("missing key name accepted")
+	}
+
+	p, err := NewVaultProvider(VaultConfig{Address: "http://vault/", KeyName: "videos"})
+	if err != nil {
+		t.Fatalf("NewVaultProvider: %v", err)
+	}
+	if p.config.Mount != "transit" || p.config.Address != "http://vault" || p.client == nil {
+		t.Errorf("defaults not applied: %+v", p.config)
+	}
+	if _, err := p.GetKey(1); !errors.Is(err, ErrKeyNotExportable) {
+		t.Errorf("GetKey error = %v, want %v", err, ErrKeyNotExportable)
+	}
+}
+
+func TestVaultProviderRoundTrip(t *testing.T) {
+	stub := newTransitStub(t)
+	stub.latest = 3
+	p := stub.provider(t)
+
+	active, err := p.ActiveVersion()
+	if err != nil || active != 3 {
+		t.Fatalf("ActiveVersion = %d, %v; want 3", active, err)
+	}
+
+	dataKey := bytes.Repeat([]byte{0x42}, 32)
+	wrapped, version, err := p.WrapKey(dataKey)
+	if err != nil {
+		t.Fatalf("WrapKey: %v", err)
+	}
+	if version != 3 || !strings.HasPrefix(string(wrapped), "vault:v3:") {
+		t.Fatalf("WrapKey = %q, version %d", wrapped, version)
+	}
+
+	unwrapped, err := p.UnwrapKey(wrapped, version)
+	if err != nil {
+		t.Fatalf("UnwrapKey: %v", err)
+	}
+	if !bytes.Equal(unwrapped, dataKey) {
+		t.Errorf("UnwrapKey = %x, want %x", unwrapped, dataKey)
+	}
+
+	want := []string{
+		"GET /v1/transit/keys/videos",
+		"POST /v1/transit/encrypt/videos",
+		"POST /v1/transit/decrypt/videos",
+	}
+	if fmt.Sprint(stub.requests) != fmt.Sprint(want) {
+		t.Errorf("requests = %v, want %v", stub.requests, want)
+	}
+}
+
+func TestVaultProviderUnwrapChecksVersion(t *testing.T) {
+	stub := newTransitStub(t)
+	p := stub.provider(t)
+
+	tests := []struct {
+		name    string
+		wrapped string
+		version int
+	}{
+		{"version mismatch", "vault:v2:AAAA", 1},
+		{"not a vault ciphertext", "AAAA", 1},
+		{"missing version prefix", "vault:2:AAAA", 2},
+		{"non-numeric version", "vault:vX:AAAA", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := p.UnwrapKey([]byte(tt.wrapped), tt.version); err == nil {
+				t.Errorf("UnwrapKey(%q, %d) succeeded", tt.wrapped, tt.version)
+			}
+		})
+	}
+	if len(stub.requests) != 0 {
+		t.Errorf("invalid ciphertexts reached vault: %v", stub.requests)
+	}
+}
+
+func TestVaultProviderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(s *transitStub)
+		token   string
+		wantErr string
+	}{
+		{
+			name:    "wrong token",
+			token:   "other-token",
+			wantErr: "status 403: permission denied",
+		},
+		{
+			name:    "unknown key",
+			setup:   func(s *transitStub) { s.key = "other" },
+			wantErr: "status 404: no handler for route",
+		},
+		{
+			name:    "server error",
+			setup:   func(s *transitStub) { s.fail, s.failMsg = http.StatusInternalServerError, "sealed" },
+			wantErr: "status 500: sealed",
+		},
+		{
+			name:    "invalid JSON",
+			setup:   func(s *transitStub) { s.reply = "not json" },
+			wantErr: "invalid response from vault",
+		},
+		{
+			name:    "unexpected ciphertext",
+			setup:   func(s *transitStub) { s.reply = `{"data":{"ciphertext":"plain","plaintext":"!!"}}` },
+			wantErr: "unexpected vault ciphertext format",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newTransitStub(t)
+			p := stub.provider(t)
+			if tt.setup != nil {
+				tt.setup(stub)
+			}
+			if tt.token != "" {
+				p.config.Token = tt.token
+			}
+
+			_, _, err := p.WrapKey(make([]byte, 32))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("WrapKey error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVaultProviderUnwrapErrors(t *testing.T) {
+	stub := newTransitStub(t)
+	p := stub.provider(t)
+
+	stub.reply = `{"data":{"plaintext":"not base64!"}}`
+	if _, err := p.UnwrapKey([]byte("vault:v1:AAAA"), 1); err == nil || !strings.Contains(err.Error(), "invalid plaintext") {
+		t.Errorf("UnwrapKey error = %v, want invalid plaintext", err)
+	}
+
+	stub.reply = ""
+	stub.fail, stub.failMsg = http.StatusBadRequest, "invalid ciphertext"
+	if _, err := p.UnwrapKey([]byte("vault:v1:AAAA"), 1); err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Errorf("UnwrapKey error = %v, want status 400", err)
+	}
+}
+
+func TestVaultProviderUnreachable(t *testing.T) {
+	stub := newTransitStub(t)
+	p := stub.provider(t)
+	stub.server.Close()
+
+	if _, err := p.ActiveVersion(); err == nil || !strings.Contains(err.Error(), "vault request failed") {
+		t.Errorf("ActiveVersion error = %v, want vault request failed", err)
+	}
+}
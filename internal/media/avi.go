@@ -0,0 +1,211 @@
+package media
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// validateAVI checks the RIFF structure of an AVI file: the header list with
+// its main AVI header must come first, followed somewhere by the movi list
+// holding the stream data.
+func validateAVI(r io.ReadSeeker, size int64) error {
+	var riff [12]byte
+	if err := readAt(r, 0, riff[:]); err != nil {
+		return err
+	}
+	riffEnd := 8 + int64(binary.LittleEndian.Uint32(riff[4:8]))
+	if riffEnd > size {
+		return invalidf("RIFF chunk overruns the file")
+	}
+
+	sawMovi := false
+	first := true
+	for off := int64(12); off < riffEnd; {
+		chunk, err := readRIFFChunk(r, off, riffEnd)
+		if err != nil {
+			return err
+		}
+
+		if first {
+			if chunk.id != "LIST" || chunk.listType != "hdrl" {
+				return invalidf("AVI header list is not first")
+			}
+			sub, err := readRIFFChunk(r, off+12, off+8+chunk.size)
+			if err != nil {
+				return err
+			}
+			if sub.id != "avih" {
+				return invalidf("AVI header list does not start with avih")
+			}
+			first = false
+		}
+		if chunk.id == "LIST" && chunk.listType == "movi" {
+			sawMovi = true
+		}
+
+		// Chunks are padded to an even size
+		off += 8 + chunk.size + chunk.size%2
+	}
+
+	if first {
+		return invalidf("empty AVI file")
+	}
+	if !sawMovi {
+		return invalidf("no movi list")
+	}
+	return nil
+}
+
+type riffChunk struct {
+	id       string
+	size     int64 // data size, excluding the header and padding
+	listType string
+}
+
+// readRIFFChunk reads the header of the chunk at off, which must end by end.
+func readRIFFChunk(r io.ReadSeeker, off, end int64) (riffChunk, error) {
+	if end-off < 8 {
+		return riffChunk{}, invalidf("truncated chunk header at offset %d", off)
+	}
+	var hdr [12]byte
+	if err := readAt(r, off, hdr[:8]); err != nil {
+		return riffChunk{}, err
+	}
+	if !isFourCC(hdr[0:4]) {
+		return riffChunk{}, invalidf("invalid chunk ID at offset %d", off)
+	}
+
+	chunk := riffChunk{
+		id:   string(hdr[0:4]),
+		size: int64(binary.LittleEndian.Uint32(hdr[4:8])),
+	}
+	if chunk.size > end-off-8 {
+		return riffChunk{}, invalidf("%s chunk at offset %d overruns its parent", chunk.id, off)
+	}
+	if chunk.id == "LIST" {
+		if chunk.size < 4 {
+			return riffChunk{}, invalidf("LIST chunk at offset %d too small", off)
+		}
+		if err := readAt(r, off+8, hdr[8:12]); err != nil {
+			return riffChunk{}, err
+		}
+		chunk.listType = string(hdr[8:12])
+	}
+	return chunk, nil
+}
+
+// WAVE format tags of common audio codecs in AVI files
+var waveFormats = map[uint16]string{
+	0x0001: "pcm",
+	0x0050: "mp2",
+	0x0055: "mp3",
+	0x00FF: "aac",
+	0x1610: "aac",
+	0x2000: "ac3",
+}
+
+// probeAVI reads the main AVI header and the stream headers and formats of
+// the first video and audio streams.
+func probeAVI(r io.ReadSeeker, size int64, meta *Metadata) error {
+	hdrl, err := readRIFFChunk(r, 12, size)
+	if err != nil {
+		return err
+	}
+	hdrlEnd := 12 + 8 + hdrl.size
+
+	var avih [40]byte
+	off := int64(12 + 12)
+	for off < hdrlEnd {
+		chunk, err := readRIFFChunk(r, off, hdrlEnd)
+		if err != nil {
+			return err
+		}
+		switch {
+		case chunk.id == "avih" && chunk.size >= int64(len(avih)):
+			if err := readAt(r, off+8, avih[:]); err != nil {
+				return err
+			}
+			usPerFrame := binary.LittleEndian.Uint32(avih[0:4])
+			totalFrames := binary.LittleEndian.Uint32(avih[16:20])
+			meta.Duration = float64(usPerFrame) * float64(totalFrames) / 1e6
+			meta.Width = int(binary.LittleEndian.Uint32(avih[32:36]))
+			meta.Height = int(binary.LittleEndian.Uint32(avih[36:40]))
+		case chunk.id == "LIST" && chunk.listType == "strl":
+			if err := probeAVIStream(r, off+12, off+8+chunk.size, meta); err != nil {
+				return err
+			}
+		}
+		off += 8 + chunk.size + chunk.size%2
+	}
+	return nil
+}
+
+// probeAVIStream reads one strl list: a stream header followed by the
+// stream format.
+func probeAVIStream(r io.ReadSeeker, start, end int64, meta *Metadata) error {
+	strh, err := readRIFFChunk(r, start, end)
+	if err != nil {
+		return err
+	}
+	if strh.id != "strh" || strh.size < 36 {
+		return invalidf("stream list without a stream header")
+	}
+	var header [36]byte
+	if err := readAt(r, start+8, header[:]); err != nil {
+		return err
+	}
+
+	strfStart := start + 8 + strh.size + strh.size%2
+	strf, err := readRIFFChunk(r, strfStart, end)
+	if err != nil {
+		return err
+	}
+	if strf.id != "strf" {
+		return invalidf("stream list without a stream format")
+	}
+
+	switch string(header[0:4]) {
+	case "vids":
+		if meta.VideoCodec != "" {
+			return nil
+		}
+		// The stream's own rate and length are more precise than the
+		// frame period in the main header
+		scale := binary.LittleEndian.Uint32(header[20:24])
+		rate := binary.LittleEndian.Uint32(header[24:28])
+		length := binary.LittleEndian.Uint32(header[32:36])
+		if scale > 0 && rate > 0 {
+			meta.Duration = float64(length) * float64(scale) / float64(rate)
+		}
+
+		// BITMAPINFOHEADER biCompression, falling back to the handler
+		codec := string(header[4:8])
+		if strf.size >= 20 {
+			var compression [4]byte
+			if err := readAt(r, strfStart+8+16, compression[:]); err != nil {
+				return err
+			}
+			if isFourCC(compression[:]) {
+				codec = string(compression[:])
+			}
+		}
+		meta.VideoCodec = codecName(codec)
+
+	case "auds":
+		if meta.AudioCodec != "" || strf.size < 2 {
+			return nil
+		}
+		var tag [2]byte
+		if err := readAt(r, strfStart+8, tag[:]); err != nil {
+			return err
+		}
+		formatTag := binary.LittleEndian.Uint16(tag[:])
+		if name, ok := waveFormats[formatTag]; ok {
+			meta.AudioCodec = name
+		} else {
+			meta.AudioCodec = fmt.Sprintf("0x%04x", formatTag)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// validateBMFF checks that an ISO BMFF file (MP4, QuickTime) is a sequence of
+// well-formed top-level boxes exactly covering the file, including the movie
+// box describing its tracks.
+func validateBMFF(r io.ReadSeeker, size int64) error {
+	var off int64
+	sawMoov := false
+	for off < size {
+		box, err := readBoxHeader(r, off, size)
+		if err != nil {
+			return err
+		}
+
+		switch box.typ {
+		case "ftyp":
+			if off != 0 {
+				return invalidf("ftyp box at offset %d is not first", off)
+			}
+			if box.size < box.headerLen+8 {
+				return invalidf("ftyp box too small")
+			}
+		case "moov":
+			sawMoov = true
+		}
+		off += box.size
+	}
+
+	if !sawMoov {
+		return invalidf("no moov box")
+	}
+	return nil
+}
+
+type boxHeader struct {
+	typ       string
+	size      int64 // including the header
+	headerLen int64
+}
+
+// readBoxHeader reads the header of the box at off, which must end by end.
+func readBoxHeader(r io.ReadSeeker, off, end int64) (boxHeader, error) {
+	if end-off < 8 {
+		return boxHeader{}, invalidf("truncated box header at offset %d", off)
+	}
+	var hdr [16]byte
+	if err := readAt(r, off, hdr[:8]); err != nil {
+		return boxHeader{}, err
+	}
+
+	box := boxHeader{
+		typ:       string(hdr[4:8]),
+		size:      int64(binary.BigEndian.Uint32(hdr[0:4])),
+		headerLen: 8,
+	}
+	if !isFourCC(hdr[4:8]) {
+		return boxHeader{}, invalidf("invalid box type at offset %d", off)
+	}
+
+	switch box.size {
+	case 0:
+		// The box extends to the end of its parent
+		box.size = end - off
+	case 1:
+		// 64-bit size follows the type
+		if end-off < 16 {
+			return boxHeader{}, invalidf("truncated %s box header at offset %d", box.typ, off)
+		}
+		if err := readAt(r, off+8, hdr[8:16]); err != nil {
+			return boxHeader{}, err
+		}
+		large := binary.BigEndian.Uint64(hdr[8:16])
+		if large > uint64(end) {
+			return boxHeader{}, invalidf("%s box at offset %d overruns the file", box.typ, off)
+		}
+		box.size = int64(large)
+		box.headerLen = 16
+	}
+
+	if box.size < box.headerLen {
+		return boxHeader{}, invalidf("%s box at offset %d has invalid size %d", box.typ, off, box.size)
+	}
+	if box.size > end-off {
+		return boxHeader{}, invalidf("%s box at offset %d overruns the file", box.typ, off)
+	}
+	return box, nil
+}
+
+// isFourCC reports whether b is made of printable ASCII, as box types and
+// RIFF chunk IDs are.
+func isFourCC(b []byte) bool {
+	for _, ch := range b {
+		if ch < 0x20 || ch > 0x7E {
+			// © is used by QuickTime metadata atoms
+			if ch != 0xA9 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// probeBMFF reads the duration from the movie header and the codec and
+// dimensions of the first video and audio tracks.
+func probeBMFF(r io.ReadSeeker, size int64, meta *Metadata) error {
+	moov, err := findBox(r, 0, size, "moov")
+	if err != nil {
+		return err
+	}
+
+	return walkBoxes(r, moov.start, moov.end, func(box boxRange) error {
+		switch box.typ {
+		case "mvhd":
+			timescale, duration, err := readMediaTimes(r, box)
+			if err != nil {
+				return err
+			}
+			if timescale > 0 {
+				meta.Duration = float64(duration) / float64(timescale)
+			}
+		case "trak":
+			return probeTrack(r, box, meta)
+		}
+		return nil
+	})
+}
+
+// probeTrack fills in the codec of a track, and the dimensions for video.
+func probeTrack(r io.ReadSeeker, trak boxRange, meta *Metadata) error {
+	var handler, codec string
+	var width, height int
+
+	tkhd, err := findBox(r, trak.start, trak.end, "tkhd")
+	if err != nil {
+		return err
+	}
+	// Width and height close the box as 16.16 fixed point numbers
+	if tkhd.end-tkhd.start >= 8 {
+		var dims [8]byte
+		if err := readAt(r, tkhd.end-8, dims[:]); err != nil {
+			return err
+		}
+		width = int(binary.BigEndian.Uint32(dims[0:4]) >> 16)
+		height = int(binary.BigEndian.Uint32(dims[4:8]) >> 16)
+	}
+
+	mdia, err := findBox(r, trak.start, trak.end, "mdia")
+	if err != nil {
+		return err
+	}
+	hdlr, err := findBox(r, mdia.start, mdia.end, "hdlr")
+	if err != nil {
+		return err
+	}
+	// version/flags, pre_defined, handler_type
+	if hdlr.end-hdlr.start < 12 {
+		return invalidf("hdlr box too small")
+	}
+	var hdlrData [12]byte
+	if err := readAt(r, hdlr.start, hdlrData[:]); err != nil {
+		return err
+	}
+	handler = string(hdlrData[8:12])
+	if handler != "vide" && handler != "soun" {
+		return nil
+	}
+
+	stsd, err := findPath(r, mdia, "minf", "stbl", "stsd")
+	if err != nil {
+		return err
+	}
+	// version/flags and entry_count precede the first sample entry
+	entry, err := readBoxHeader(r, stsd.start+8, stsd.end)
+	if err != nil {
+		return err
+	}
+	codec = codecName(entry.typ)
+
+	if handler == "vide" {
+		// Fall back to the sample entry dimensions, which follow 24 bytes
+		// of reserved and predefined fields
+		entryStart := stsd.start + 8 + entry.headerLen
+		if (width == 0 || height == 0) && entry.size-entry.headerLen >= 28 {
+			var dims [4]byte
+			if err := readAt(r, entryStart+24, dims[:]); err != nil {
+				return err
+			}
+			width = int(binary.BigEndian.Uint16(dims[0:2]))
+			height = int(binary.BigEndian.Uint16(dims[2:4]))
+		}
+		if meta.VideoCodec == "" {
+			meta.VideoCodec = codec
+			meta.Width, meta.Height = width, height
+		}
+	} else if meta.AudioCodec == "" {
+		meta.AudioCodec = codec
+	}
+	return nil
+}
+
+// readMediaTimes reads the timescale and duration of an mvhd or mdhd box.
+func readMediaTimes(r io.ReadSeeker, box boxRange) (uint32, uint64, error) {
+	var buf [32]byte
+	n := box.end - box.start
+	if n > int64(len(buf)) {
+		n = int64(len(buf))
+	}
+	if n < 20 {
+		return 0, 0, invalidf("%s box too small", box.typ)
+	}
+	if err := readAt(r, box.start, buf[:n]); err != nil {
+		return 0, 0, err
+	}
+
+	// Version 1 uses 64-bit times and durations
+	if buf[0] == 1 {
+		if n < 32 {
+			return 0, 0, invalidf("%s box too small", box.typ)
+		}
+		return binary.BigEndian.Uint32(buf[20:24]), binary.BigEndian.Uint64(buf[24:32]), nil
+	}
+	return binary.BigEndian.Uint32(buf[12:16]), uint64(binary.BigEndian.Uint32(buf[16:20])), nil
+}
+
+// boxRange locates a box and its payload.
+type boxRange struct {
+	typ        string
+	offset     int64 // start of the box header
+	start, end int64 // payload
+}
+
+// walkBoxes calls fn for each box between start and end.
+func walkBoxes(r io.ReadSeeker, start, end int64, fn func(boxRange) error) error {
+	for off := start; off < end; {
+		box, err := readBoxHeader(r, off, end)
+		if err != nil {
+			return err
+		}
+		if err := fn(boxRange{typ: box.typ, offset: off, start: off + box.headerLen, end: off + box.size}); err != nil {
+			return err
+		}
+		off += box.size
+	}
+	return nil
+}
+
+// errFound stops walkBoxes once findBox has its box.
+var errFound = errors.New("box found")
+
+// findBox returns the first box of the given type between start and end.
+func findBox(r io.ReadSeeker, start, end int64, typ string) (boxRange, error) {
+	var found boxRange
+	err := walkBoxes(r, start, end, func(box boxRange) error {
+		if box.typ == typ {
+			found = box
+			return errFound
+		}
+		return nil
+	})
+	if err == errFound {
+		return found, nil
+	}
+	if err != nil {
+		return boxRange{}, err
+	}
+	return boxRange{}, invalidf("no %s box", typ)
+}
+
+// findPath descends through nested boxes, e.g. minf/stbl/stsd.
+func findPath(r io.ReadSeeker, parent boxRange, path ...string) (boxRange, error) {
+	box := parent
+	for _, typ := range path {
+		var err error
+		if box, err = findBox(r, box.start, box.end, typ); err != nil {
+			return boxRange{}, err
+		}
+	}
+	return box, nil
+}
@@ -0,0 +1,117 @@
+package media
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Sizes of the fixed fields of visual and audio sample entries, after the
+// box header
+const (
+	visualSampleEntrySize = 78
+	audioSampleEntrySize  = 28
+)
+
+// CodecString returns the RFC 6381 codecs parameter for the track, as used
+// in HLS and DASH manifests, or "" if it cannot be determined.
+func (t *Track) CodecString() string {
+	entry := t.SampleEntry()
+	if len(entry) < 8 {
+		return ""
+	}
+
+	switch t.Codec {
+	case "avc1", "avc3":
+		avcC := findChildBox(entry, 8+visualSampleEntrySize, "avcC")
+		if len(avcC) < 4 {
+			return ""
+		}
+		// profile_idc, constraint flags, level_idc
+		return fmt.Sprintf("%s.%02X%02X%02X", t.Codec, avcC[1], avcC[2], avcC[3])
+
+	case "mp4a":
+		esds := findChildBox(entry, 8+audioSampleEntrySize, "esds")
+		if len(esds) < 4 {
+			return ""
+		}
+		objectType, audioObjectType := parseESDS(esds[4:])
+		if objectType == 0 {
+			return ""
+		}
+		if objectType == 0x40 && audioObjectType != 0 {
+			return fmt.Sprintf("mp4a.40.%d", audioObjectType)
+		}
+		return fmt.Sprintf("mp4a.%02X", objectType)
+	}
+	return ""
+}
+
+// findChildBox returns the payload of the first child box of type typ in a
+// box, starting the search at offset start.
+func findChildBox(box []byte, start int, typ string) []byte {
+	for off := start; off+8 <= len(box); {
+		size := int(binary.BigEndian.Uint32(box[off:]))
+		if size < 8 || off+size > len(box) {
+			return nil
+		}
+		if string(box[off+4:off+8]) == typ {
+			return box[off+8 : off+size]
+		}
+		off += size
+	}
+	return nil
+}
+
+// parseESDS reads the object type indication and, for MPEG-4 audio, the
+// audio object type from an ES descriptor.
+func parseESDS(data []byte) (objectType byte, audioObjectType byte) {
+	tag, body, _ := readDescriptor(data)
+	if tag != 0x03 || len(body) < 3 {
+		return 0, 0
+	}
+	// ES_ID, then flags announcing optional fields
+	flags := body[2]
+	body = body[3:]
+	if flags&0x80 != 0 && len(body) >= 2 {
+		body = body[2:]
+	}
+	if flags&0x40 != 0 && len(body) >= 1 && len(body) >= 1+int(body[0]) {
+		body = body[1+int(body[0]):]
+	}
+	if flags&0x20 != 0 && len(body) >= 2 {
+		body = body[2:]
+	}
+
+	tag, config, _ := readDescriptor(body)
+	if tag != 0x04 || len(config) < 13 {
+		return 0, 0
+	}
+	objectType = config[0]
+
+	tag, specific, _ := readDescriptor(config[13:])
+	if tag == 0x05 && len(specific) > 0 {
+		audioObjectType = specific[0] >> 3
+	}
+	return objectType, audioObjectType
+}
+
+// readDescriptor splits an MPEG-4 descriptor into its tag and body.
+func readDescriptor(data []byte) (tag byte, body, rest []byte) {
+	if len(data) < 2 {
+		return 0, nil, nil
+	}
+	tag = data[0]
+	var size int
+	i := 1
+	for ; i < len(data) && i <= 4; i++ {
+		size = size<<7 | int(data[i]&0x7F)
+		if data[i]&0x80 == 0 {
+			i++
+			break
+		}
+	}
+	if i+size > len(data) {
+		return 0, nil, nil
+	}
+	return tag, data[i : i+size], data[i+size:]
+}
@@ -0,0 +1,277 @@
+package media
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Fragmented MP4 (CMAF style) output: an init segment describing the tracks
+// followed by moof+mdat media segments, each starting on a video sync
+// sample so it can be decoded on its own.
+
+// trun flags: data offset, and per-sample duration, size, flags and
+// composition time offset
+const trunFlags = 0x000001 | 0x000100 | 0x000200 | 0x000400 | 0x000800
+
+// tfhd flag: sample offsets are relative to the start of the moof box
+const tfhdDefaultBaseIsMoof = 0x020000
+
+// Sample flags: a sync sample depends on no other sample; other samples
+// depend on earlier ones and are not sync samples
+const (
+	sampleFlagsSync    = 0x02000000
+	sampleFlagsNonSync = 0x01010000
+)
+
+// unityMatrix is the identity transformation of movie and track headers.
+var unityMatrix = concat(
+	u32(0x00010000), u32(0), u32(0),
+	u32(0), u32(0x00010000), u32(0),
+	u32(0), u32(0), u32(0x40000000),
+)
+
+// Segment is a run of samples of every track, cut at a video sync sample.
+type Segment struct {
+	Duration float64 // seconds
+	ranges   [][2]int
+}
+
+// Segments cuts the movie into segments of at least target seconds,
+// starting each one at a sync sample of the first video track (or at any
+// sample for audio-only movies).
+func (m *Movie) Segments(target float64) []Segment {
+	ref := m.Tracks[0]
+	for _, track := range m.Tracks {
+		if track.IsVideo() {
+			ref = track
+			break
+		}
+	}
+	if len(ref.Samples) == 0 {
+		return nil
+	}
+
+	// Decode times (in the reference timescale) at which segments start
+	targetTicks := uint64(target * float64(ref.Timescale))
+	cuts := []uint64{ref.Samples[0].DecodeTime}
+	for _, sample := range ref.Samples[1:] {
+		if sample.Sync && sample.DecodeTime-cuts[len(cuts)-1] >= targetTicks {
+			cuts = append(cuts, sample.DecodeTime)
+		}
+	}
+	last := ref.Samples[len(ref.Samples)-1]
+	end := last.DecodeTime + uint64(last.Duration)
+
+	segments := make([]Segment, len(cuts))
+	for i := range segments {
+		next := end
+		if i+1 < len(cuts) {
+			next = cuts[i+1]
+		}
+		segments[i].Duration = float64(next-cuts[i]) / float64(ref.Timescale)
+		segments[i].ranges = make([][2]int, len(m.Tracks))
+	}
+
+	// Assign every track's samples by decode time, comparing across
+	// timescales without rounding
+	for t, track := range m.Tracks {
+		s := 0
+		for i := range segments {
+			first := s
+			if i+1 < len(cuts) {
+				for s < len(track.Samples) &&
+					track.Samples[s].DecodeTime*uint64(ref.Timescale) < cuts[i+1]*uint64(track.Timescale) {
+					s++
+				}
+			} else {
+				s = len(track.Samples)
+			}
+			segments[i].ranges[t] = [2]int{first, s}
+		}
+	}
+	return segments
+}
+
+// Split returns track t as a movie of its own, with the parts of segments
+// that hold its samples, for renditions that carry each track separately.
+// Segments in which the track has no samples are dropped.
+func (m *Movie) Split(t int, segments []Segment) (*Movie, []Segment) {
+	track := m.Tracks[t]
+	single := &Movie{Timescale: m.Timescale, Tracks: []*Track{track}}
+
+	var parts []Segment
+	for _, seg := range segments {
+		first, end := seg.ranges[t][0], seg.ranges[t][1]
+		if first == end {
+			continue
+		}
+		part := Segment{ranges: [][2]int{{first, end}}}
+		_, duration := single.Span(part)
+		part.Duration = float64(duration) / float64(track.Timescale)
+		parts = append(parts, part)
+	}
+	return single, parts
+}
+
+// Span returns the decode time of the first sample of seg in the first
+// track and the duration of its samples, both in the track timescale.
+func (m *Movie) Span(seg Segment) (start, duration uint64) {
+	track := m.Tracks[0]
+	samples := track.Samples[seg.ranges[0][0]:seg.ranges[0][1]]
+	if len(samples) == 0 {
+		return 0, 0
+	}
+	last := samples[len(samples)-1]
+	return samples[0].DecodeTime, last.DecodeTime + uint64(last.Duration) - samples[0].DecodeTime
+}
+
+// InitSegment returns the fragmented MP4 init segment: the track
+// descriptions without any samples.
+func (m *Movie) InitSegment() []byte {
+	ftyp := appendBox(nil, "ftyp", []byte("iso6"), u32(0), []byte("iso6mp41"))
+
+	var maxID uint32
+	var traks, trexes []byte
+	for _, track := range m.Tracks {
+		if track.ID > maxID {
+			maxID = track.ID
+		}
+
+		// An empty sample table; samples are described by the fragments
+		empty := make([]byte, 8)
+		stbl := appendBox(nil, "stbl",
+			track.stsd,
+			appendBox(nil, "stts", empty),
+			appendBox(nil, "stsc", empty),
+			appendBox(nil, "stsz", make([]byte, 12)),
+			appendBox(nil, "stco", empty),
+		)
+		minf := appendBox(nil, "minf", append(concat(track.minfHeaders...), stbl...))
+		mdia := appendBox(nil, "mdia", track.mdhd, track.hdlr, minf)
+		traks = appendBox(traks, "trak", track.tkhd, mdia)
+
+		// Default sample description index 1, other defaults unused
+		trexes = appendBox(trexes, "trex", u32(0), u32(track.ID), u32(1), u32(0), u32(0), u32(0))
+	}
+
+	mvhd := appendBox(nil, "mvhd",
+		make([]byte, 12), // version and flags, creation and modification times
+		u32(m.Timescale),
+		u32(0),          // duration, given by the fragments
+		u32(0x00010000), // rate 1.0
+		[]byte{0x01, 0x00},
+		make([]byte, 10),
+		unityMatrix,
+		make([]byte, 24),
+		u32(maxID+1), // next_track_ID
+	)
+	moov := appendBox(nil, "moov", mvhd, traks, appendBox(nil, "mvex", trexes))
+	return append(ftyp, moov...)
+}
+
+// Fragment returns the moof+mdat media segment for seg, reading the samples
+// from r. sequence numbers the fragment, starting at 1.
+func (m *Movie) Fragment(r io.ReadSeeker, seg Segment, sequence uint32) ([]byte, error) {
+	var trafs []byte
+	var dataOffsetPos []int // positions of trun data_offset fields in trafs
+	var dataSizes []int64
+	for t, track := range m.Tracks {
+		first, end := seg.ranges[t][0], seg.ranges[t][1]
+		if first == end {
+			continue
+		}
+		samples := track.Samples[first:end]
+
+		tfhd := appendBox(nil, "tfhd", u32(tfhdDefaultBaseIsMoof), u32(track.ID))
+		tfdt := appendBox(nil, "tfdt", u32(1<<24), u64(samples[0].DecodeTime))
+
+		// Version 1 trun: composition offsets are signed
+		trun := make([]byte, 0, 12+16*len(samples))
+		trun = append(trun, u32(1<<24|trunFlags)...)
+		trun = append(trun, u32(uint32(len(samples)))...)
+		trun = append(trun, u32(0)...) // data_offset, patched below
+		var size int64
+		for _, sample := range samples {
+			flags := uint32(sampleFlagsSync)
+			if track.IsVideo() && !sample.Sync {
+				flags = sampleFlagsNonSync
+			}
+			trun = append(trun, u32(sample.Duration)...)
+			trun = append(trun, u32(sample.Size)...)
+			trun = append(trun, u32(flags)...)
+			trun = append(trun, u32(uint32(sample.CompositionOffset))...)
+			size += int64(sample.Size)
+		}
+
+		// Offset of data_offset: traf header, tfhd, tfdt, trun header,
+		// version/flags and sample_count
+		dataOffsetPos = append(dataOffsetPos, len(trafs)+8+len(tfhd)+len(tfdt)+8+8)
+		dataSizes = append(dataSizes, size)
+		trafs = appendBox(trafs, "traf", tfhd, tfdt, appendBox(nil, "trun", trun))
+	}
+
+	mfhd := appendBox(nil, "mfhd", u32(0), u32(sequence))
+	moof := appendBox(nil, "moof", mfhd, trafs)
+
+	// Point each track run at its data, laid out track after track in mdat
+	trafsStart := 8 + len(mfhd)
+	dataOffset := int64(len(moof)) + 8
+	var mdatSize int64
+	for i, pos := range dataOffsetPos {
+		if dataOffset > 1<<31-1 {
+			return nil, fmt.Errorf("%w: fragment too large", ErrUnsupportedMedia)
+		}
+		binary.BigEndian.PutUint32(moof[trafsStart+pos:], uint32(dataOffset))
+		dataOffset += dataSizes[i]
+		mdatSize += dataSizes[i]
+	}
+	if mdatSize+8 > 1<<32-1 {
+		return nil, fmt.Errorf("%w: fragment too large", ErrUnsupportedMedia)
+	}
+
+	out := make([]byte, 0, int64(len(moof))+8+mdatSize)
+	out = append(out, moof...)
+	out = append(out, u32(uint32(mdatSize+8))...)
+	out = append(out, "mdat"...)
+	for t, track := range m.Tracks {
+		for _, sample := range track.Samples[seg.ranges[t][0]:seg.ranges[t][1]] {
+			start := len(out)
+			out = out[:start+int(sample.Size)]
+			if err := readAt(r, sample.Offset, out[start:]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// appendBox appends a box made of the concatenated payload parts to b.
+func appendBox(b []byte, typ string, payload ...[]byte) []byte {
+	size := 8
+	for _, p := range payload {
+		size += len(p)
+	}
+	b = append(b, u32(uint32(size))...)
+	b = append(b, typ...)
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func concat(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func u32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+func u64(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
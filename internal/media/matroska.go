@@ -0,0 +1,379 @@
+package media
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Matroska element IDs
+const (
+	idEBML     = 0x1A45DFA3
+	idDocType  = 0x4282
+	idSegment  = 0x18538067
+	idInfo     = 0x1549A966
+	idTracks   = 0x1654AE6B
+	idVoid     = 0xEC
+	maxDocType = 32
+
+	idTimecodeScale = 0x2AD7B1
+	idDuration      = 0x4489
+	idTrackEntry    = 0xAE
+	idTrackType     = 0x83
+	idCodecID       = 0x86
+	idVideo         = 0xE0
+	idPixelWidth    = 0xB0
+	idPixelHeight   = 0xBA
+
+	trackTypeVideo = 1
+	trackTypeAudio = 2
+)
+
+// unknownSize marks an element whose size is not coded, which Matroska
+// allows for live-written Segments and Clusters.
+const unknownSize = -1
+
+type ebmlElement struct {
+	id        uint32
+	size      int64 // data size, or unknownSize
+	headerLen int64
+}
+
+// validateMatroska checks the EBML header of a Matroska or WebM file and the
+// top-level elements of its Segment, which must include Info and Tracks.
+func validateMatroska(r io.ReadSeeker, size int64) error {
+	start, end, err := findSegment(r, size)
+	if err != nil {
+		return err
+	}
+
+	sawInfo, sawTracks := false, false
+	err = walkSegment(r, start, end, func(id uint32, dataStart, dataEnd int64) bool {
+		switch id {
+		case idInfo:
+			sawInfo = true
+		case idTracks:
+			sawTracks = true
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	if !sawInfo {
+		return invalidf("Segment has no Info element")
+	}
+	if !sawTracks {
+		return invalidf("Segment has no Tracks element")
+	}
+	return nil
+}
+
+// findSegment checks the EBML header and returns where the data of the
+// Segment that follows it starts and ends.
+func findSegment(r io.ReadSeeker, size int64) (int64, int64, error) {
+	header, err := readElementHeader(r, 0, size)
+	if err != nil {
+		return 0, 0, err
+	}
+	if header.id != idEBML || header.size == unknownSize {
+		return 0, 0, invalidf("missing EBML header")
+	}
+	headerEnd := header.headerLen + header.size
+
+	docType, err := readDocType(r, header.headerLen, headerEnd)
+	if err != nil {
+		return 0, 0, err
+	}
+	if docType != "matroska" && docType != "webm" {
+		return 0, 0, invalidf("unsupported EBML document type %q", docType)
+	}
+
+	// Skip any Void elements before the Segment
+	off := headerEnd
+	var segment ebmlElement
+	for {
+		segment, err = readElementHeader(r, off, size)
+		if err != nil {
+			return 0, 0, err
+		}
+		if segment.id != idVoid || segment.size == unknownSize {
+			break
+		}
+		off += segment.headerLen + segment.size
+	}
+	if segment.id != idSegment {
+		return 0, 0, invalidf("missing Segment element")
+	}
+
+	start := off + segment.headerLen
+	if segment.size == unknownSize {
+		return start, size, nil
+	}
+	return start, start + segment.size, nil
+}
+
+// walkSegment calls fn with the ID and data bounds of each top-level element
+// of the Segment, until fn returns false. It stops at an element of unknown
+// size (a live-written Cluster), whose end can only be found by parsing its
+// contents.
+func walkSegment(r io.ReadSeeker, start, end int64, fn func(id uint32, dataStart, dataEnd int64) bool) error {
+	for off := start; off < end; {
+		element, err := readElementHeader(r, off, end)
+		if err != nil {
+			return err
+		}
+		dataStart := off + element.headerLen
+		if element.size == unknownSize {
+			fn(element.id, dataStart, end)
+			return nil
+		}
+		if !fn(element.id, dataStart, dataStart+element.size) {
+			return nil
+		}
+		off = dataStart + element.size
+	}
+	return nil
+}
+
+// readDocType finds the DocType among the EBML header's children.
+func readDocType(r io.ReadSeeker, off, end int64) (string, error) {
+	for off < end {
+		element, err := readElementHeader(r, off, end)
+		if err != nil {
+			return "", err
+		}
+		if element.size == unknownSize {
+			return "", invalidf("EBML header element with unknown size")
+		}
+		if element.id == idDocType {
+			if element.size > maxDocType {
+				return "", invalidf("DocType too long")
+			}
+			return readStringElement(r, off+element.headerLen, element.size)
+		}
+		off += element.headerLen + element.size
+	}
+	return "", invalidf("EBML header has no DocType")
+}
+
+// readElementHeader reads the ID and data size of the element at off, which
+// must end by end unless its size is unknown.
+func readElementHeader(r io.ReadSeeker, off, end int64) (ebmlElement, error) {
+	var buf [12]byte
+	n := int64(len(buf))
+	if end-off < n {
+		n = end - off
+	}
+	if n < 2 {
+		return ebmlElement{}, invalidf("truncated element header at offset %d", off)
+	}
+	if err := readAt(r, off, buf[:n]); err != nil {
+		return ebmlElement{}, err
+	}
+
+	idLen := vintLength(buf[0])
+	if idLen == 0 || idLen > 4 || int64(idLen) >= n {
+		return ebmlElement{}, invalidf("invalid element ID at offset %d", off)
+	}
+	var id uint32
+	for _, b := range buf[:idLen] {
+		id = id<<8 | uint32(b)
+	}
+
+	sizeLen := vintLength(buf[idLen])
+	if sizeLen == 0 || int64(idLen+sizeLen) > n {
+		return ebmlElement{}, invalidf("invalid element size at offset %d", off)
+	}
+	// The length marker bit is not part of the value
+	value := int64(buf[idLen] & (0xFF >> sizeLen))
+	allOnes := value == int64(0xFF>>sizeLen)
+	for _, b := range buf[idLen+1 : idLen+sizeLen] {
+		value = value<<8 | int64(b)
+		allOnes = allOnes && b == 0xFF
+	}
+
+	element := ebmlElement{id: id, size: value, headerLen: int64(idLen + sizeLen)}
+	if allOnes {
+		element.size = unknownSize
+	} else if element.size > end-off-element.headerLen {
+		return ebmlElement{}, invalidf("element 0x%X at offset %d overruns its parent", id, off)
+	}
+	return element, nil
+}
+
+// vintLength returns the length of an EBML variable-size integer from its
+// first byte, or 0 if the byte is invalid.
+func vintLength(first byte) int {
+	for i := 0; i < 8; i++ {
+		if first&(0x80>>i) != 0 {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// probeMatroska reads the duration from the Segment Info and the codec and
+// dimensions of the first video and audio tracks.
+func probeMatroska(r io.ReadSeeker, size int64, meta *Metadata) error {
+	start, end, err := findSegment(r, size)
+	if err != nil {
+		return err
+	}
+
+	var info, tracks [2]int64
+	err = walkSegment(r, start, end, func(id uint32, dataStart, dataEnd int64) bool {
+		switch id {
+		case idInfo:
+			info = [2]int64{dataStart, dataEnd}
+		case idTracks:
+			tracks = [2]int64{dataStart, dataEnd}
+		}
+		return info[1] == 0 || tracks[1] == 0
+	})
+	if err != nil {
+		return err
+	}
+
+	if info[1] != 0 {
+		if err := probeMatroskaInfo(r, info[0], info[1], meta); err != nil {
+			return err
+		}
+	}
+	if tracks[1] != 0 {
+		return walkElements(r, tracks[0], tracks[1], func(element ebmlElement, dataStart int64) error {
+			if element.id == idTrackEntry {
+				return probeMatroskaTrack(r, dataStart, dataStart+element.size, meta)
+			}
+			return nil
+		})
+	}
+	return nil
+}
+
+func probeMatroskaInfo(r io.ReadSeeker, start, end int64, meta *Metadata) error {
+	// Durations are in units of TimecodeScale nanoseconds
+	timecodeScale := uint64(1000000)
+	var duration float64
+	err := walkElements(r, start, end, func(element ebmlElement, dataStart int64) error {
+		var err error
+		switch element.id {
+		case idTimecodeScale:
+			timecodeScale, err = readUintElement(r, dataStart, element.size)
+		case idDuration:
+			duration, err = readFloatElement(r, dataStart, element.size)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	meta.Duration = duration * float64(timecodeScale) / 1e9
+	return nil
+}
+
+func probeMatroskaTrack(r io.ReadSeeker, start, end int64, meta *Metadata) error {
+	var trackType uint64
+	var codecID string
+	var width, height uint64
+	err := walkElements(r, start, end, func(element ebmlElement, dataStart int64) error {
+		var err error
+		switch element.id {
+		case idTrackType:
+			trackType, err = readUintElement(r, dataStart, element.size)
+		case idCodecID:
+			codecID, err = readStringElement(r, dataStart, element.size)
+		case idVideo:
+			err = walkElements(r, dataStart, dataStart+element.size, func(video ebmlElement, videoStart int64) error {
+				var err error
+				switch video.id {
+				case idPixelWidth:
+					width, err = readUintElement(r, videoStart, video.size)
+				case idPixelHeight:
+					height, err = readUintElement(r, videoStart, video.size)
+				}
+				return err
+			})
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case trackType == trackTypeVideo && meta.VideoCodec == "":
+		meta.VideoCodec = codecName(codecID)
+		meta.Width, meta.Height = int(width), int(height)
+	case trackType == trackTypeAudio && meta.AudioCodec == "":
+		meta.AudioCodec = codecName(codecID)
+	}
+	return nil
+}
+
+// walkElements calls fn for each child element between start and end.
+func walkElements(r io.ReadSeeker, start, end int64, fn func(element ebmlElement, dataStart int64) error) error {
+	for off := start; off < end; {
+		element, err := readElementHeader(r, off, end)
+		if err != nil {
+			return err
+		}
+		if element.size == unknownSize {
+			return invalidf("element 0x%X at offset %d has unknown size", element.id, off)
+		}
+		if err := fn(element, off+element.headerLen); err != nil {
+			return err
+		}
+		off += element.headerLen + element.size
+	}
+	return nil
+}
+
+func readUintElement(r io.ReadSeeker, off, size int64) (uint64, error) {
+	if size > 8 {
+		return 0, invalidf("integer element at offset %d too long", off)
+	}
+	var buf [8]byte
+	if err := readAt(r, off, buf[8-size:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+func readFloatElement(r io.ReadSeeker, off, size int64) (float64, error) {
+	var buf [8]byte
+	switch size {
+	case 0:
+		return 0, nil
+	case 4:
+		if err := readAt(r, off, buf[:4]); err != nil {
+			return 0, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(buf[:4]))), nil
+	case 8:
+		if err := readAt(r, off, buf[:]); err != nil {
+			return 0, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(buf[:])), nil
+	}
+	return 0, invalidf("float element at offset %d has invalid size %d", off, size)
+}
+
+// maxStringElement bounds the strings read, such as codec IDs.
+const maxStringElement = 256
+
+func readStringElement(r io.ReadSeeker, off, size int64) (string, error) {
+	if size > maxStringElement {
+		return "", invalidf("string element at offset %d too long", off)
+	}
+	value := make([]byte, size)
+	if err := readAt(r, off, value); err != nil {
+		return "", err
+	}
+	// Strings may be padded with zero bytes
+	for len(value) > 0 && value[len(value)-1] == 0 {
+		value = value[:len(value)-1]
+	}
+	return string(value), nil
+}
@@ -0,0 +1,132 @@
+// Package media recognises the video containers accepted for upload (ISO
+// BMFF as used by MP4 and QuickTime, Matroska/WebM and AVI) and checks that
+// their structure is intact, using only the container headers.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Containers
+const (
+	ContainerBMFF     = "bmff"
+	ContainerMatroska = "matroska"
+	ContainerAVI      = "avi"
+)
+
+// SniffLen is the number of leading bytes Sniff looks at.
+const SniffLen = 64
+
+// ErrInvalidMedia is returned (wrapped) for files that are not a supported
+// video container or whose container structure is damaged.
+var ErrInvalidMedia = errors.New("invalid media file")
+
+// Format identifies a container and the MIME type it is served with.
+type Format struct {
+	Container string
+	MIMEType  string
+}
+
+// extContainers lists the container expected for each upload extension.
+var extContainers = map[string]string{
+	".mp4": ContainerBMFF,
+	".mov": ContainerBMFF,
+	".mkv": ContainerMatroska,
+	".avi": ContainerAVI,
+}
+
+// extMIMETypes is used for videos stored before content sniffing.
+var extMIMETypes = map[string]string{
+	".mp4": "video/mp4",
+	".mov": "video/quicktime",
+	".mkv": "video/x-matroska",
+	".avi": "video/x-msvideo",
+}
+
+// MatchesExt reports whether a file with this format may carry the given
+// (lower case) extension.
+func (f Format) MatchesExt(ext string) bool {
+	return extContainers[ext] == f.Container
+}
+
+// MIMETypeForExt guesses a MIME type from a file extension, defaulting to
+// video/mp4.
+func MIMETypeForExt(ext string) string {
+	if mimeType, ok := extMIMETypes[ext]; ok {
+		return mimeType
+	}
+	return "video/mp4"
+}
+
+// Sniff identifies the container from the first bytes of a file (up to
+// SniffLen).
+func Sniff(header []byte) (Format, error) {
+	switch {
+	case len(header) >= 12 && string(header[4:8]) == "ftyp":
+		// The major brand tells QuickTime movies apart from MP4
+		if string(header[8:12]) == "qt  " {
+			return Format{ContainerBMFF, "video/quicktime"}, nil
+		}
+		return Format{ContainerBMFF, "video/mp4"}, nil
+
+	case len(header) >= 8 && isQuickTimeAtom(header[4:8]):
+		// Older QuickTime files have no ftyp box
+		return Format{ContainerBMFF, "video/quicktime"}, nil
+
+	case bytes.HasPrefix(header, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		if bytes.Contains(header, []byte("webm")) {
+			return Format{ContainerMatroska, "video/webm"}, nil
+		}
+		return Format{ContainerMatroska, "video/x-matroska"}, nil
+
+	case len(header) >= 12 && string(header[0:4]) == "RIFF" && string(header[8:12]) == "AVI ":
+		return Format{ContainerAVI, "video/x-msvideo"}, nil
+	}
+	return Format{}, fmt.Errorf("%w: not a recognised video container", ErrInvalidMedia)
+}
+
+func isQuickTimeAtom(atomType []byte) bool {
+	switch string(atomType) {
+	case "moov", "mdat", "wide", "free", "skip":
+		return true
+	}
+	return false
+}
+
+// Validate walks the container structure of a file of the given size and
+// returns an error wrapping ErrInvalidMedia if it is truncated, malformed or
+// lacks the parts a playable video needs. Errors reading r are returned as
+// is.
+func Validate(r io.ReadSeeker, size int64, format Format) error {
+	switch format.Container {
+	case ContainerBMFF:
+		return validateBMFF(r, size)
+	case ContainerMatroska:
+		return validateMatroska(r, size)
+	case ContainerAVI:
+		return validateAVI(r, size)
+	}
+	return fmt.Errorf("%w: unsupported container %q", ErrInvalidMedia, format.Container)
+}
+
+func invalidf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{ErrInvalidMedia}, args...)...)
+}
+
+// readAt fills p from offset off. Running into the end of the file is
+// reported as invalid media.
+func readAt(r io.ReadSeeker, off int64, p []byte) error {
+	if _, err := r.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(r, p); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return invalidf("file truncated at offset %d", off)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package media
+
+import (
+	"io"
+	"strings"
+)
+
+// Metadata holds the technical properties of a video read from its
+// container headers. Fields the container does not declare are left zero.
+type Metadata struct {
+	Duration   float64 // seconds
+	Width      int
+	Height     int
+	VideoCodec string
+	AudioCodec string
+	Bitrate    int64 // average bits per second over the whole file
+	FileSize   int64
+}
+
+// Probe reads the metadata of a file of the given size. The file should have
+// passed Validate.
+func Probe(r io.ReadSeeker, size int64, format Format) (*Metadata, error) {
+	meta := &Metadata{FileSize: size}
+
+	var err error
+	switch format.Container {
+	case ContainerBMFF:
+		err = probeBMFF(r, size, meta)
+	case ContainerMatroska:
+		err = probeMatroska(r, size, meta)
+	case ContainerAVI:
+		err = probeAVI(r, size, meta)
+	default:
+		err = invalidf("unsupported container %q", format.Container)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if meta.Duration > 0 {
+		meta.Bitrate = int64(float64(size*8) / meta.Duration)
+	}
+	return meta, nil
+}
+
+// codecNames maps container codec identifiers (MP4 sample entry types,
+// Matroska codec IDs and AVI FourCCs) to common short names.
+var codecNames = map[string]string{
+	// ISO BMFF sample entries
+	"avc1": "h264",
+	"avc3": "h264",
+	"hvc1": "hevc",
+	"hev1": "hevc",
+	"av01": "av1",
+	"vp08": "vp8",
+	"vp09": "vp9",
+	"mp4v": "mpeg4",
+	"mp4a": "aac",
+	"opus": "opus",
+	"ac-3": "ac3",
+	"ec-3": "eac3",
+	"flac": "flac",
+	".mp3": "mp3",
+
+	// Matroska codec IDs
+	"V_MPEG4/ISO/AVC":  "h264",
+	"V_MPEGH/ISO/HEVC": "hevc",
+	"V_AV1":            "av1",
+	"V_VP8":            "vp8",
+	"V_VP9":            "vp9",
+	"V_MPEG4/ISO/ASP":  "mpeg4",
+	"A_AAC":            "aac",
+	"A_OPUS":           "opus",
+	"A_VORBIS":         "vorbis",
+	"A_AC3":            "ac3",
+	"A_EAC3":           "eac3",
+	"A_MPEG/L3":        "mp3",
+	"A_FLAC":           "flac",
+
+	// AVI FourCCs
+	"h264": "h264",
+	"x264": "h264",
+	"hevc": "hevc",
+	"h265": "hevc",
+	"xvid": "mpeg4",
+	"divx": "mpeg4",
+	"dx50": "mpeg4",
+	"fmp4": "mpeg4",
+	"mjpg": "mjpeg",
+}
+
+// codecName normalises a codec identifier, falling back to the identifier
+// itself in lower case.
+func codecName(id string) string {
+	id = strings.TrimRight(id, " \x00")
+	if name, ok := codecNames[id]; ok {
+		return name
+	}
+	if name, ok := codecNames[strings.ToLower(id)]; ok {
+		return name
+	}
+	// Matroska AAC IDs carry the profile, e.g. A_AAC/MPEG4/LC
+	if strings.HasPrefix(id, "A_AAC") {
+		return "aac"
+	}
+	return strings.ToLower(id)
+}
@@ -0,0 +1,482 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrUnsupportedMedia is returned (wrapped) for valid files that use a layout
+// the remuxer does not handle, such as already fragmented MP4.
+var ErrUnsupportedMedia = errors.New("unsupported media layout")
+
+// maxTableSize bounds the sample tables read into memory.
+const maxTableSize = 256 << 20
+
+// Movie is the sample-level structure of an ISO BMFF file.
+type Movie struct {
+	Timescale uint32
+	Tracks    []*Track
+}
+
+// Track is a video or audio track with its sample table. The raw header
+// boxes are kept to describe the track in a fragmented init segment.
+type Track struct {
+	ID        uint32
+	Handler   string // "vide" or "soun"
+	Timescale uint32
+	Codec     string // sample entry type, e.g. avc1
+	Samples   []Sample
+
+	tkhd, mdhd, hdlr, stsd []byte
+	minfHeaders            [][]byte // minf children other than stbl
+}
+
+// Sample is one access unit of a track.
+type Sample struct {
+	Offset            int64
+	Size              uint32
+	DecodeTime        uint64 // in the track timescale
+	Duration          uint32
+	CompositionOffset int32
+	Sync              bool
+}
+
+// IsVideo reports whether the track is a video track.
+func (t *Track) IsVideo() bool {
+	return t.Handler == "vide"
+}
+
+// Dimensions returns the display size of a video track from its track
+// header, falling back to the coded size in its sample entry.
+func (t *Track) Dimensions() (int, int) {
+	if n := len(t.tkhd); n >= 16 {
+		width := int(binary.BigEndian.Uint32(t.tkhd[n-8:]) >> 16)
+		height := int(binary.BigEndian.Uint32(t.tkhd[n-4:]) >> 16)
+		if width > 0 && height > 0 {
+			return width, height
+		}
+	}
+	entry := t.SampleEntry()
+	if len(entry) < 8+28 {
+		return 0, 0
+	}
+	return int(binary.BigEndian.Uint16(entry[8+24:])), int(binary.BigEndian.Uint16(entry[8+26:]))
+}
+
+// SampleEntry returns the raw sample description of the track (the first
+// entry of its stsd box), from which codec parameters can be read.
+func (t *Track) SampleEntry() []byte {
+	// fullbox header, version/flags and entry count precede the entry
+	if len(t.stsd) < 16 {
+		return nil
+	}
+	return t.stsd[16:]
+}
+
+// ReadMovie reads the tracks and sample tables of a progressive (not
+// fragmented) ISO BMFF file that passed Validate. Tracks other than video
+// and audio are skipped.
+func ReadMovie(r io.ReadSeeker, size int64) (*Movie, error) {
+	moov, err := findBox(r, 0, size, "moov")
+	if err != nil {
+		return nil, err
+	}
+
+	movie := &Movie{}
+	err = walkBoxes(r, moov.start, moov.end, func(box boxRange) error {
+		switch box.typ {
+		case "mvhd":
+			timescale, _, err := readMediaTimes(r, box)
+			if err != nil {
+				return err
+			}
+			movie.Timescale = timescale
+		case "mvex":
+			return fmt.Errorf("%w: file is already fragmented", ErrUnsupportedMedia)
+		case "trak":
+			track, err := readTrack(r, box, size)
+			if err != nil {
+				return err
+			}
+			if track != nil {
+				movie.Tracks = append(movie.Tracks, track)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if movie.Timescale == 0 {
+		return nil, invalidf("movie has no timescale")
+	}
+	if len(movie.Tracks) == 0 {
+		return nil, fmt.Errorf("%w: no audio or video tracks", ErrUnsupportedMedia)
+	}
+	return movie, nil
+}
+
+// readTrack reads a trak box, returning nil for tracks that are neither
+// video nor audio.
+func readTrack(r io.ReadSeeker, trak boxRange, fileSize int64) (*Track, error) {
+	track := &Track{}
+
+	mdia, err := findBox(r, trak.start, trak.end, "mdia")
+	if err != nil {
+		return nil, err
+	}
+	hdlrBox, err := findBox(r, mdia.start, mdia.end, "hdlr")
+	if err != nil {
+		return nil, err
+	}
+	if track.hdlr, err = readRawBox(r, hdlrBox); err != nil {
+		return nil, err
+	}
+	if len(track.hdlr) < 20 {
+		return nil, invalidf("hdlr box too small")
+	}
+	track.Handler = string(track.hdlr[16:20])
+	if track.Handler != "vide" && track.Handler != "soun" {
+		return nil, nil
+	}
+
+	tkhdBox, err := findBox(r, trak.start, trak.end, "tkhd")
+	if err != nil {
+		return nil, err
+	}
+	if track.tkhd, err = readRawBox(r, tkhdBox); err != nil {
+		return nil, err
+	}
+	// The track ID follows the creation and modification times
+	idOffset := 8 + 12
+	if len(track.tkhd) > 8 && track.tkhd[8] == 1 {
+		idOffset = 8 + 20
+	}
+	if len(track.tkhd) < idOffset+4 {
+		return nil, invalidf("tkhd box too small")
+	}
+	track.ID = binary.BigEndian.Uint32(track.tkhd[idOffset:])
+
+	mdhdBox, err := findBox(r, mdia.start, mdia.end, "mdhd")
+	if err != nil {
+		return nil, err
+	}
+	if track.mdhd, err = readRawBox(r, mdhdBox); err != nil {
+		return nil, err
+	}
+	if track.Timescale, _, err = readMediaTimes(r, mdhdBox); err != nil {
+		return nil, err
+	}
+	if track.Timescale == 0 {
+		return nil, invalidf("track %d has no timescale", track.ID)
+	}
+
+	minf, err := findBox(r, mdia.start, mdia.end, "minf")
+	if err != nil {
+		return nil, err
+	}
+	var stbl boxRange
+	err = walkBoxes(r, minf.start, minf.end, func(box boxRange) error {
+		if box.typ == "stbl" {
+			stbl = box
+			return nil
+		}
+		raw, err := readRawBox(r, box)
+		if err != nil {
+			return err
+		}
+		track.minfHeaders = append(track.minfHeaders, raw)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if stbl.typ == "" {
+		return nil, invalidf("track %d has no sample table", track.ID)
+	}
+
+	if err := readSampleTable(r, stbl, track, fileSize); err != nil {
+		return nil, err
+	}
+	return track, nil
+}
+
+// readSampleTable resolves the offset, size, timing and sync flag of every
+// sample from the stbl boxes.
+func readSampleTable(r io.ReadSeeker, stbl boxRange, track *Track, fileSize int64) error {
+	tables := make(map[string][]byte)
+	err := walkBoxes(r, stbl.start, stbl.end, func(box boxRange) error {
+		switch box.typ {
+		case "stsd":
+			raw, err := readRawBox(r, box)
+			if err != nil {
+				return err
+			}
+			track.stsd = raw
+		case "stts", "ctts", "stsc", "stsz", "stz2", "stco", "co64", "stss":
+			payload, err := readPayload(r, box)
+			if err != nil {
+				return err
+			}
+			tables[box.typ] = payload
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	entry := track.SampleEntry()
+	if len(entry) < 8 {
+		return invalidf("track %d has no sample description", track.ID)
+	}
+	track.Codec = string(entry[4:8])
+
+	sizes, err := sampleSizes(tables)
+	if err != nil {
+		return err
+	}
+	offsets, err := sampleOffsets(tables, sizes)
+	if err != nil {
+		return err
+	}
+
+	samples := make([]Sample, len(sizes))
+	for i := range samples {
+		if offsets[i]+int64(sizes[i]) > fileSize {
+			return invalidf("track %d sample %d lies outside the file", track.ID, i+1)
+		}
+		samples[i] = Sample{Offset: offsets[i], Size: sizes[i], Sync: true}
+	}
+
+	// Decoding times
+	stts := tables["stts"]
+	if stts == nil {
+		return invalidf("track %d has no stts box", track.ID)
+	}
+	var decodeTime uint64
+	i := 0
+	err = readEntries(stts, 8, func(e []byte) error {
+		count, delta := binary.BigEndian.Uint32(e[0:4]), binary.BigEndian.Uint32(e[4:8])
+		for ; count > 0 && i < len(samples); count-- {
+			samples[i].DecodeTime = decodeTime
+			samples[i].Duration = delta
+			decodeTime += uint64(delta)
+			i++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if i != len(samples) {
+		return invalidf("track %d stts covers %d of %d samples", track.ID, i, len(samples))
+	}
+
+	// Composition offsets (version 1 makes them signed)
+	if ctts := tables["ctts"]; ctts != nil {
+		i = 0
+		err = readEntries(ctts, 8, func(e []byte) error {
+			count, offset := binary.BigEndian.Uint32(e[0:4]), int32(binary.BigEndian.Uint32(e[4:8]))
+			for ; count > 0 && i < len(samples); count-- {
+				samples[i].CompositionOffset = offset
+				i++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Without stss every sample is a sync sample
+	if stss := tables["stss"]; stss != nil {
+		for i := range samples {
+			samples[i].Sync = false
+		}
+		err = readEntries(stss, 4, func(e []byte) error {
+			n := binary.BigEndian.Uint32(e)
+			if n == 0 || int(n) > len(samples) {
+				return invalidf("track %d stss refers to sample %d", track.ID, n)
+			}
+			samples[n-1].Sync = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	track.Samples = samples
+	return nil
+}
+
+// sampleSizes reads the stsz or stz2 box.
+func sampleSizes(tables map[string][]byte) ([]uint32, error) {
+	if stsz := tables["stsz"]; stsz != nil {
+		if len(stsz) < 12 {
+			return nil, invalidf("stsz box too small")
+		}
+		fixed := binary.BigEndian.Uint32(stsz[4:8])
+		count := int(binary.BigEndian.Uint32(stsz[8:12]))
+		if fixed == 0 && len(stsz)-12 < count*4 {
+			return nil, invalidf("stsz box too small for %d samples", count)
+		}
+		if fixed != 0 && count > maxTableSize/4 {
+			return nil, invalidf("stsz declares too many samples")
+		}
+
+		sizes := make([]uint32, count)
+		for i := range sizes {
+			if fixed != 0 {
+				sizes[i] = fixed
+			} else {
+				sizes[i] = binary.BigEndian.Uint32(stsz[12+i*4:])
+			}
+		}
+		return sizes, nil
+	}
+
+	if stz2 := tables["stz2"]; stz2 != nil {
+		if len(stz2) < 12 {
+			return nil, invalidf("stz2 box too small")
+		}
+		fieldSize := int(stz2[7])
+		count := int(binary.BigEndian.Uint32(stz2[8:12]))
+		if fieldSize != 4 && fieldSize != 8 && fieldSize != 16 {
+			return nil, invalidf("stz2 box has invalid field size %d", fieldSize)
+		}
+		if len(stz2)-12 < (count*fieldSize+7)/8 {
+			return nil, invalidf("stz2 box too small for %d samples", count)
+		}
+
+		sizes := make([]uint32, count)
+		data := stz2[12:]
+		for i := range sizes {
+			switch fieldSize {
+			case 4:
+				b := data[i/2]
+				if i%2 == 0 {
+					sizes[i] = uint32(b >> 4)
+				} else {
+					sizes[i] = uint32(b & 0x0F)
+				}
+			case 8:
+				sizes[i] = uint32(data[i])
+			case 16:
+				sizes[i] = uint32(binary.BigEndian.Uint16(data[i*2:]))
+			}
+		}
+		return sizes, nil
+	}
+
+	return nil, invalidf("no stsz box")
+}
+
+// sampleOffsets places the samples in their chunks using the stsc and stco
+// or co64 boxes.
+func sampleOffsets(tables map[string][]byte, sizes []uint32) ([]int64, error) {
+	count := len(sizes)
+	var chunkOffsets []int64
+	if stco := tables["stco"]; stco != nil {
+		err := readEntries(stco, 4, func(e []byte) error {
+			chunkOffsets = append(chunkOffsets, int64(binary.BigEndian.Uint32(e)))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else if co64 := tables["co64"]; co64 != nil {
+		err := readEntries(co64, 8, func(e []byte) error {
+			chunkOffsets = append(chunkOffsets, int64(binary.BigEndian.Uint64(e)))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, invalidf("no stco box")
+	}
+
+	type stscEntry struct{ firstChunk, samplesPerChunk uint32 }
+	var runs []stscEntry
+	stsc := tables["stsc"]
+	if stsc == nil {
+		return nil, invalidf("no stsc box")
+	}
+	err := readEntries(stsc, 12, func(e []byte) error {
+		if binary.BigEndian.Uint32(e[8:12]) != 1 {
+			return fmt.Errorf("%w: multiple sample descriptions in one track", ErrUnsupportedMedia)
+		}
+		runs = append(runs, stscEntry{binary.BigEndian.Uint32(e[0:4]), binary.BigEndian.Uint32(e[4:8])})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make([]int64, 0, count)
+	for run := range runs {
+		lastChunk := uint32(len(chunkOffsets))
+		if run+1 < len(runs) {
+			lastChunk = runs[run+1].firstChunk - 1
+		}
+		for chunk := runs[run].firstChunk; chunk <= lastChunk && len(offsets) < count; chunk++ {
+			if chunk == 0 || int(chunk) > len(chunkOffsets) {
+				return nil, invalidf("stsc refers to chunk %d", chunk)
+			}
+			offset := chunkOffsets[chunk-1]
+			for s := uint32(0); s < runs[run].samplesPerChunk && len(offsets) < count; s++ {
+				offsets = append(offsets, offset)
+				offset += int64(sizes[len(offsets)-1])
+			}
+		}
+	}
+	if len(offsets) != count {
+		return nil, invalidf("chunks hold %d of %d samples", len(offsets), count)
+	}
+	return offsets, nil
+}
+
+// readEntries calls fn for each fixed-size entry of a full box whose payload
+// starts with version/flags and an entry count.
+func readEntries(payload []byte, entrySize int, fn func([]byte) error) error {
+	if len(payload) < 8 {
+		return invalidf("table box too small")
+	}
+	count := int(binary.BigEndian.Uint32(payload[4:8]))
+	if len(payload)-8 < count*entrySize {
+		return invalidf("table box too small for %d entries", count)
+	}
+	for i := 0; i < count; i++ {
+		if err := fn(payload[8+i*entrySize : 8+(i+1)*entrySize]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readPayload reads the payload of a box into memory.
+func readPayload(r io.ReadSeeker, box boxRange) ([]byte, error) {
+	if box.end-box.start > maxTableSize {
+		return nil, fmt.Errorf("%w: %s box too large", ErrUnsupportedMedia, box.typ)
+	}
+	payload := make([]byte, box.end-box.start)
+	if err := readAt(r, box.start, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// readRawBox reads a whole box, header included, into memory. The header is
+// normalised to the 8-byte form.
+func readRawBox(r io.ReadSeeker, box boxRange) ([]byte, error) {
+	payload, err := readPayload(r, box)
+	if err != nil {
+		return nil, err
+	}
+	return appendBox(nil, box.typ, payload), nil
+}
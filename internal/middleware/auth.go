@@ -1,16 +1,21 @@
 package middleware
 
 import (
-	"fmt"
+	"log"
 	"net/http"
-	"os"
 	"strings"
 
+	"secure-video-api/internal/jwtkeys"
+	"secure-video-api/internal/models"
+	"secure-video-api/internal/repository"
+
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware authenticates requests by a bearer access token, checking
+// the user and the token's revocation against users and tokens.
+func AuthMiddleware(users repository.UserRepository, tokens repository.TokenRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -27,12 +32,7 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		tokenString := tokenParts[1]
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
+		token, err := jwtkeys.Keys.Parse(tokenString)
 
 		if err != nil || !token.Valid {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
@@ -47,17 +47,73 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		c.Set("user_id", claims["user_id"])
-		c.Set("is_admin", claims["is_admin"])
+		// Tokens without an ID predate revocation and cannot be revoked
+		userID, _ := claims["user_id"].(string)
+		tokenID, _ := claims["jti"].(string)
+		expires, err := claims.GetExpirationTime()
+		if userID == "" || tokenID == "" || err != nil || expires == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+			c.Abort()
+			return
+		}
+
+		access, ok := checkUser(c, users, tokens, userID, tokenID)
+		if !ok {
+			return
+		}
+
+		c.Set("user_id", userID)
+		c.Set("is_admin", access.IsAdmin)
+		c.Set("access", access)
+		c.Set("token_id", tokenID)
+		c.Set("token_expires", expires.Time)
 		c.Next()
 	}
 }
 
-func AdminMiddleware() gin.HandlerFunc {
+// checkUser looks up the current state of an authenticated user on every
+// request, so that deactivating or deleting a user, changing their roles or
+// revoking the token takes effect immediately; the permissions in the token
+// are only for other services. Requests without a token ID are not checked
+// for revocation. It aborts the request if the user may not proceed.
+func checkUser(c *gin.Context, users repository.UserRepository, tokens repository.TokenRepository, userID, tokenID string) (*models.UserAccess, bool) {
+	access, err := users.Access(userID)
+	if err == repository.ErrNotFound {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		c.Abort()
+		return nil, false
+	}
+	var revoked bool
+	if err == nil && tokenID != "" {
+		revoked, err = tokens.AccessTokenRevoked(tokenID)
+	}
+	if err != nil {
+		log.Printf("[Auth] Error checking user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		c.Abort()
+		return nil, false
+	}
+	if access.Status == models.UserStatusInactive {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Account is deactivated"})
+		c.Abort()
+		return nil, false
+	}
+	if revoked {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+		c.Abort()
+		return nil, false
+	}
+	return access, true
+}
+
+// RequirePermission allows the request only if the authenticated user holds
+// permission through one of their roles.
+func RequirePermission(permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		isAdmin, exists := c.Get("is_admin")
-		if !exists || !isAdmin.(bool) {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		value, _ := c.Get("access")
+		access, ok := value.(*models.UserAccess)
+		if !ok || !access.Can(permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission required: " + permission})
 			c.Abort()
 			return
 		}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"secure-video-api/internal/repository"
+	"secure-video-api/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PlaybackURLMiddleware authenticates requests by a signed playback URL
+// instead of a bearer token, for players that cannot send headers. The
+// signature covers the video in the path, the user, the expiry and, when
+// the URL is IP bound, the client address. The user is checked against
+// users.
+func PlaybackURLMiddleware(users repository.UserRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.Query("uid")
+		signature := c.Query("sig")
+		expires, err := strconv.ParseInt(c.Query("exp"), 10, 64)
+		if userID == "" || signature == "" || err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Playback signature is required"})
+			c.Abort()
+			return
+		}
+
+		clientIP := ""
+		if c.Query("ipb") == "1" {
+			clientIP = c.ClientIP()
+		}
+		if !utils.VerifyPlayback(c.Param("id"), userID, expires, clientIP, signature) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid playback signature"})
+			c.Abort()
+			return
+		}
+		if time.Now().Unix() > expires {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Playback URL has expired"})
+			c.Abort()
+			return
+		}
+
+		access, ok := checkUser(c, users, nil, userID, "")
+		if !ok {
+			return
+		}
+
+		c.Set("user_id", userID)
+		c.Set("is_admin", access.IsAdmin)
+		c.Set("access", access)
+		c.Next()
+	}
+}
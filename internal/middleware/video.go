@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"secure-video-api/internal/models"
+	"secure-video-api/internal/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireVideoAccess allows the request only if the authenticated user can
+// see the video named by the id parameter. Videos the user cannot see are
+// reported as not found, so their existence is not disclosed.
+func RequireVideoAccess(videos repository.VideoRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, _ := c.Get("access")
+		access, _ := value.(*models.UserAccess)
+		visible, err := videos.CanView(c.Param("id"), c.GetString("user_id"), access)
+		if err != nil {
+			log.Printf("[ACL] Error checking access to video %s: %v", c.Param("id"), err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			c.Abort()
+			return
+		}
+		if !visible {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Video visibility levels. The uploader and users with videos:manage can
+// always see a video.
+const (
+	VisibilityPrivate    = "private"    // nobody else
+	VisibilityRestricted = "restricted" // subjects granted access in the video's ACL
+	VisibilityAllUsers   = "all-users"  // every user with videos:view
+)
+
+// ValidVisibility reports whether v is a known visibility level.
+func ValidVisibility(v string) bool {
+	return v == VisibilityPrivate || v == VisibilityRestricted || v == VisibilityAllUsers
+}
+
+// Subjects of video ACL entries
+const (
+	ACLSubjectUser  = "user"
+	ACLSubjectGroup = "group"
+)
+
+// VideoGrant is an ACL entry allowing a subject to view a restricted video.
+type VideoGrant struct {
+	SubjectType string    `json:"subject_type"`
+	SubjectID   string    `json:"subject_id"`
+	GrantedBy   string    `json:"granted_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Group is a named set of users. Members hold the group's roles in addition
+// to their own, and can view videos shared with the group.
+type Group struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Roles       []string  `json:"roles"`
+	MemberCount int       `json:"member_count"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// GroupMember is a user belonging to a group.
+type GroupMember struct {
+	UserID  string    `json:"user_id"`
+	Email   string    `json:"email"`
+	AddedAt time.Time `json:"added_at"`
+}
@@ -0,0 +1,58 @@
+package models
+
+import "sort"
+
+// Permissions checked by RequirePermission
+const (
+	PermVideosView   = "videos:view"   // list and stream videos
+	PermVideosUpload = "videos:upload" // upload videos and follow their processing
+	PermVideosManage = "videos:manage" // edit and delete any video
+	PermUsersManage  = "users:manage"  // list, deactivate and delete users
+	PermRolesAssign  = "roles:assign"  // grant and revoke roles
+	PermAdminsManage = "admins:manage" // create and delete admin accounts
+	PermKeysManage   = "keys:manage"   // rotate the master keys
+	PermGroupsManage = "groups:manage" // create groups and manage their members
+)
+
+// Built-in roles
+const (
+	RoleViewer     = "viewer"
+	RoleUploader   = "uploader"
+	RoleModerator  = "moderator"
+	RoleUserAdmin  = "user-admin"
+	RoleSuperAdmin = "super-admin"
+)
+
+// Role is a named set of permissions.
+type Role struct {
+	ID          string   `json:"id"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// BuiltinRoles are seeded into the database at startup.
+var BuiltinRoles = []Role{
+	{RoleViewer, "Watch videos", []string{PermVideosView}},
+	{RoleUploader, "Upload videos", []string{PermVideosView, PermVideosUpload}},
+	{RoleModerator, "Edit and remove videos", []string{PermVideosView, PermVideosManage}},
+	{RoleUserAdmin, "Manage user accounts", []string{PermVideosView, PermUsersManage, PermRolesAssign, PermGroupsManage}},
+	{RoleSuperAdmin, "Full access", []string{
+		PermVideosView, PermVideosUpload, PermVideosManage,
+		PermUsersManage, PermRolesAssign, PermAdminsManage, PermKeysManage, PermGroupsManage,
+	}},
+}
+
+// UserAccess is what a user is currently allowed to do. Roles and
+// Permissions are sorted.
+type UserAccess struct {
+	IsAdmin     bool
+	Status      string
+	Roles       []string
+	Permissions []string
+}
+
+// Can reports whether the user holds a permission.
+func (a *UserAccess) Can(permission string) bool {
+	i := sort.SearchStrings(a.Permissions, permission)
+	return i < len(a.Permissions) && a.Permissions[i] == permission
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// RefreshToken is a stored refresh token. Only the hash of the token is
+// kept; tokens rotated from one another share a family, which ends as a
+// whole when one of them is reused.
+type RefreshToken struct {
+	ID        string
+	UserID    string
+	FamilyID  string
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Upload is a resumable (tus) upload in progress. Its bytes are staged
+// encrypted under the wrapped key until the whole file has arrived.
+type Upload struct {
+	ID            string
+	Length        int64
+	Offset        int64
+	StagedSize    int64
+	StagedRecords int64
+	Metadata      string
+	WrappedKey    string
+	KeyVersion    int
+	VideoID       string // set once the upload is being turned into a video
+	CreatedBy     string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
@@ -8,6 +8,19 @@ type Video struct {
 	Description string    `json:"description"`
 	FileName    string    `json:"file_name"`
 	UploadedBy  string    `json:"uploaded_by"`
+	WrappedKey  string    `json:"-"`
+	KeyVersion  int       `json:"-"`
+	MIMEType    string    `json:"mime_type,omitempty"`
+	Duration    float64   `json:"duration,omitempty"`
+	Width       int       `json:"width,omitempty"`
+	Height      int       `json:"height,omitempty"`
+	VideoCodec  string    `json:"video_codec,omitempty"`
+	AudioCodec  string    `json:"audio_codec,omitempty"`
+	Bitrate     int64     `json:"bitrate,omitempty"`
+	FileSize    int64     `json:"file_size,omitempty"`
+	Visibility  string    `json:"visibility"`
+	Status      string    `json:"-"`
+	HLSKey      string    `json:"-"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
@@ -20,6 +33,19 @@ func NewVideo() *Video {
 		Description: "",
 		FileName:    "",
 		UploadedBy:  "",
+		WrappedKey:  "",
+		KeyVersion:  0,
+		MIMEType:    "",
+		Duration:    0,
+		Width:       0,
+		Height:      0,
+		VideoCodec:  "",
+		AudioCodec:  "",
+		Bitrate:     0,
+		FileSize:    0,
+		Visibility:  "",
+		Status:      "",
+		HLSKey:      "",
 		CreatedAt:   time.Time{},
 		UpdatedAt:   time.Time{},
 	}
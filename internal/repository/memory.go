@@ -0,0 +1,547 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"secure-video-api/internal/database"
+	"secure-video-api/internal/jobs"
+	"secure-video-api/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// memoryStore is the state of the in-memory repositories. They share it
+// the way the SQL repositories share the database, so that e.g. a user's
+// groups decide which videos they see.
+type memoryStore struct {
+	mu            sync.Mutex
+	users         map[string]models.User
+	userRoles     map[string][]string
+	groups        map[string]models.Group         // without roles and member count
+	groupRoles    map[string][]string             // by group ID
+	members       map[string]map[string]time.Time // time added, by user ID, by group ID
+	videos        map[string]models.Video
+	grants        map[string][]models.VideoGrant // by video ID
+	refreshTokens map[string]*memoryRefreshToken // by hash
+	revokedTokens map[string]time.Time           // expiry, by JWT ID
+	uploads       map[string]models.Upload
+	uploadLeases  map[string]memoryLease // by upload ID
+	jobs          map[string]jobs.Job
+}
+
+// NewMemory returns empty in-memory repositories sharing one store. Roles
+// are the built-in ones. Nothing is written to disk: videos leave their
+// files alone and no jobs run.
+func NewMemory() Repositories {
+	s := &memoryStore{
+		users:         make(map[string]models.User),
+		userRoles:     make(map[string][]string),
+		groups:        make(map[string]models.Group),
+		groupRoles:    make(map[string][]string),
+		members:       make(map[string]map[string]time.Time),
+		videos:        make(map[string]models.Video),
+		grants:        make(map[string][]models.VideoGrant),
+		refreshTokens: make(map[string]*memoryRefreshToken),
+		revokedTokens: make(map[string]time.Time),
+		uploads:       make(map[string]models.Upload),
+		uploadLeases:  make(map[string]memoryLease),
+		jobs:          make(map[string]jobs.Job),
+	}
+	return Repositories{
+		Users:   &MemoryUserRepository{s},
+		Videos:  &MemoryVideoRepository{s},
+		Groups:  &MemoryGroupRepository{s},
+		Roles:   MemoryRoleRepository{},
+		Tokens:  &MemoryTokenRepository{s},
+		Uploads: &MemoryUploadRepository{s},
+		Jobs:    &MemoryJobRepository{s},
+	}
+}
+
+// MemoryUserRepository keeps users in memory.
+type MemoryUserRepository struct {
+	s *memoryStore
+}
+
+func (r *MemoryUserRepository) Get(id string) (*models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	user, ok := r.s.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (r *MemoryUserRepository) FindByEmail(email string) (*models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, user := range r.s.users {
+		if user.Email == email {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryUserRepository) List() ([]models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	users := make([]models.User, 0, len(r.s.users))
+	for _, user := range r.s.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].CreatedAt.After(users[j].CreatedAt) })
+	return users, nil
+}
+
+// Roles returns the roles a user holds directly, not through groups.
+func (r *MemoryUserRepository) Roles(id string) []string {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return append([]string(nil), r.s.userRoles[id]...)
+}
+
+func (r *MemoryUserRepository) Create(user *models.User, roles []string) error {
+	if err := checkBuiltinRoles(roles); err != nil {
+		return err
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, existing := range r.s.users {
+		if existing.ID == user.ID || existing.Email == user.Email {
+			return fmt.Errorf("user %s already exists", user.Email)
+		}
+	}
+	if user.Status == "" {
+		user.Status = models.UserStatusActive
+	}
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+	user.UpdatedAt = user.CreatedAt
+	user.IsAdmin = holdsAdminRole(roles)
+	r.s.users[user.ID] = *user
+	r.s.userRoles[user.ID] = append([]string(nil), roles...)
+	return nil
+}
+
+func (r *MemoryUserRepository) SetStatus(id, status string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	user, ok := r.s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Status = status
+	user.UpdatedAt = time.Now()
+	r.s.users[id] = user
+	return nil
+}
+
+func (r *MemoryUserRepository) Delete(id string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, ok := r.s.users[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.s.users, id)
+	delete(r.s.userRoles, id)
+	for _, members := range r.s.members {
+		delete(members, id)
+	}
+	r.s.removeGrants(models.ACLSubjectUser, id)
+	for hash, token := range r.s.refreshTokens {
+		if token.UserID == id {
+			delete(r.s.refreshTokens, hash)
+		}
+	}
+	return nil
+}
+
+func (r *MemoryUserRepository) Access(id string) (*models.UserAccess, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.access(id)
+}
+
+func (r *MemoryUserRepository) SetRoles(id string, roles []string, authorize func(*models.UserAccess) error) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	current, err := r.s.access(id)
+	if err != nil {
+		return err
+	}
+	if err := authorize(current); err != nil {
+		return err
+	}
+	if err := checkBuiltinRoles(roles); err != nil {
+		return err
+	}
+	user := r.s.users[id]
+	user.IsAdmin = holdsAdminRole(roles)
+	r.s.users[id] = user
+	r.s.userRoles[id] = append([]string(nil), roles...)
+	return nil
+}
+
+// access applies the same rules as database.LoadUserAccess. The caller
+// holds the lock.
+func (s *memoryStore) access(userID string) (*models.UserAccess, error) {
+	user, ok := s.users[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	access := &models.UserAccess{IsAdmin: user.IsAdmin, Status: user.Status}
+	roles := make(map[string]bool)
+	for _, role := range s.userRoles[userID] {
+		roles[role] = true
+	}
+	for _, groupID := range s.groupsOf(userID) {
+		for _, role := range s.groupRoles[groupID] {
+			roles[role] = true
+		}
+	}
+
+	permissions := make(map[string]bool)
+	for _, role := range models.BuiltinRoles {
+		if !roles[role.ID] {
+			continue
+		}
+		if role.ID != models.RoleViewer {
+			access.IsAdmin = true
+		}
+		for _, permission := range role.Permissions {
+			permissions[permission] = true
+		}
+	}
+	access.Roles = sortedSet(roles)
+	access.Permissions = sortedSet(permissions)
+	return access, nil
+}
+
+// groupsOf returns the IDs of the groups a user belongs to. The caller
+// holds the lock.
+func (s *memoryStore) groupsOf(userID string) []string {
+	var groups []string
+	for groupID, members := range s.members {
+		if _, ok := members[userID]; ok {
+			groups = append(groups, groupID)
+		}
+	}
+	return groups
+}
+
+// removeGrants removes every video grant made to a subject. The caller
+// holds the lock.
+func (s *memoryStore) removeGrants(subjectType, subjectID string) {
+	for videoID, grants := range s.grants {
+		kept := grants[:0]
+		for _, grant := range grants {
+			if grant.SubjectType != subjectType || grant.SubjectID != subjectID {
+				kept = append(kept, grant)
+			}
+		}
+		s.grants[videoID] = kept
+	}
+}
+
+// checkBuiltinRoles returns database.ErrUnknownRole unless every role is
+// built in.
+func checkBuiltinRoles(roles []string) error {
+	for _, role := range roles {
+		if !isBuiltinRole(role) {
+			return fmt.Errorf("%w in %v", database.ErrUnknownRole, roles)
+		}
+	}
+	return nil
+}
+
+func isBuiltinRole(id string) bool {
+	for _, role := range models.BuiltinRoles {
+		if role.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedSet(set map[string]bool) []string {
+	values := make([]string, 0, len(set))
+	for value := range set {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// MemoryRoleRepository lists the built-in roles.
+type MemoryRoleRepository struct{}
+
+func (MemoryRoleRepository) List() ([]models.Role, error) {
+	roles := make([]models.Role, len(models.BuiltinRoles))
+	for i, role := range models.BuiltinRoles {
+		role.Permissions = append([]string(nil), role.Permissions...)
+		sort.Strings(role.Permissions)
+		roles[i] = role
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].ID < roles[j].ID })
+	return roles, nil
+}
+
+// MemoryVideoRepository keeps videos and their access grants in memory.
+type MemoryVideoRepository struct {
+	s *memoryStore
+}
+
+// SetStatus changes the processing status of a video, e.g. to mark it
+// ready as the processing job would.
+func (r *MemoryVideoRepository) SetStatus(id, status string) error {
+	return r.update(id, func(video *models.Video) { video.Status = status })
+}
+
+func (r *MemoryVideoRepository) Get(id string) (*models.Video, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	video, ok := r.s.videos[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &video, nil
+}
+
+func (r *MemoryVideoRepository) ListVisible(userID string, access *models.UserAccess) ([]models.Video, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	videos := []models.Video{}
+	for _, video := range r.s.videos {
+		if video.Status == jobs.StatusReady && r.visible(&video, userID, access) {
+			videos = append(videos, video)
+		}
+	}
+	sort.Slice(videos, func(i, j int) bool { return videos[i].CreatedAt.After(videos[j].CreatedAt) })
+	return videos, nil
+}
+
+func (r *MemoryVideoRepository) CanView(id, userID string, access *models.UserAccess) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	video, ok := r.s.videos[id]
+	return ok && r.visible(&video, userID, access), nil
+}
+
+// visible applies the same rules as database.VisibleVideosFilter. The
+// caller holds the lock.
+func (r *MemoryVideoRepository) visible(video *models.Video, userID string, access *models.UserAccess) bool {
+	if access != nil && access.Can(models.PermVideosManage) {
+		return true
+	}
+	if video.UploadedBy == userID || video.Visibility == models.VisibilityAllUsers {
+		return true
+	}
+	if video.Visibility != models.VisibilityRestricted {
+		return false
+	}
+	groups := r.s.groupsOf(userID)
+	for _, grant := range r.s.grants[video.ID] {
+		if grant.SubjectType == models.ACLSubjectUser && grant.SubjectID == userID {
+			return true
+		}
+		if grant.SubjectType == models.ACLSubjectGroup {
+			for _, groupID := range groups {
+				if grant.SubjectID == groupID {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Create stores the video and its job as queued; no job runs, so both stay
+// queued.
+func (r *MemoryVideoRepository) Create(video *models.Video) (string, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, ok := r.s.videos[video.ID]; ok {
+		return "", fmt.Errorf("video %s already exists", video.ID)
+	}
+	now := time.Now()
+	video.Status = jobs.StatusQueued
+	video.CreatedAt, video.UpdatedAt = now, now
+	r.s.videos[video.ID] = *video
+	return r.queueJob(video.ID, now), nil
+}
+
+// queueJob adds a queued job for a video and returns its ID.
+func (r *MemoryVideoRepository) queueJob(videoID string, now time.Time) string {
+	job := jobs.Job{
+		ID:          uuid.New().String(),
+		Type:        jobs.TypeProcessVideo,
+		VideoID:     videoID,
+		Status:      jobs.StatusQueued,
+		MaxAttempts: jobs.DefaultMaxAttempts,
+		RunAt:       now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	r.s.jobs[job.ID] = job
+	return job.ID
+}
+
+// deleteJobs removes the jobs of a video.
+func (r *MemoryVideoRepository) deleteJobs(videoID string) {
+	for jobID, job := range r.s.jobs {
+		if job.VideoID == videoID {
+			delete(r.s.jobs, jobID)
+		}
+	}
+}
+
+func (r *MemoryVideoRepository) ReplaceFile(video *models.Video) (string, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	stored, ok := r.s.videos[video.ID]
+	if !ok {
+		return "", ErrNotFound
+	}
+	if stored.Status == jobs.StatusProcessing {
+		return "", ErrBusy
+	}
+	stored.FileName = video.FileName
+	stored.WrappedKey = video.WrappedKey
+	stored.KeyVersion = video.KeyVersion
+	stored.MIMEType = video.MIMEType
+	stored.Duration = video.Duration
+	stored.Width = video.Width
+	stored.Height = video.Height
+	stored.VideoCodec = video.VideoCodec
+	stored.AudioCodec = video.AudioCodec
+	stored.Bitrate = video.Bitrate
+	stored.FileSize = video.FileSize
+	stored.HLSKey = ""
+	stored.Status = jobs.StatusQueued
+	stored.UpdatedAt = time.Now()
+	r.s.videos[video.ID] = stored
+	video.Status, video.HLSKey, video.UpdatedAt = stored.Status, stored.HLSKey, stored.UpdatedAt
+
+	r.deleteJobs(video.ID)
+	return r.queueJob(video.ID, stored.UpdatedAt), nil
+}
+
+func (r *MemoryVideoRepository) UpdateDetails(id, title, description string) error {
+	return r.update(id, func(video *models.Video) {
+		video.Title = title
+		video.Description = description
+	})
+}
+
+func (r *MemoryVideoRepository) SetVisibility(id, visibility string) error {
+	return r.update(id, func(video *models.Video) { video.Visibility = visibility })
+}
+
+func (r *MemoryVideoRepository) SetHLSKey(id, hlsKey string) error {
+	return r.update(id, func(video *models.Video) { video.HLSKey = hlsKey })
+}
+
+// StageFile records nothing: the memory repositories leave files alone.
+func (r *MemoryVideoRepository) StageFile(id, path string) error {
+	return nil
+}
+
+func (r *MemoryVideoRepository) DiscardFiles(id string) error {
+	return nil
+}
+
+func (r *MemoryVideoRepository) KeepFiles(id string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, ok := r.s.videos[id]; !ok {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MemoryVideoRepository) update(id string, change func(*models.Video)) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	video, ok := r.s.videos[id]
+	if !ok {
+		return ErrNotFound
+	}
+	change(&video)
+	video.UpdatedAt = time.Now()
+	r.s.videos[id] = video
+	return nil
+}
+
+func (r *MemoryVideoRepository) Grants(id string) ([]models.VideoGrant, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return append([]models.VideoGrant{}, r.s.grants[id]...), nil
+}
+
+func (r *MemoryVideoRepository) Grant(id string, grant models.VideoGrant) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, ok := r.s.videos[id]; !ok {
+		return ErrNotFound
+	}
+	exists := false
+	switch grant.SubjectType {
+	case models.ACLSubjectUser:
+		_, exists = r.s.users[grant.SubjectID]
+	case models.ACLSubjectGroup:
+		_, exists = r.s.groups[grant.SubjectID]
+	}
+	if !exists {
+		return ErrUnknownSubject
+	}
+	for _, existing := range r.s.grants[id] {
+		if existing.SubjectType == grant.SubjectType && existing.SubjectID == grant.SubjectID {
+			return nil
+		}
+	}
+	grant.CreatedAt = time.Now()
+	r.s.grants[id] = append(r.s.grants[id], grant)
+	return nil
+}
+
+func (r *MemoryVideoRepository) Revoke(id, subjectType, subjectID string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	grants := r.s.grants[id]
+	for i, grant := range grants {
+		if grant.SubjectType == subjectType && grant.SubjectID == subjectID {
+			r.s.grants[id] = append(grants[:i:i], grants[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryVideoRepository) Delete(id string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, ok := r.s.videos[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.s.videos, id)
+	delete(r.s.grants, id)
+	r.deleteJobs(id)
+	return nil
+}
+
+var (
+	_ UserRepository   = (*MemoryUserRepository)(nil)
+	_ VideoRepository  = (*MemoryVideoRepository)(nil)
+	_ GroupRepository  = (*MemoryGroupRepository)(nil)
+	_ RoleRepository   = MemoryRoleRepository{}
+	_ TokenRepository  = (*MemoryTokenRepository)(nil)
+	_ UploadRepository = (*MemoryUploadRepository)(nil)
+	_ JobRepository    = (*MemoryJobRepository)(nil)
+)
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"sort"
+	"time"
+
+	"secure-video-api/internal/models"
+)
+
+// MemoryGroupRepository keeps groups and their members in memory.
+type MemoryGroupRepository struct {
+	s *memoryStore
+}
+
+func (r *MemoryGroupRepository) List() ([]models.Group, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	groups := make([]models.Group, 0, len(r.s.groups))
+	for id := range r.s.groups {
+		groups = append(groups, *r.s.group(id))
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
+
+func (r *MemoryGroupRepository) Get(id string) (*models.Group, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	group := r.s.group(id)
+	if group == nil {
+		return nil, ErrNotFound
+	}
+	return group, nil
+}
+
+// group returns a group with its roles and member count, or nil. The
+// caller holds the lock.
+func (s *memoryStore) group(id string) *models.Group {
+	group, ok := s.groups[id]
+	if !ok {
+		return nil
+	}
+	group.Roles = append([]string{}, s.groupRoles[id]...)
+	sort.Strings(group.Roles)
+	group.MemberCount = len(s.members[id])
+	return &group
+}
+
+func (r *MemoryGroupRepository) Members(id string) ([]models.GroupMember, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	members := []models.GroupMember{}
+	for userID, added := range r.s.members[id] {
+		members = append(members, models.GroupMember{UserID: userID, Email: r.s.users[userID].Email, AddedAt: added})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Email < members[j].Email })
+	return members, nil
+}
+
+func (r *MemoryGroupRepository) Create(group *models.Group) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if r.s.groupNameTaken(group.Name, group.ID) {
+		return ErrConflict
+	}
+	now := time.Now()
+	group.CreatedAt, group.UpdatedAt = now, now
+	group.Roles = []string{}
+	group.MemberCount = 0
+	r.s.groups[group.ID] = *group
+	return nil
+}
+
+func (r *MemoryGroupRepository) Update(id, name, description string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	group, ok := r.s.groups[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if r.s.groupNameTaken(name, id) {
+		return ErrConflict
+	}
+	group.Name = name
+	group.Description = description
+	group.UpdatedAt = time.Now()
+	r.s.groups[id] = group
+	return nil
+}
+
+// groupNameTaken reports whether a group other than id is named name. The
+// caller holds the lock.
+func (s *memoryStore) groupNameTaken(name, id string) bool {
+	for _, group := range s.groups {
+		if group.Name == name && group.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *MemoryGroupRepository) Delete(id string, authorize func(*models.Group) error) error {
+	return r.change(id, authorize, func() error {
+		delete(r.s.groups, id)
+		delete(r.s.groupRoles, id)
+		delete(r.s.members, id)
+		r.s.removeGrants(models.ACLSubjectGroup, id)
+		return nil
+	})
+}
+
+func (r *MemoryGroupRepository) AddMember(id, userID string, authorize func(*models.Group) error) error {
+	return r.change(id, authorize, func() error {
+		if _, ok := r.s.users[userID]; !ok {
+			return ErrUnknownSubject
+		}
+		if r.s.members[id] == nil {
+			r.s.members[id] = make(map[string]time.Time)
+		}
+		if _, ok := r.s.members[id][userID]; !ok {
+			r.s.members[id][userID] = time.Now()
+		}
+		return nil
+	})
+}
+
+func (r *MemoryGroupRepository) RemoveMember(id, userID string, authorize func(*models.Group) error) error {
+	return r.change(id, authorize, func() error {
+		if _, ok := r.s.members[id][userID]; !ok {
+			return ErrNotMember
+		}
+		delete(r.s.members[id], userID)
+		return nil
+	})
+}
+
+func (r *MemoryGroupRepository) SetRoles(id string, roles []string, authorize func(*models.Group) error) error {
+	return r.change(id, authorize, func() error {
+		if err := checkBuiltinRoles(roles); err != nil {
+			return err
+		}
+		r.s.groupRoles[id] = append([]string(nil), roles...)
+		return nil
+	})
+}
+
+// change applies a change to a group once it exists and authorize allows
+// the change, holding the lock throughout.
+func (r *MemoryGroupRepository) change(id string, authorize func(*models.Group) error, apply func() error) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	group := r.s.group(id)
+	if group == nil {
+		return ErrNotFound
+	}
+	if err := authorize(group); err != nil {
+		return err
+	}
+	return apply()
+}
@@ -0,0 +1,19 @@
+package repository
+
+import "secure-video-api/internal/jobs"
+
+// MemoryJobRepository keeps the jobs queued by MemoryVideoRepository.Create,
+// which stay queued since no worker runs them.
+type MemoryJobRepository struct {
+	s *memoryStore
+}
+
+func (r *MemoryJobRepository) Get(id string) (*jobs.Job, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	job, ok := r.s.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &job, nil
+}
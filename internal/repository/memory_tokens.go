@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"secure-video-api/internal/models"
+)
+
+// memoryRefreshToken is a stored refresh token with its revocation.
+type memoryRefreshToken struct {
+	models.RefreshToken
+	Revoked bool
+}
+
+// MemoryTokenRepository keeps refresh tokens and revoked access tokens in
+// memory.
+type MemoryTokenRepository struct {
+	s *memoryStore
+}
+
+func (r *MemoryTokenRepository) CreateRefreshToken(token *models.RefreshToken) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.addRefreshToken(token)
+	return nil
+}
+
+// addRefreshToken stores a refresh token. The caller holds the lock.
+func (s *memoryStore) addRefreshToken(token *models.RefreshToken) {
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+	s.refreshTokens[token.TokenHash] = &memoryRefreshToken{RefreshToken: *token}
+}
+
+func (r *MemoryTokenRepository) RotateRefreshToken(hash string, next *models.RefreshToken) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	token, ok := r.s.refreshTokens[hash]
+	if !ok {
+		return ErrNotFound
+	}
+	user, ok := r.s.users[token.UserID]
+	if !ok {
+		return ErrNotFound
+	}
+	if token.Revoked {
+		r.s.revokeRefreshTokens(func(t *memoryRefreshToken) bool { return t.FamilyID == token.FamilyID })
+		return fmt.Errorf("%w: family %s revoked", ErrTokenReused, token.FamilyID)
+	}
+	if time.Now().After(token.ExpiresAt) || user.Status == models.UserStatusInactive {
+		return ErrNotFound
+	}
+
+	next.UserID = token.UserID
+	next.FamilyID = token.FamilyID
+	r.s.addRefreshToken(next)
+	token.Revoked = true
+	return nil
+}
+
+func (r *MemoryTokenRepository) RevokeRefreshFamily(hash, userID string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	token, ok := r.s.refreshTokens[hash]
+	if !ok || token.UserID != userID {
+		return nil
+	}
+	r.s.revokeRefreshTokens(func(t *memoryRefreshToken) bool { return t.FamilyID == token.FamilyID })
+	return nil
+}
+
+func (r *MemoryTokenRepository) RevokeUserRefreshTokens(userID string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.revokeRefreshTokens(func(t *memoryRefreshToken) bool { return t.UserID == userID })
+	return nil
+}
+
+// revokeRefreshTokens revokes the refresh tokens matching a condition.
+// The caller holds the lock.
+func (s *memoryStore) revokeRefreshTokens(match func(*memoryRefreshToken) bool) {
+	for _, token := range s.refreshTokens {
+		if match(token) {
+			token.Revoked = true
+		}
+	}
+}
+
+func (r *MemoryTokenRepository) RevokeAccessToken(jti string, expires time.Time) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.revokedTokens[jti] = expires
+	for id, expiry := range r.s.revokedTokens {
+		if expiry.Before(time.Now()) {
+			delete(r.s.revokedTokens, id)
+		}
+	}
+	return nil
+}
+
+func (r *MemoryTokenRepository) AccessTokenRevoked(jti string) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	_, revoked := r.s.revokedTokens[jti]
+	return revoked, nil
+}
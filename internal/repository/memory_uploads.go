@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"secure-video-api/internal/models"
+)
+
+// MemoryUploadRepository keeps uploads in memory.
+type MemoryUploadRepository struct {
+	s *memoryStore
+}
+
+// memoryLease is a lease on an upload.
+type memoryLease struct {
+	holder string
+	until  time.Time
+}
+
+func (r *MemoryUploadRepository) Create(upload *models.Upload) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, ok := r.s.uploads[upload.ID]; ok {
+		return fmt.Errorf("upload %s already exists", upload.ID)
+	}
+	now := time.Now()
+	upload.CreatedAt, upload.UpdatedAt = now, now
+	r.s.uploads[upload.ID] = *upload
+	return nil
+}
+
+func (r *MemoryUploadRepository) Get(id string) (*models.Upload, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	upload, ok := r.s.uploads[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &upload, nil
+}
+
+func (r *MemoryUploadRepository) Lease(id, holder string, until time.Time) error {
+	return r.lease(id, func(lease memoryLease, ok bool) (memoryLease, error) {
+		if ok && !lease.until.Before(time.Now()) {
+			return lease, ErrLeased
+		}
+		return memoryLease{holder: holder, until: until}, nil
+	})
+}
+
+func (r *MemoryUploadRepository) RenewLease(id, holder string, until time.Time) error {
+	return r.lease(id, func(lease memoryLease, ok bool) (memoryLease, error) {
+		if !ok || lease.holder != holder {
+			return lease, ErrLeased
+		}
+		return memoryLease{holder: holder, until: until}, nil
+	})
+}
+
+func (r *MemoryUploadRepository) ReleaseLease(id, holder string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if lease, ok := r.s.uploadLeases[id]; ok && lease.holder == holder {
+		delete(r.s.uploadLeases, id)
+	}
+	return nil
+}
+
+// lease replaces the lease on an upload with the one change returns.
+func (r *MemoryUploadRepository) lease(id string, change func(lease memoryLease, ok bool) (memoryLease, error)) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, ok := r.s.uploads[id]; !ok {
+		return ErrNotFound
+	}
+	lease, ok := r.s.uploadLeases[id]
+	lease, err := change(lease, ok)
+	if err != nil {
+		return err
+	}
+	r.s.uploadLeases[id] = lease
+	return nil
+}
+
+func (r *MemoryUploadRepository) SetProgress(upload *models.Upload, holder string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	stored, ok := r.s.uploads[upload.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if r.s.uploadLeases[upload.ID].holder != holder {
+		return ErrLeased
+	}
+	stored.Offset = upload.Offset
+	stored.StagedSize = upload.StagedSize
+	stored.StagedRecords = upload.StagedRecords
+	stored.UpdatedAt = time.Now()
+	r.s.uploads[upload.ID] = stored
+	return nil
+}
+
+func (r *MemoryUploadRepository) SetVideoID(id, videoID string) error {
+	return r.update(id, func(stored *models.Upload) { stored.VideoID = videoID })
+}
+
+func (r *MemoryUploadRepository) update(id string, change func(*models.Upload)) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	upload, ok := r.s.uploads[id]
+	if !ok {
+		return ErrNotFound
+	}
+	change(&upload)
+	upload.UpdatedAt = time.Now()
+	r.s.uploads[id] = upload
+	return nil
+}
+
+func (r *MemoryUploadRepository) Delete(id string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	delete(r.s.uploads, id)
+	delete(r.s.uploadLeases, id)
+	return nil
+}
@@ -0,0 +1,209 @@
+// Package repository stores the API's data behind interfaces, so that
+// handlers do not depend on a particular database. The SQL implementations
+// are used by the server, on SQLite or PostgreSQL depending on how the
+// database was opened; the in-memory ones need no database and suit tests.
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"secure-video-api/internal/jobs"
+	"secure-video-api/internal/models"
+)
+
+var (
+	// ErrNotFound is returned for records that do not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a unique name is already in use.
+	ErrConflict = errors.New("already in use")
+	// ErrUnknownSubject is returned when adding a user that does not exist
+	// to a group, or granting video access to a missing user or group.
+	ErrUnknownSubject = errors.New("unknown user or group")
+	// ErrNotMember is returned when removing a user who is not a member of
+	// a group.
+	ErrNotMember = errors.New("not a member")
+	// ErrTokenReused is returned for a refresh token that was already
+	// rotated or revoked; its whole family is revoked in response.
+	ErrTokenReused = errors.New("refresh token reused")
+	// ErrLeased is returned when an upload is leased to another request.
+	ErrLeased = errors.New("leased to another request")
+	// ErrBusy is returned when replacing the file of a video that is being
+	// processed, or whose file was changed meanwhile.
+	ErrBusy = errors.New("video is busy")
+)
+
+// Repositories are the repositories the API works on.
+type Repositories struct {
+	Users   UserRepository
+	Videos  VideoRepository
+	Groups  GroupRepository
+	Roles   RoleRepository
+	Tokens  TokenRepository
+	Uploads UploadRepository
+	Jobs    JobRepository
+}
+
+// UserRepository stores user accounts.
+type UserRepository interface {
+	// Get returns a user by ID.
+	Get(id string) (*models.User, error)
+	// FindByEmail returns the user with an email address, including the
+	// password hash.
+	FindByEmail(email string) (*models.User, error)
+	// List returns every user, newest first.
+	List() ([]models.User, error)
+	// Create stores a new user holding roles, returning
+	// database.ErrUnknownRole if a role does not exist.
+	Create(user *models.User, roles []string) error
+	// SetStatus activates or deactivates a user.
+	SetStatus(id, status string) error
+	// Delete removes a user with their sessions, roles, group memberships
+	// and video access grants.
+	Delete(id string) error
+	// Access returns what a user is currently allowed to do, including
+	// through the roles of their groups.
+	Access(id string) (*models.UserAccess, error)
+	// SetRoles replaces the roles of a user, returning
+	// database.ErrUnknownRole if a role does not exist. authorize is given
+	// the user's current access as part of the change and can stop it by
+	// returning an error, which SetRoles returns.
+	SetRoles(id string, roles []string, authorize func(current *models.UserAccess) error) error
+}
+
+// VideoRepository stores video metadata and access grants.
+type VideoRepository interface {
+	// Get returns a video by ID, whatever its status.
+	Get(id string) (*models.Video, error)
+	// ListVisible returns the ready videos a user can see, newest first.
+	ListVisible(userID string, access *models.UserAccess) ([]models.Video, error)
+	// CanView reports whether a video exists and a user can see it,
+	// whatever its status.
+	CanView(id, userID string, access *models.UserAccess) (bool, error)
+	// Create stores a new video and queues the job processing it,
+	// returning the job ID. Files staged for the video with StageFile are
+	// kept if it is stored.
+	Create(video *models.Video) (string, error)
+	// ReplaceFile points a video at a new file, described by the file
+	// fields of video (name, key, MIME type and media metadata), and queues
+	// the job processing it again, returning the job ID. The new file must
+	// have been staged with StageFile: it is kept, and the old file released
+	// and removed, if the change is stored. It returns ErrBusy while the
+	// video is being processed.
+	ReplaceFile(video *models.Video) (string, error)
+	// UpdateDetails changes the title and description of a video.
+	UpdateDetails(id, title, description string) error
+	// SetVisibility changes who can see a video.
+	SetVisibility(id, visibility string) error
+	// StageFile records that path, relative to storage.Dir, is about to be
+	// created for a video (see storage.Stage).
+	StageFile(id, path string) error
+	// DiscardFiles removes the files staged for a video whose row was not
+	// stored or updated.
+	DiscardFiles(id string) error
+	// SetHLSKey records the wrapped key of a video's HLS segments and keeps
+	// the files staged for the video.
+	SetHLSKey(id, hlsKey string) error
+	// KeepFiles keeps the files staged for a video, or returns ErrNotFound
+	// if the video no longer exists and the caller should discard them.
+	KeepFiles(id string) error
+	// Grants returns the access grants of a video, oldest first.
+	Grants(id string) ([]models.VideoGrant, error)
+	// Grant lets the subject of grant view a restricted video, returning
+	// ErrUnknownSubject if the user or group does not exist. Granting
+	// access twice is not an error.
+	Grant(id string, grant models.VideoGrant) error
+	// Revoke removes an access grant from a video.
+	Revoke(id, subjectType, subjectID string) error
+	// Delete removes a video with its access grants and jobs, and then its
+	// files.
+	Delete(id string) error
+}
+
+// GroupRepository stores groups and their members. Changes that alter
+// what members may do take an authorize function, given the group as part
+// of the change, which can stop the change by returning an error that is
+// then returned as is.
+type GroupRepository interface {
+	// List returns every group with its roles and member count, by name.
+	List() ([]models.Group, error)
+	// Get returns a group with its roles and member count.
+	Get(id string) (*models.Group, error)
+	// Members returns the members of a group, by email.
+	Members(id string) ([]models.GroupMember, error)
+	// Create stores a new group without roles or members, returning
+	// ErrConflict if its name is taken.
+	Create(group *models.Group) error
+	// Update renames a group and changes its description, returning
+	// ErrConflict if the name is taken by another group.
+	Update(id, name, description string) error
+	// Delete removes a group with its memberships, roles and video grants.
+	Delete(id string, authorize func(*models.Group) error) error
+	// AddMember adds a user to a group, returning ErrUnknownSubject if
+	// the user does not exist. Adding a member twice is not an error.
+	AddMember(id, userID string, authorize func(*models.Group) error) error
+	// RemoveMember removes a user from a group, returning ErrNotMember if
+	// they do not belong to it.
+	RemoveMember(id, userID string, authorize func(*models.Group) error) error
+	// SetRoles replaces the roles of a group, returning
+	// database.ErrUnknownRole if a role does not exist.
+	SetRoles(id string, roles []string, authorize func(*models.Group) error) error
+}
+
+// RoleRepository lists the roles users and groups can hold.
+type RoleRepository interface {
+	// List returns every role with its permissions, by ID.
+	List() ([]models.Role, error)
+}
+
+// TokenRepository stores refresh tokens, by hash, and the access tokens
+// revoked before they expire.
+type TokenRepository interface {
+	// CreateRefreshToken stores the first refresh token of a session, or
+	// any other refresh token whose family is known.
+	CreateRefreshToken(token *models.RefreshToken) error
+	// RotateRefreshToken replaces the refresh token with a hash by next,
+	// setting its user and family to those of the old token. It returns
+	// ErrNotFound for unknown and expired tokens and for tokens of
+	// inactive users, and ErrTokenReused, after revoking the rest of the
+	// family, for tokens that were already rotated or revoked.
+	RotateRefreshToken(hash string, next *models.RefreshToken) error
+	// RevokeRefreshFamily ends the session a refresh token belongs to, if
+	// the token is one of userID's.
+	RevokeRefreshFamily(hash, userID string) error
+	// RevokeUserRefreshTokens ends every session of a user.
+	RevokeUserRefreshTokens(userID string) error
+	// RevokeAccessToken denies an access token until it expires.
+	RevokeAccessToken(jti string, expires time.Time) error
+	// AccessTokenRevoked reports whether an access token was revoked.
+	AccessTokenRevoked(jti string) (bool, error)
+}
+
+// JobRepository reads the background jobs processing videos.
+type JobRepository interface {
+	// Get returns a job by ID.
+	Get(id string) (*jobs.Job, error)
+}
+
+// UploadRepository stores resumable uploads in progress.
+type UploadRepository interface {
+	// Create stores a new upload.
+	Create(upload *models.Upload) error
+	// Get returns an upload by ID.
+	Get(id string) (*models.Upload, error)
+	// Lease leases an upload to holder until the given time, unless another
+	// holder's lease has yet to lapse, in which case it returns ErrLeased.
+	Lease(id, holder string, until time.Time) error
+	// RenewLease extends holder's lease on an upload, returning ErrLeased if
+	// the upload has since been leased to someone else or released.
+	RenewLease(id, holder string, until time.Time) error
+	// ReleaseLease ends holder's lease on an upload, if it still holds one.
+	ReleaseLease(id, holder string) error
+	// SetProgress records the offset and staged size of an upload written
+	// under holder's lease, returning ErrLeased if the lease was lost.
+	SetProgress(upload *models.Upload, holder string) error
+	// SetVideoID records the video an upload is turned into.
+	SetVideoID(id, videoID string) error
+	// Delete removes an upload.
+	Delete(id string) error
+}
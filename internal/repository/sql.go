@@ -0,0 +1,581 @@
+package repository
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"secure-video-api/internal/database"
+	"secure-video-api/internal/jobs"
+	"secure-video-api/internal/models"
+	"secure-video-api/internal/storage"
+)
+
+// NewSQL returns the repositories backed by db, which must have been
+// opened by the database package so that its queries suit the driver.
+func NewSQL(db *sql.DB) Repositories {
+	return Repositories{
+		Users:   NewSQLUserRepository(db),
+		Videos:  NewSQLVideoRepository(db),
+		Groups:  NewSQLGroupRepository(db),
+		Roles:   NewSQLRoleRepository(db),
+		Tokens:  NewSQLTokenRepository(db),
+		Uploads: NewSQLUploadRepository(db),
+		Jobs:    NewSQLJobRepository(db),
+	}
+}
+
+// SQLUserRepository stores users in the users table. Its queries run on
+// both SQLite and PostgreSQL.
+type SQLUserRepository struct {
+	db *sql.DB
+}
+
+// NewSQLUserRepository returns a UserRepository backed by db.
+func NewSQLUserRepository(db *sql.DB) *SQLUserRepository {
+	return &SQLUserRepository{db: db}
+}
+
+const userColumns = "id, email, password, is_admin, COALESCE(status, 'active'), created_at, updated_at"
+
+func scanUser(row interface{ Scan(...any) error }) (*models.User, error) {
+	var user models.User
+	err := row.Scan(&user.ID, &user.Email, &user.Password, &user.IsAdmin, &user.Status,
+		(*database.Time)(&user.CreatedAt), (*database.Time)(&user.UpdatedAt))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *SQLUserRepository) Get(id string) (*models.User, error) {
+	return scanUser(r.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+}
+
+func (r *SQLUserRepository) FindByEmail(email string) (*models.User, error) {
+	return scanUser(r.db.QueryRow("SELECT "+userColumns+" FROM users WHERE email = ?", email))
+}
+
+func (r *SQLUserRepository) List() ([]models.User, error) {
+	rows, err := r.db.Query("SELECT " + userColumns + " FROM users ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+func (r *SQLUserRepository) Create(user *models.User, roles []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if user.Status == "" {
+		user.Status = models.UserStatusActive
+	}
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+	user.CreatedAt = user.CreatedAt.UTC().Truncate(time.Second)
+	user.UpdatedAt = user.CreatedAt
+	currentTime := database.FormatTime(user.CreatedAt)
+	_, err = tx.Exec(`
+		INSERT INTO users (id, email, password, is_admin, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, user.ID, user.Email, user.Password, false, user.Status, currentTime, currentTime)
+	if err != nil {
+		return err
+	}
+	if err := database.SetUserRoles(tx, user.ID, roles); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	user.IsAdmin = holdsAdminRole(roles)
+	return nil
+}
+
+func (r *SQLUserRepository) SetStatus(id, status string) error {
+	result, err := r.db.Exec(
+		"UPDATE users SET status = ?, updated_at = ? WHERE id = ?",
+		status, database.FormatTime(time.Now()), id,
+	)
+	return checkAffected(result, err)
+}
+
+func (r *SQLUserRepository) Delete(id string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		"DELETE FROM refresh_tokens WHERE user_id = ?",
+		"DELETE FROM user_roles WHERE user_id = ?",
+		"DELETE FROM group_members WHERE user_id = ?",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("DELETE FROM video_acl WHERE subject_type = ? AND subject_id = ?", models.ACLSubjectUser, id)
+	if err != nil {
+		return err
+	}
+	if err := checkAffected(tx.Exec("DELETE FROM users WHERE id = ?", id)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SQLUserRepository) Access(id string) (*models.UserAccess, error) {
+	access, err := database.LoadUserAccess(r.db, id)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return access, err
+}
+
+func (r *SQLUserRepository) SetRoles(id string, roles []string, authorize func(*models.UserAccess) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := database.LoadUserAccess(tx, id)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := authorize(current); err != nil {
+		return err
+	}
+	if err := database.SetUserRoles(tx, id, roles); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SQLVideoRepository stores videos in the videos table. Its queries run on
+// both SQLite and PostgreSQL.
+type SQLVideoRepository struct {
+	db *sql.DB
+}
+
+// NewSQLVideoRepository returns a VideoRepository backed by db.
+func NewSQLVideoRepository(db *sql.DB) *SQLVideoRepository {
+	return &SQLVideoRepository{db: db}
+}
+
+const videoColumns = `
+	v.id, v.title, v.description, v.file_name, v.uploaded_by, v.wrapped_key, v.key_version,
+	v.mime_type, v.duration, v.width, v.height, v.video_codec, v.audio_codec, v.bitrate,
+	v.file_size, v.hls_key, v.visibility, v.status, v.created_at, v.updated_at`
+
+func scanVideo(row interface{ Scan(...any) error }) (*models.Video, error) {
+	var video models.Video
+	// Key and media columns are NULL for videos stored before they existed
+	var description, wrappedKey, mimeType, videoCodec, audioCodec, hlsKey sql.NullString
+	var duration sql.NullFloat64
+	var width, height, bitrate, fileSize sql.NullInt64
+	err := row.Scan(
+		&video.ID,
+		&video.Title,
+		&description,
+		&video.FileName,
+		&video.UploadedBy,
+		&wrappedKey,
+		&video.KeyVersion,
+		&mimeType,
+		&duration,
+		&width,
+		&height,
+		&videoCodec,
+		&audioCodec,
+		&bitrate,
+		&fileSize,
+		&hlsKey,
+		&video.Visibility,
+		&video.Status,
+		(*database.Time)(&video.CreatedAt),
+		(*database.Time)(&video.UpdatedAt),
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	video.Description = description.String
+	video.WrappedKey = wrappedKey.String
+	video.MIMEType = mimeType.String
+	video.Duration = duration.Float64
+	video.Width = int(width.Int64)
+	video.Height = int(height.Int64)
+	video.VideoCodec = videoCodec.String
+	video.AudioCodec = audioCodec.String
+	video.Bitrate = bitrate.Int64
+	video.FileSize = fileSize.Int64
+	video.HLSKey = hlsKey.String
+	return &video, nil
+}
+
+func (r *SQLVideoRepository) Get(id string) (*models.Video, error) {
+	return scanVideo(r.db.QueryRow("SELECT "+videoColumns+" FROM videos v WHERE v.id = ?", id))
+}
+
+func (r *SQLVideoRepository) ListVisible(userID string, access *models.UserAccess) ([]models.Video, error) {
+	filter, args := database.VisibleVideosFilter(userID, access)
+	rows, err := r.db.Query(
+		"SELECT "+videoColumns+" FROM videos v WHERE v.status = ? AND "+filter+" ORDER BY v.created_at DESC",
+		append([]any{jobs.StatusReady}, args...)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []models.Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, *video)
+	}
+	return videos, rows.Err()
+}
+
+func (r *SQLVideoRepository) CanView(id, userID string, access *models.UserAccess) (bool, error) {
+	return database.CanViewVideo(r.db, id, userID, access)
+}
+
+func (r *SQLVideoRepository) Create(video *models.Video) (string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	video.Status = jobs.StatusQueued
+	video.CreatedAt, video.UpdatedAt = now, now
+	currentTime := database.FormatTime(now)
+	_, err = tx.Exec(`
+		INSERT INTO videos (
+			id,
+			title,
+			description,
+			file_name,
+			uploaded_by,
+			wrapped_key,
+			key_version,
+			mime_type,
+			duration,
+			width,
+			height,
+			video_codec,
+			audio_codec,
+			bitrate,
+			file_size,
+			visibility,
+			status,
+			created_at,
+			updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		video.ID,
+		video.Title,
+		video.Description,
+		video.FileName,
+		video.UploadedBy,
+		video.WrappedKey,
+		video.KeyVersion,
+		video.MIMEType,
+		video.Duration,
+		video.Width,
+		video.Height,
+		video.VideoCodec,
+		video.AudioCodec,
+		video.Bitrate,
+		video.FileSize,
+		video.Visibility,
+		video.Status,
+		currentTime,
+		currentTime,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if err := storage.Keep(tx, video.ID); err != nil {
+		return "", err
+	}
+	jobID, err := jobs.Enqueue(tx, jobs.TypeProcessVideo, video.ID)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	jobs.Notify()
+	return jobID, nil
+}
+
+func (r *SQLVideoRepository) ReplaceFile(video *models.Video) (string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var oldFileName string
+	err = tx.QueryRow("SELECT file_name FROM videos WHERE id = ?", video.ID).Scan(&oldFileName)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// A worker claiming the video, or another replacement committing, in
+	// the meantime leaves the row unmatched
+	now := time.Now().UTC().Truncate(time.Second)
+	result, err := tx.Exec(`
+		UPDATE videos SET
+			file_name = ?, wrapped_key = ?, key_version = ?, mime_type = ?,
+			duration = ?, width = ?, height = ?, video_codec = ?, audio_codec = ?, bitrate = ?, file_size = ?,
+			hls_key = NULL, status = ?, updated_at = ?
+		WHERE id = ? AND file_name = ? AND status <> ?
+	`, video.FileName, video.WrappedKey, video.KeyVersion, video.MIMEType,
+		video.Duration, video.Width, video.Height, video.VideoCodec, video.AudioCodec, video.Bitrate, video.FileSize,
+		jobs.StatusQueued, database.FormatTime(now),
+		video.ID, oldFileName, jobs.StatusProcessing)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return "", ErrBusy
+	}
+
+	// The jobs of the old file have finished or not started; the new one
+	// supersedes them
+	if err := jobs.DeleteForVideo(tx, video.ID); err != nil {
+		return "", err
+	}
+	if err := storage.Keep(tx, video.ID); err != nil {
+		return "", err
+	}
+	if err := storage.Release(tx, video.ID, oldFileName+".enc"); err != nil {
+		return "", err
+	}
+	jobID, err := jobs.Enqueue(tx, jobs.TypeProcessVideo, video.ID)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	video.Status = jobs.StatusQueued
+	video.HLSKey = ""
+	video.UpdatedAt = now
+
+	// The row points at the new file; if the old one cannot be removed now
+	// it is left to storage.Recover
+	if err := storage.Purge(r.db, video.ID); err != nil {
+		log.Printf("Error removing old file of video %s: %v", video.ID, err)
+	}
+	jobs.Notify()
+	return jobID, nil
+}
+
+func (r *SQLVideoRepository) UpdateDetails(id, title, description string) error {
+	return checkAffected(r.db.Exec(
+		"UPDATE videos SET title = ?, description = ?, updated_at = ? WHERE id = ?",
+		title, description, database.FormatTime(time.Now()), id,
+	))
+}
+
+func (r *SQLVideoRepository) SetVisibility(id, visibility string) error {
+	return checkAffected(r.db.Exec(
+		"UPDATE videos SET visibility = ?, updated_at = ? WHERE id = ?",
+		visibility, database.FormatTime(time.Now()), id,
+	))
+}
+
+func (r *SQLVideoRepository) StageFile(id, path string) error {
+	return storage.Stage(r.db, id, path)
+}
+
+func (r *SQLVideoRepository) DiscardFiles(id string) error {
+	return storage.Discard(r.db, id)
+}
+
+func (r *SQLVideoRepository) SetHLSKey(id, hlsKey string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkAffected(tx.Exec("UPDATE videos SET hls_key = ? WHERE id = ?", hlsKey, id)); err != nil {
+		return err
+	}
+	if err := storage.Keep(tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SQLVideoRepository) KeepFiles(id string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The files are in place by now: a delete committing after this check
+	// purges them with the rest of the video's files
+	var exists int
+	err = tx.QueryRow("SELECT 1 FROM videos WHERE id = ?", id).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := storage.Keep(tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SQLVideoRepository) Grants(id string) ([]models.VideoGrant, error) {
+	return database.ListVideoGrants(r.db, id)
+}
+
+// subjectTables maps the subject types of grants to the table holding them.
+var subjectTables = map[string]string{models.ACLSubjectUser: "users", models.ACLSubjectGroup: "groups"}
+
+func (r *SQLVideoRepository) Grant(id string, grant models.VideoGrant) error {
+	table, ok := subjectTables[grant.SubjectType]
+	if !ok {
+		return ErrUnknownSubject
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM videos WHERE id = ?)", id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM "+table+" WHERE id = ?)", grant.SubjectID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUnknownSubject
+	}
+	_, err = tx.Exec(`
+		INSERT INTO video_acl (video_id, subject_type, subject_id, granted_by, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`, id, grant.SubjectType, grant.SubjectID, grant.GrantedBy, database.FormatTime(time.Now()))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SQLVideoRepository) Revoke(id, subjectType, subjectID string) error {
+	return checkAffected(r.db.Exec(
+		"DELETE FROM video_acl WHERE video_id = ? AND subject_type = ? AND subject_id = ?",
+		id, subjectType, subjectID,
+	))
+}
+
+func (r *SQLVideoRepository) Delete(id string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var fileName string
+	err = tx.QueryRow("SELECT file_name FROM videos WHERE id = ?", id).Scan(&fileName)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM video_acl WHERE video_id = ?", id); err != nil {
+		return err
+	}
+	if err := jobs.DeleteForVideo(tx, id); err != nil {
+		return err
+	}
+	if err := checkAffected(tx.Exec("DELETE FROM videos WHERE id = ?", id)); err != nil {
+		return err
+	}
+	if err := storage.Release(tx, id, storage.VideoPaths(id, fileName)...); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// The row is gone; files that cannot be removed now are left to
+	// storage.Recover
+	if err := storage.Purge(r.db, id); err != nil {
+		log.Printf("Error removing files of video %s: %v", id, err)
+	}
+	return nil
+}
+
+// checkAffected turns an update that matched no rows into ErrNotFound.
+func checkAffected(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// holdsAdminRole reports whether roles include any beyond viewer, which is
+// what the is_admin flag records.
+func holdsAdminRole(roles []string) bool {
+	for _, role := range roles {
+		if role != models.RoleViewer {
+			return true
+		}
+	}
+	return false
+}
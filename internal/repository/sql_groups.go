@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"secure-video-api/internal/database"
+	"secure-video-api/internal/models"
+)
+
+// SQLGroupRepository stores groups in the groups, group_members and
+// group_roles tables. Its queries run on both SQLite and PostgreSQL.
+type SQLGroupRepository struct {
+	db *sql.DB
+}
+
+// NewSQLGroupRepository returns a GroupRepository backed by db.
+func NewSQLGroupRepository(db *sql.DB) *SQLGroupRepository {
+	return &SQLGroupRepository{db: db}
+}
+
+func (r *SQLGroupRepository) List() ([]models.Group, error) {
+	return database.ListGroups(r.db)
+}
+
+func (r *SQLGroupRepository) Get(id string) (*models.Group, error) {
+	group, err := database.LoadGroup(r.db, id)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return group, err
+}
+
+func (r *SQLGroupRepository) Members(id string) ([]models.GroupMember, error) {
+	return database.GroupMembers(r.db, id)
+}
+
+func (r *SQLGroupRepository) Create(group *models.Group) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkGroupName(tx, group.Name, group.ID); err != nil {
+		return err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	group.CreatedAt, group.UpdatedAt = now, now
+	group.Roles = []string{}
+	group.MemberCount = 0
+	currentTime := database.FormatTime(now)
+	_, err = tx.Exec(
+		"INSERT INTO groups (id, name, description, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+		group.ID, group.Name, group.Description, currentTime, currentTime,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SQLGroupRepository) Update(id, name, description string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkGroupName(tx, name, id); err != nil {
+		return err
+	}
+	err = checkAffected(tx.Exec(
+		"UPDATE groups SET name = ?, description = ?, updated_at = ? WHERE id = ?",
+		name, description, database.FormatTime(time.Now()), id,
+	))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkGroupName returns ErrConflict if a group other than id is named
+// name.
+func checkGroupName(tx *sql.Tx, name, id string) error {
+	var exists bool
+	err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM groups WHERE name = ? AND id != ?)", name, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (r *SQLGroupRepository) Delete(id string, authorize func(*models.Group) error) error {
+	return r.change(id, authorize, func(tx *sql.Tx) error {
+		return database.DeleteGroup(tx, id)
+	})
+}
+
+func (r *SQLGroupRepository) AddMember(id, userID string, authorize func(*models.Group) error) error {
+	return r.change(id, authorize, func(tx *sql.Tx) error {
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)", userID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrUnknownSubject
+		}
+		_, err := tx.Exec(
+			"INSERT INTO group_members (group_id, user_id, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+			id, userID, database.FormatTime(time.Now()),
+		)
+		return err
+	})
+}
+
+func (r *SQLGroupRepository) RemoveMember(id, userID string, authorize func(*models.Group) error) error {
+	return r.change(id, authorize, func(tx *sql.Tx) error {
+		err := checkAffected(tx.Exec("DELETE FROM group_members WHERE group_id = ? AND user_id = ?", id, userID))
+		if err == ErrNotFound {
+			return ErrNotMember
+		}
+		return err
+	})
+}
+
+func (r *SQLGroupRepository) SetRoles(id string, roles []string, authorize func(*models.Group) error) error {
+	return r.change(id, authorize, func(tx *sql.Tx) error {
+		return database.SetGroupRoles(tx, id, roles)
+	})
+}
+
+// change runs apply in a transaction once the group exists and authorize
+// allows the change.
+func (r *SQLGroupRepository) change(id string, authorize func(*models.Group) error, apply func(*sql.Tx) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	group, err := database.LoadGroup(tx, id)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := authorize(group); err != nil {
+		return err
+	}
+	if err := apply(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SQLRoleRepository reads the roles seeded by the database package.
+type SQLRoleRepository struct {
+	db *sql.DB
+}
+
+// NewSQLRoleRepository returns a RoleRepository backed by db.
+func NewSQLRoleRepository(db *sql.DB) *SQLRoleRepository {
+	return &SQLRoleRepository{db: db}
+}
+
+func (r *SQLRoleRepository) List() ([]models.Role, error) {
+	return database.ListRoles(r.db)
+}
@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"database/sql"
+
+	"secure-video-api/internal/jobs"
+)
+
+// SQLJobRepository reads jobs from the jobs table, which the jobs package
+// runs.
+type SQLJobRepository struct {
+	db *sql.DB
+}
+
+// NewSQLJobRepository returns a JobRepository backed by db.
+func NewSQLJobRepository(db *sql.DB) *SQLJobRepository {
+	return &SQLJobRepository{db: db}
+}
+
+func (r *SQLJobRepository) Get(id string) (*jobs.Job, error) {
+	job, err := jobs.Get(r.db, id)
+	if err == jobs.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	return job, err
+}
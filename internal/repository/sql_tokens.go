@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"secure-video-api/internal/database"
+	"secure-video-api/internal/models"
+)
+
+// SQLTokenRepository stores tokens in the refresh_tokens and
+// revoked_tokens tables. Its queries run on both SQLite and PostgreSQL.
+type SQLTokenRepository struct {
+	db *sql.DB
+}
+
+// NewSQLTokenRepository returns a TokenRepository backed by db.
+func NewSQLTokenRepository(db *sql.DB) *SQLTokenRepository {
+	return &SQLTokenRepository{db: db}
+}
+
+func (r *SQLTokenRepository) CreateRefreshToken(token *models.RefreshToken) error {
+	return insertRefreshToken(r.db, token)
+}
+
+func insertRefreshToken(q database.Querier, token *models.RefreshToken) error {
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+	_, err := q.Exec(`
+		INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, token.ID, token.UserID, token.FamilyID, token.TokenHash,
+		database.FormatTime(token.ExpiresAt), database.FormatTime(token.CreatedAt))
+	return err
+}
+
+func (r *SQLTokenRepository) RotateRefreshToken(hash string, next *models.RefreshToken) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id, status string
+	var expires time.Time
+	var revokedAt database.NullTime
+	err = tx.QueryRow(`
+		SELECT t.id, t.user_id, t.family_id, t.expires_at, t.revoked_at, u.status
+		FROM refresh_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ?
+	`, hash).Scan(&id, &next.UserID, &next.FamilyID, (*database.Time)(&expires), &revokedAt, &status)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if revokedAt.Valid {
+		if _, err := tx.Exec(
+			"UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL",
+			database.FormatTime(time.Now()), next.FamilyID,
+		); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: family %s revoked", ErrTokenReused, next.FamilyID)
+	}
+	if time.Now().After(expires) || status == models.UserStatusInactive {
+		return ErrNotFound
+	}
+
+	if err := insertRefreshToken(tx, next); err != nil {
+		return err
+	}
+	// Guard against a concurrent rotation of the same token
+	err = checkAffected(tx.Exec(
+		"UPDATE refresh_tokens SET revoked_at = ?, replaced_by = ? WHERE id = ? AND revoked_at IS NULL",
+		database.FormatTime(time.Now()), next.ID, id,
+	))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SQLTokenRepository) RevokeRefreshFamily(hash, userID string) error {
+	_, err := r.db.Exec(`
+		UPDATE refresh_tokens SET revoked_at = ?
+		WHERE family_id = (SELECT family_id FROM refresh_tokens WHERE token_hash = ? AND user_id = ?)
+		AND revoked_at IS NULL
+	`, database.FormatTime(time.Now()), hash, userID)
+	return err
+}
+
+func (r *SQLTokenRepository) RevokeUserRefreshTokens(userID string) error {
+	_, err := r.db.Exec(
+		"UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL",
+		database.FormatTime(time.Now()), userID,
+	)
+	return err
+}
+
+func (r *SQLTokenRepository) RevokeAccessToken(jti string, expires time.Time) error {
+	if _, err := r.db.Exec(
+		"INSERT INTO revoked_tokens (jti, expires_at) VALUES (?, ?) ON CONFLICT DO NOTHING",
+		jti, database.FormatTime(expires),
+	); err != nil {
+		return err
+	}
+
+	// Expired entries can go, the token itself is no longer accepted
+	_, err := r.db.Exec("DELETE FROM revoked_tokens WHERE expires_at < ?", database.FormatTime(time.Now()))
+	return err
+}
+
+func (r *SQLTokenRepository) AccessTokenRevoked(jti string) (bool, error) {
+	var revoked bool
+	err := r.db.QueryRow("SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = ?)", jti).Scan(&revoked)
+	return revoked, err
+}
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"secure-video-api/internal/database"
+	"secure-video-api/internal/models"
+)
+
+// SQLUploadRepository stores uploads in the uploads table. Its queries run
+// on both SQLite and PostgreSQL.
+type SQLUploadRepository struct {
+	db *sql.DB
+}
+
+// NewSQLUploadRepository returns an UploadRepository backed by db.
+func NewSQLUploadRepository(db *sql.DB) *SQLUploadRepository {
+	return &SQLUploadRepository{db: db}
+}
+
+func (r *SQLUploadRepository) Create(upload *models.Upload) error {
+	now := time.Now().UTC().Truncate(time.Second)
+	upload.CreatedAt, upload.UpdatedAt = now, now
+	currentTime := database.FormatTime(now)
+	_, err := r.db.Exec(`
+		INSERT INTO uploads (id, upload_length, metadata, wrapped_key, key_version, created_by, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, upload.ID, upload.Length, upload.Metadata, upload.WrappedKey, upload.KeyVersion,
+		upload.CreatedBy, currentTime, currentTime)
+	return err
+}
+
+func (r *SQLUploadRepository) Get(id string) (*models.Upload, error) {
+	var upload models.Upload
+	var metadata, videoID sql.NullString
+	err := r.db.QueryRow(`
+		SELECT id, upload_length, upload_offset, staged_size, staged_records, metadata,
+			wrapped_key, key_version, video_id, created_by, created_at, updated_at
+		FROM uploads WHERE id = ?
+	`, id).Scan(&upload.ID, &upload.Length, &upload.Offset, &upload.StagedSize, &upload.StagedRecords,
+		&metadata, &upload.WrappedKey, &upload.KeyVersion, &videoID, &upload.CreatedBy,
+		(*database.Time)(&upload.CreatedAt), (*database.Time)(&upload.UpdatedAt))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	upload.Metadata = metadata.String
+	upload.VideoID = videoID.String
+	return &upload, nil
+}
+
+func (r *SQLUploadRepository) Lease(id, holder string, until time.Time) error {
+	result, err := r.db.Exec(`
+		UPDATE uploads SET lease_holder = ?, lease_until = ?
+		WHERE id = ? AND (lease_holder IS NULL OR lease_until < ?)
+	`, holder, database.FormatTime(until), id, database.FormatTime(time.Now()))
+	return r.checkLeased(id, result, err)
+}
+
+func (r *SQLUploadRepository) RenewLease(id, holder string, until time.Time) error {
+	result, err := r.db.Exec(
+		"UPDATE uploads SET lease_until = ? WHERE id = ? AND lease_holder = ?",
+		database.FormatTime(until), id, holder,
+	)
+	return r.checkLeased(id, result, err)
+}
+
+func (r *SQLUploadRepository) ReleaseLease(id, holder string) error {
+	_, err := r.db.Exec(
+		"UPDATE uploads SET lease_holder = NULL, lease_until = NULL WHERE id = ? AND lease_holder = ?",
+		id, holder,
+	)
+	return err
+}
+
+func (r *SQLUploadRepository) SetProgress(upload *models.Upload, holder string) error {
+	upload.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	result, err := r.db.Exec(`
+		UPDATE uploads
+		SET upload_offset = ?, staged_size = ?, staged_records = ?, updated_at = ?
+		WHERE id = ? AND lease_holder = ?
+	`, upload.Offset, upload.StagedSize, upload.StagedRecords, database.FormatTime(upload.UpdatedAt),
+		upload.ID, holder)
+	return r.checkLeased(upload.ID, result, err)
+}
+
+// checkLeased turns an update of an upload conditional on its lease that
+// matched no rows into ErrLeased, or ErrNotFound if the upload is gone.
+func (r *SQLUploadRepository) checkLeased(id string, result sql.Result, err error) error {
+	if err := checkAffected(result, err); err != ErrNotFound {
+		return err
+	}
+	var exists int
+	err = r.db.QueryRow("SELECT 1 FROM uploads WHERE id = ?", id).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return ErrLeased
+}
+
+func (r *SQLUploadRepository) SetVideoID(id, videoID string) error {
+	return checkAffected(r.db.Exec("UPDATE uploads SET video_id = ? WHERE id = ?", videoID, id))
+}
+
+func (r *SQLUploadRepository) Delete(id string) error {
+	_, err := r.db.Exec("DELETE FROM uploads WHERE id = ?", id)
+	return err
+}
@@ -0,0 +1,273 @@
+// Package storage keeps the files under ENCRYPTED_PATH in step with the
+// database rows that refer to them.
+//
+// Files are journaled in the pending_files table around every change that
+// adds or removes them. A new file is staged before it is moved into place,
+// and the transaction storing the row that refers to it drops the entry
+// (Keep). A file that is no longer needed is released by the transaction
+// removing or replacing its row, which adds an entry (Release). Either way,
+// an entry that remains names a file no committed row refers to: it is
+// removed by Discard when storing the row failed, by Purge once the release
+// has committed, or by Recover after a crash. Removing a file that is already
+// gone is not an error, so every step can be retried.
+//
+// A staged file may take a long time to write, e.g. while a video is
+// re-encrypted, so its entry is leased to the process that staged it, which
+// renews the lease until the file is kept or discarded. Recover leaves the
+// entry alone until the lease lapses, i.e. until that process is gone.
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"secure-video-api/internal/database"
+
+	"github.com/google/uuid"
+)
+
+// Journal actions
+const (
+	actionCreate = "create"
+	actionRemove = "remove"
+)
+
+const (
+	// leaseDuration is how long a staged file's entry lasts without being
+	// renewed; the process that staged it renews it every
+	// leaseRenewInterval
+	leaseDuration      = 10 * time.Minute
+	leaseRenewInterval = 2 * time.Minute
+	// legacyAge is how old an entry journaled before leases existed must be
+	// before Recover acts on it
+	legacyAge        = time.Hour
+	recoveryInterval = 10 * time.Minute
+)
+
+var (
+	// owner identifies this process in the entries it leases
+	owner = newOwner()
+
+	// staging holds the videos this process has staged files for and not
+	// yet kept or discarded; their leases are renewed
+	stagingMu sync.Mutex
+	staging   = make(map[string]bool)
+	renewing  sync.Once
+)
+
+func newOwner() string {
+	host, _ := os.Hostname()
+	return host + "/" + uuid.New().String()
+}
+
+// Dir returns the absolute ENCRYPTED_PATH, creating it if needed.
+func Dir() (string, error) {
+	dir := os.Getenv("ENCRYPTED_PATH")
+	if !filepath.IsAbs(dir) {
+		workDir, err := os.Getwd()
+		if err != nil {
+			return "", fmt.Errorf("failed to get working directory: %v", err)
+		}
+		dir = filepath.Join(workDir, dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create encrypted directory: %v", err)
+	}
+	return dir, nil
+}
+
+// VideoPaths returns the files and directories stored for a video: its
+// encrypted file and its HLS and DASH renditions.
+func VideoPaths(videoID, fileName string) []string {
+	return []string{
+		fileName + ".enc",
+		filepath.Join("hls", videoID),
+		filepath.Join("dash", videoID),
+	}
+}
+
+// Stage records that path, relative to Dir, is about to be created for a
+// video. Call it before the file appears under its final name; the entry is
+// committed at once, so the file is accounted for even if the process dies
+// before its row is stored. The entry stays leased to this process until
+// Keep or Discard is called for the video; the lease is renewed through db.
+func Stage(db *sql.DB, videoID, path string) error {
+	stagingMu.Lock()
+	staging[videoID] = true
+	stagingMu.Unlock()
+	renewing.Do(func() { go renewLeases(db) })
+
+	return journal(db, videoID, actionCreate, path)
+}
+
+// Keep, called within the transaction storing a video's row, marks the
+// files staged for the video as referenced: they are kept if and only if
+// the transaction commits. If it does not, call Discard; otherwise the
+// files are left to Recover once their lease lapses.
+func Keep(q database.Querier, videoID string) error {
+	unstage(videoID)
+	_, err := q.Exec("DELETE FROM pending_files WHERE video_id = ? AND action = ?", videoID, actionCreate)
+	return err
+}
+
+// Discard removes the files staged for a video whose row was not stored.
+// Files kept by a transaction that did commit are left alone.
+func Discard(db *sql.DB, videoID string) error {
+	unstage(videoID)
+	_, err := sweep(db, "video_id = ? AND action = ?", videoID, actionCreate)
+	return err
+}
+
+// unstage stops renewing the leases of a video's staged files.
+func unstage(videoID string) {
+	stagingMu.Lock()
+	delete(staging, videoID)
+	stagingMu.Unlock()
+}
+
+// renewLeases keeps the entries of the files this process is staging from
+// lapsing, for as long as the process runs.
+func renewLeases(db *sql.DB) {
+	for range time.Tick(leaseRenewInterval) {
+		stagingMu.Lock()
+		videoIDs := make([]string, 0, len(staging))
+		for videoID := range staging {
+			videoIDs = append(videoIDs, videoID)
+		}
+		stagingMu.Unlock()
+
+		leaseUntil := database.FormatTime(time.Now().Add(leaseDuration))
+		for _, videoID := range videoIDs {
+			if _, err := db.Exec(
+				"UPDATE pending_files SET lease_until = ? WHERE video_id = ? AND action = ? AND owner = ?",
+				leaseUntil, videoID, actionCreate, owner,
+			); err != nil {
+				log.Printf("[Storage] Error renewing leases of video %s: %v", videoID, err)
+			}
+		}
+	}
+}
+
+// Release, called within the transaction removing or replacing a video's
+// row, schedules paths, relative to Dir, for removal: they are removed by
+// Purge once the transaction has committed, and stay if it does not.
+func Release(q database.Querier, videoID string, paths ...string) error {
+	for _, path := range paths {
+		if err := journal(q, videoID, actionRemove, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Purge removes the files released for a video. Files it fails to remove
+// stay journaled and are retried by Recover.
+func Purge(db *sql.DB, videoID string) error {
+	_, err := sweep(db, "video_id = ? AND action = ?", videoID, actionRemove)
+	return err
+}
+
+// Recover finishes operations interrupted before their files were cleaned
+// up. Released files are unreferenced as soon as their release commits, and
+// a staged file whose lease has lapsed belongs to a process that died
+// before storing or discarding it; either way no row refers to the file. It
+// returns how many entries it cleared.
+func Recover(db *sql.DB) (int, error) {
+	now := time.Now()
+	return sweep(db,
+		"action = ? OR lease_until < ? OR (lease_until IS NULL AND created_at < ?)",
+		actionRemove, database.FormatTime(now), database.FormatTime(now.Add(-legacyAge)),
+	)
+}
+
+// StartRecovery runs Recover now and then periodically until ctx is
+// cancelled.
+func StartRecovery(ctx context.Context, db *sql.DB) {
+	go func() {
+		ticker := time.NewTicker(recoveryInterval)
+		defer ticker.Stop()
+		for {
+			n, err := Recover(db)
+			if err != nil {
+				log.Printf("[Storage] Error recovering pending files: %v", err)
+			} else if n > 0 {
+				log.Printf("[Storage] Removed %d files left by interrupted operations", n)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// journal adds an entry, leased to this process if it is a staged file.
+func journal(q database.Querier, videoID, action, path string) error {
+	if !filepath.IsLocal(path) {
+		return fmt.Errorf("invalid storage path %q", path)
+	}
+	now := time.Now()
+	var leaseOwner, leaseUntil sql.NullString
+	if action == actionCreate {
+		leaseOwner = sql.NullString{String: owner, Valid: true}
+		leaseUntil = sql.NullString{String: database.FormatTime(now.Add(leaseDuration)), Valid: true}
+	}
+	_, err := q.Exec(`
+		INSERT INTO pending_files (id, video_id, action, path, owner, lease_until, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, uuid.New().String(), videoID, action, filepath.ToSlash(path), leaseOwner, leaseUntil, database.FormatTime(now))
+	return err
+}
+
+// sweep removes the files of the entries matching where, dropping each
+// entry once its file is gone, and returns how many it dropped.
+func sweep(db *sql.DB, where string, args ...any) (int, error) {
+	dir, err := Dir()
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := db.Query("SELECT id, path FROM pending_files WHERE "+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	type entry struct{ id, path string }
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.id, &e.path); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	cleared := 0
+	var firstErr error
+	for _, e := range entries {
+		err := os.RemoveAll(filepath.Join(dir, filepath.FromSlash(e.path)))
+		if err == nil {
+			_, err = db.Exec("DELETE FROM pending_files WHERE id = ?", e.id)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to remove %s: %v", e.path, err)
+			}
+			continue
+		}
+		cleared++
+	}
+	return cleared, firstErr
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"secure-video-api/internal/database"
+)
+
+func openTestDB(t *testing.T) string {
+	t.Helper()
+	t.Setenv("DB_DRIVER", database.DriverSQLite)
+	t.Setenv("SQLITE_DB_PATH", filepath.Join(t.TempDir(), "test.db"))
+	t.Setenv("DB_AUTO_MIGRATE", "true")
+	t.Setenv("ENCRYPTED_PATH", t.TempDir())
+	if err := database.InitDB(); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() {
+		database.DB.Close()
+		database.DB = nil
+	})
+	dir, err := Dir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// createFile stages a file for a video and writes it.
+func createFile(t *testing.T, dir, videoID, path string) string {
+	t.Helper()
+	if err := Stage(database.DB, videoID, path); err != nil {
+		t.Fatal(err)
+	}
+	full := filepath.Join(dir, path)
+	if err := os.WriteFile(full, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return full
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestRecover(t *testing.T) {
+	dir := openTestDB(t)
+
+	// A file being written by a live process is left alone, however long
+	// it takes
+	running := createFile(t, dir, "running", "running.enc")
+	old := database.FormatTime(time.Now().Add(-24 * time.Hour))
+	if _, err := database.DB.Exec("UPDATE pending_files SET created_at = ? WHERE video_id = ?", old, "running"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file whose process died stops being renewed and its lease lapses
+	abandoned := createFile(t, dir, "abandoned", "abandoned.enc")
+	lapsed := database.FormatTime(time.Now().Add(-time.Second))
+	if _, err := database.DB.Exec("UPDATE pending_files SET lease_until = ? WHERE video_id = ?", lapsed, "abandoned"); err != nil {
+		t.Fatal(err)
+	}
+	unstage("abandoned")
+
+	// Entries from before leases existed are swept by age
+	legacy := filepath.Join(dir, "legacy.enc")
+	if err := os.WriteFile(legacy, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.DB.Exec(
+		"INSERT INTO pending_files (id, video_id, action, path, created_at) VALUES ('legacy', 'legacy', ?, 'legacy.enc', ?)",
+		actionCreate, old,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// A released file is unreferenced as soon as the release commits
+	released := filepath.Join(dir, "released.enc")
+	if err := os.WriteFile(released, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Release(database.DB, "released", "released.enc"); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := Recover(database.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("Recover cleared %d entries, want 3", n)
+	}
+	if !exists(running) {
+		t.Error("Recover removed a file still being written")
+	}
+	for _, path := range []string{abandoned, legacy, released} {
+		if exists(path) {
+			t.Errorf("Recover left %s", filepath.Base(path))
+		}
+	}
+
+	// Once its row is stored the file is kept and its entry dropped
+	if err := Keep(database.DB, "running"); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(running) {
+		t.Error("Keep removed the kept file")
+	}
+	var pending int
+	if err := database.DB.QueryRow("SELECT COUNT(*) FROM pending_files").Scan(&pending); err != nil {
+		t.Fatal(err)
+	}
+	if pending != 0 {
+		t.Errorf("%d entries left", pending)
+	}
+}
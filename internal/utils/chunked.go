@@ -0,0 +1,396 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// On-disk layout of a chunked encrypted video (format versions 1 and 2):
+//
+//	magic "SVEF" | version (1) | reserved (3) | chunk size (4, big endian) | nonce prefix (7)
+//	chunk 0 | chunk 1 | ... | chunk N-1
+//
+// Every chunk holds ChunkSize bytes of plaintext (the last one may hold
+// fewer) sealed with AES-256-GCM. The nonce of chunk i is the nonce prefix
+// followed by i as a 32-bit big endian counter and a final-chunk flag byte,
+// so any chunk can be located and opened on its own, and a file cut short at
+// a chunk boundary fails authentication on its new last chunk.
+//
+// Version 2 additionally authenticates the video ID, the format version and
+// the chunk index as associated data, binding the ciphertext to the video it
+// belongs to: a file moved under another video's name, or chunks reordered
+// within a file, fail authentication. Version 1 files are still readable.
+//
+// Files written before this format existed ("legacy") start directly with a
+// 12-byte nonce that was reused for every 64KB chunk. They are still readable
+// through the same API.
+const (
+	FormatVersion = 2
+
+	DefaultChunkSize = 64 * 1024
+
+	formatMagic       = "SVEF"
+	noncePrefixSize   = 7
+	headerSize        = len(formatMagic) + 1 + 3 + 4 + noncePrefixSize
+	legacyNonceSize   = 12
+	legacyChunkSize   = 64 * 1024
+	maxChunkSize      = 16 * 1024 * 1024
+	finalChunkFlag    = 0x01
+	nonFinalChunkFlag = 0x00
+)
+
+var (
+	// ErrInvalidFormat is returned when an encrypted file header or layout
+	// cannot be understood.
+	ErrInvalidFormat = errors.New("invalid encrypted file format")
+	// ErrChunkOutOfRange is returned when a chunk index beyond the end of the
+	// file is requested.
+	ErrChunkOutOfRange = errors.New("chunk index out of range")
+	// ErrIntegrity is returned when ciphertext fails authentication: it was
+	// modified, truncated, reordered or belongs to another video.
+	ErrIntegrity = errors.New("encrypted video failed integrity check")
+)
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid key length: got %d bytes, want 32 bytes", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %v", err)
+	}
+	return gcm, nil
+}
+
+// chunkNonce derives the nonce of a chunk from the file's nonce prefix.
+func chunkNonce(prefix []byte, index uint32, final bool) []byte {
+	nonce := make([]byte, 0, noncePrefixSize+5)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, index)
+	if final {
+		return append(nonce, finalChunkFlag)
+	}
+	return append(nonce, nonFinalChunkFlag)
+}
+
+// chunkAAD builds the associated data authenticated with a chunk. Version 1
+// files carry none.
+func chunkAAD(version int, videoID string, index uint32) []byte {
+	if version < 2 {
+		return nil
+	}
+	aad := make([]byte, 0, len(formatMagic)+1+4+len(videoID))
+	aad = append(aad, formatMagic...)
+	aad = append(aad, byte(version))
+	aad = binary.BigEndian.AppendUint32(aad, index)
+	return append(aad, videoID...)
+}
+
+// EncryptWriter is an io.WriteCloser that encrypts everything written to it
+// into the chunked format on the underlying writer. A full chunk is only
+// sealed once more data arrives, so Close can flag the last chunk as final;
+// Close must be called for the output to be complete.
+type EncryptWriter struct {
+	w       io.Writer
+	gcm     cipher.AEAD
+	prefix  []byte
+	videoID string
+
+	buf     []byte
+	out     []byte
+	index   uint32
+	written int64
+	closed  bool
+}
+
+// NewEncryptWriter writes the format header to w and returns a writer that
+// encrypts plaintext bound to videoID with key.
+func NewEncryptWriter(w io.Writer, key []byte, videoID string) (*EncryptWriter, error) {
+	if videoID == "" {
+		return nil, fmt.Errorf("video ID is required for encryption")
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, fmt.Errorf("failed to create nonce prefix: %v", err)
+	}
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, formatMagic...)
+	header = append(header, FormatVersion, 0, 0, 0)
+	header = binary.BigEndian.AppendUint32(header, DefaultChunkSize)
+	header = append(header, prefix...)
+	if _, err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write header: %v", err)
+	}
+
+	return &EncryptWriter{
+		w:       w,
+		gcm:     gcm,
+		prefix:  prefix,
+		videoID: videoID,
+		buf:     make([]byte, 0, DefaultChunkSize),
+		out:     make([]byte, 0, DefaultChunkSize+gcm.Overhead()),
+	}, nil
+}
+
+func (ew *EncryptWriter) Write(p []byte) (int, error) {
+	if ew.closed {
+		return 0, fmt.Errorf("write to closed encrypt writer")
+	}
+
+	var n int
+	for len(p) > 0 {
+		if len(ew.buf) == DefaultChunkSize {
+			// More data follows, so the buffered chunk is not the last one
+			if err := ew.seal(false); err != nil {
+				return n, err
+			}
+		}
+		copied := copy(ew.buf[len(ew.buf):cap(ew.buf)], p)
+		ew.buf = ew.buf[:len(ew.buf)+copied]
+		p = p[copied:]
+		n += copied
+	}
+	return n, nil
+}
+
+// Close seals the buffered data as the final chunk. It does not close the
+// underlying writer.
+func (ew *EncryptWriter) Close() error {
+	if ew.closed {
+		return nil
+	}
+	ew.closed = true
+	return ew.seal(true)
+}
+
+// Written reports the number of plaintext bytes written so far.
+func (ew *EncryptWriter) Written() int64 {
+	return ew.written + int64(len(ew.buf))
+}
+
+func (ew *EncryptWriter) seal(final bool) error {
+	nonce := chunkNonce(ew.prefix, ew.index, final)
+	ew.out = ew.gcm.Seal(ew.out[:0], nonce, ew.buf, chunkAAD(FormatVersion, ew.videoID, ew.index))
+	if _, err := ew.w.Write(ew.out); err != nil {
+		return fmt.Errorf("failed to write encrypted data: %v", err)
+	}
+	ew.written += int64(len(ew.buf))
+	ew.buf = ew.buf[:0]
+
+	if !final {
+		if ew.index == ^uint32(0) {
+			return fmt.Errorf("input too large: chunk counter exhausted")
+		}
+		ew.index++
+	}
+	return nil
+}
+
+// encryptStream reads plaintext from r and writes it to w in the chunked
+// format bound to videoID, returning the number of plaintext bytes consumed.
+func encryptStream(r io.Reader, w io.Writer, key []byte, videoID string) (int64, error) {
+	ew, err := NewEncryptWriter(w, key, videoID)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(ew, r); err != nil {
+		return ew.Written(), fmt.Errorf("failed to encrypt input: %v", err)
+	}
+	if err := ew.Close(); err != nil {
+		return ew.Written(), err
+	}
+	return ew.Written(), nil
+}
+
+// EncryptedFile gives random access to the chunks of an encrypted video.
+type EncryptedFile struct {
+	file       *os.File
+	gcm        cipher.AEAD
+	version    int
+	chunkSize  int64
+	nonce      []byte // nonce prefix, or the shared nonce of a legacy file
+	dataOffset int64
+	size       int64
+	chunks     int64
+	lastChunk  int64 // sealed length of the final chunk
+	videoID    string
+}
+
+// OpenEncryptedFile opens the encrypted file of the video with the given ID
+// in either the chunked or the legacy format. Only version 2 files are bound
+// to the video ID.
+func OpenEncryptedFile(path string, key []byte, videoID string) (*EncryptedFile, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open encrypted file %s: %v", path, err)
+	}
+
+	ef, err := parseEncryptedFile(file, gcm)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	ef.videoID = videoID
+	return ef, nil
+}
+
+func parseEncryptedFile(file *os.File, gcm cipher.AEAD) (*EncryptedFile, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat encrypted file: %v", err)
+	}
+	fileSize := info.Size()
+	overhead := int64(gcm.Overhead())
+
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, fmt.Errorf("failed to read header: %v", err)
+	}
+	header = header[:n]
+
+	ef := &EncryptedFile{file: file, gcm: gcm}
+
+	if len(header) == headerSize && bytes.Equal(header[:len(formatMagic)], []byte(formatMagic)) {
+		ef.version = int(header[len(formatMagic)])
+		if ef.version < 1 || ef.version > FormatVersion {
+			return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidFormat, ef.version)
+		}
+		ef.chunkSize = int64(binary.BigEndian.Uint32(header[8:12]))
+		if ef.chunkSize == 0 || ef.chunkSize > maxChunkSize {
+			return nil, fmt.Errorf("%w: bad chunk size %d", ErrInvalidFormat, ef.chunkSize)
+		}
+		ef.nonce = append([]byte(nil), header[12:headerSize]...)
+		ef.dataOffset = int64(headerSize)
+
+		dataLen := fileSize - ef.dataOffset
+		sealed := ef.chunkSize + overhead
+		if dataLen < overhead {
+			return nil, fmt.Errorf("%w: file is truncated", ErrIntegrity)
+		}
+		ef.chunks = (dataLen + sealed - 1) / sealed
+		ef.lastChunk = dataLen - (ef.chunks-1)*sealed
+		if ef.lastChunk < overhead {
+			return nil, fmt.Errorf("%w: file is truncated", ErrIntegrity)
+		}
+		if ef.chunks-1 > int64(^uint32(0)) {
+			return nil, fmt.Errorf("%w: too many chunks", ErrInvalidFormat)
+		}
+		ef.size = dataLen - ef.chunks*overhead
+		return ef, nil
+	}
+
+	// Legacy layout: a shared nonce followed by 64KB chunks.
+	if fileSize < legacyNonceSize {
+		return nil, fmt.Errorf("%w: file is truncated", ErrInvalidFormat)
+	}
+	ef.version = 0
+	ef.chunkSize = legacyChunkSize
+	ef.nonce = make([]byte, legacyNonceSize)
+	if _, err := file.ReadAt(ef.nonce, 0); err != nil {
+		return nil, fmt.Errorf("failed to read nonce: %v", err)
+	}
+	ef.dataOffset = legacyNonceSize
+
+	dataLen := fileSize - ef.dataOffset
+	sealed := ef.chunkSize + overhead
+	ef.chunks = (dataLen + sealed - 1) / sealed
+	if ef.chunks > 0 {
+		ef.lastChunk = dataLen - (ef.chunks-1)*sealed
+		if ef.lastChunk <= overhead {
+			return nil, fmt.Errorf("%w: file is truncated", ErrInvalidFormat)
+		}
+	}
+	ef.size = dataLen - ef.chunks*overhead
+	return ef, nil
+}
+
+// Version reports the format version of the file, 0 for legacy files.
+func (ef *EncryptedFile) Version() int { return ef.version }
+
+// Size reports the plaintext size of the file.
+func (ef *EncryptedFile) Size() int64 { return ef.size }
+
+// ChunkSize reports the number of plaintext bytes held by every chunk but
+// the last.
+func (ef *EncryptedFile) ChunkSize() int64 { return ef.chunkSize }
+
+// ChunkCount reports the number of chunks in the file.
+func (ef *EncryptedFile) ChunkCount() int64 { return ef.chunks }
+
+// ReadChunk decrypts chunk index, appending the plaintext to dst.
+func (ef *EncryptedFile) ReadChunk(index int64, dst []byte) ([]byte, error) {
+	if index < 0 || index >= ef.chunks {
+		return nil, ErrChunkOutOfRange
+	}
+
+	sealedSize := ef.chunkSize + int64(ef.gcm.Overhead())
+	length := sealedSize
+	if index == ef.chunks-1 {
+		length = ef.lastChunk
+	}
+
+	buf := make([]byte, length)
+	if _, err := ef.file.ReadAt(buf, ef.dataOffset+index*sealedSize); err != nil {
+		return nil, fmt.Errorf("failed to read chunk %d: %v", index, err)
+	}
+
+	nonce := ef.nonce
+	var aad []byte
+	if ef.version != 0 {
+		nonce = chunkNonce(ef.nonce, uint32(index), index == ef.chunks-1)
+		aad = chunkAAD(ef.version, ef.videoID, uint32(index))
+	}
+
+	plaintext, err := ef.gcm.Open(dst, nonce, buf, aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: chunk %d: %v", ErrIntegrity, index, err)
+	}
+	return plaintext, nil
+}
+
+// Verify authenticates the first and the last chunk. This cheaply catches
+// files that belong to another video or were truncated, before any of the
+// content is served; damage elsewhere is caught when that chunk is read.
+func (ef *EncryptedFile) Verify() error {
+	if ef.chunks == 0 {
+		return nil
+	}
+	buf := make([]byte, 0, ef.chunkSize)
+	if _, err := ef.ReadChunk(0, buf); err != nil {
+		return err
+	}
+	if ef.chunks > 1 {
+		if _, err := ef.ReadChunk(ef.chunks-1, buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the underlying file.
+func (ef *EncryptedFile) Close() error {
+	return ef.file.Close()
+}
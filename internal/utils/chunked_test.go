@@ -0,0 +1,334 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// encryptToFile encrypts plaintext for a video in the current format and
+// returns the path of the encrypted file.
+func encryptToFile(t *testing.T, plaintext, key []byte, videoID string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := encryptStream(bytes.NewReader(plaintext), &buf, key, videoID); err != nil {
+		t.Fatal(err)
+	}
+	return writeFile(t, buf.Bytes())
+}
+
+func writeFile(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "video.enc")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// decryptAll opens an encrypted file and decrypts every chunk.
+func decryptAll(t *testing.T, path string, key []byte, videoID string) ([]byte, error) {
+	t.Helper()
+	ef, err := OpenEncryptedFile(path, key, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer ef.Close()
+	var plaintext []byte
+	for i := int64(0); i < ef.ChunkCount(); i++ {
+		if plaintext, err = ef.ReadChunk(i, plaintext); err != nil {
+			return nil, err
+		}
+	}
+	return plaintext, nil
+}
+
+// sealedChunk returns the bounds of chunk i in a chunked file with the
+// default chunk size.
+func sealedChunk(i int) (start, end int) {
+	sealed := DefaultChunkSize + 16
+	return headerSize + i*sealed, headerSize + (i+1)*sealed
+}
+
+func TestEncryptedFileRoundTrip(t *testing.T) {
+	key := testKey(t)
+	tests := []struct {
+		name   string
+		size   int
+		chunks int64
+	}{
+		{"empty", 0, 1},
+		{"shorter than a chunk", 100, 1},
+		{"exactly one chunk", DefaultChunkSize, 1},
+		{"one byte over a chunk", DefaultChunkSize + 1, 2},
+		{"multi-chunk tail", 3*DefaultChunkSize + 1234, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext := randomBytes(t, tt.size)
+			path := encryptToFile(t, plaintext, key, "video")
+
+			ef, err := OpenEncryptedFile(path, key, "video")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ef.Close()
+			if ef.Version() != FormatVersion || ef.Size() != int64(tt.size) || ef.ChunkCount() != tt.chunks {
+				t.Errorf("version %d, size %d, %d chunks; want %d, %d, %d",
+					ef.Version(), ef.Size(), ef.ChunkCount(), FormatVersion, tt.size, tt.chunks)
+			}
+			if err := ef.Verify(); err != nil {
+				t.Errorf("Verify: %v", err)
+			}
+
+			got, err := decryptAll(t, path, key, "video")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Error("decrypted plaintext differs")
+			}
+		})
+	}
+}
+
+func TestEncryptedFileTampering(t *testing.T) {
+	key := testKey(t)
+	encrypted := readFile(t, encryptToFile(t, randomBytes(t, 3*DefaultChunkSize+500), key, "video"))
+	chunk := func(i int) []byte {
+		start, end := sealedChunk(i)
+		return encrypted[start:end]
+	}
+	splice := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	_, lastStart := sealedChunk(2)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		// Cut at a chunk boundary, so the new last chunk is complete but
+		// lacks the final flag
+		{"truncated at a chunk boundary", encrypted[:lastStart]},
+		{"truncated mid-chunk", encrypted[:len(encrypted)-10]},
+		{"chunks reordered", splice(encrypted[:headerSize], chunk(1), chunk(0), chunk(2), encrypted[lastStart:])},
+		{"chunk duplicated", splice(encrypted[:headerSize], chunk(0), chunk(0), chunk(2), encrypted[lastStart:])},
+		{"chunk dropped", splice(encrypted[:headerSize], chunk(0), chunk(2), encrypted[lastStart:])},
+		{"tag flipped", splice(encrypted[:len(encrypted)-1], []byte{encrypted[len(encrypted)-1] ^ 1})},
+		{"ciphertext flipped", splice(encrypted[:headerSize+10], []byte{encrypted[headerSize+10] ^ 1}, encrypted[headerSize+11:])},
+		{"nonce prefix changed", splice(encrypted[:12], []byte{encrypted[12] ^ 1}, encrypted[13:])},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decryptAll(t, writeFile(t, tt.data), key, "video"); !errors.Is(err, ErrIntegrity) {
+				t.Errorf("got %v, want ErrIntegrity", err)
+			}
+		})
+	}
+
+	// Truncation is caught by Verify alone, before anything is served
+	ef, err := OpenEncryptedFile(writeFile(t, encrypted[:lastStart]), key, "video")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ef.Close()
+	if err := ef.Verify(); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("Verify of a truncated file: got %v, want ErrIntegrity", err)
+	}
+}
+
+func TestEncryptedFileInvalidHeader(t *testing.T) {
+	key := testKey(t)
+	encrypted := readFile(t, encryptToFile(t, []byte("plaintext"), key, "video"))
+
+	unsupported := bytes.Clone(encrypted)
+	unsupported[len(formatMagic)] = FormatVersion + 1
+	if _, err := OpenEncryptedFile(writeFile(t, unsupported), key, "video"); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("unsupported version: got %v, want ErrInvalidFormat", err)
+	}
+	badChunkSize := bytes.Clone(encrypted)
+	binary.BigEndian.PutUint32(badChunkSize[8:12], 0)
+	if _, err := OpenEncryptedFile(writeFile(t, badChunkSize), key, "video"); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("zero chunk size: got %v, want ErrInvalidFormat", err)
+	}
+	if _, err := OpenEncryptedFile(writeFile(t, encrypted[:headerSize+5]), key, "video"); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("header without a chunk: got %v, want ErrIntegrity", err)
+	}
+}
+
+// writeLegacyFile encrypts plaintext as files were before the chunked
+// format: one 12-byte nonce reused for every 64KB chunk.
+func writeLegacyFile(t *testing.T, plaintext, key []byte) string {
+	t.Helper()
+	gcm, err := newGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := randomBytes(t, legacyNonceSize)
+	data := bytes.Clone(nonce)
+	for len(plaintext) > 0 {
+		n := min(len(plaintext), legacyChunkSize)
+		data = gcm.Seal(data, nonce, plaintext[:n], nil)
+		plaintext = plaintext[n:]
+	}
+	return writeFile(t, data)
+}
+
+func TestLegacyEncryptedFile(t *testing.T) {
+	key := testKey(t)
+	plaintext := randomBytes(t, 2*legacyChunkSize+77)
+	path := writeLegacyFile(t, plaintext, key)
+
+	ef, err := OpenEncryptedFile(path, key, "video")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ef.Close()
+	if ef.Version() != 0 || ef.Size() != int64(len(plaintext)) || ef.ChunkCount() != 3 {
+		t.Errorf("version %d, size %d, %d chunks", ef.Version(), ef.Size(), ef.ChunkCount())
+	}
+	got, err := decryptAll(t, path, key, "video")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Error("decrypted legacy plaintext differs")
+	}
+
+	legacy := readFile(t, path)
+	legacy[len(legacy)-1] ^= 1
+	if _, err := decryptAll(t, writeFile(t, legacy), key, "video"); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("tampered legacy file: got %v, want ErrIntegrity", err)
+	}
+}
+
+// writeV1File encrypts plaintext in format version 1, which authenticated
+// no associated data.
+func writeV1File(t *testing.T, plaintext, key []byte) string {
+	t.Helper()
+	gcm, err := newGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := randomBytes(t, noncePrefixSize)
+	data := []byte(formatMagic)
+	data = append(data, 1, 0, 0, 0)
+	data = binary.BigEndian.AppendUint32(data, DefaultChunkSize)
+	data = append(data, prefix...)
+	for i := uint32(0); ; i++ {
+		n := min(len(plaintext), DefaultChunkSize)
+		final := n == len(plaintext)
+		data = gcm.Seal(data, chunkNonce(prefix, i, final), plaintext[:n], nil)
+		plaintext = plaintext[n:]
+		if final {
+			return writeFile(t, data)
+		}
+	}
+}
+
+// encryptReusingHeader encrypts plaintext for a video under the header, and
+// so the nonce prefix, of another file, so that its chunks differ from that
+// file's only in their associated data.
+func encryptReusingHeader(t *testing.T, plaintext, key []byte, videoID string, header []byte) []byte {
+	t.Helper()
+	ew, err := NewEncryptWriter(io.Discard, key, videoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	buf.Write(header[:headerSize])
+	ew.w, ew.prefix = &buf, header[12:headerSize]
+	if _, err := ew.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err := ew.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncryptedFileBoundToVideo(t *testing.T) {
+	// The same key on both videos, so only the binding tells them apart
+	key := testKey(t)
+	a := readFile(t, encryptToFile(t, randomBytes(t, 2*DefaultChunkSize+10), key, "video-a"))
+	b := encryptReusingHeader(t, randomBytes(t, 2*DefaultChunkSize+10), key, "video-b", a)
+
+	// Video A's file stored under video B's ID
+	moved := writeFile(t, a)
+	if _, err := decryptAll(t, moved, key, "video-b"); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("file of another video: got %v, want ErrIntegrity", err)
+	}
+	ef, err := OpenEncryptedFile(moved, key, "video-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ef.Close()
+	if err := ef.Verify(); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("Verify of another video's file: got %v, want ErrIntegrity", err)
+	}
+
+	// A chunk of video A spliced into video B's file at the same index: its
+	// nonce is the one B's chunk had, yet it does not open as B's
+	start, end := sealedChunk(1)
+	spliced := bytes.Join([][]byte{b[:start], a[start:end], b[end:]}, nil)
+	ef, err = OpenEncryptedFile(writeFile(t, spliced), key, "video-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ef.Close()
+	for i := int64(0); i < ef.ChunkCount(); i++ {
+		_, err := ef.ReadChunk(i, nil)
+		if i == 1 && !errors.Is(err, ErrIntegrity) {
+			t.Errorf("chunk of another video: got %v, want ErrIntegrity", err)
+		}
+		if i != 1 && err != nil {
+			t.Errorf("chunk %d of the video itself: %v", i, err)
+		}
+	}
+}
+
+func TestVersion1EncryptedFile(t *testing.T) {
+	key := testKey(t)
+	plaintext := randomBytes(t, DefaultChunkSize+99)
+	path := writeV1File(t, plaintext, key)
+
+	// Version 1 files predate the binding and open under any video ID
+	ef, err := OpenEncryptedFile(path, key, "video")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ef.Close()
+	if ef.Version() != 1 || ef.Size() != int64(len(plaintext)) {
+		t.Errorf("version %d, size %d", ef.Version(), ef.Size())
+	}
+	if err := ef.Verify(); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	got, err := decryptAll(t, path, key, "video")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Error("decrypted version 1 plaintext differs")
+	}
+
+	// They still fail on truncation
+	start, _ := sealedChunk(1)
+	if _, err := decryptAll(t, writeFile(t, readFile(t, path)[:start]), key, "video"); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("truncated version 1 file: got %v, want ErrIntegrity", err)
+	}
+}
@@ -1,9 +1,6 @@
 package utils
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
 	"fmt"
 	"io"
 	"log"
@@ -100,7 +97,43 @@ func createTempFile(prefix string) (string, error) {
 	return tmpPath, nil
 }
 
-func EncryptFile(inputPath, outputPath string, key []byte) error {
+// EncryptReader encrypts everything read from r into outputPath, bound to
+// the given video. The
+// ciphertext is written next to outputPath first and moved into place once
+// complete, so an existing file is only replaced by a finished one.
+func EncryptReader(r io.Reader, outputPath string, key []byte, videoID string) error {
+	if !filepath.IsAbs(outputPath) {
+		return fmt.Errorf("output path must be absolute: %s", outputPath)
+	}
+	if err := validateFileAndPermissions(outputPath, true); err != nil {
+		return fmt.Errorf("output validation error: %v", err)
+	}
+
+	outFile, err := os.CreateTemp(filepath.Dir(outputPath), ".encrypt-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	tempOutput := outFile.Name()
+	defer os.Remove(tempOutput)
+	defer outFile.Close()
+
+	if _, err := encryptStream(r, outFile, key, videoID); err != nil {
+		return err
+	}
+	if err := outFile.Chmod(0644); err != nil {
+		return fmt.Errorf("failed to set permissions on output file: %v", err)
+	}
+	if err := outFile.Close(); err != nil {
+		return fmt.Errorf("failed to finish encrypted file: %v", err)
+	}
+
+	if err := os.Rename(tempOutput, outputPath); err != nil {
+		return fmt.Errorf("failed to move encrypted file to final location: %v", err)
+	}
+	return nil
+}
+
+func EncryptFile(inputPath, outputPath string, key []byte, videoID string) error {
 	cleanup := NewFileCleanup()
 	defer cleanup.Cleanup()
 
@@ -166,47 +199,12 @@ func EncryptFile(inputPath, outputPath string, key []byte) error {
 		return fmt.Errorf("failed to set permissions on output file: %v", err)
 	}
 
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return fmt.Errorf("failed to create cipher: %v", err)
+	// Encrypt the file in independently sealed chunks
+	if _, err := encryptStream(inFile, outFile, key, videoID); err != nil {
+		return err
 	}
-
-	// Create the GCM mode
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return fmt.Errorf("failed to create GCM: %v", err)
-	}
-
-	// Create and write nonce
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return fmt.Errorf("failed to create nonce: %v", err)
-	}
-	if _, err := outFile.Write(nonce); err != nil {
-		return fmt.Errorf("failed to write nonce: %v", err)
-	}
-
-	// Create a buffer for reading chunks
-	const chunkSize = 64 * 1024 // 64KB chunks
-	buf := make([]byte, chunkSize)
-
-	// Read and encrypt file in chunks
-	for {
-		n, err := inFile.Read(buf)
-		if err != nil && err != io.EOF {
-			return fmt.Errorf("failed to read input file: %v", err)
-		}
-		if n == 0 {
-			break
-		}
-
-		// Encrypt chunk
-		ciphertext := gcm.Seal(nil, nonce, buf[:n], nil)
-
-		// Write encrypted chunk
-		if _, err := outFile.Write(ciphertext); err != nil {
-			return fmt.Errorf("failed to write encrypted data: %v", err)
-		}
+	if err := outFile.Close(); err != nil {
+		return fmt.Errorf("failed to finish encrypted file: %v", err)
 	}
 
 	// Use atomic rename for final move
@@ -227,7 +225,7 @@ func EncryptFile(inputPath, outputPath string, key []byte) error {
 	return nil
 }
 
-func DecryptFile(inputPath, outputPath string, key []byte) error {
+func DecryptFile(inputPath, outputPath string, key []byte, videoID string) error {
 	cleanup := NewFileCleanup()
 	defer cleanup.Cleanup()
 
@@ -253,13 +251,12 @@ func DecryptFile(inputPath, outputPath string, key []byte) error {
 		return fmt.Errorf("output validation error: %v", err)
 	}
 
-	// Open input file with explicit permissions
-	inFile, err := os.OpenFile(inputPath, os.O_RDONLY, 0644)
+	// Open input file; both the chunked and the legacy format are accepted
+	encFile, err := OpenEncryptedFile(inputPath, key, videoID)
 	if err != nil {
-		return fmt.Errorf("failed to open encrypted file %s: %v (permissions: %s)",
-			inputPath, err, getFilePermissions(inputPath))
+		return fmt.Errorf("%v (permissions: %s)", err, getFilePermissions(inputPath))
 	}
-	defer inFile.Close()
+	defer encFile.Close()
 
 	// Create output file with explicit permissions
 	outFile, err := os.OpenFile(tempOutput, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
@@ -274,42 +271,12 @@ func DecryptFile(inputPath, outputPath string, key []byte) error {
 		return fmt.Errorf("failed to set permissions on output file: %v", err)
 	}
 
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return fmt.Errorf("failed to create cipher: %v", err)
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return fmt.Errorf("failed to create GCM: %v", err)
-	}
-
-	nonceSize := gcm.NonceSize()
-	nonce := make([]byte, nonceSize)
-
-	// Read the nonce
-	if _, err := io.ReadFull(inFile, nonce); err != nil {
-		return fmt.Errorf("failed to read nonce: %v", err)
-	}
-
-	// Create a buffer for reading chunks
-	const chunkSize = 64 * 1024 // 64KB chunks
-	buf := make([]byte, chunkSize+gcm.Overhead())
-
-	// Read and decrypt file in chunks
-	for {
-		n, err := inFile.Read(buf)
-		if err != nil && err != io.EOF {
-			return fmt.Errorf("failed to read encrypted file: %v", err)
-		}
-		if n == 0 {
-			break
-		}
-
-		// Decrypt chunk
-		plaintext, err := gcm.Open(nil, nonce, buf[:n], nil)
+	// Decrypt file chunk by chunk
+	buf := make([]byte, 0, encFile.ChunkSize())
+	for i := int64(0); i < encFile.ChunkCount(); i++ {
+		plaintext, err := encFile.ReadChunk(i, buf[:0])
 		if err != nil {
-			return fmt.Errorf("failed to decrypt data: %v", err)
+			return err
 		}
 
 		// Write decrypted chunk
@@ -317,6 +284,9 @@ func DecryptFile(inputPath, outputPath string, key []byte) error {
 			return fmt.Errorf("failed to write decrypted data: %v", err)
 		}
 	}
+	if err := outFile.Close(); err != nil {
+		return fmt.Errorf("failed to finish decrypted file: %v", err)
+	}
 
 	// Use atomic rename for final move
 	if err := os.Rename(tempOutput, outputPath); err != nil {
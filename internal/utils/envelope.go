@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+// DataKeySize is the size of the per-video data encryption keys.
+const DataKeySize = 32
+
+// GenerateDataKey returns a new random data encryption key.
+func GenerateDataKey() ([]byte, error) {
+	key := make([]byte, DataKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %v", err)
+	}
+	return key, nil
+}
+
+// WrapKey encrypts a data key with a key-encryption key. The result holds
+// the random nonce followed by the sealed key and is safe to store.
+func WrapKey(kek, dataKey []byte) ([]byte, error) {
+	return WrapSecret(kek, dataKey)
+}
+
+// UnwrapKey recovers a data key wrapped by WrapKey.
+func UnwrapKey(kek, wrapped []byte) ([]byte, error) {
+	dataKey, err := UnwrapSecret(kek, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %v", err)
+	}
+	if len(dataKey) != DataKeySize {
+		return nil, fmt.Errorf("invalid data key length: %d bytes", len(dataKey))
+	}
+	return dataKey, nil
+}
+
+// WrapSecret seals an arbitrary secret with a 32-byte key, prefixing the
+// random nonce.
+func WrapSecret(kek, secret []byte) ([]byte, error) {
+	gcm, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to create nonce: %v", err)
+	}
+	return gcm.Seal(nonce, nonce, secret, nil), nil
+}
+
+// UnwrapSecret opens a secret sealed by WrapSecret.
+func UnwrapSecret(kek, wrapped []byte) ([]byte, error) {
+	gcm, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrapped) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, fmt.Errorf("wrapped secret too short: %d bytes", len(wrapped))
+	}
+	nonce, sealed := wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, nil)
+}
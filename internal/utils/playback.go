@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// CheckPlaybackSecret reports whether PLAYBACK_URL_SECRET is usable. The
+// server refuses to start otherwise: the secret is never derived from
+// another setting, which may be empty or published.
+func CheckPlaybackSecret() error {
+	secret := os.Getenv("PLAYBACK_URL_SECRET")
+	if secret == "" {
+		return errors.New("PLAYBACK_URL_SECRET is not set")
+	}
+	if secret == os.Getenv("JWT_SECRET") {
+		return errors.New("PLAYBACK_URL_SECRET must differ from JWT_SECRET")
+	}
+	return nil
+}
+
+// playbackSecret returns the key signing playback URLs.
+func playbackSecret() []byte {
+	return []byte(os.Getenv("PLAYBACK_URL_SECRET"))
+}
+
+// SignPlayback returns the signature of a playback URL letting userID stream
+// videoID until the Unix time expires. A non-empty clientIP binds the URL to
+// that address.
+func SignPlayback(videoID, userID string, expires int64, clientIP string) string {
+	mac := hmac.New(sha256.New, playbackSecret())
+	mac.Write([]byte(strings.Join([]string{
+		"v1", videoID, userID, strconv.FormatInt(expires, 10), clientIP,
+	}, "\n")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyPlayback reports whether signature was issued by SignPlayback for
+// the same parameters. Expiry is left to the caller.
+func VerifyPlayback(videoID, userID string, expires int64, clientIP, signature string) bool {
+	if len(playbackSecret()) == 0 {
+		return false
+	}
+	expected := SignPlayback(videoID, userID, expires, clientIP)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DecryptingReader is an io.ReadSeeker over the plaintext of an encrypted
+// video. Chunks are decrypted on demand, so reading a range only touches the
+// chunks that overlap it and no plaintext is ever written to disk.
+type DecryptingReader struct {
+	file   *EncryptedFile
+	offset int64
+
+	chunkIndex int64 // index of the chunk held in buf, -1 if none
+	buf        []byte
+}
+
+// NewDecryptingReader opens the encrypted file of the given video for random
+// access reads of its plaintext. The caller must Close the reader.
+func NewDecryptingReader(path string, key []byte, videoID string) (*DecryptingReader, error) {
+	file, err := OpenEncryptedFile(path, key, videoID)
+	if err != nil {
+		return nil, err
+	}
+	return &DecryptingReader{
+		file:       file,
+		chunkIndex: -1,
+		buf:        make([]byte, 0, file.ChunkSize()),
+	}, nil
+}
+
+// Size reports the plaintext size of the video.
+func (r *DecryptingReader) Size() int64 {
+	return r.file.Size()
+}
+
+func (r *DecryptingReader) Read(p []byte) (int, error) {
+	if r.offset >= r.file.Size() {
+		return 0, io.EOF
+	}
+
+	var n int
+	for n < len(p) && r.offset < r.file.Size() {
+		index := r.offset / r.file.ChunkSize()
+		if err := r.loadChunk(index); err != nil {
+			return n, err
+		}
+
+		copied := copy(p[n:], r.buf[r.offset-index*r.file.ChunkSize():])
+		n += copied
+		r.offset += int64(copied)
+	}
+	return n, nil
+}
+
+func (r *DecryptingReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.offset + offset
+	case io.SeekEnd:
+		abs = r.file.Size() + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if abs < 0 {
+		return 0, fmt.Errorf("negative position: %d", abs)
+	}
+	r.offset = abs
+	return abs, nil
+}
+
+// Verify checks that the file belongs to the video and is complete; see
+// EncryptedFile.Verify.
+func (r *DecryptingReader) Verify() error {
+	return r.file.Verify()
+}
+
+// Close releases the underlying encrypted file.
+func (r *DecryptingReader) Close() error {
+	return r.file.Close()
+}
+
+func (r *DecryptingReader) loadChunk(index int64) error {
+	if index == r.chunkIndex {
+		return nil
+	}
+	plaintext, err := r.file.ReadChunk(index, r.buf[:0])
+	if err != nil {
+		r.chunkIndex = -1
+		return err
+	}
+	r.buf = plaintext
+	r.chunkIndex = index
+	return nil
+}
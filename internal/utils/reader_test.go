@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func openReader(t *testing.T, path string, key []byte, videoID string) *DecryptingReader {
+	t.Helper()
+	r, err := NewDecryptingReader(path, key, videoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestDecryptingReaderRanges(t *testing.T) {
+	key := testKey(t)
+	const chunk = DefaultChunkSize
+	plaintext := randomBytes(t, 3*chunk+1000)
+	size := int64(len(plaintext))
+	r := openReader(t, encryptToFile(t, plaintext, key, "video"), key, "video")
+	if r.Size() != size {
+		t.Fatalf("Size = %d, want %d", r.Size(), size)
+	}
+
+	tests := []struct {
+		name   string
+		offset int64
+		length int
+		want   int // bytes read before EOF
+	}{
+		{"start of file", 0, 100, 100},
+		{"whole first chunk", 0, chunk, chunk},
+		{"start of a chunk", chunk, 100, 100},
+		{"last byte of a chunk", chunk - 1, 1, 1},
+		{"end of a chunk into the next", chunk - 10, 20, 20},
+		{"across two boundaries", chunk - 1, chunk + 2, chunk + 2},
+		{"start of the last partial chunk", 3 * chunk, 1000, 1000},
+		{"into the last partial chunk", 3*chunk - 5, 500, 500},
+		{"past the end of the last chunk", size - 10, 100, 10},
+		{"last byte", size - 1, 1, 1},
+		{"at EOF", size, 10, 0},
+		{"past EOF", size + chunk, 10, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := r.Seek(tt.offset, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(io.LimitReader(r, int64(tt.length)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tt.want {
+				t.Fatalf("read %d bytes, want %d", len(got), tt.want)
+			}
+			if tt.want > 0 && !bytes.Equal(got, plaintext[tt.offset:tt.offset+int64(tt.want)]) {
+				t.Error("read the wrong bytes")
+			}
+			if n, err := r.Read(make([]byte, 1)); tt.want < tt.length && (n != 0 || err != io.EOF) {
+				t.Errorf("read after the end = %d, %v; want 0, EOF", n, err)
+			}
+		})
+	}
+}
+
+func TestDecryptingReaderSeek(t *testing.T) {
+	key := testKey(t)
+	plaintext := randomBytes(t, 2*DefaultChunkSize+10)
+	size := int64(len(plaintext))
+	r := openReader(t, encryptToFile(t, plaintext, key, "video"), key, "video")
+
+	tests := []struct {
+		name   string
+		offset int64
+		whence int
+		want   int64
+	}{
+		{"from start", DefaultChunkSize, io.SeekStart, DefaultChunkSize},
+		{"forward from current", 5, io.SeekCurrent, DefaultChunkSize + 5},
+		{"back from current", -10, io.SeekCurrent, DefaultChunkSize - 5},
+		{"from end", -3, io.SeekEnd, size - 3},
+		{"past the end", 7, io.SeekEnd, size + 7},
+	}
+	for _, tt := range tests {
+		pos, err := r.Seek(tt.offset, tt.whence)
+		if err != nil || pos != tt.want {
+			t.Errorf("%s: Seek = %d, %v; want %d", tt.name, pos, err, tt.want)
+		}
+	}
+
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Error("seek before the start accepted")
+	}
+	if _, err := r.Seek(0, 42); err == nil {
+		t.Error("invalid whence accepted")
+	}
+
+	// Reading from the end backwards across a chunk boundary
+	if _, err := r.Seek(-(DefaultChunkSize + 20), io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 40)
+	if _, err := io.ReadFull(r, got); err != nil {
+		t.Fatal(err)
+	}
+	if want := plaintext[size-DefaultChunkSize-20 : size-DefaultChunkSize+20]; !bytes.Equal(got, want) {
+		t.Error("read the wrong bytes after seeking from the end")
+	}
+}
+
+func TestDecryptingReaderVerify(t *testing.T) {
+	key := testKey(t)
+	path := encryptToFile(t, randomBytes(t, 3*DefaultChunkSize), key, "video")
+	if err := openReader(t, path, key, "video").Verify(); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if err := openReader(t, path, key, "other").Verify(); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("Verify under another video: got %v, want ErrIntegrity", err)
+	}
+
+	// Damage in the middle is not caught by Verify, but by the read that
+	// reaches it, which fails without returning the damaged chunk
+	damaged := readFile(t, path)
+	start, _ := sealedChunk(1)
+	damaged[start+100] ^= 1
+	r := openReader(t, writeFile(t, damaged), key, "video")
+	if err := r.Verify(); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if _, err := r.Seek(DefaultChunkSize-10, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 20)
+	n, err := r.Read(buf)
+	if !errors.Is(err, ErrIntegrity) || n != 10 {
+		t.Errorf("read into a damaged chunk = %d, %v; want the 10 bytes before it and ErrIntegrity", n, err)
+	}
+
+	truncated := readFile(t, path)
+	start, _ = sealedChunk(2)
+	r = openReader(t, writeFile(t, truncated[:start]), key, "video")
+	if err := r.Verify(); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("Verify of a truncated file: got %v, want ErrIntegrity", err)
+	}
+}
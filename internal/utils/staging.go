@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Partial uploads are staged as a sequence of independently sealed records,
+// each holding up to StagingRecordSize bytes of plaintext:
+//
+//	header (4, big endian) | nonce (12) | AES-256-GCM sealed plaintext
+//
+// The header is the plaintext length with stagingRandomNonce set. Record i is
+// sealed under a fresh random nonce and authenticated with the upload ID and
+// i, so a staging file can grow across many requests, be cut back to any
+// record boundary, and still never hold plaintext. A record rewritten after
+// the file was cut back, e.g. when a request died before its progress was
+// saved, never reuses the nonce of the record it replaces.
+//
+// Records staged by earlier versions have no nonce and no flag: record i was
+// sealed with a nonce derived from i and authenticated with the upload ID
+// alone. They are still read, but no longer written.
+const StagingRecordSize = 64 * 1024
+
+// stagingRandomNonce flags a record header whose nonce follows it.
+const stagingRandomNonce = 1 << 31
+
+// StagingProgress reports how much of an upload has been staged.
+type StagingProgress struct {
+	Records        int64 // records written
+	PlaintextBytes int64 // upload bytes they hold
+	StagedBytes    int64 // bytes written to the staging file
+}
+
+// legacyStagingNonce is the nonce of record index in a staging file written
+// before records carried their own.
+func legacyStagingNonce(index int64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], uint64(index))
+	return nonce
+}
+
+// stagingAAD binds a record to its upload and its place in the file.
+func stagingAAD(uploadID string, index int64) []byte {
+	return binary.BigEndian.AppendUint64([]byte(uploadID), uint64(index))
+}
+
+// AppendStagingRecords seals everything read from r into records appended to
+// w, numbering them from firstRecord. On error, the returned progress still
+// covers every record that was completely written.
+func AppendStagingRecords(w io.Writer, r io.Reader, key []byte, uploadID string, firstRecord int64) (StagingProgress, error) {
+	var progress StagingProgress
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return progress, err
+	}
+
+	buf := make([]byte, StagingRecordSize)
+	out := make([]byte, 0, 4+gcm.NonceSize()+StagingRecordSize+gcm.Overhead())
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			out = binary.BigEndian.AppendUint32(out[:0], uint32(n)|stagingRandomNonce)
+			nonce := out[4 : 4+gcm.NonceSize()]
+			if _, err := rand.Read(nonce); err != nil {
+				return progress, fmt.Errorf("failed to generate nonce: %v", err)
+			}
+			index := firstRecord + progress.Records
+			out = gcm.Seal(out[:4+len(nonce)], nonce, buf[:n], stagingAAD(uploadID, index))
+			if _, err := w.Write(out); err != nil {
+				return progress, fmt.Errorf("failed to write staging record: %v", err)
+			}
+			progress.Records++
+			progress.PlaintextBytes += int64(n)
+			progress.StagedBytes += int64(len(out))
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			return progress, nil
+		}
+		if readErr != nil {
+			return progress, readErr
+		}
+	}
+}
+
+// StagingReader decrypts a staging file written by AppendStagingRecords.
+type StagingReader struct {
+	r        io.Reader
+	gcm      cipher.AEAD
+	uploadID string
+	index    int64
+	sealed   []byte
+	pending  []byte
+}
+
+// NewStagingReader returns a reader over the plaintext of a staging file.
+func NewStagingReader(r io.Reader, key []byte, uploadID string) (*StagingReader, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &StagingReader{
+		r:        r,
+		gcm:      gcm,
+		uploadID: uploadID,
+		sealed:   make([]byte, gcm.NonceSize()+StagingRecordSize+gcm.Overhead()),
+	}, nil
+}
+
+func (sr *StagingReader) Read(p []byte) (int, error) {
+	for len(sr.pending) == 0 {
+		var buf [4]byte
+		if _, err := io.ReadFull(sr.r, buf[:]); err != nil {
+			if err == io.EOF {
+				return 0, io.EOF
+			}
+			return 0, fmt.Errorf("%w: truncated staging record", ErrIntegrity)
+		}
+		header := binary.BigEndian.Uint32(buf[:])
+		length := int(header &^ stagingRandomNonce)
+		if length == 0 || length > StagingRecordSize {
+			return 0, fmt.Errorf("%w: bad staging record length %d", ErrIntegrity, length)
+		}
+
+		nonceSize := 0
+		if header&stagingRandomNonce != 0 {
+			nonceSize = sr.gcm.NonceSize()
+		}
+		record := sr.sealed[:nonceSize+length+sr.gcm.Overhead()]
+		if _, err := io.ReadFull(sr.r, record); err != nil {
+			return 0, fmt.Errorf("%w: truncated staging record", ErrIntegrity)
+		}
+		nonce, aad := legacyStagingNonce(sr.index), []byte(sr.uploadID)
+		if nonceSize > 0 {
+			nonce, aad = record[:nonceSize], stagingAAD(sr.uploadID, sr.index)
+		}
+		sealed := record[nonceSize:]
+		plaintext, err := sr.gcm.Open(sealed[:0], nonce, sealed, aad)
+		if err != nil {
+			return 0, fmt.Errorf("%w: staging record %d: %v", ErrIntegrity, sr.index, err)
+		}
+		sr.index++
+		sr.pending = plaintext
+	}
+
+	n := copy(p, sr.pending)
+	sr.pending = sr.pending[n:]
+	return n, nil
+}
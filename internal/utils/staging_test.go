@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+	key, err := GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func readStaging(t *testing.T, staged []byte, key []byte, uploadID string) ([]byte, error) {
+	t.Helper()
+	sr, err := NewStagingReader(bytes.NewReader(staged), key, uploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return io.ReadAll(sr)
+}
+
+// stagingNonces returns the nonce of every record of a staging file.
+func stagingNonces(t *testing.T, staged []byte) [][]byte {
+	t.Helper()
+	var nonces [][]byte
+	for len(staged) > 0 {
+		header := binary.BigEndian.Uint32(staged)
+		if header&stagingRandomNonce == 0 {
+			t.Fatal("record written without its own nonce")
+		}
+		length := int(header &^ stagingRandomNonce)
+		nonces = append(nonces, staged[4:16])
+		staged = staged[4+12+length+16:]
+	}
+	return nonces
+}
+
+func TestStagingRecords(t *testing.T) {
+	key := testKey(t)
+	data := randomBytes(t, 2*StagingRecordSize+100)
+
+	// Staged across requests, in pieces not aligned to records
+	var staged bytes.Buffer
+	var records int64
+	for _, piece := range [][]byte{data[:1000], data[1000 : StagingRecordSize+5], data[StagingRecordSize+5:]} {
+		progress, err := AppendStagingRecords(&staged, bytes.NewReader(piece), key, "upload", records)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if progress.PlaintextBytes != int64(len(piece)) {
+			t.Fatalf("staged %d of %d bytes", progress.PlaintextBytes, len(piece))
+		}
+		records += progress.Records
+	}
+
+	got, err := readStaging(t, staged.Bytes(), key, "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("staged data does not round-trip")
+	}
+	if _, err := readStaging(t, staged.Bytes(), key, "other"); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("reading under another upload ID: got %v, want ErrIntegrity", err)
+	}
+}
+
+func TestStagingRetryAfterCrash(t *testing.T) {
+	key := testKey(t)
+
+	// A request stages two records, then dies before its progress is saved,
+	// leaving the upload at its previous offset
+	first := randomBytes(t, StagingRecordSize+10)
+	var crashed bytes.Buffer
+	if _, err := AppendStagingRecords(&crashed, bytes.NewReader(first), key, "upload", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// The retry cuts the file back and stages other bytes at the same
+	// indexes: they must not be sealed under the same nonces
+	retried := randomBytes(t, StagingRecordSize+10)
+	var staged bytes.Buffer
+	if _, err := AppendStagingRecords(&staged, bytes.NewReader(retried), key, "upload", 0); err != nil {
+		t.Fatal(err)
+	}
+	before, after := stagingNonces(t, crashed.Bytes()), stagingNonces(t, staged.Bytes())
+	if len(before) != 2 || len(after) != 2 {
+		t.Fatalf("got %d and %d records, want 2", len(before), len(after))
+	}
+	for i := range before {
+		if bytes.Equal(before[i], after[i]) {
+			t.Errorf("record %d resealed under the same nonce", i)
+		}
+	}
+
+	got, err := readStaging(t, staged.Bytes(), key, "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, retried) {
+		t.Error("retried upload does not hold the retried bytes")
+	}
+}
+
+func TestStagingRecordsReordered(t *testing.T) {
+	key := testKey(t)
+	var first, second bytes.Buffer
+	if _, err := AppendStagingRecords(&first, bytes.NewReader([]byte("first")), key, "upload", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AppendStagingRecords(&second, bytes.NewReader([]byte("second")), key, "upload", 1); err != nil {
+		t.Fatal(err)
+	}
+	swapped := append(second.Bytes(), first.Bytes()...)
+	if _, err := readStaging(t, swapped, key, "upload"); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("reading reordered records: got %v, want ErrIntegrity", err)
+	}
+	truncated := append(first.Bytes(), second.Bytes()[:second.Len()-1]...)
+	if _, err := readStaging(t, truncated, key, "upload"); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("reading a truncated record: got %v, want ErrIntegrity", err)
+	}
+}
+
+func TestLegacyStagingRecords(t *testing.T) {
+	key := testKey(t)
+	gcm, err := newGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Written by an earlier version, then resumed by this one
+	var staged []byte
+	for i, record := range []string{"legacy ", "records "} {
+		staged = binary.BigEndian.AppendUint32(staged, uint32(len(record)))
+		staged = gcm.Seal(staged, legacyStagingNonce(int64(i)), []byte(record), []byte("upload"))
+	}
+	var resumed bytes.Buffer
+	if _, err := AppendStagingRecords(&resumed, bytes.NewReader([]byte("resumed")), key, "upload", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readStaging(t, append(staged, resumed.Bytes()...), key, "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "legacy records resumed" {
+		t.Errorf("read %q", got)
+	}
+}